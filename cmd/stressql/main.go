@@ -0,0 +1,675 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mjdesa/stress_parser"
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// errDifferent is returned by diff when the configs differ, so the command
+// exits non-zero the same way diff(1) does.
+var errDifferent = errors.New("configs differ")
+
+// errRegressed is returned by compare when a run regressed from its
+// baseline.
+var errRegressed = errors.New("run regressed from baseline")
+
+var (
+	pprofAddr  = flag.String("pprof-addr", "", "serve net/http/pprof on this address")
+	cpuprofile = flag.String("cpuprofile", "", "write a CPU profile to this file")
+	memprofile = flag.String("memprofile", "", "write a heap profile to this file on exit")
+	defaults   = flag.String("defaults", "", "defaults file merged under SET statements (default ~/"+mdstress.DefaultsFile+")")
+	isolate    = flag.Bool("isolate", false, "keep the SETs of each file in a directory of configs to that file")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: stressql [flags] <command> [arguments]
+
+Commands:
+  run file            run a config against the server, writing the run
+                      report as JSON; file may be a directory of .iql
+                      files, run in order of name
+  run -from-manifest manifest.json
+                      repeat the run a manifest recorded
+  run -scenario name [-scale n]
+                      run a built-in scenario
+  scenarios [-scale n] [name]
+                      list the built-in scenarios, or print one as a
+                      config to start from
+  diff a.iql b.iql    report semantic differences between two configs
+  schema              print the JSON Schema for JSON and YAML workloads
+  import-legacy file  convert an influx_stress TOML config to stressql
+  import-telegraf file
+                      synthesize inserts matching a Telegraf config
+  import-querylog file
+                      synthesize queries matching an access log, influxd
+                      query log, or list of queries
+  dashboard file      generate a Grafana dashboard for a config
+  export file         export a config's queries as Vegeta targets or a k6 script
+  replay file         write line protocol or an influx_inspect export with
+                      timestamps shifted to now
+  compare base.json run.json
+                      compare a run report against a baseline
+  runs a.json b.json ...
+                      tabulate several run reports side by side
+  html run.json       render a run report as a self-contained HTML page
+  fit file.csv [column]
+                      print the quantiles of a CSV column, to use with
+                      empirical() in place of the original data
+
+Exit status is 0 on success, 1 on error or when compared configs or runs
+differ, 2 on usage errors, 3 when a config does not parse, 4 when the
+server cannot be reached, 5 when a run fails an SLO, 6 when more of a
+run's requests fail than its errorBudget allows, 7 when a run is stopped
+by its deadline, and 8 when a run reaches its MAXPOINTS or MAXBYTES.
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	os.Exit(run(flag.Arg(0), flag.Args()[1:]))
+}
+
+func run(cmd string, args []string) int {
+	stop, err := startProfiling(*pprofAddr, *cpuprofile, *memprofile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "stressql:", err)
+		return 1
+	}
+	defer stop()
+
+	switch cmd {
+	case "run":
+		err = runRun(args)
+	case "diff":
+		err = runDiff(args)
+	case "schema":
+		err = runSchema(args)
+	case "import-legacy":
+		err = runImportLegacy(args)
+	case "import-telegraf":
+		err = runImportTelegraf(args)
+	case "import-querylog":
+		err = runImportQueryLog(args)
+	case "dashboard":
+		err = runDashboard(args)
+	case "export":
+		err = runExport(args)
+	case "replay":
+		err = runReplay(args)
+	case "compare":
+		err = runCompare(args)
+	case "runs":
+		err = runRuns(args)
+	case "scenarios":
+		err = runScenarios(args)
+	case "html":
+		err = runHTML(args)
+	case "fit":
+		err = runFit(args)
+	default:
+		fmt.Fprintf(os.Stderr, "stressql: unknown command %q\n", cmd)
+		usage()
+		return 2
+	}
+
+	if err == errDifferent || err == errRegressed {
+		return 1
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "stressql:", err)
+		return stressql.ExitCode(stressql.ErrorStatus(err))
+	}
+	return 0
+}
+
+func runRun(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	out := fs.String("o", "", "write the run report to this file (default stdout)")
+	queryArgs := kvFlag{}
+	fs.Var(queryArgs, "arg", "query template value as var=value, e.g. %f=busy (repeatable)")
+	profile := fs.String("profile", "", "run the PROFILE sections of this name")
+	overrides := kvFlag{}
+	fs.Var(overrides, "var", "override the SETs of a variable as var=value, e.g. database=test (repeatable)")
+	deadline := fs.Duration("deadline", 0, "stop the run after this long, in place of the config's DEADLINE")
+	manifest := fs.String("manifest", "", "write a manifest to repeat the run to this file")
+	fromManifest := fs.String("from-manifest", "", "repeat the run this manifest recorded, in place of a config file")
+	scenario := fs.String("scenario", "", "run this built-in scenario, in place of a config file; see stressql scenarios")
+	scale := fs.Int64("scale", 1, "size of the -scenario run, growing linearly from 1")
+	fs.Parse(args)
+
+	var cfg stressql.Config
+	opts := []stressql.Option{stressql.WithOutput(os.Stderr)}
+	if *fromManifest != "" {
+		if fs.NArg() != 0 {
+			return fmt.Errorf("run: -from-manifest takes no config file")
+		}
+		var err error
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "arg", "profile", "var", "deadline", "scenario", "scale":
+				err = fmt.Errorf("run: -%s does not apply with -from-manifest", f.Name)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		m, err := stressql.LoadManifest(*fromManifest)
+		if err != nil {
+			return err
+		}
+		if v := stressql.BuildVersion(); m.Version != v {
+			fmt.Fprintf(os.Stderr, "stressql: manifest was recorded by version %s, not %s; the run may differ\n", m.Version, v)
+		}
+		seq, err := mdstress.ParseString(m.Config)
+		if err != nil {
+			return &stressql.RunError{Status: stressql.StatusParseError, Err: fmt.Errorf("%s: %v", *fromManifest, err)}
+		}
+		cfg = stressql.Config{Statements: seq, Vars: m.Defaults, Args: m.Args}
+		overrides = m.Vars
+		opts = append(opts, m.Options()...)
+	} else if *scenario != "" {
+		if fs.NArg() != 0 {
+			return fmt.Errorf("run: -scenario takes no config file")
+		}
+		if *profile != "" {
+			return fmt.Errorf("run: -profile does not apply with -scenario")
+		}
+		seq, err := loadScenario(*scenario, *scale)
+		if err != nil {
+			return err
+		}
+		cfg = stressql.Config{Statements: seq, Args: queryArgs}
+		opts = append(opts, stressql.WithVars(overrides), stressql.WithDeadline(*deadline))
+	} else {
+		if fs.NArg() != 1 {
+			return fmt.Errorf("run: expected one config file")
+		}
+		seq, err := loadConfig(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if seq, err = stressql.SelectProfile(seq, *profile); err != nil {
+			return err
+		}
+		cfg = stressql.Config{Statements: seq, Args: queryArgs}
+		opts = append(opts, stressql.WithVars(overrides), stressql.WithDeadline(*deadline))
+	}
+
+	vars := map[string]string{}
+	for k, v := range cfg.Vars {
+		vars[k] = v
+	}
+	for _, s := range cfg.Statements {
+		if s, ok := s.(*stressql.SetStatement); ok {
+			vars[s.Var] = s.Value
+		}
+	}
+	for k, v := range overrides {
+		vars[k] = v
+	}
+	logger, err := stressql.LoggerFromVars(os.Stderr, vars)
+	if err != nil {
+		return err
+	}
+
+	r, err := stressql.NewRunner(cfg, append(opts, stressql.WithLogger(logger))...)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, runErr := r.Run(ctx)
+	if *manifest != "" {
+		if err := stressql.SaveManifest(*manifest, r.Manifest(res)); err != nil {
+			return err
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	if err := stressql.WriteResult(w, res); err != nil {
+		return err
+	}
+	if runErr == nil && res.Status != stressql.StatusSuccess {
+		runErr = &stressql.RunError{Status: res.Status, Err: errors.New(res.Reason)}
+	}
+	return runErr
+}
+
+func runScenarios(args []string) error {
+	fs := flag.NewFlagSet("scenarios", flag.ExitOnError)
+	scale := fs.Int64("scale", 1, "size of the printed scenario, growing linearly from 1")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fmt.Errorf("scenarios: expected at most one scenario")
+	}
+
+	if fs.NArg() == 0 {
+		for _, s := range mdstress.Scenarios() {
+			fmt.Printf("%-18s %s\n", s.Name, s.Description)
+		}
+		return nil
+	}
+	s, err := mdstress.LookupScenario(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	seq, err := s.Statements(*scale)
+	if err != nil {
+		return err
+	}
+	fmt.Print(stressql.Format(seq))
+	return nil
+}
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("diff: expected two config files")
+	}
+
+	a, err := loadConfig(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := loadConfig(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	diffs := stressql.Diff(a, b)
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) > 0 {
+		return errDifferent
+	}
+	return nil
+}
+
+func runSchema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fs.Parse(args)
+
+	schema, err := mdstress.WorkloadSchema()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(schema))
+	return nil
+}
+
+func runImportLegacy(args []string) error {
+	fs := flag.NewFlagSet("import-legacy", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import-legacy: expected one config file")
+	}
+
+	seq, err := mdstress.ImportLegacy(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	fmt.Print(stressql.Format(seq))
+	return nil
+}
+
+func runImportTelegraf(args []string) error {
+	var opts mdstress.TelegrafOptions
+	fs := flag.NewFlagSet("import-telegraf", flag.ExitOnError)
+	fs.IntVar(&opts.Hosts, "hosts", 100, "number of agents to simulate")
+	fs.DurationVar(&opts.Duration, "duration", time.Hour, "span of data written per agent")
+	fs.BoolVar(&opts.SkipUnknown, "skip-unknown", false, "skip input plugins without a known schema")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import-telegraf: expected one config file")
+	}
+
+	seq, err := mdstress.ImportTelegraf(fs.Arg(0), opts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	fmt.Print(stressql.Format(seq))
+	return nil
+}
+
+func runImportQueryLog(args []string) error {
+	var opts mdstress.QueryLogOptions
+	fs := flag.NewFlagSet("import-querylog", flag.ExitOnError)
+	fs.Int64Var(&opts.Total, "total", 0, "total queries to run, split by frequency (default as many as logged)")
+	fs.IntVar(&opts.Top, "top", 0, "keep only the most frequent queries")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import-querylog: expected one log file")
+	}
+
+	seq, err := mdstress.ImportQueryLog(fs.Arg(0), opts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	fmt.Print(stressql.Format(seq))
+	return nil
+}
+
+func runDashboard(args []string) error {
+	var opts mdstress.DashboardOptions
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	fs.StringVar(&opts.Title, "title", "", "dashboard title (default the config file name)")
+	fs.StringVar(&opts.Datasource, "datasource", "", "Grafana datasource for the reporting database")
+	fs.StringVar(&opts.ServerDatasource, "server-datasource", "", "Grafana datasource for the server's _internal database")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("dashboard: expected one config file")
+	}
+	if opts.Title == "" {
+		opts.Title = filepath.Base(fs.Arg(0))
+	}
+
+	seq, err := loadConfig(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	buf, err := mdstress.Dashboard(seq, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(buf))
+	return nil
+}
+
+func runExport(args []string) error {
+	opts := mdstress.ExportOptions{Args: map[string]string{}}
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "vegeta", "output format: vegeta or k6")
+	fs.StringVar(&opts.Addr, "addr", "", "target address (default the config's addresses)")
+	fs.StringVar(&opts.Database, "database", "", "target database (default the config's database)")
+	fs.Var(kvFlag(opts.Args), "arg", "query template value as var=value, e.g. %f=busy (repeatable)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("export: expected one config file")
+	}
+
+	seq, err := loadConfig(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "vegeta":
+		return mdstress.ExportVegeta(seq, os.Stdout, opts)
+	case "k6":
+		return mdstress.ExportK6(seq, os.Stdout, opts)
+	}
+	return fmt.Errorf("export: unknown format %q", *format)
+}
+
+func runReplay(args []string) error {
+	var opts stressql.ReplayOptions
+	w := &stressql.HTTPWriter{}
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&w.Addr, "addr", "localhost:8086", "target address, or unix:///path for a Unix domain socket")
+	fs.StringVar(&w.Database, "database", "", "target database (default the export's database)")
+	fs.StringVar(&w.RetentionPolicy, "rp", "", "target retention policy (default the export's retention policy)")
+	base := fs.String("base", "", "RFC3339 time of the first point (default now)")
+	fs.Float64Var(&opts.Speed, "speed", 1, "time compression factor, e.g. 24 replays a day in an hour")
+	fs.BoolVar(&opts.RealTime, "realtime", false, "send each point when the clock reaches its timestamp")
+	batchSize := fs.Int("batch-size", 5000, "points per write")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("replay: expected one data file")
+	}
+
+	if *base != "" {
+		t, err := time.Parse(time.RFC3339, *base)
+		if err != nil {
+			return fmt.Errorf("replay: -base: %v", err)
+		}
+		opts.Base = t
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(fs.Arg(0), ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fs.Arg(0), err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	p := stressql.NewReplayer(r, opts)
+
+	// The export's context lines come before its data, so the target is
+	// filled in from them on the first write.
+	target := &replayTarget{w: w, p: p}
+	points, errs, err := p.WriteTo(context.Background(), target, *batchSize)
+	fmt.Fprintf(os.Stderr, "replayed %d points, %d failed writes\n", points, errs)
+	return err
+}
+
+func runCompare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	tolerance := fs.Float64("tolerance", 15, "percent a metric may worsen before it is a regression")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("compare: expected a baseline and a run report")
+	}
+
+	base, err := stressql.LoadResult(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cur, err := stressql.LoadResult(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	c := stressql.Compare(base, cur, stressql.CompareOptions{Tolerance: *tolerance / 100})
+	if _, err := c.WriteTo(os.Stdout); err != nil {
+		return err
+	}
+	if c.Regressed() {
+		return errRegressed
+	}
+	return nil
+}
+
+func runRuns(args []string) error {
+	fs := flag.NewFlagSet("runs", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fmt.Errorf("runs: expected one or more run reports")
+	}
+
+	names := make([]string, fs.NArg())
+	runs := make([]*stressql.RunResult, fs.NArg())
+	for i, file := range fs.Args() {
+		r, err := stressql.LoadResult(file)
+		if err != nil {
+			return err
+		}
+		names[i] = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		runs[i] = r
+	}
+
+	_, err := stressql.TabulateRuns(names, runs).WriteTo(os.Stdout)
+	return err
+}
+
+func runHTML(args []string) error {
+	fs := flag.NewFlagSet("html", flag.ExitOnError)
+	title := fs.String("title", "", "page title (default the report file name)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("html: expected one run report")
+	}
+	if *title == "" {
+		*title = filepath.Base(fs.Arg(0))
+	}
+
+	r, err := stressql.LoadResult(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return stressql.WriteHTML(os.Stdout, r, *title)
+}
+
+func runFit(args []string) error {
+	fs := flag.NewFlagSet("fit", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("fit: expected a CSV file and optional column")
+	}
+
+	d, err := stressql.LoadDistribution(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	return d.WriteCSV(os.Stdout)
+}
+
+// replayTarget defaults a writer's database and retention policy to the
+// context of the export being replayed.
+type replayTarget struct {
+	w       *stressql.HTTPWriter
+	p       *stressql.Replayer
+	writers map[string]*stressql.HTTPWriter
+}
+
+func (t *replayTarget) WriteBatch(ctx context.Context, batch []byte) error {
+	db, rp := t.w.Database, t.w.RetentionPolicy
+	if db == "" {
+		db = t.p.Database
+	}
+	if rp == "" {
+		rp = t.p.RetentionPolicy
+	}
+	if db == "" {
+		return fmt.Errorf("replay: no database given and none in the export")
+	}
+
+	w := t.writers[db+"."+rp]
+	if w == nil {
+		w = &stressql.HTTPWriter{
+			Addr:            t.w.Addr,
+			Database:        db,
+			RetentionPolicy: rp,
+			Username:        t.w.Username,
+			Password:        t.w.Password,
+			Client:          t.w.Client,
+		}
+		if t.writers == nil {
+			t.writers = map[string]*stressql.HTTPWriter{}
+		}
+		t.writers[db+"."+rp] = w
+	}
+	err := w.WriteBatch(ctx, batch)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+	}
+	return err
+}
+
+// kvFlag collects repeated key=value flags into a map.
+type kvFlag map[string]string
+
+func (f kvFlag) String() string { return "" }
+
+func (f kvFlag) Set(s string) error {
+	n := strings.Index(s, "=")
+	if n <= 0 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	f[s[:n]] = s[n+1:]
+	return nil
+}
+
+// loadScenario returns a built-in scenario at scale, with the user's
+// defaults merged under it.
+func loadScenario(name string, scale int64) ([]stressql.Statement, error) {
+	d, err := mdstress.LoadDefaults(*defaults)
+	if err != nil {
+		return nil, fmt.Errorf("defaults: %v", err)
+	}
+	s, err := mdstress.LookupScenario(name)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := s.Statements(scale)
+	if err != nil {
+		return nil, err
+	}
+	return d.Merge(seq), nil
+}
+
+// loadConfig parses a config file and merges the user's defaults under it.
+func loadConfig(file string) ([]stressql.Statement, error) {
+	d, err := mdstress.LoadDefaults(*defaults)
+	if err != nil {
+		return nil, fmt.Errorf("defaults: %v", err)
+	}
+
+	var seq []stressql.Statement
+	if fi, err := os.Stat(file); err == nil && fi.IsDir() {
+		scope := mdstress.SharedScope
+		if *isolate {
+			scope = mdstress.FileScope
+		}
+		if seq, err = mdstress.ParseDirScope(file, scope); err != nil {
+			return nil, &stressql.RunError{Status: stressql.StatusParseError, Err: err}
+		}
+		return d.Merge(seq), nil
+	}
+	switch filepath.Ext(file) {
+	case ".yaml", ".yml":
+		seq, err = mdstress.ParseYAML(file)
+	case ".toml":
+		seq, err = mdstress.ParseTOML(file)
+	case ".json":
+		seq, err = mdstress.ParseJSON(file)
+	default:
+		// Its errors name the file and line already.
+		if seq, err = mdstress.ParseCommands(file); err != nil {
+			return nil, &stressql.RunError{Status: stressql.StatusParseError, Err: err}
+		}
+	}
+	if err != nil {
+		return nil, &stressql.RunError{Status: stressql.StatusParseError, Err: fmt.Errorf("%s: %v", file, err)}
+	}
+
+	return d.Merge(seq), nil
+}
@@ -0,0 +1,154 @@
+package mdstress
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// DefaultsFile is the name of the per-user defaults file, looked up in the
+// home directory when no explicit path is given.
+const DefaultsFile = ".stressql.toml"
+
+// Defaults holds environment-specific settings that are kept out of .iql
+// files. Each non-empty setting becomes a SET statement placed ahead of the
+// config, so any SET in the config itself takes precedence.
+type Defaults struct {
+	Addresses        []string `toml:"addresses"`
+	Username         string   `toml:"username"`
+	Password         string   `toml:"password"`
+	Database         string   `toml:"database"`
+	RetentionPolicy  string   `toml:"retention_policy"`
+	Concurrency      int      `toml:"concurrency"`
+	QueryConcurrency int      `toml:"query_concurrency"`
+	// MemoryLimit bounds the client's buffered data, e.g. "512MB".
+	MemoryLimit string `toml:"memory_limit"`
+
+	// Vars holds any other SET variables by name.
+	Vars map[string]string `toml:"vars"`
+}
+
+// LoadDefaults reads a defaults file. If path is empty, ~/.stressql.toml is
+// used and a missing file is not an error.
+func LoadDefaults(path string) (*Defaults, error) {
+	d := &Defaults{}
+
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return d, nil
+		}
+		path = filepath.Join(home, DefaultsFile)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return d, nil
+		}
+	}
+
+	md, err := toml.DecodeFile(path, d)
+	if err != nil {
+		return nil, err
+	}
+	// Reporting is set by SET variables, as any others are under [vars];
+	// say so rather than ignore the settings.
+	if md.IsDefined("reporting") {
+		return nil, fmt.Errorf("%s: [reporting] is not supported, set reportDatabase under [vars]", path)
+	}
+	if d.MemoryLimit != "" {
+		if _, err := stressql.ParseSize(d.MemoryLimit); err != nil {
+			return nil, fmt.Errorf("%s: memory_limit: %v", path, err)
+		}
+	}
+
+	return d, nil
+}
+
+// Sets returns the defaults as SET statements, in a stable order.
+func (d *Defaults) Sets() []*stressql.SetStatement {
+	vars := map[string]string{}
+	for k, v := range d.Vars {
+		vars[k] = v
+	}
+
+	set := func(k, v string) {
+		if v != "" {
+			vars[k] = v
+		}
+	}
+	set("addresses", strings.Join(d.Addresses, ","))
+	set("username", d.Username)
+	set("password", d.Password)
+	set("database", d.Database)
+	set("retentionPolicy", d.RetentionPolicy)
+	if d.Concurrency > 0 {
+		set("concurrency", strconv.Itoa(d.Concurrency))
+	}
+	if d.QueryConcurrency > 0 {
+		set("queryConcurrency", strconv.Itoa(d.QueryConcurrency))
+	}
+	if n, _ := stressql.ParseSize(d.MemoryLimit); n > 0 {
+		set("memoryLimit", strconv.FormatInt(n, 10))
+	}
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sets := make([]*stressql.SetStatement, 0, len(keys))
+	for _, k := range keys {
+		sets = append(sets, &stressql.SetStatement{Var: k, Value: vars[k]})
+	}
+	return sets
+}
+
+// Merge places the defaults under seq. Variables that seq sets anywhere are
+// left to the config, except that a SET to nothing, as ParseDirScope makes
+// to reset a variable, resets it to its default.
+func (d *Defaults) Merge(seq []stressql.Statement) []stressql.Statement {
+	if d == nil {
+		return seq
+	}
+
+	configured := map[string]bool{}
+	for _, s := range seq {
+		switch s := s.(type) {
+		case *stressql.SetStatement:
+			if s.Value != "" {
+				configured[s.Var] = true
+			}
+		case *stressql.UseStatement:
+			configured["database"] = true
+			configured["retentionPolicy"] = true
+		}
+	}
+
+	merged := []stressql.Statement{}
+	// A VERSION pragma must stay first.
+	if len(seq) > 0 {
+		if _, ok := seq[0].(*stressql.VersionStatement); ok {
+			merged, seq = append(merged, seq[0]), seq[1:]
+		}
+	}
+	defaults := map[string]stressql.Statement{}
+	for _, s := range d.Sets() {
+		defaults[s.Var] = s
+		if !configured[s.Var] {
+			merged = append(merged, s)
+		}
+	}
+
+	for _, s := range seq {
+		if set, ok := s.(*stressql.SetStatement); ok && set.Value == "" && defaults[set.Var] != nil {
+			s = defaults[set.Var]
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
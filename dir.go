@@ -0,0 +1,82 @@
+package mdstress
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// SetScope says how far the SET and USE statements of a file in a
+// directory of configs reach.
+type SetScope int
+
+const (
+	// SharedScope lets SETs carry over to the files after them, as if the
+	// files were one config.
+	SharedScope SetScope = iota
+	// FileScope keeps each file's SETs to that file. Variables a file sets
+	// are reset after it to their value before the directory's first file.
+	FileScope
+)
+
+// ParseDir parses the *.iql files in dir, in order of name, as one config
+// whose SETs carry from one file to the next.
+func ParseDir(dir string) ([]stressql.Statement, error) {
+	return ParseDirScope(dir, SharedScope)
+}
+
+// ParseDirScope parses the *.iql files in dir, in order of name, as one
+// config, scoping SETs as scope says. Only the first file's VERSION pragma
+// is kept, although each file is parsed by its own.
+func ParseDirScope(dir string, scope SetScope) ([]stressql.Statement, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.iql"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no .iql files", dir)
+	}
+
+	seq := []stressql.Statement{}
+	for i, file := range files {
+		fseq, err := ParseFile(file, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(fseq) > 0 && i > 0 {
+			if _, ok := fseq[0].(*stressql.VersionStatement); ok {
+				fseq = fseq[1:]
+			}
+		}
+		seq = append(seq, fseq...)
+		if scope == FileScope && i < len(files)-1 {
+			seq = append(seq, resets(fseq)...)
+		}
+	}
+	return seq, nil
+}
+
+// resets returns SETs emptying each variable seq sets, in the order seq
+// first sets them. Defaults.Merge resets variables it has defaults for to
+// those instead.
+func resets(seq []stressql.Statement) []stressql.Statement {
+	var sets []stressql.Statement
+	seen := map[string]bool{}
+	reset := func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			sets = append(sets, &stressql.SetStatement{Var: k})
+		}
+	}
+	for _, s := range seq {
+		switch s := s.(type) {
+		case *stressql.SetStatement:
+			reset(s.Var)
+		case *stressql.UseStatement:
+			reset("database")
+			reset("retentionPolicy")
+		}
+	}
+	return sets
+}
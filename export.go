@@ -0,0 +1,198 @@
+package mdstress
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// ExportOptions configures exporting QUERY statements to other load tools.
+// Non-empty options take precedence over the config's SET statements.
+type ExportOptions struct {
+	Addr     string
+	Database string
+	// Args supplies values for query template variables, keyed by the
+	// variable as written in the config, e.g. "%f".
+	Args map[string]string
+}
+
+// exportQuery is a QUERY resolved against the SETs in effect before it.
+type exportQuery struct {
+	Name        string
+	URL         string
+	Count       int64
+	Concurrency int
+	Interval    time.Duration
+	Header      http.Header
+}
+
+func exportQueries(seq []stressql.Statement, opts ExportOptions) ([]exportQuery, error) {
+	vars := map[string]string{
+		"addresses":        "localhost:8086",
+		"queryConcurrency": "1",
+	}
+
+	var qs []exportQuery
+	inserts := map[string]*stressql.InsertStatement{}
+	for _, s := range seq {
+		if g, ok := s.(*stressql.GoStatement); ok {
+			s = g.Statement
+		}
+
+		switch s := s.(type) {
+		case *stressql.SetStatement:
+			vars[s.Var] = s.Value
+		case *stressql.UseStatement:
+			vars["database"] = s.Database
+		case *stressql.InsertStatement:
+			inserts[s.Name] = s
+		case *stressql.QueryStatement:
+			addr := strings.Split(vars["addresses"], ",")[0]
+			if opts.Addr != "" {
+				addr = opts.Addr
+			}
+			if !strings.Contains(addr, "://") {
+				addr = "http://" + addr
+			}
+			db := vars["database"]
+			if opts.Database != "" {
+				db = opts.Database
+			}
+
+			// "%t key" variables take a value the INSERT of the same
+			// name writes.
+			var g *stressql.Generator
+			if ins := inserts[s.Name]; ins != nil {
+				var err error
+				if g, err = stressql.Compile(ins); err != nil {
+					return nil, err
+				}
+			}
+			qg, err := stressql.CompileQuery(s, g, opts.Args)
+			if err != nil {
+				return nil, err
+			}
+			q := strings.TrimSpace(string(qg.AppendQuery(nil, 0)))
+
+			count, err := strconv.ParseInt(s.Count, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("query %q: invalid count %q", s.Name, s.Count)
+			}
+			concurrency, _ := strconv.Atoi(vars["queryConcurrency"])
+			if concurrency <= 0 {
+				concurrency = 1
+			}
+			// A FANOUT round is that many concurrent requests.
+			concurrency *= qg.Fanout
+			if count, err = stressql.MulCounts(count, int64(qg.Fanout)); err != nil {
+				return nil, fmt.Errorf("query %q: %v", s.Name, err)
+			}
+			interval, _ := time.ParseDuration(vars["queryInterval"])
+
+			qs = append(qs, exportQuery{
+				Name:        s.Name,
+				URL:         addr + "/query?" + url.Values{"db": {db}, "q": {q}}.Encode(),
+				Count:       count,
+				Concurrency: concurrency,
+				Interval:    interval,
+				Header:      stressql.HeadersFromVars(vars),
+			})
+		}
+	}
+
+	return qs, nil
+}
+
+// ExportVegeta writes the config's queries as a Vegeta target file. Vegeta
+// cycles through targets, so each query appears once; the rate implied by
+// queryInterval and queryConcurrency is noted in a comment.
+func ExportVegeta(seq []stressql.Statement, w io.Writer, opts ExportOptions) error {
+	qs, err := exportQueries(seq, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, q := range qs {
+		if q.Interval > 0 {
+			rate := float64(q.Concurrency) / q.Interval.Seconds()
+			fmt.Fprintf(w, "# %s: %d requests at %g/s\n", q.Name, q.Count, rate)
+		} else {
+			fmt.Fprintf(w, "# %s: %d requests\n", q.Name, q.Count)
+		}
+		fmt.Fprintf(w, "GET %s\n", q.URL)
+		for _, k := range sortedKeys(q.Header) {
+			fmt.Fprintf(w, "%s: %s\n", k, q.Header[k][0])
+		}
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
+
+func sortedKeys(h http.Header) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ExportK6 writes the config's queries as a k6 script with one scenario per
+// query, running its count across queryConcurrency virtual users.
+func ExportK6(seq []stressql.Statement, w io.Writer, opts ExportOptions) error {
+	qs, err := exportQueries(seq, opts)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]int{}
+	fns := make([]string, len(qs))
+	for i, q := range qs {
+		fn := "query_" + nonIdent.ReplaceAllString(q.Name, "_")
+		if n := names[fn]; n > 0 {
+			fn += "_" + strconv.Itoa(n)
+		}
+		names[fn]++
+		fns[i] = fn
+	}
+
+	fmt.Fprint(w, "import http from 'k6/http';\nimport { check, sleep } from 'k6';\n\n")
+
+	fmt.Fprint(w, "export const options = {\n  scenarios: {\n")
+	for i, q := range qs {
+		fmt.Fprintf(w, "    %s: { executor: 'shared-iterations', vus: %d, iterations: %d, exec: '%s' },\n",
+			fns[i], q.Concurrency, q.Count, fns[i])
+	}
+	fmt.Fprint(w, "  },\n};\n")
+
+	for i, q := range qs {
+		fmt.Fprintf(w, "\nexport function %s() {\n", fns[i])
+		if len(q.Header) > 0 {
+			var hs []string
+			for _, k := range sortedKeys(q.Header) {
+				hs = append(hs, strconv.Quote(k)+": "+strconv.Quote(q.Header[k][0]))
+			}
+			fmt.Fprintf(w, "  const res = http.get(%s, { headers: { %s } });\n", strconv.Quote(q.URL), strings.Join(hs, ", "))
+		} else {
+			fmt.Fprintf(w, "  const res = http.get(%s);\n", strconv.Quote(q.URL))
+		}
+		fmt.Fprint(w, "  check(res, { 'status is 200': (r) => r.status === 200 });\n")
+		if q.Interval > 0 {
+			fmt.Fprintf(w, "  sleep(%g);\n", q.Interval.Seconds())
+		}
+		fmt.Fprint(w, "}\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package mdstress
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// DashboardOptions configures a generated Grafana dashboard.
+type DashboardOptions struct {
+	Title string
+	// Datasource is the Grafana datasource for the database a run reports
+	// to with SET reportDatabase.
+	Datasource string
+	// ServerDatasource, if set, adds panels from the target server's
+	// _internal database so server behaviour can be read against the load.
+	ServerDatasource string
+}
+
+type dashboard struct {
+	Title         string            `json:"title"`
+	Tags          []string          `json:"tags"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          map[string]string `json:"time"`
+	Panels        []dashboardPanel  `json:"panels"`
+}
+
+type dashboardPanel struct {
+	ID          int               `json:"id"`
+	Title       string            `json:"title"`
+	Type        string            `json:"type"`
+	Datasource  string            `json:"datasource,omitempty"`
+	GridPos     map[string]int    `json:"gridPos"`
+	Targets     []dashboardTarget `json:"targets,omitempty"`
+	FieldConfig interface{}       `json:"fieldConfig,omitempty"`
+}
+
+type dashboardTarget struct {
+	RefID        string `json:"refId"`
+	Alias        string `json:"alias,omitempty"`
+	RawQuery     bool   `json:"rawQuery"`
+	ResultFormat string `json:"resultFormat"`
+	Query        string `json:"query"`
+}
+
+// Dashboard generates Grafana dashboard JSON with throughput and latency
+// panels for every named INSERT and QUERY in seq.
+func Dashboard(seq []stressql.Statement, opts DashboardOptions) ([]byte, error) {
+	if opts.Title == "" {
+		opts.Title = "stressql"
+	}
+
+	d := &dashboard{
+		Title:         opts.Title,
+		Tags:          []string{"stressql"},
+		SchemaVersion: 27,
+		Refresh:       "10s",
+		Time:          map[string]string{"from": "now-1h", "to": "now"},
+	}
+
+	y := 0
+	row := func(title string) {
+		d.Panels = append(d.Panels, dashboardPanel{
+			Type:    "row",
+			Title:   title,
+			GridPos: map[string]int{"h": 1, "w": 24, "x": 0, "y": y},
+		})
+		y++
+	}
+	panel := func(ds, title, unit string, x int, targets ...dashboardTarget) {
+		d.Panels = append(d.Panels, dashboardPanel{
+			Type:        "timeseries",
+			Title:       title,
+			Datasource:  ds,
+			GridPos:     map[string]int{"h": 8, "w": 12, "x": x, "y": y},
+			Targets:     targets,
+			FieldConfig: map[string]interface{}{"defaults": map[string]string{"unit": unit}},
+		})
+	}
+
+	seen := map[string]bool{}
+	cqs := false
+	for _, s := range seq {
+		if g, ok := s.(*stressql.GoStatement); ok {
+			s = g.Statement
+		}
+
+		var kind, name, measurement, counter string
+		switch s := s.(type) {
+		case *stressql.InsertStatement:
+			kind, name, measurement, counter = "INSERT", s.Name, stressql.ReportWriteMeasurement, stressql.ReportPointsField
+		case *stressql.QueryStatement:
+			kind, name, measurement, counter = "QUERY", s.Name, stressql.ReportQueryMeasurement, stressql.ReportRequestsField
+		case *stressql.ContinuousQueryStatement:
+			cqs = true
+			continue
+		default:
+			continue
+		}
+		if name == "" || seen[measurement+name] {
+			continue
+		}
+		seen[measurement+name] = true
+
+		where := fmt.Sprintf(`WHERE "%s" = '%s' AND $timeFilter`, stressql.ReportStatementTag, name)
+		row(kind + " " + name)
+		panel(opts.Datasource, name+" throughput", counter+"/s", 0,
+			influxTarget("A", counter+"/s", fmt.Sprintf(`SELECT non_negative_derivative(max("%s"), 1s) FROM "%s" %s GROUP BY time($__interval)`, counter, measurement, where)),
+			influxTarget("B", "errors/s", fmt.Sprintf(`SELECT non_negative_derivative(max("%s"), 1s) FROM "%s" %s GROUP BY time($__interval)`, stressql.ReportErrorsField, measurement, where)),
+		)
+		panel(opts.Datasource, name+" latency", "ns", 12,
+			influxTarget("A", "p50", fmt.Sprintf(`SELECT mean("%s") FROM "%s" %s GROUP BY time($__interval)`, stressql.ReportP50Field, measurement, where)),
+			influxTarget("B", "p99", fmt.Sprintf(`SELECT mean("%s") FROM "%s" %s GROUP BY time($__interval)`, stressql.ReportP99Field, measurement, where)),
+		)
+		y += 8
+	}
+
+	if opts.ServerDatasource != "" {
+		ds := opts.ServerDatasource
+		row("Server")
+		panel(ds, "Points written", "points/s", 0,
+			influxTarget("A", "ok", `SELECT non_negative_derivative(sum("pointReqLocal"), 1s) FROM "write" WHERE $timeFilter GROUP BY time($__interval)`),
+			influxTarget("B", "failed", `SELECT non_negative_derivative(sum("pointReqFail"), 1s) FROM "write" WHERE $timeFilter GROUP BY time($__interval)`),
+		)
+		panel(ds, "HTTP requests", "reqps", 12,
+			influxTarget("A", "writes", `SELECT non_negative_derivative(sum("writeReq"), 1s) FROM "httpd" WHERE $timeFilter GROUP BY time($__interval)`),
+			influxTarget("B", "queries", `SELECT non_negative_derivative(sum("queryReq"), 1s) FROM "httpd" WHERE $timeFilter GROUP BY time($__interval)`),
+		)
+		y += 8
+		panel(ds, "Heap", "bytes", 0,
+			influxTarget("A", "heap", `SELECT mean("HeapInUse") FROM "runtime" WHERE $timeFilter GROUP BY time($__interval)`),
+		)
+		panel(ds, "Cache", "bytes", 12,
+			influxTarget("A", "cache", `SELECT sum("memBytes") FROM "tsm1_cache" WHERE $timeFilter GROUP BY time($__interval)`),
+			influxTarget("B", "disk", `SELECT sum("diskBytes") FROM "tsm1_filestore" WHERE $timeFilter GROUP BY time($__interval)`),
+		)
+		if cqs {
+			y += 8
+			panel(ds, "Continuous queries", "ops", 0,
+				influxTarget("A", "ok", `SELECT non_negative_derivative(sum("queryOk"), 1s) FROM "cq" WHERE $timeFilter GROUP BY time($__interval)`),
+				influxTarget("B", "failed", `SELECT non_negative_derivative(sum("queryFail"), 1s) FROM "cq" WHERE $timeFilter GROUP BY time($__interval)`),
+			)
+		}
+	}
+
+	for i := range d.Panels {
+		d.Panels[i].ID = i + 1
+	}
+
+	return json.MarshalIndent(d, "", "  ")
+}
+
+func influxTarget(ref, alias, q string) dashboardTarget {
+	return dashboardTarget{RefID: ref, Alias: alias, RawQuery: true, ResultFormat: "time_series", Query: q}
+}
@@ -0,0 +1,79 @@
+package mdstress
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// dashboardQuery picks out the field, measurement and statement a
+// statement panel's query reads.
+var dashboardQuery = regexp.MustCompile(`(?:max|mean)\("(\w+)"\).* FROM "(\w+)" WHERE "statement" = '(\w+)'`)
+
+func TestDashboardReadsWhatIsReported(t *testing.T) {
+	seq, err := ParseString("SET reportDatabase results\n\n" +
+		"INSERT cpu\ncpu,\nhost=[str rand(8) 10]\nv=[int rand(100) 0]\n100 10s\n\n" +
+		"QUERY cpu\nSELECT count(v) FROM cpu\nDO 5\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &stressql.MemorySink{}
+	r, err := stressql.NewRunner(stressql.Config{Statements: seq}, stressql.WithSink(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// reported has the fields written, by measurement and statement.
+	reported := map[string]bool{}
+	for _, b := range sink.Batches() {
+		if b.Database != "results" {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(b.Lines)), "\n") {
+			parts := strings.Split(line, " ")
+			tags := strings.Split(parts[0], ",")
+			for _, tag := range tags[1:] {
+				if !strings.HasPrefix(tag, stressql.ReportStatementTag+"=") {
+					continue
+				}
+				for _, f := range strings.Split(parts[1], ",") {
+					reported[tags[0]+" "+strings.TrimPrefix(tag, stressql.ReportStatementTag+"=")+" "+f[:strings.IndexByte(f, '=')]] = true
+				}
+			}
+		}
+	}
+
+	b, err := Dashboard(seq, DashboardOptions{Datasource: "results"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d dashboard
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	var queries int
+	for _, p := range d.Panels {
+		for _, target := range p.Targets {
+			m := dashboardQuery.FindStringSubmatch(target.Query)
+			if m == nil {
+				t.Errorf("panel %q: unexpected query %s", p.Title, target.Query)
+				continue
+			}
+			field, measurement, statement := m[1], m[2], m[3]
+			if !reported[measurement+" "+statement+" "+field] {
+				t.Errorf("panel %q reads %s of %s for %s, which nothing reported", p.Title, field, measurement, statement)
+			}
+			queries++
+		}
+	}
+	if queries != 8 {
+		t.Errorf("dashboard has %d statement queries, want 8", queries)
+	}
+}
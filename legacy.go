@@ -0,0 +1,221 @@
+package mdstress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// legacyConfig mirrors the basic sections of the original influx_stress
+// TOML configuration.
+type legacyConfig struct {
+	Provision struct {
+		Basic struct {
+			Enabled       bool   `toml:"enabled"`
+			Address       string `toml:"address"`
+			Database      string `toml:"database"`
+			ResetDatabase bool   `toml:"reset_database"`
+		} `toml:"basic"`
+	} `toml:"provision"`
+
+	Write struct {
+		PointGenerator struct {
+			Basic struct {
+				Enabled     bool       `toml:"enabled"`
+				PointCount  int64      `toml:"point_count"`
+				SeriesCount int64      `toml:"series_count"`
+				Tick        string     `toml:"tick"`
+				Jitter      bool       `toml:"jitter"`
+				Measurement string     `toml:"measurement"`
+				StartDate   string     `toml:"start_date"`
+				Precision   string     `toml:"precision"`
+				Tags        []legacyKV `toml:"tag"`
+				Fields      []legacyKV `toml:"field"`
+			} `toml:"basic"`
+		} `toml:"point_generator"`
+
+		InfluxClient struct {
+			Basic struct {
+				Enabled       bool     `toml:"enabled"`
+				Addresses     []string `toml:"addresses"`
+				Database      string   `toml:"database"`
+				Precision     string   `toml:"precision"`
+				BatchSize     int      `toml:"batch_size"`
+				BatchInterval string   `toml:"batch_interval"`
+				Concurrency   int      `toml:"concurrency"`
+				SSL           bool     `toml:"ssl"`
+				Format        string   `toml:"format"`
+			} `toml:"basic"`
+		} `toml:"influx_client"`
+	} `toml:"write"`
+
+	Read struct {
+		QueryGenerator struct {
+			Basic struct {
+				Template   string `toml:"template"`
+				QueryCount int64  `toml:"query_count"`
+			} `toml:"basic"`
+		} `toml:"query_generator"`
+
+		QueryClient struct {
+			Basic struct {
+				Enabled       bool     `toml:"enabled"`
+				Addresses     []string `toml:"addresses"`
+				Database      string   `toml:"database"`
+				QueryInterval string   `toml:"query_interval"`
+				Concurrency   int      `toml:"concurrency"`
+			} `toml:"basic"`
+		} `toml:"query_client"`
+	} `toml:"read"`
+}
+
+type legacyKV struct {
+	Key   string `toml:"key"`
+	Value string `toml:"value"`
+}
+
+// legacyFields maps influx_stress field types to generator specs.
+var legacyFields = map[string]string{
+	"float64": "float rand(1000) 0",
+	"float":   "float rand(1000) 0",
+	"int":     "int rand(1000) 0",
+	"int64":   "int rand(1000) 0",
+	"string":  "str rand(8) 0",
+}
+
+// ImportLegacy converts an influx_stress TOML config into equivalent
+// statements. The write and read sections run concurrently, as they did in
+// influx_stress.
+func ImportLegacy(file string) ([]stressql.Statement, error) {
+	c := &legacyConfig{}
+	if _, err := toml.DecodeFile(file, c); err != nil {
+		return nil, err
+	}
+
+	seq := []stressql.Statement{}
+	set := func(k, v string) {
+		if v != "" && v != "0" {
+			seq = append(seq, &stressql.SetStatement{Var: k, Value: v})
+		}
+	}
+
+	wc := c.Write.InfluxClient.Basic
+	addrs := wc.Addresses
+	if wc.SSL {
+		// The scheme of an address is what makes it use TLS.
+		addrs = make([]string, len(wc.Addresses))
+		for i, a := range wc.Addresses {
+			if !strings.Contains(a, "://") {
+				a = "https://" + a
+			}
+			addrs[i] = a
+		}
+	}
+	set("addresses", strings.Join(addrs, ","))
+	set("database", wc.Database)
+	set("batchSize", strconv.Itoa(wc.BatchSize))
+	set("concurrency", strconv.Itoa(wc.Concurrency))
+
+	qc := c.Read.QueryClient.Basic
+	set("queryConcurrency", strconv.Itoa(qc.Concurrency))
+	set("queryInterval", qc.QueryInterval)
+
+	if p := c.Provision.Basic; p.Enabled && p.Database != "" {
+		if p.ResetDatabase {
+			seq = append(seq, &stressql.InfluxqlStatement{Value: "DROP DATABASE " + p.Database})
+		}
+		seq = append(seq, &stressql.InfluxqlStatement{Value: "CREATE DATABASE " + p.Database})
+	}
+
+	var body []stressql.Statement
+
+	if pg := c.Write.PointGenerator.Basic; pg.Enabled {
+		stmt, err := legacyInsert(pg.Measurement, pg.Tags, pg.Fields, pg.SeriesCount, pg.PointCount, pg.Tick, pg.Jitter)
+		if err != nil {
+			return nil, fmt.Errorf("write.point_generator.basic: %v", err)
+		}
+		body = append(body, stmt)
+	}
+
+	if qg := c.Read.QueryGenerator.Basic; qc.Enabled && qg.Template != "" {
+		stmt, err := (&WorkloadQuery{Name: "basic", Query: qg.Template, Count: qg.QueryCount}).statement()
+		if err != nil {
+			return nil, fmt.Errorf("read.query_generator.basic: %v", err)
+		}
+		body = append(body, stmt)
+	}
+
+	for _, s := range body {
+		seq = append(seq, &stressql.GoStatement{Statement: s})
+	}
+	if len(body) > 0 {
+		seq = append(seq, &stressql.WaitStatement{})
+	}
+
+	return seq, nil
+}
+
+// legacyInsert builds the INSERT for the basic point generator. influx_stress
+// suffixed every tag value with the series number; here only the first tag
+// varies, which produces the same number of series.
+func legacyInsert(measurement string, tags, fields []legacyKV, series, points int64, tick string, jitter bool) (*stressql.InsertStatement, error) {
+	if measurement == "" || len(fields) == 0 {
+		return nil, fmt.Errorf("missing measurement or fields")
+	}
+	if series <= 0 || points <= 0 {
+		return nil, fmt.Errorf("series_count and point_count must be positive")
+	}
+	if tick == "" {
+		tick = "1s"
+	}
+	total, err := stressql.MulCounts(series, points)
+	if err != nil {
+		return nil, fmt.Errorf("series_count times point_count: %v", err)
+	}
+
+	stmt := &stressql.InsertStatement{
+		Name: "basic",
+		Timestamp: &stressql.Timestamp{
+			Count:    strconv.FormatInt(total, 10),
+			Duration: tick,
+			Jitter:   jitter,
+		},
+	}
+
+	tmpl := measurement
+	for i, t := range tags {
+		if i == 0 {
+			tmpl += "," + t.Key + "=" + t.Value + "-%v"
+			stmt.Templates = append(stmt.Templates, &stressql.Template{
+				Functions: []*stressql.Function{{Type: "int", Fn: "inc", Argument: "0", Count: strconv.FormatInt(series, 10)}},
+			})
+			continue
+		}
+		tmpl += "," + t.Key + "=" + t.Value
+	}
+
+	for i, f := range fields {
+		gen, ok := legacyFields[f.Value]
+		if !ok {
+			return nil, fmt.Errorf("field %q: unsupported type %q", f.Key, f.Value)
+		}
+		fn, err := parseGenerator(gen)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			tmpl += " "
+		} else {
+			tmpl += ","
+		}
+		tmpl += f.Key + "=%v"
+		stmt.Templates = append(stmt.Templates, &stressql.Template{Functions: []*stressql.Function{fn}})
+	}
+
+	stmt.TemplateString = tmpl + " %v"
+
+	return stmt, nil
+}
@@ -2,11 +2,13 @@ package mdstress
 
 import (
 	"bufio"
-	"bytes"
-	//"fmt"
+	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/influxdata/influxdb/influxql"
 	"github.com/mjdesa/stress_parser/stressql"
@@ -20,97 +22,122 @@ const (
 	EOF
 	STATEMENT
 	BREAK
+	// TOOLONG is a statement longer than stressql.MaxStatementSize, which
+	// is not read into memory.
+	TOOLONG
 )
 
-var eof = rune(0)
-
 func check(e error) {
 	if e != nil {
 		panic(e)
 	}
 }
 
-func isNewline(r rune) bool {
-	return r == '\n'
-}
-
+// Scanner splits a config into statements separated by blank lines. It
+// reads through a bufio window into a reused buffer, so ScanBytes does not
+// allocate per statement.
 type Scanner struct {
-	r *bufio.Reader
+	r   *bufio.Reader
+	buf []byte
+	// line counts the newlines read, and start is the line the last
+	// token began on.
+	line  int
+	start int
+	// err is the error reading the config, other than io.EOF.
+	err error
 }
 
 func NewScanner(r io.Reader) *Scanner {
 	return &Scanner{r: bufio.NewReader(r)}
 }
 
-func (s *Scanner) read() rune {
-	ch, _, err := s.r.ReadRune()
-	if err != nil {
-		return eof
-	}
-	return ch
+func (s *Scanner) Scan() (tok Token, lit string) {
+	tok, b := s.ScanBytes()
+	return tok, string(b)
 }
 
-func (s *Scanner) unread() { _ = s.r.UnreadRune() }
-
-func (s *Scanner) peek() rune {
-	ch := s.read()
-	s.unread()
-	return ch
-}
+// ScanBytes returns the next token. The returned slice is only valid until
+// the next call.
+func (s *Scanner) ScanBytes() (tok Token, lit []byte) {
+	s.buf = s.buf[:0]
+	s.start = s.line + 1
+	if s.err != nil {
+		return EOF, nil
+	}
 
-func (s *Scanner) Scan() (tok Token, lit string) {
-	ch := s.read()
+	c, err := s.r.ReadByte()
+	if err != nil {
+		return s.fail(err)
+	}
+	s.r.UnreadByte()
 
-	if isNewline(ch) {
-		s.unread()
+	if c == '\n' {
 		return s.scanNewlines()
-	} else if ch == eof {
-		return EOF, ""
-	} else {
-		s.unread()
-		return s.scanStatements()
 	}
-
-	return ILLEGAL, string(ch)
+	return s.scanStatements()
 }
 
-func (s *Scanner) scanNewlines() (tok Token, lit string) {
-	var buf bytes.Buffer
-	buf.WriteRune(s.read())
+// Line returns the line, counting from 1, that the last token began on.
+func (s *Scanner) Line() int { return s.start }
+
+// Err returns the error that ended the scan early, or nil if it reached
+// the end of the config.
+func (s *Scanner) Err() error { return s.err }
+
+// fail ends the scan at a read error. A statement cut short by one is not
+// returned.
+func (s *Scanner) fail(err error) (Token, []byte) {
+	if err != io.EOF {
+		s.err = err
+	}
+	return EOF, nil
+}
 
+func (s *Scanner) scanNewlines() (tok Token, lit []byte) {
 	for {
-		if ch := s.read(); ch == eof {
+		if c, err := s.r.ReadByte(); err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
 			break
-		} else if !isNewline(ch) {
-			s.unread()
+		} else if c != '\n' {
+			s.r.UnreadByte()
 			break
-		} else {
-			buf.WriteRune(ch)
 		}
+		s.line++
+		s.buf = append(s.buf, '\n')
 	}
 
-	return BREAK, buf.String()
+	return BREAK, s.buf
 }
 
-func (s *Scanner) scanStatements() (tok Token, lit string) {
-	var buf bytes.Buffer
-	buf.WriteRune(s.read())
-
+func (s *Scanner) scanStatements() (tok Token, lit []byte) {
 	for {
-		if ch := s.read(); ch == eof {
+		line, err := s.r.ReadSlice('\n')
+		s.buf = append(s.buf, line...)
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			s.line++
+		}
+		if len(s.buf) > stressql.MaxStatementSize {
+			return TOOLONG, nil
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err == io.EOF {
 			break
-		} else if isNewline(ch) && isNewline(s.peek()) {
-			s.unread()
+		} else if err != nil {
+			return s.fail(err)
+		}
+
+		// A blank line ends the statement. The newline ending the last
+		// line is dropped; the blank line is scanned as a BREAK.
+		if next, err := s.r.Peek(1); err == nil && next[0] == '\n' {
+			s.buf = s.buf[:len(s.buf)-1]
 			break
-		} else if isNewline(ch) {
-			s.unread()
-			buf.WriteRune(ch)
-		} else {
-			buf.WriteRune(ch)
 		}
 	}
 
-	return STATEMENT, buf.String()
+	return STATEMENT, s.buf
 }
 
 //func main() {
@@ -154,45 +181,193 @@ func (s *Scanner) scanStatements() (tok Token, lit string) {
 //
 //}
 
+// ParseCommands parses the config in file.
 func ParseCommands(file string) ([]stressql.Statement, error) {
-	seq := []stressql.Statement{}
+	return ParseFile(file, nil)
+}
+
+// Progress is called while a config is read with the number of bytes read
+// so far and the size of the file.
+type Progress func(read, total int64)
 
+// ParseFile parses the config in file, reporting read progress to progress
+// if it is not nil.
+func ParseFile(file string, progress Progress) ([]stressql.Statement, error) {
 	f, err := os.Open(file)
-	check(err)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	s := NewScanner(f)
-	//fmt.Printf("%#v\n", s)
-	for {
-		t, l := s.Scan()
-		//fmt.Printf("%v %#v\n", t, l)
-		if t == EOF {
-			break
+	var r io.Reader = f
+	if progress != nil {
+		var total int64
+		if fi, err := f.Stat(); err == nil {
+			total = fi.Size()
 		}
-		_, err := influxql.ParseStatement(l)
-		if err == nil {
-			//fmt.Println(state)
-			seq = append(seq, &stressql.InfluxqlStatement{Value: l})
-		} else if t == BREAK {
-			continue
-		} else {
-			f := strings.NewReader(l)
-			p := stressql.NewParser(f)
-			s, err := p.Parse()
-			if err != nil {
-				return nil, err
+		r = &progressReader{r: f, total: total, fn: progress}
+	}
+
+	return parseReader(r, file)
+}
+
+type progressReader struct {
+	r     io.Reader
+	read  int64
+	total int64
+	fn    Progress
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	p.fn(p.read, p.total)
+	return n, err
+}
+
+type block struct {
+	i       int
+	lit     string
+	line    int
+	version int
+	err     error
+}
+
+type parsed struct {
+	i    int
+	stmt stressql.Statement
+	err  error
+}
+
+// ParseReader parses a config as it is read. Statements are independent
+// once split, so they are parsed by a pool of workers while scanning
+// continues, and reassembled in order. Scanning stops at the first error.
+// Each statement records the lines it was read from, which errors name.
+func ParseReader(r io.Reader) ([]stressql.Statement, error) {
+	return parseReader(r, "")
+}
+
+// parseReader is ParseReader for a config read from file.
+func parseReader(r io.Reader, file string) ([]stressql.Statement, error) {
+	workers := runtime.GOMAXPROCS(0)
+	blocks := make(chan block, workers*4)
+	results := make(chan parsed, workers*4)
+
+	var failed int32
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range blocks {
+				text := strings.TrimRight(b.lit, "\n")
+				pos := stressql.Pos{
+					File:    file,
+					Line:    b.line,
+					EndLine: b.line + strings.Count(text, "\n"),
+					Text:    text,
+				}
+				var stmt stressql.Statement
+				err := b.err
+				if err == nil {
+					stmt, err = parseBlock(b.lit, b.version)
+				}
+				if _, ok := stmt.(*stressql.VersionStatement); ok && b.i > 0 {
+					err = fmt.Errorf("VERSION must be the first statement")
+				}
+				if err != nil {
+					pos.EndLine = 0
+					err = fmt.Errorf("%s: %v", pos.Location(), err)
+					atomic.StoreInt32(&failed, 1)
+				} else {
+					*stmt.Position() = pos
+					if g, ok := stmt.(*stressql.GoStatement); ok {
+						*g.Statement.Position() = pos
+					}
+				}
+				results <- parsed{i: b.i, stmt: stmt, err: err}
 			}
-			seq = append(seq, s)
+		}()
+	}
+
+	go func() {
+		defer close(blocks)
 
+		s := NewScanner(r)
+		version := stressql.Version1
+		for i := 0; atomic.LoadInt32(&failed) == 0; {
+			t, l := s.Scan()
+			if t == EOF {
+				if err := s.Err(); err != nil {
+					blocks <- block{i: i, line: s.Line(), err: err}
+				}
+				return
+			} else if t == BREAK {
+				continue
+			} else if t == TOOLONG {
+				blocks <- block{i: i, line: s.Line(), err: fmt.Errorf("statement exceeds %d bytes", stressql.MaxStatementSize)}
+				return
+			}
+			if i == 0 {
+				// A VERSION pragma changes how the statements after it
+				// parse.
+				if st, err := stressql.ParseStatement(l); err == nil {
+					if v, ok := st.(*stressql.VersionStatement); ok {
+						version, _ = v.Number()
+					}
+				}
+			}
+			blocks <- block{i: i, lit: l, line: s.Line(), version: version}
+			i++
 		}
-	}
+	}()
 
-	//fmt.Println(seq)
-	//for _, step := range seq {
-	//	fmt.Printf("%#v\n", step)
-	//}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
 
-	f.Close()
+	var seq []stressql.Statement
+	errAt, errIdx := error(nil), -1
+	for p := range results {
+		if p.err != nil {
+			if errIdx < 0 || p.i < errIdx {
+				errAt, errIdx = p.err, p.i
+			}
+			continue
+		}
+		for len(seq) <= p.i {
+			seq = append(seq, nil)
+		}
+		seq[p.i] = p.stmt
+	}
 
+	if errAt != nil {
+		return nil, errAt
+	}
+	if seq == nil {
+		seq = []stressql.Statement{}
+	}
 	return seq, nil
+}
 
+// ParseString parses a config held in memory, such as one sent to a
+// service. Like ParseReader, it reports malformed input of any kind as an
+// error.
+func ParseString(src string) ([]stressql.Statement, error) {
+	return ParseReader(strings.NewReader(src))
+}
+
+// parseBlock parses a statement as InfluxQL, falling back to the given
+// version of stressql.
+func parseBlock(l string, version int) (stmt stressql.Statement, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stmt, err = nil, fmt.Errorf("parse: internal error: %v", r)
+		}
+	}()
+	if _, err := influxql.ParseStatement(l); err == nil {
+		return &stressql.InfluxqlStatement{Value: l}, nil
+	}
+	return stressql.ParseStatementVersion(l, version)
 }
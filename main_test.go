@@ -0,0 +1,47 @@
+package mdstress
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const scanConfig = "SET database stress\n\nINSERT cpu\ncpu,\nhost=server-[int inc(0) 1000]\nbusy=[float rand(100) 0]\n100000 10s\n\nWAIT\n"
+
+func TestParseReaderError(t *testing.T) {
+	// The statements before a read error are not returned as the config.
+	boom := errors.New("boom")
+	seq, err := ParseReader(io.MultiReader(strings.NewReader(scanConfig), iotest.ErrReader(boom)))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("ParseReader returned %d statements and %v, want %v", len(seq), err, boom)
+	}
+}
+
+func TestScanBytesDoesNotAllocate(t *testing.T) {
+	r := strings.NewReader(scanConfig)
+	s := NewScanner(r)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(scanConfig)
+		s.r.Reset(r)
+		for tok, _ := s.ScanBytes(); tok != EOF; tok, _ = s.ScanBytes() {
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("scanning allocated %v times, want 0", allocs)
+	}
+}
+
+func BenchmarkScanBytes(b *testing.B) {
+	r := strings.NewReader(scanConfig)
+	s := NewScanner(r)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(scanConfig)))
+	for i := 0; i < b.N; i++ {
+		r.Reset(scanConfig)
+		s.r.Reset(r)
+		for tok, _ := s.ScanBytes(); tok != EOF; tok, _ = s.ScanBytes() {
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package mdstress
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// QueryLogOptions shapes the read workload synthesized from a query log.
+type QueryLogOptions struct {
+	// Total is the number of queries run, split between the statements by
+	// how often each query was logged. Zero runs each as often as logged.
+	Total int64
+	// Top keeps only the most frequent queries; zero keeps them all.
+	Top int
+}
+
+var (
+	// accessLogRequest matches the request of an HTTP access log line, as
+	// in "GET /query?db=stress&q=SELECT... HTTP/1.1".
+	accessLogRequest = regexp.MustCompile(`"[A-Z]+ (/\S*) HTTP/[\d.]+"`)
+	// serverLogQuery matches the query of an influxd "Executing query"
+	// log line, as in `query="SELECT ..."`, and serverLogDatabase its
+	// database, if logged.
+	serverLogQuery    = regexp.MustCompile(`\bquery="((?:[^"\\]|\\.)*)"`)
+	serverLogDatabase = regexp.MustCompile(`\b(?:database|db)=("(?:[^"\\]|\\.)*"|\S+)`)
+	// countedQuery matches a line of a plain list, "count<TAB>query".
+	countedQuery = regexp.MustCompile(`^(\d+)\s+(.+)$`)
+)
+
+// loggedQuery is a distinct query and the number of times it was logged.
+type loggedQuery struct {
+	db    string
+	query string
+	count int64
+}
+
+// ImportQueryLog reads queries from an InfluxDB HTTP access log, an influxd
+// log with query logging enabled, or a plain list of queries, one per line,
+// each optionally preceded by the number of times it runs. It synthesizes
+// one concurrent QUERY per distinct query, run in proportion to how often
+// it was logged, so a stress run's reads mirror the logged traffic.
+//
+// Queries containing %, which the DSL reads as a template variable, are
+// skipped.
+func ImportQueryLog(file string, opts QueryLogOptions) ([]stressql.Statement, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := map[[2]string]*loggedQuery{}
+	var queries []*loggedQuery
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		db, q, count, err := parseQueryLogLine(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		q = strings.Join(strings.Fields(q), " ")
+		if q == "" || strings.Contains(q, "%") {
+			continue
+		}
+		k := [2]string{db, q}
+		if lq := seen[k]; lq != nil {
+			if lq.count, err = stressql.AddCounts(lq.count, count); err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			continue
+		}
+		seen[k] = &loggedQuery{db: db, query: q, count: count}
+		queries = append(queries, seen[k])
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no queries found")
+	}
+
+	sort.SliceStable(queries, func(i, j int) bool { return queries[i].count > queries[j].count })
+	if opts.Top > 0 && len(queries) > opts.Top {
+		queries = queries[:opts.Top]
+	}
+	// Group queries by database so each needs only one USE.
+	sort.SliceStable(queries, func(i, j int) bool { return queries[i].db < queries[j].db })
+
+	var sum int64
+	for _, q := range queries {
+		var err error
+		if sum, err = stressql.AddCounts(sum, q.count); err != nil {
+			return nil, err
+		}
+	}
+
+	seq := []stressql.Statement{}
+	db := ""
+	for i, q := range queries {
+		if q.db != db && q.db != "" {
+			seq = append(seq, &stressql.UseStatement{Database: q.db})
+			db = q.db
+		}
+		count := q.count
+		if opts.Total > 0 {
+			count = int64(math.Round(float64(opts.Total) * float64(q.count) / float64(sum)))
+			if count < 1 {
+				count = 1
+			}
+		}
+		seq = append(seq, &stressql.GoStatement{Statement: &stressql.QueryStatement{
+			Name:           "q" + strconv.Itoa(i+1),
+			TemplateString: q.query,
+			Count:          strconv.FormatInt(count, 10),
+		}})
+	}
+	seq = append(seq, &stressql.WaitStatement{})
+
+	return seq, nil
+}
+
+// parseQueryLogLine returns the database and query of a log line, and the
+// number of times it ran. Lines that are not queries return no query.
+func parseQueryLogLine(line string) (db, query string, count int64, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", 0, nil
+	}
+
+	if m := accessLogRequest.FindStringSubmatch(line); m != nil {
+		u, err := url.Parse(m[1])
+		if err != nil {
+			return "", "", 0, err
+		}
+		if u.Path != "/query" {
+			return "", "", 0, nil
+		}
+		v := u.Query()
+		return v.Get("db"), v.Get("q"), 1, nil
+	}
+
+	if m := serverLogQuery.FindStringSubmatch(line); m != nil {
+		query, err := strconv.Unquote(`"` + m[1] + `"`)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("invalid query %s", m[1])
+		}
+		if m := serverLogDatabase.FindStringSubmatch(line); m != nil {
+			db = m[1]
+			if strings.HasPrefix(db, `"`) {
+				db, _ = strconv.Unquote(db)
+			}
+		}
+		return db, query, 1, nil
+	}
+	if strings.Contains(line, "msg=") {
+		// Other influxd log lines.
+		return "", "", 0, nil
+	}
+
+	if m := countedQuery.FindStringSubmatch(line); m != nil {
+		count, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return "", "", 0, err
+		}
+		return "", m[2], count, nil
+	}
+	return "", line, 1, nil
+}
@@ -0,0 +1,189 @@
+package mdstress
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// A Scenario is a canned workload exercising one way a server is commonly
+// stressed, so a meaningful run needs no config. Its size grows linearly
+// with a scale: 1 runs in a few minutes against a laptop server, and 10
+// writes ten times the series or queries.
+type Scenario struct {
+	Name        string
+	Description string
+	// Workload returns the scenario at a scale of at least 1.
+	Workload func(scale int64) *Workload
+}
+
+// Statements returns the statements of the scenario at scale.
+func (s Scenario) Statements(scale int64) ([]stressql.Statement, error) {
+	if scale < 1 {
+		return nil, fmt.Errorf("scenario %s: scale must be at least 1, got %d", s.Name, scale)
+	}
+	return s.Workload(scale).Statements()
+}
+
+var scenarios = []Scenario{
+	{"high-cardinality", "ingest into an ever larger number of series, watching the series cardinality", HighCardinality},
+	{"dashboard-storm", "many dashboards refreshing panels at once over an hour of data", DashboardStorm},
+	{"backfill-live", "a day of history written while live agents keep writing and reading", BackfillLive},
+	{"delete-churn", "series replaced as they are written while old data is deleted and series dropped", DeleteChurn},
+}
+
+// Scenarios returns the built-in scenarios.
+func Scenarios() []Scenario {
+	return append([]Scenario(nil), scenarios...)
+}
+
+// LookupScenario returns the built-in scenario called name.
+func LookupScenario(name string) (Scenario, error) {
+	names := make([]string, len(scenarios))
+	for i, s := range scenarios {
+		if strings.EqualFold(s.Name, name) {
+			return s, nil
+		}
+		names[i] = s.Name
+	}
+	return Scenario{}, fmt.Errorf("unknown scenario %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// scenarioSetup creates the database the scenarios write to, unless
+// WithVars sets another database over theirs.
+var scenarioSetup = []string{"CREATE DATABASE stress"}
+
+// cpuFields are the fields of the cpu measurement the scenarios write, as
+// Telegraf's cpu plugin reports them.
+var cpuFields = []WorkloadField{
+	{Key: "usage_user", Generator: "float rand(100) 0"},
+	{Key: "usage_system", Generator: "float rand(100) 0"},
+	{Key: "usage_idle", Generator: "float rand(100) 0"},
+}
+
+// cpuMeasurement writes hosts hosts in each of four regions of the cpu
+// measurement, for steps steps of interval.
+func cpuMeasurement(name string, hosts, steps int64, interval string) WorkloadMeasurement {
+	return WorkloadMeasurement{
+		Name:        name,
+		Measurement: "cpu",
+		Tags: []WorkloadTag{
+			{Key: "region", Values: []string{"us-west", "us-east", "eu-north", "ap-south"}},
+			{Key: "host", Generator: fmt.Sprintf("str rand(12) %d", hosts)},
+		},
+		Fields:   cpuFields,
+		Points:   4 * hosts * steps,
+		Interval: interval,
+	}
+}
+
+// HighCardinality writes 100,000 series per unit of scale, ten points
+// each, in batches touching every series, and polls the series
+// cardinality as the index grows.
+func HighCardinality(scale int64) *Workload {
+	return &Workload{
+		Vars:  map[string]string{"database": "stress"},
+		Setup: scenarioSetup,
+		Phases: []WorkloadPhase{{
+			Name:       "ingest",
+			Concurrent: true,
+			Measurements: []WorkloadMeasurement{{
+				Name:        "ingest",
+				Measurement: "containers",
+				Tags: []WorkloadTag{
+					{Key: "region", Values: []string{"us-west", "us-east", "eu-north", "ap-south"}},
+					{Key: "host", Generator: fmt.Sprintf("str rand(12) %d", 250*scale)},
+					{Key: "container", Generator: "str rand(16) 100"},
+				},
+				Fields: []WorkloadField{
+					{Key: "cpu", Generator: "float rand(100) 0"},
+					{Key: "mem", Generator: "int rand(1073741824) 0"},
+				},
+				Points:   1000000 * scale,
+				Interval: "10s",
+			}},
+			Meta: []WorkloadMeta{{Query: "SHOW SERIES CARDINALITY", Interval: "30s"}},
+		}},
+	}
+}
+
+// DashboardStorm writes an hour of cpu data, 400 series per unit of
+// scale, then refreshes the panels of 20 dashboards per unit of scale at
+// once, each panel query issued by eight viewers together.
+func DashboardStorm(scale int64) *Workload {
+	return &Workload{
+		Vars:  map[string]string{"database": "stress"},
+		Setup: scenarioSetup,
+		Phases: []WorkloadPhase{
+			{
+				Name:         "seed",
+				Measurements: []WorkloadMeasurement{cpuMeasurement("seed", 100*scale, 360, "10s")},
+			},
+			{
+				Name:       "storm",
+				Vars:       map[string]string{"queryConcurrency": fmt.Sprint(8 * scale)},
+				Concurrent: true,
+				Queries: []WorkloadQuery{
+					{Name: "overview", Query: "SELECT mean(usage_user) FROM cpu WHERE time > now() - 1h GROUP BY time(1m), region", Count: 20 * scale, Fanout: 8},
+					{Name: "hosts", Query: "SELECT max(usage_system) FROM cpu WHERE time > now() - 15m GROUP BY time(10s), host", Count: 20 * scale, Fanout: 8},
+					{Name: "latest", Query: "SELECT last(usage_idle) FROM cpu GROUP BY host", Count: 20 * scale, Fanout: 8},
+				},
+			},
+		},
+	}
+}
+
+// BackfillLive backfills a day of cpu data, 400 series per unit of
+// scale, while as many live series write every 10s for five minutes and a
+// dashboard reads what they write.
+func BackfillLive(scale int64) *Workload {
+	live := cpuMeasurement("live", 100*scale, 30, "10s")
+	live.RealTime = true
+	return &Workload{
+		Vars:  map[string]string{"database": "stress"},
+		Setup: scenarioSetup,
+		Phases: []WorkloadPhase{{
+			Name:         "mix",
+			Concurrent:   true,
+			Measurements: []WorkloadMeasurement{cpuMeasurement("backfill", 100*scale, 8640, "10s"), live},
+			Queries: []WorkloadQuery{
+				{Name: "recent", Query: "SELECT mean(usage_user) FROM cpu WHERE time > now() - 5m GROUP BY time(10s)", Count: 300 * scale},
+			},
+		}},
+	}
+}
+
+// DeleteChurn writes six hours of data for 4,000 series per unit of
+// scale, a tenth of them replaced by new ones every ten minutes of data,
+// while data older than three hours is deleted and a region's series are
+// dropped on a schedule.
+func DeleteChurn(scale int64) *Workload {
+	return &Workload{
+		Vars:  map[string]string{"database": "stress"},
+		Setup: scenarioSetup,
+		Phases: []WorkloadPhase{{
+			Name:       "churn",
+			Concurrent: true,
+			Measurements: []WorkloadMeasurement{{
+				Name:        "churn",
+				Measurement: "sessions",
+				Tags: []WorkloadTag{
+					{Key: "region", Values: []string{"us-west", "us-east", "eu-north", "ap-south"}},
+					{Key: "session", Generator: fmt.Sprintf("str rand(16) %d churn(10) 10m", 1000*scale)},
+				},
+				Fields: []WorkloadField{
+					{Key: "requests", Generator: "int rand(1000) 0"},
+					{Key: "latency", Generator: "float rand(500) 0"},
+				},
+				Points:   4 * 1000 * scale * 2160,
+				Interval: "10s",
+			}},
+			Meta: []WorkloadMeta{
+				{Query: "DELETE FROM sessions WHERE time < now() - 3h", Interval: "10s"},
+				{Query: "DROP SERIES FROM sessions WHERE region = 'ap-south'", Interval: "30s"},
+				{Query: "SHOW SERIES CARDINALITY", Interval: "30s"},
+			},
+		}},
+	}
+}
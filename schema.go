@@ -0,0 +1,119 @@
+package mdstress
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// ParseJSON reads a workload in its JSON representation and converts it to
+// statements. The document has the same shape as the YAML representation
+// and is described by WorkloadSchema.
+func ParseJSON(file string) ([]stressql.Statement, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	w := &Workload{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(w); err != nil {
+		return nil, err
+	}
+
+	return w.Statements()
+}
+
+// generatorFunctions match the generator functions with their arguments,
+// as in rand(100), pattern("srv-[a-z]{3}") or ipv4("10.0.0.0/16").
+var generatorFunctions = []string{
+	`(rand|inc|RAND|INC)\s*\(\s*\d+\s*\)`,
+	`(special|SPECIAL)\s*\(\s*\d+\s*\)`,
+	`pattern\s*\(\s*"[^"]*"\s*\)`,
+	`ipv4\s*\(\s*"[0-9.]+/\d+"\s*\)`,
+	`ipv6\s*\(\s*"[0-9A-Fa-f:.]+/\d+"\s*\)`,
+	`mac\s*\(\s*\)`,
+	`empirical\s*\(\s*"[^"]+"\s*\)`,
+}
+
+// generatorPattern matches a generator: a type, a function and a count of
+// values, which may churn, or vary by a tag's value, as in
+// by(env, prod=90, dev=30).
+var generatorPattern = `^(int|float|str|INT|FLOAT|STR)\s+(` + strings.Join(generatorFunctions, "|") + `)\s+\d+` +
+	`(\s+churn\s*\(\s*\d+\s*\)\s+\d+(ns|us|µs|ms|s|m|h)` +
+	`|\s+by\s*\(\s*[^,()\s]+(\s*,\s*[^,()=]+=\s*("[^"]*"|[^,()"\s]+))+\s*\))*$`
+
+// schemaPatterns constrains string properties by name.
+var schemaPatterns = map[string]string{
+	"generator": generatorPattern,
+	"interval":  `^\d+(ns|us|µs|ms|s|m|h)$`,
+}
+
+// schemaRequired lists the properties each workload type must set.
+var schemaRequired = map[reflect.Type][]string{
+	reflect.TypeOf(WorkloadMeasurement{}): {"measurement", "fields", "points", "interval"},
+	reflect.TypeOf(WorkloadTag{}):         {"key"},
+	reflect.TypeOf(WorkloadField{}):       {"key", "generator"},
+	reflect.TypeOf(WorkloadQuery{}):       {"query", "count"},
+	reflect.TypeOf(WorkloadMeta{}):        {"query", "interval"},
+}
+
+// WorkloadSchema returns a JSON Schema describing the JSON and YAML
+// workload representation, for editor validation and CI checks.
+func WorkloadSchema() ([]byte, error) {
+	schema := typeSchema(reflect.TypeOf(Workload{}), "")
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "stressql workload"
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func typeSchema(t reflect.Type, name string) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := strings.Split(f.Tag.Get("json"), ",")[0]
+			if key == "" || key == "-" {
+				continue
+			}
+			props[key] = typeSchema(f.Type, key)
+		}
+
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if req, ok := schemaRequired[t]; ok {
+			s["required"] = req
+		}
+		return s
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem(), ""),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem(), ""),
+		}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	}
+
+	s := map[string]interface{}{"type": "string"}
+	if p, ok := schemaPatterns[name]; ok {
+		s["pattern"] = p
+	}
+	return s
+}
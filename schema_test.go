@@ -0,0 +1,43 @@
+package mdstress
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSchemaGeneratorPattern(t *testing.T) {
+	re := regexp.MustCompile(schemaPatterns["generator"])
+	for _, tt := range []struct {
+		generator string
+		valid     bool
+	}{
+		{"int rand(100) 0", true},
+		{"str rand(12) 1000 churn(10) 1h", true},
+		{`str pattern("srv-[a-z]{3}-[0-9]{2}") 100`, true},
+		{"str pattern(srv) 100", false},
+		{`str ipv4("10.0.0.0/16") 1000`, true},
+		{`str ipv6("2001:db8::/64") 1000`, true},
+		{"str mac() 1000", true},
+		{`str ipv4("10.0.0.0") 1000`, false},
+		{`float empirical("latency.csv:p99") 0`, true},
+		{`int empirical("latency.csv") 0`, true},
+		{`float empirical("") 0`, false},
+		{"float rand(100) 0 by(env, prod=90, dev=30)", true},
+		{`str pattern("a-[0-9]") 0 by(region, "us-west"="b-x")`, true},
+		{"float rand(100) 0 by(env)", false},
+		{"float rand(100) 0 by(env, prod)", false},
+		{"int rand(100)", false},
+		{"int rnd(100) 0", false},
+		{"INT RAND(100) 0", true},
+		{"str special(8) 100", true},
+		{"str special() 100", false},
+		{"str ipv4(10) 1000", false},
+	} {
+		if got := re.MatchString(tt.generator); got != tt.valid {
+			t.Errorf("%s: matched %v, want %v", tt.generator, got, tt.valid)
+		}
+		if _, err := parseGenerator(tt.generator); tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.generator, err)
+		}
+	}
+}
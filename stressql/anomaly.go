@@ -0,0 +1,159 @@
+package stressql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of anomaly.
+const (
+	// AnomalySpike multiplies a series' numeric fields by Factor, 10 by
+	// default, for an outlier.
+	AnomalySpike = "spike"
+	// AnomalyShift multiplies them by Factor, 2 by default, for a level
+	// shift; give it a FOR so the shift lasts.
+	AnomalyShift = "shift"
+	// AnomalyGap skips a series' points for a gap in the data.
+	AnomalyGap = "gap"
+)
+
+// anomaly is a compiled ANOMALY modifier. Each series' steps are split into
+// windows of steps, and a rate fraction of windows are anomalous. Which ones
+// is a pure function of the series and window, so the anomalies a run
+// injected can be recovered with Generator.Anomaly.
+type anomaly struct {
+	kind   string
+	rate   float64
+	factor float64
+	steps  int64
+	salt   uint64
+}
+
+func compileAnomalies(specs []*AnomalySpec, interval time.Duration) ([]anomaly, error) {
+	var as []anomaly
+	for n, s := range specs {
+		a := anomaly{kind: s.Kind, steps: 1, salt: mix(anomalySalt + uint64(n))}
+		switch s.Kind {
+		case AnomalySpike:
+			a.factor = 10
+		case AnomalyShift:
+			a.factor = 2
+		case AnomalyGap:
+			if s.Factor != "" {
+				return nil, fmt.Errorf("a gap anomaly takes no factor")
+			}
+		default:
+			return nil, fmt.Errorf("unknown anomaly %q, expected spike, shift or gap", s.Kind)
+		}
+
+		var err error
+		if a.rate, err = anomalyRate(s.Rate); err != nil {
+			return nil, err
+		}
+		if s.Factor != "" {
+			if a.factor, err = strconv.ParseFloat(s.Factor, 64); err != nil || a.factor <= 0 {
+				return nil, fmt.Errorf("invalid anomaly factor %q", s.Factor)
+			}
+		}
+		if s.For != "" {
+			d, err := time.ParseDuration(s.For)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid anomaly duration %q", s.For)
+			}
+			a.steps = int64((d + interval - 1) / interval)
+		}
+		as = append(as, a)
+	}
+	return as, nil
+}
+
+// anomalyRate parses a rate written as a fraction, "1/1000", or as a
+// percentage, "5" or "5%".
+func anomalyRate(s string) (float64, error) {
+	var r float64
+	num, den, frac := strings.Cut(strings.TrimSuffix(s, "%"), "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if frac {
+		d, derr := strconv.ParseFloat(den, 64)
+		if derr != nil || d == 0 {
+			err = derr
+		} else {
+			r = n / d
+		}
+	} else {
+		r = n / 100
+	}
+	if err != nil || r <= 0 || r > 1 {
+		return 0, fmt.Errorf("invalid anomaly rate %q, expected a fraction or percentage", s)
+	}
+	return r, nil
+}
+
+const anomalySalt = 0x616e6f6d616c79
+
+func (a *anomaly) hits(series, step uint64) bool {
+	return unitFloat(mix(a.salt^series<<32^step/uint64(a.steps))) < a.rate
+}
+
+// Anomaly returns the kind of anomaly injected into point i, or "" if it is
+// a normal point. A gap takes precedence, since the point is not written;
+// otherwise a point in several anomalies reports the first.
+func (g *Generator) Anomaly(i int64) string {
+	if g.gap(i) {
+		return AnomalyGap
+	}
+	series, step := uint64(i%g.Series), uint64(i/g.Series)
+	for n := range g.anomalies {
+		if g.anomalies[n].hits(series, step) {
+			return g.anomalies[n].kind
+		}
+	}
+	return ""
+}
+
+// gap reports whether point i falls in a gap anomaly.
+func (g *Generator) gap(i int64) bool {
+	series, step := uint64(i%g.Series), uint64(i/g.Series)
+	for n := range g.anomalies {
+		if a := &g.anomalies[n]; a.kind == AnomalyGap && a.hits(series, step) {
+			return true
+		}
+	}
+	return false
+}
+
+// factor returns the product of the factors of the anomalies point is in.
+func (g *Generator) factor(series, point uint64) float64 {
+	f, step := 1.0, point/uint64(g.Series)
+	for n := range g.anomalies {
+		if a := &g.anomalies[n]; a.kind != AnomalyGap && a.hits(series, step) {
+			f *= a.factor
+		}
+	}
+	return f
+}
+
+// anomalous wraps a numeric field so anomalies scale its value.
+func (g *Generator) anomalous(p part, kind valueKind) part {
+	return func(b []byte, series, point uint64) []byte {
+		f := g.factor(series, point)
+		if f == 1 {
+			return p(b, series, point)
+		}
+		n := len(b)
+		if b = p(b, series, point); len(b) <= n {
+			// The field was left out.
+			return b
+		}
+		if kind == kindInt {
+			v, _ := strconv.ParseInt(string(b[n:len(b)-1]), 10, 64)
+			b = strconv.AppendInt(b[:n], int64(math.Round(float64(v)*f)), 10)
+			return append(b, 'i')
+		}
+		v, _ := strconv.ParseFloat(string(b[n:]), 64)
+		return strconv.AppendFloat(b[:n], v*f, 'f', -1, 64)
+	}
+}
@@ -0,0 +1,192 @@
+package stressql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// WriteResult writes r as indented JSON, the format of run reports and
+// baselines.
+func WriteResult(w io.Writer, r *RunResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// SaveBaseline writes r to file as a baseline for later runs.
+func SaveBaseline(file string, r *RunResult) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := WriteResult(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadResult reads a run report or baseline written by WriteResult.
+func LoadResult(file string) (*RunResult, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &RunResult{}
+	if err := json.NewDecoder(f).Decode(r); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return r, nil
+}
+
+// CompareOptions sets how far a run may stray from its baseline.
+type CompareOptions struct {
+	// Tolerance is the fraction by which a metric may get worse before it
+	// counts as a regression, e.g. 0.15 for 15%.
+	Tolerance float64
+}
+
+// Comparison is the difference between a run and its baseline.
+type Comparison struct {
+	Rows []ComparisonRow
+	// Missing lists baseline statements the run did not have.
+	Missing []string
+}
+
+// ComparisonRow compares one metric of one statement.
+type ComparisonRow struct {
+	Statement string
+	Metric    string
+	Baseline  float64
+	Current   float64
+	// Change is the relative change from the baseline; positive is worse.
+	Change    float64
+	Regressed bool
+}
+
+// Regressed reports whether any metric regressed beyond the tolerance.
+func (c *Comparison) Regressed() bool {
+	for _, r := range c.Rows {
+		if r.Regressed {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTo writes the comparison as a table.
+func (c *Comparison) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATEMENT\tMETRIC\tBASELINE\tCURRENT\tCHANGE\t")
+	for _, r := range c.Rows {
+		mark := ""
+		if r.Regressed {
+			mark = "REGRESSED"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%+.1f%%\t%s\n", r.Statement, r.Metric,
+			formatMetric(r.Metric, r.Baseline), formatMetric(r.Metric, r.Current), r.Change*100, mark)
+	}
+	tw.Flush()
+	for _, m := range c.Missing {
+		fmt.Fprintf(cw, "missing from run: %s\n", m)
+	}
+	return cw.n, cw.err
+}
+
+func formatMetric(metric string, v float64) string {
+	switch metric {
+	case "errors":
+		return fmt.Sprintf("%.3f%%", v*100)
+	case "throughput":
+		return fmt.Sprintf("%.0f/s", v)
+	}
+	return time.Duration(v).String()
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	if c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Compare compares each statement of cur with the statement of the same
+// name and phase in base: latency percentiles and error rate regress when
+// they rise, throughput when it falls.
+func Compare(base, cur *RunResult, opts CompareOptions) *Comparison {
+	c := &Comparison{}
+	current := map[string]StatementResult{}
+	for _, s := range cur.Statements {
+		current[resultKey(s)] = s
+	}
+
+	for _, b := range base.Statements {
+		key := resultKey(b)
+		s, ok := current[key]
+		if !ok {
+			c.Missing = append(c.Missing, key)
+			continue
+		}
+
+		add := func(metric string, old, new float64, higherIsWorse bool) {
+			row := ComparisonRow{Statement: key, Metric: metric, Baseline: old, Current: new}
+			switch {
+			case old != 0:
+				row.Change = (new - old) / old
+			case new != 0:
+				row.Change = 1
+			}
+			if !higherIsWorse {
+				row.Change = -row.Change
+			}
+			row.Regressed = row.Change > opts.Tolerance
+			c.Rows = append(c.Rows, row)
+		}
+		add("p50", float64(b.Latency.P50), float64(s.Latency.P50), true)
+		add("p95", float64(b.Latency.P95), float64(s.Latency.P95), true)
+		add("p99", float64(b.Latency.P99), float64(s.Latency.P99), true)
+		add("errors", errorRate(b), errorRate(s), true)
+		if b.Points > 0 {
+			add("throughput", throughput(b), throughput(s), false)
+		}
+	}
+	sort.Strings(c.Missing)
+	return c
+}
+
+func resultKey(s StatementResult) string {
+	if s.Phase != "" {
+		return s.Phase + "/" + s.Name
+	}
+	return s.Name
+}
+
+func errorRate(s StatementResult) float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+func throughput(s StatementResult) float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Points) / s.Duration.Seconds()
+}
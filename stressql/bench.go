@@ -0,0 +1,28 @@
+package stressql
+
+import "io"
+
+// GenerateN writes the first n points of stmt to w as line protocol, or
+// all of them if it has fewer.
+func GenerateN(stmt *InsertStatement, n int, w io.Writer) error {
+	g, err := Compile(stmt)
+	if err != nil {
+		return err
+	}
+	if int64(n) > g.Points {
+		n = int(g.Points)
+	}
+
+	buf := make([]byte, 0, 64<<10)
+	for i := 0; i < n; i++ {
+		buf = g.AppendPoint(buf, int64(i))
+		if len(buf) >= 60<<10 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	_, err = w.Write(buf)
+	return err
+}
@@ -0,0 +1,117 @@
+package stressql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MemoryBudget bounds the memory a run holds in queued batches, histograms
+// and buffered results. Producers Acquire before allocating and block once
+// the budget is spent, so generation slows to the rate memory is released
+// instead of growing without bound.
+//
+// A nil or zero-limit MemoryBudget never blocks.
+type MemoryBudget struct {
+	limit int64
+
+	mu    sync.Mutex
+	used  int64
+	freed chan struct{}
+}
+
+// NewMemoryBudget returns a budget of limit bytes.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit, freed: make(chan struct{})}
+}
+
+// Acquire reserves n bytes, waiting until they are available or ctx is done.
+// A reservation larger than the whole budget is granted once nothing else is
+// held, so it cannot deadlock.
+func (m *MemoryBudget) Acquire(ctx context.Context, n int64) error {
+	if m == nil || m.limit <= 0 {
+		return nil
+	}
+
+	for {
+		m.mu.Lock()
+		if m.used == 0 || m.used+n <= m.limit {
+			m.used += n
+			m.mu.Unlock()
+			return nil
+		}
+		freed := m.freed
+		m.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release returns n bytes to the budget.
+func (m *MemoryBudget) Release(n int64) {
+	if m == nil || m.limit <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	m.used -= n
+	close(m.freed)
+	m.freed = make(chan struct{})
+	m.mu.Unlock()
+}
+
+// Limit returns the budget in bytes.
+func (m *MemoryBudget) Limit() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.limit
+}
+
+// InUse returns the bytes currently reserved.
+func (m *MemoryBudget) InUse() int64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used
+}
+
+var sizeUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// ParseSize parses a byte size such as "512MB", "1GiB" or "1048576".
+func ParseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mult = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.n
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	size, ok := floatCount(n * float64(mult))
+	if !ok {
+		return 0, fmt.Errorf("size %q too large", s)
+	}
+	return size, nil
+}
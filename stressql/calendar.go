@@ -0,0 +1,375 @@
+package stressql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Calendar modes.
+const (
+	// CalendarDaily starts every day's steps at local midnight.
+	CalendarDaily = "daily"
+	// CalendarBusiness places steps only within business hours on
+	// weekdays.
+	CalendarBusiness = "business"
+)
+
+const day = 24 * time.Hour
+
+// Calendar places a Generator's steps at local wall-clock times in place
+// of every Interval from Start, so retention, shard boundaries and GROUP
+// BY time can be tested against data with a local daily pattern. A step
+// of an Interval shorter than a day is one of the Interval-long slots
+// into which it divides each day, or each day's business hours; a longer
+// one is a whole number of days, or of business days.
+//
+// Within a day, slots are Interval apart in absolute time from its start
+// up to its end, so none is repeated or skipped across a daylight saving
+// change: the day the clocks go forward has fewer, the day they go back
+// more, and a day's last slot may be less than an Interval before the
+// next day's first. Unix time has no leap seconds, so neither do slots.
+type Calendar struct {
+	Mode     string
+	Location *time.Location
+	// Open and Close bound business hours, as wall-clock times of day.
+	Open, Close time.Duration
+
+	interval time.Duration
+	// perDay is the number of slots on a day without a change of zone
+	// offset, if the interval is shorter than a day, and days otherwise
+	// the days per slot.
+	perDay, days int64
+
+	// tab numbers the slots of the days around those used so far.
+	mu  sync.Mutex
+	tab atomic.Pointer[slotTable]
+}
+
+// NewCalendar returns a Calendar of the given mode for steps of interval,
+// with business hours from open to close.
+func NewCalendar(mode string, loc *time.Location, open, close, interval time.Duration) (*Calendar, error) {
+	c := &Calendar{Mode: mode, Location: loc, Open: open, Close: close, interval: interval}
+	window := day
+	switch mode {
+	case CalendarDaily:
+		c.Open, c.Close = 0, day
+	case CalendarBusiness:
+		if open < 0 || close > day || open >= close {
+			return nil, fmt.Errorf("invalid business hours %v to %v", open, close)
+		}
+		window = close - open
+	default:
+		return nil, fmt.Errorf("unknown calendar %q, expected %s or %s", mode, CalendarDaily, CalendarBusiness)
+	}
+
+	switch {
+	case interval <= 0:
+		return nil, fmt.Errorf("invalid interval %v", interval)
+	case interval <= window:
+		if window%interval != 0 {
+			return nil, fmt.Errorf("%s calendar: interval %v does not divide %v", mode, interval, window)
+		}
+		c.perDay = int64(window / interval)
+	case interval%day == 0:
+		c.days = int64(interval / day)
+	default:
+		return nil, fmt.Errorf("%s calendar: interval %v is longer than %v but not a whole number of days", mode, interval, window)
+	}
+	return c, nil
+}
+
+// CalendarFromVars reads the calendar set in the DSL with
+//
+//	SET calendar business
+//	SET timeZone "America/New_York"
+//	SET businessHours "08:30-17:30"
+//
+// for steps of interval. It returns nil if no calendar is set. The time
+// zone defaults to UTC, and business hours to 09:00-17:00.
+func CalendarFromVars(vars map[string]string, interval time.Duration) (*Calendar, error) {
+	mode := vars["calendar"]
+	if mode == "" || mode == "off" {
+		return nil, nil
+	}
+	loc := time.UTC
+	if v := vars["timeZone"]; v != "" {
+		var err error
+		if loc, err = time.LoadLocation(v); err != nil {
+			return nil, fmt.Errorf("invalid timeZone %q: %v", v, err)
+		}
+	}
+	open, close := 9*time.Hour, 17*time.Hour
+	if v := vars["businessHours"]; v != "" {
+		var ok bool
+		if open, close, ok = parseHours(v); !ok {
+			return nil, fmt.Errorf("invalid businessHours %q, expected HH:MM-HH:MM", v)
+		}
+	}
+	return NewCalendar(mode, loc, open, close, interval)
+}
+
+// parseHours parses a range of times of day, as in 09:00-17:00. The end
+// may be 24:00.
+func parseHours(v string) (open, close time.Duration, ok bool) {
+	from, to, ok := strings.Cut(v, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	open, ok1 := parseTimeOfDay(strings.TrimSpace(from))
+	close, ok2 := parseTimeOfDay(strings.TrimSpace(to))
+	return open, close, ok1 && ok2
+}
+
+func parseTimeOfDay(v string) (time.Duration, bool) {
+	if v == "24:00" {
+		return day, true
+	}
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, true
+}
+
+// at returns the time of the wall clock reading offset on day d, counted
+// in days from 1970-01-01. A reading the clocks skip falls after the gap.
+func (c *Calendar) at(d int64, offset time.Duration) time.Time {
+	t := time.Date(1970, 1, 1+int(d), 0, 0, 0, int(offset), c.Location)
+	// time.Date may place a skipped reading before the gap, where the
+	// clock reads earlier than asked; move it past the gap by as much.
+	want := time.Date(1970, 1, 1+int(d), 0, 0, 0, int(offset), time.UTC)
+	_, off := t.Zone()
+	if got := t.Add(time.Duration(off) * time.Second).UTC(); got.Before(want) {
+		return t.Add(want.Sub(got))
+	}
+	return t
+}
+
+// window returns the start and end of the slots on day d, which for a
+// business calendar counts business days.
+func (c *Calendar) window(d int64) (time.Time, time.Time) {
+	if c.Mode == CalendarBusiness {
+		d = businessDay(d)
+	}
+	return c.at(d, c.Open), c.at(d, c.Close)
+}
+
+// dayOf returns the day of t, or, if t is not on a business day, the
+// business day before it and false.
+func (c *Calendar) dayOf(t time.Time) (int64, bool) {
+	y, m, dd := t.In(c.Location).Date()
+	d := time.Date(y, m, dd, 0, 0, 0, 0, time.UTC).Unix() / int64(day/time.Second)
+	if c.Mode == CalendarBusiness {
+		return businessIndex(d)
+	}
+	return d, true
+}
+
+// count returns the number of slots on day d.
+func (c *Calendar) count(d int64) int64 {
+	start, end := c.window(d)
+	if !end.After(start) {
+		return 0
+	}
+	return ceilDiv(int64(end.Sub(start)), int64(c.interval))
+}
+
+// SlotTime returns the time of slot n. Slot 0 is the first of 1970-01-01,
+// or for a business calendar of Monday 1970-01-05.
+func (c *Calendar) SlotTime(n int64) time.Time {
+	if c.days > 0 {
+		start, _ := c.window(n * c.days)
+		return start
+	}
+	t := c.tab.Load()
+	if t == nil {
+		t = c.tableFor(divFloor(n, c.perDay))
+	}
+	for {
+		d, k, ok := t.day(n)
+		if ok {
+			start, _ := c.window(d)
+			return start.Add(time.Duration(k) * c.interval)
+		}
+		t = c.tableFor(d)
+	}
+}
+
+// SlotAt returns the last slot at or before t.
+func (c *Calendar) SlotAt(t time.Time) int64 {
+	d, in := c.dayOf(t)
+	if in {
+		if start, _ := c.window(d); t.Before(start) {
+			d, in = d-1, false
+		}
+	}
+	if c.days > 0 {
+		return divFloor(d, c.days)
+	}
+	before, count := c.tableFor(d).slots(d)
+	if !in {
+		// The last slot of the day before.
+		return before + count - 1
+	}
+	start, _ := c.window(d)
+	k := int64(t.Sub(start) / c.interval)
+	if k >= count {
+		k = count - 1
+	}
+	return before + k
+}
+
+// slotTable numbers the slots of a range of days. Slots count from day 0,
+// so their numbers do not depend on the range.
+type slotTable struct {
+	lo, hi int64
+	perDay int64
+	// shift is the number of slots before day lo, less lo*perDay.
+	shift int64
+	// irregular are the days in the range with other than perDay slots,
+	// in order.
+	irregular []irregularDay
+}
+
+type irregularDay struct {
+	day, count, shift int64
+}
+
+// tableFor returns a table covering day d, extending the one kept if need
+// be.
+func (c *Calendar) tableFor(d int64) *slotTable {
+	if t := c.tab.Load(); t != nil && d >= t.lo && d < t.hi {
+		return t
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.tab.Load()
+	lo, hi := d, d+1
+	if lo > 0 {
+		lo = 0
+	}
+	if hi < 1 {
+		hi = 1
+	}
+	if t != nil {
+		if d >= t.lo && d < t.hi {
+			return t
+		}
+		if t.lo < lo {
+			lo = t.lo
+		}
+		if t.hi > hi {
+			hi = t.hi
+		}
+	}
+	// A year to spare, so a run rarely extends it again.
+	t = c.table(lo-366, hi+366)
+	c.tab.Store(t)
+	return t
+}
+
+// table builds a table of the days from lo to hi, which include day 0.
+func (c *Calendar) table(lo, hi int64) *slotTable {
+	t := &slotTable{lo: lo, hi: hi, perDay: c.perDay}
+	for d := lo; d < hi; {
+		if n := c.count(d); n != c.perDay {
+			t.irregular = append(t.irregular, irregularDay{day: d, count: n})
+		}
+		// Days are regular until the zone offset next changes.
+		start, _ := c.window(d)
+		_, end := start.ZoneBounds()
+		if end.IsZero() {
+			break
+		}
+		next, _ := c.dayOf(end)
+		if next--; next <= d {
+			next = d + 1
+		}
+		d = next
+	}
+
+	for _, e := range t.irregular {
+		if e.day < 0 {
+			t.shift -= e.count - c.perDay
+		}
+	}
+	shift := t.shift
+	for i := range t.irregular {
+		t.irregular[i].shift = shift
+		shift += t.irregular[i].count - c.perDay
+	}
+	return t
+}
+
+// slots returns the number of slots before day d, and on it.
+func (t *slotTable) slots(d int64) (int64, int64) {
+	i := sort.Search(len(t.irregular), func(i int) bool { return t.irregular[i].day >= d })
+	shift, count := t.shift, t.perDay
+	if i > 0 {
+		e := t.irregular[i-1]
+		shift = e.shift + e.count - t.perDay
+	}
+	if i < len(t.irregular) && t.irregular[i].day == d {
+		count = t.irregular[i].count
+	}
+	return d*t.perDay + shift, count
+}
+
+// day returns the day of slot n and its place in the day, and whether
+// the day is in the table; if not, the day is only an estimate.
+func (t *slotTable) day(n int64) (int64, int64, bool) {
+	i := sort.Search(len(t.irregular), func(i int) bool {
+		e := t.irregular[i]
+		return e.day*t.perDay+e.shift > n
+	})
+	shift := t.shift
+	if i > 0 {
+		e := t.irregular[i-1]
+		if before := e.day*t.perDay + e.shift; n < before+e.count {
+			return e.day, n - before, true
+		}
+		shift = e.shift + e.count - t.perDay
+	}
+	d, k := floorDiv(n-shift, t.perDay)
+	return d, k, d >= t.lo && d < t.hi
+}
+
+// epochMonday is 1970-01-05, the first Monday after the epoch, in days.
+const epochMonday = 4
+
+// businessDay returns the day of business day b, counted from Monday
+// 1970-01-05.
+func businessDay(b int64) int64 {
+	w, r := floorDiv(b, 5)
+	return epochMonday + w*7 + r
+}
+
+// businessIndex returns the business day of day d, or, if d is a
+// weekend, of the Friday before it and false.
+func businessIndex(d int64) (int64, bool) {
+	w, r := floorDiv(d-epochMonday, 7)
+	if r >= 5 {
+		return w*5 + 4, false
+	}
+	return w*5 + r, true
+}
+
+// floorDiv divides a by b, positive, rounding down, and returns the
+// quotient and the remainder, which is not negative.
+func floorDiv(a, b int64) (int64, int64) {
+	q, r := a/b, a%b
+	if r < 0 {
+		q, r = q-1, r+b
+	}
+	return q, r
+}
+
+// divFloor returns a/b, for b positive, rounded down.
+func divFloor(a, b int64) int64 {
+	q, _ := floorDiv(a, b)
+	return q
+}
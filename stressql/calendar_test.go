@@ -0,0 +1,138 @@
+package stressql
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestCalendarSlots(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		zone      string
+		mode      string
+		interval  time.Duration
+		from, to  string
+		counts    map[string]int64
+		exactGaps bool
+	}{
+		{
+			name: "spring forward", zone: "America/New_York", mode: CalendarDaily, interval: time.Hour,
+			from: "2024-03-09", to: "2024-03-12",
+			counts:    map[string]int64{"2024-03-09": 24, "2024-03-10": 23, "2024-03-11": 24},
+			exactGaps: true,
+		},
+		{
+			name: "fall back", zone: "America/New_York", mode: CalendarDaily, interval: time.Hour,
+			from: "2024-11-02", to: "2024-11-05",
+			counts:    map[string]int64{"2024-11-02": 24, "2024-11-03": 25, "2024-11-04": 24},
+			exactGaps: true,
+		},
+		{
+			// A day without a reading of 00:00 starts at 01:00.
+			name: "midnight skipped", zone: "America/Sao_Paulo", mode: CalendarDaily, interval: 15 * time.Minute,
+			from: "2018-11-03", to: "2018-11-06",
+			counts:    map[string]int64{"2018-11-03": 96, "2018-11-04": 92, "2018-11-05": 96},
+			exactGaps: true,
+		},
+		{
+			// 23:00 to midnight is read twice, on the day before.
+			name: "midnight repeated", zone: "America/Sao_Paulo", mode: CalendarDaily, interval: 15 * time.Minute,
+			from: "2019-02-15", to: "2019-02-18",
+			counts:    map[string]int64{"2019-02-15": 96, "2019-02-16": 100, "2019-02-17": 96},
+			exactGaps: true,
+		},
+		{
+			// 90m slots leave half an hour over on a 23h day.
+			name: "interval not dividing a short day", zone: "America/New_York", mode: CalendarDaily, interval: 90 * time.Minute,
+			from: "2024-03-09", to: "2024-03-12",
+			counts: map[string]int64{"2024-03-09": 16, "2024-03-10": 16, "2024-03-11": 16},
+		},
+		{
+			name: "months", zone: "America/New_York", mode: CalendarDaily, interval: time.Hour,
+			from: "2023-10-01", to: "2024-04-01",
+			exactGaps: true,
+		},
+		{
+			name: "business months", zone: "America/New_York", mode: CalendarBusiness, interval: 30 * time.Minute,
+			from: "2024-02-01", to: "2024-12-01",
+			counts: map[string]int64{"2024-03-08": 16, "2024-03-11": 16, "2024-11-04": 16},
+		},
+		{
+			name: "days", zone: "America/New_York", mode: CalendarDaily, interval: 2 * day,
+			from: "2024-01-01", to: "2024-12-31",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := NewCalendar(tt.mode, loc, 9*time.Hour, 17*time.Hour, tt.interval)
+			if err != nil {
+				t.Fatal(err)
+			}
+			date := func(s string) time.Time {
+				d, err := time.ParseInLocation("2006-01-02", s, loc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return d
+			}
+
+			counts := map[string]int64{}
+			from, to := c.SlotAt(date(tt.from)), c.SlotAt(date(tt.to))
+			prev := c.SlotTime(from - 1)
+			for n := from; n <= to; n++ {
+				st := c.SlotTime(n)
+				if got := c.SlotAt(st); got != n {
+					t.Fatalf("SlotAt(SlotTime(%d) = %v) = %d", n, st, got)
+				}
+				if got := c.SlotAt(st.Add(-1)); got != n-1 {
+					t.Fatalf("SlotAt(%v) = %d, want %d", st.Add(-1), got, n-1)
+				}
+				gap := st.Sub(prev)
+				if gap <= 0 || tt.exactGaps && gap != tt.interval || c.days == 0 && c.Mode == CalendarDaily && gap > tt.interval {
+					t.Fatalf("slot %d at %v, %v after the one before", n, st, gap)
+				}
+				counts[st.In(loc).Format("2006-01-02")]++
+				prev = st
+			}
+			for d, want := range tt.counts {
+				if counts[d] != want {
+					t.Errorf("%d slots on %s, want %d", counts[d], d, want)
+				}
+			}
+			if tt.exactGaps {
+				if want := int64(date(tt.to).Sub(date(tt.from)) / tt.interval); to-from != want {
+					t.Errorf("%d slots from %s to %s, want %d", to-from, tt.from, tt.to, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCalendarTableExtends(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Slots number the same whichever range of days was looked up first.
+	near, _ := NewCalendar(CalendarDaily, loc, 0, 0, time.Hour)
+	far, _ := NewCalendar(CalendarDaily, loc, 0, 0, time.Hour)
+	at := time.Date(2031, 11, 2, 12, 0, 0, 0, loc)
+	near.SlotAt(time.Date(1971, 1, 1, 0, 0, 0, 0, loc))
+	if a, b := near.SlotAt(at), far.SlotAt(at); a != b {
+		t.Fatalf("slot %d of a table extended over 60 years, %d of one built for it", a, b)
+	}
+
+	tab := near.tab.Load()
+	for d := tab.lo; d < tab.hi; d++ {
+		before, count := tab.slots(d)
+		for _, k := range []int64{0, count - 1} {
+			if gd, gk, ok := tab.day(before + k); !ok || gd != d || gk != k {
+				t.Fatalf("slot %d is %d of day %d (%v), want %d of day %d", before+k, gk, gd, ok, k, d)
+			}
+		}
+	}
+}
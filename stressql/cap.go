@@ -0,0 +1,98 @@
+package stressql
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Limit returns the number of points or bytes the cap allows.
+func (i *CapStatement) Limit() (int64, error) {
+	switch i.Kind {
+	case "MAXPOINTS":
+		// Counts take the suffixes rates do, and exponents, as in 1e9.
+		if f, ok := parseRate(i.Value); ok && f >= 1 {
+			if n, ok := floatCount(f); ok {
+				return n, nil
+			}
+		}
+	case "MAXBYTES":
+		if n, err := ParseSize(i.Value); err == nil && n > 0 {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: invalid limit %q", i.Kind, i.Value)
+}
+
+// WriteCap bounds the points and bytes a whole run generates, across its
+// INSERTs, so a config with a mistyped count cannot flood a shared server.
+// Once either limit is reached, generation stops: the batch that would
+// pass it is not sent, nor any after it.
+//
+// A nil WriteCap, or a zero limit, bounds nothing.
+type WriteCap struct {
+	MaxPoints int64
+	MaxBytes  int64
+
+	points, bytes int64
+	reached       int32
+}
+
+// NewWriteCap returns the cap set by the MAXPOINTS and MAXBYTES
+// statements in seq, the last of each applying, or nil if there are none.
+func NewWriteCap(seq []Statement) (*WriteCap, error) {
+	var c *WriteCap
+	for _, s := range seq {
+		s, ok := s.(*CapStatement)
+		if !ok {
+			continue
+		}
+		n, err := s.Limit()
+		if err != nil {
+			return nil, located(&s.Pos, err)
+		}
+		if c == nil {
+			c = &WriteCap{}
+		}
+		if s.Kind == "MAXPOINTS" {
+			c.MaxPoints = n
+		} else {
+			c.MaxBytes = n
+		}
+	}
+	return c, nil
+}
+
+// Take counts a batch of points against the cap, reporting false, and
+// counting nothing, if it would go over.
+func (c *WriteCap) Take(points, bytes int64) bool {
+	if c == nil {
+		return true
+	}
+	if atomic.LoadInt32(&c.reached) != 0 {
+		return false
+	}
+	p := atomic.AddInt64(&c.points, points)
+	b := atomic.AddInt64(&c.bytes, bytes)
+	if (c.MaxPoints > 0 && p > c.MaxPoints) || (c.MaxBytes > 0 && b > c.MaxBytes) {
+		atomic.AddInt64(&c.points, -points)
+		atomic.AddInt64(&c.bytes, -bytes)
+		atomic.StoreInt32(&c.reached, 1)
+		return false
+	}
+	return true
+}
+
+// Reached reports whether the cap has stopped generation.
+func (c *WriteCap) Reached() bool {
+	return c != nil && atomic.LoadInt32(&c.reached) != 0
+}
+
+// Err returns the error a run the cap stopped ends with, or nil.
+func (c *WriteCap) Err() error {
+	if !c.Reached() {
+		return nil
+	}
+	return &RunError{Status: StatusCapReached, Err: fmt.Errorf(
+		"write cap reached after %d points and %d bytes; generation stopped",
+		atomic.LoadInt64(&c.points), atomic.LoadInt64(&c.bytes))}
+}
@@ -0,0 +1,134 @@
+package stressql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of Capture.
+const (
+	CaptureWrite = "write"
+	CaptureQuery = "query"
+)
+
+// Capture is one body kept by a Capturer: the line protocol of a write, or
+// the response to a query.
+type Capture struct {
+	Time      time.Time `json:"time"`
+	Statement string    `json:"statement"`
+	Kind      string    `json:"kind"`
+	Database  string    `json:"database,omitempty"`
+	Query     string    `json:"query,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Body      string    `json:"body"`
+}
+
+// Capturer keeps a random sample of write bodies and query responses as
+// JSON lines, to check after a run what was really sent and received. It
+// is set in the DSL with
+//
+//	SET captureSample "1/10000"
+//	SET captureLog "capture.jsonl"
+//
+// and is safe for concurrent use. A nil Capturer keeps nothing.
+type Capturer struct {
+	// Rate is the fraction of bodies kept.
+	Rate float64
+	// Secrets, if set, are redacted from the bodies kept.
+	Secrets *Redactor
+
+	mu   sync.Mutex
+	rand *rand.Rand
+	enc  *json.Encoder
+	c    io.Closer
+}
+
+// NewCapturer returns a Capturer keeping the fraction rate of bodies, and
+// writing them to w.
+func NewCapturer(w io.Writer, rate float64) *Capturer {
+	c := &Capturer{Rate: rate, rand: rand.New(rand.NewSource(time.Now().UnixNano())), enc: json.NewEncoder(w)}
+	if cl, ok := w.(io.Closer); ok {
+		c.c = cl
+	}
+	return c
+}
+
+// CapturerFromVars opens the capture log set by SET variables. It returns
+// nil if no sample is set; the log defaults to capture.jsonl.
+func CapturerFromVars(vars map[string]string) (*Capturer, error) {
+	v := vars["captureSample"]
+	if v == "" {
+		return nil, nil
+	}
+	rate, err := parseSample(v)
+	if err != nil {
+		return nil, err
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+
+	path := vars["captureLog"]
+	if path == "" {
+		path = "capture.jsonl"
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewCapturer(f, rate), nil
+}
+
+// parseSample parses a sampling rate written as a fraction, such as 0.01,
+// or as one in n, such as 1/10000.
+func parseSample(v string) (float64, error) {
+	num, den := v, "1"
+	if i := strings.IndexByte(v, '/'); i >= 0 {
+		num, den = v[:i], v[i+1:]
+	}
+	n, err1 := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	d, err2 := strconv.ParseFloat(strings.TrimSpace(den), 64)
+	if err1 != nil || err2 != nil || d <= 0 || n < 0 || n > d {
+		return 0, fmt.Errorf("invalid captureSample %q", v)
+	}
+	return n / d, nil
+}
+
+// Sample reports whether to keep the next body. Callers check it before
+// building a Capture, so bodies not kept cost nothing.
+func (c *Capturer) Sample() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < c.Rate
+}
+
+// Record writes e to the log.
+func (c *Capturer) Record(e Capture) error {
+	if c == nil {
+		return nil
+	}
+	e.Database, e.Query = c.Secrets.Redact(e.Database), c.Secrets.Redact(e.Query)
+	e.Error, e.Body = c.Secrets.Redact(e.Error), c.Secrets.Redact(e.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(e)
+}
+
+// Close closes the underlying file, if any.
+func (c *Capturer) Close() error {
+	if c == nil || c.c == nil {
+		return nil
+	}
+	return c.c.Close()
+}
@@ -0,0 +1,65 @@
+package stressql
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// CompactionOptions tune writes to maximize compaction work in the server:
+// tiny batches, points rewritten into time ranges already written, and many
+// fields of different types. They are set in the DSL with
+//
+//	SET compaction on
+//	SET compactionBatchSize 10
+//	SET compactionOverlap 50
+//	SET compactionFieldTypes 8
+//
+// where compactionOverlap is a percentage of points.
+type CompactionOptions struct {
+	BatchSize  int
+	Overlap    float64
+	FieldTypes int
+}
+
+// DefaultCompactionOptions are used for knobs left unset.
+var DefaultCompactionOptions = CompactionOptions{
+	BatchSize:  10,
+	Overlap:    0.5,
+	FieldTypes: 4,
+}
+
+// CompactionFromVars reads compaction options from SET variables. It
+// returns nil if compaction mode is not on.
+func CompactionFromVars(vars map[string]string) (*CompactionOptions, error) {
+	if on, err := boolVar(vars, "compaction", false); err != nil || !on {
+		return nil, err
+	}
+
+	o := DefaultCompactionOptions
+	var err error
+	if o.BatchSize, err = intVar(vars, "compactionBatchSize", o.BatchSize); err != nil {
+		return nil, err
+	}
+	if v := vars["compactionOverlap"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			return nil, fmt.Errorf("invalid compactionOverlap %q, expected a percentage", v)
+		}
+		o.Overlap = float64(n) / 100
+	}
+	if v := vars["compactionFieldTypes"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid compactionFieldTypes %q", v)
+		}
+		o.FieldTypes = n
+	}
+	return &o, nil
+}
+
+// Apply configures p and its generator for compaction pressure.
+func (o *CompactionOptions) Apply(p *Pipeline) {
+	p.BatchSize = o.BatchSize
+	p.Generator.Overlap = o.Overlap
+	p.Generator.ExtraFields = o.FieldTypes
+}
@@ -0,0 +1,191 @@
+package stressql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// conflictSample is the number of points of each INSERT checkFieldTypes
+// looks at.
+const conflictSample = 1000
+
+// fieldSlot is where a field's type is fixed: InfluxDB gives a field one
+// type per measurement in each shard, so writes of the same field with
+// another type fail even to series no other INSERT writes.
+type fieldSlot struct {
+	addresses, db, rp string
+	measurement       string
+	field             string
+}
+
+type fieldUse struct {
+	insert, typ string
+}
+
+// checkFieldTypes reports an INSERT that writes a field with a type
+// another INSERT, or its own earlier points, write it with to the same
+// servers, database and retention policy. The server would take the
+// first writes and reject the rest as partial writes, midway through the
+// run. It follows the SET and USE statements in seq to know where each
+// INSERT writes, and looks at a sample of its points.
+//
+// A config that means to make the server reject them, to test how it
+// does, sets
+//
+//	SET allowFieldConflicts on
+func checkFieldTypes(seq []Statement) error {
+	vars := map[string]string{}
+	seen := map[fieldSlot]fieldUse{}
+	for _, s := range seq {
+		body := s
+		switch st := s.(type) {
+		case *SetStatement:
+			vars[st.Var] = st.Value
+		case *UseStatement:
+			vars["database"], vars["retentionPolicy"] = st.Database, st.RetentionPolicy
+			if st.Addresses != "" {
+				vars["addresses"] = st.Addresses
+			}
+		case *GoStatement:
+			body = st.Statement
+		}
+		i, ok := body.(*InsertStatement)
+		if !ok {
+			continue
+		}
+		if allow, err := boolVar(vars, "allowFieldConflicts", false); err != nil {
+			return err
+		} else if allow {
+			continue
+		}
+
+		// Statements that do not compile are reported by validate.
+		g, err := Compile(i)
+		if err != nil {
+			continue
+		}
+		dbs, err := i.Databases(vars["database"])
+		if err != nil {
+			continue
+		}
+		rp := i.RetentionPolicy
+		if rp == "" {
+			rp = vars["retentionPolicy"]
+		}
+		addrs := strings.Join(splitAddresses(vars["addresses"]), ",")
+
+		n := g.Points
+		if n > conflictSample {
+			n = conflictSample
+		}
+		var line []byte
+		for p := int64(0); p < n; p++ {
+			line = g.AppendPoint(line[:0], p)
+			measurement, fields := lineFields(line)
+			for _, f := range fields {
+				for _, db := range dbs {
+					k := fieldSlot{addresses: addrs, db: db, rp: rp, measurement: measurement, field: f.key}
+					u, ok := seen[k]
+					if !ok {
+						seen[k] = fieldUse{insert: i.Name, typ: f.typ}
+						continue
+					}
+					if u.typ == f.typ {
+						continue
+					}
+					if u.insert == i.Name {
+						return located(s.Position(), fmt.Errorf(
+							"insert %q writes field %q of %s both as %s and as %s; the server would reject the later writes",
+							i.Name, f.key, measurement, u.typ, f.typ))
+					}
+					return located(s.Position(), fmt.Errorf(
+						"insert %q writes field %q of %s in %s as %s, but insert %q writes it as %s; the server would reject the later writes",
+						i.Name, f.key, measurement, target(db, rp), f.typ, u.insert, u.typ))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type lineField struct {
+	key, typ string
+}
+
+// lineFields returns the measurement of a line of line protocol, and the
+// keys and types of its fields. It expects a well formed line.
+func lineFields(line []byte) (string, []lineField) {
+	// The series key ends at the first unescaped space.
+	i, m := 0, -1
+	for ; i < len(line) && line[i] != ' '; i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ',':
+			if m < 0 {
+				m = i
+			}
+		}
+	}
+	if m < 0 {
+		m = i
+	}
+	measurement := unescapeKey(string(line[:m]))
+
+	var fields []lineField
+	for i++; i < len(line); i++ {
+		start := i
+		for ; i < len(line) && line[i] != '='; i++ {
+			if line[i] == '\\' {
+				i++
+			}
+		}
+		if i >= len(line) {
+			break
+		}
+		key := unescapeKey(string(line[start:i]))
+
+		i++
+		start = i
+		if i < len(line) && line[i] == '"' {
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		} else {
+			for ; i < len(line) && line[i] != ',' && line[i] != ' '; i++ {
+			}
+		}
+		if i > len(line) {
+			break
+		}
+		fields = append(fields, lineField{key: key, typ: fieldType(line[start:i])})
+		if i >= len(line) || line[i] != ',' {
+			break
+		}
+	}
+	return measurement, fields
+}
+
+// fieldType names the type of a field value as written in line protocol.
+func fieldType(v []byte) string {
+	if len(v) == 0 {
+		return "float"
+	}
+	switch string(v) {
+	case "t", "T", "true", "True", "TRUE", "f", "F", "false", "False", "FALSE":
+		return "boolean"
+	}
+	if v[0] == '"' {
+		return "string"
+	}
+	switch v[len(v)-1] {
+	case 'i':
+		return "integer"
+	case 'u':
+		return "unsigned"
+	}
+	return "float"
+}
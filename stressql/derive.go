@@ -0,0 +1,193 @@
+package stressql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// expr evaluates a derived field's expression for a point.
+type expr func(point uint64) float64
+
+// compileDerived compiles a derive(...) expression over the other fields of
+// the point, such as "total * pct / 100". Expressions support + - * / and
+// parentheses over numbers and the keys of int and float fields.
+func compileDerived(src string, fields map[string]*compiledTemplate) (expr, error) {
+	p := &exprParser{src: src, fields: fields}
+	e, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skip(); p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.src[p.pos:], src)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	src    string
+	pos    int
+	fields map[string]*compiledTemplate
+}
+
+func (p *exprParser) skip() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	if p.skip(); p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) sum() (expr, error) {
+	l, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch op := p.peek(); op {
+		case '+', '-':
+			p.pos++
+			r, err := p.product()
+			if err != nil {
+				return nil, err
+			}
+			a := l
+			if op == '+' {
+				l = func(i uint64) float64 { return a(i) + r(i) }
+			} else {
+				l = func(i uint64) float64 { return a(i) - r(i) }
+			}
+		default:
+			return l, nil
+		}
+	}
+}
+
+func (p *exprParser) product() (expr, error) {
+	l, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch op := p.peek(); op {
+		case '*', '/':
+			p.pos++
+			r, err := p.factor()
+			if err != nil {
+				return nil, err
+			}
+			a := l
+			if op == '*' {
+				l = func(i uint64) float64 { return a(i) * r(i) }
+			} else {
+				l = func(i uint64) float64 { return a(i) / r(i) }
+			}
+		default:
+			return l, nil
+		}
+	}
+}
+
+func (p *exprParser) factor() (expr, error) {
+	switch c := p.peek(); {
+	case c == '(':
+		p.pos++
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) in %q", p.src)
+		}
+		p.pos++
+		return e, nil
+	case c == '-':
+		p.pos++
+		e, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return func(i uint64) float64 { return -e(i) }, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (isDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.src[start:p.pos])
+		}
+		return func(uint64) float64 { return v }, nil
+	case isLetter(rune(c)) || c == '_':
+		start := p.pos
+		for p.pos < len(p.src) && (isLetter(rune(p.src[p.pos])) || isDigit(rune(p.src[p.pos])) || p.src[p.pos] == '_') {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		f, ok := p.fields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		return f.number, nil
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of %q", p.src)
+	}
+	return nil, fmt.Errorf("unexpected %q in %q", p.src[p.pos:], p.src)
+}
+
+// number returns the template's value for point as a number.
+func (c *compiledTemplate) number(point uint64) float64 {
+	sc := getScratch()
+	sc.buf = c.fieldValue(sc.buf[:0], point)
+	v, _ := strconv.ParseFloat(string(sc.buf), 64)
+	putScratch(sc)
+	return v
+}
+
+// derivedField places a derived value as the field key of kind. A value
+// that is not finite, as from a division by zero, cannot be written as
+// line protocol, so the field is left out of the point.
+func derivedField(kind valueKind, key string, e expr) part {
+	if kind == kindInt {
+		return func(b []byte, _, point uint64) []byte {
+			v := e(point)
+			if math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) >= math.MaxInt64 {
+				return omitField(b, key)
+			}
+			return append(strconv.AppendInt(b, int64(math.Round(v)), 10), 'i')
+		}
+	}
+	return func(b []byte, _, point uint64) []byte {
+		v := e(point)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return omitField(b, key)
+		}
+		return strconv.AppendFloat(b, v, 'f', -1, 64)
+	}
+}
+
+// omitField removes the "key=" b ends in, and the comma before it. A
+// first field's space is kept, for AppendPointAt to join to the next.
+func omitField(b []byte, key string) []byte {
+	b = b[:len(b)-len(key)-1]
+	if n := len(b); n > 0 && b[n-1] == ',' {
+		b = b[:n-1]
+	}
+	return b
+}
+
+// fieldKey returns the key of the field whose value follows lit, the
+// literal text before it.
+func fieldKey(lit string) string {
+	lit = strings.TrimSuffix(lit, "=")
+	if i := strings.LastIndexAny(lit, ", "); i >= 0 {
+		lit = lit[i+1:]
+	}
+	return lit
+}
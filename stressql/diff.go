@@ -0,0 +1,302 @@
+package stressql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Difference is a single semantic change between two parsed configs.
+type Difference struct {
+	Path string
+	Old  string
+	New  string
+}
+
+func (d Difference) String() string {
+	switch {
+	case d.Old == "":
+		return strings.TrimSpace("+ " + d.Path + " " + d.New)
+	case d.New == "":
+		return strings.TrimSpace("- " + d.Path + " " + d.Old)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", d.Path, d.Old, d.New)
+}
+
+// Diff compares two statement sequences at the AST level. Statements are
+// matched by kind and name, and whitespace or formatting changes in the
+// source produce no differences.
+func Diff(a, b []Statement) []Difference {
+	ka, kb := statementKeys(a), statementKeys(b)
+
+	// lcs[i][j] is the length of the longest common key sequence of
+	// ka[i:] and kb[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if ka[i] == kb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diffs []Difference
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if ka[i] == kb[j] {
+			diffs = append(diffs, diffStatement(ka[i], a[i], b[j])...)
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			diffs = append(diffs, Difference{Path: ka[i], Old: describe(a[i])})
+			i++
+		} else {
+			diffs = append(diffs, Difference{Path: kb[j], New: describe(b[j])})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diffs = append(diffs, Difference{Path: ka[i], Old: describe(a[i])})
+	}
+	for ; j < len(b); j++ {
+		diffs = append(diffs, Difference{Path: kb[j], New: describe(b[j])})
+	}
+
+	return diffs
+}
+
+func normalize(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func anomalies(as []*AnomalySpec) string {
+	s := make([]string, len(as))
+	for i, a := range as {
+		s[i] = a.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// statementKeys returns the key of each statement. Statements sharing a
+// key, as a config's WAITs or USEs do, are told apart by their occurrence,
+// as in "WAIT #2", so two of them never diff as one.
+func statementKeys(seq []Statement) []string {
+	seen := make(map[string]int, len(seq))
+	keys := make([]string, len(seq))
+	for i, s := range seq {
+		k := statementKey(s)
+		seen[k]++
+		if n := seen[k]; n > 1 {
+			k = fmt.Sprintf("%s #%d", k, n)
+		}
+		keys[i] = k
+	}
+	return keys
+}
+
+func statementKey(s Statement) string {
+	switch s := s.(type) {
+	case *InfluxqlStatement:
+		return normalize(s.Value)
+	case *InsertStatement:
+		return "INSERT " + s.Name
+	case *QueryStatement:
+		return "QUERY " + s.Name
+	case *ExecStatement:
+		return "EXEC " + s.Script
+	case *SetStatement:
+		return "SET " + s.Var
+	case *WaitStatement:
+		return "WAIT"
+	case *UseStatement:
+		return "USE"
+	case *ContinuousQueryStatement:
+		return "CQ " + s.Name
+	case *EveryStatement:
+		return "EVERY " + normalize(s.Query)
+	case *SLOStatement:
+		return strings.TrimSpace("SLO " + s.Metric + " " + s.Scope)
+	case *GoStatement:
+		return "GO " + statementKey(s.Statement)
+	case *VersionStatement:
+		return "VERSION"
+	case *ProfileStatement:
+		return "PROFILE " + s.Name
+	case *EndStatement:
+		return "END"
+	case *DeadlineStatement:
+		return "DEADLINE"
+	case *SleepStatement:
+		return "SLEEP"
+	case *CapStatement:
+		return s.Kind
+	case *RetentionPolicyStatement:
+		if s.Alter {
+			return "ALTER RP " + s.Name
+		}
+		return "CREATE RP " + s.Name
+	}
+	return fmt.Sprintf("%T", s)
+}
+
+func describe(s Statement) string {
+	switch s := s.(type) {
+	case *InsertStatement:
+		return normalize(s.TemplateString)
+	case *QueryStatement:
+		return normalize(s.TemplateString)
+	case *ExecStatement:
+		return strings.Join(s.Args, " ")
+	case *SetStatement:
+		return s.Value
+	case *UseStatement:
+		return target(s.Database, s.RetentionPolicy)
+	case *ContinuousQueryStatement:
+		return normalize(s.Query)
+	case *EveryStatement:
+		return strings.TrimSpace(s.Interval + " " + s.Count)
+	case *SLOStatement:
+		return s.Op + " " + s.Value
+	case *GoStatement:
+		return describe(s.Statement)
+	case *VersionStatement:
+		return s.Version
+	case *DeadlineStatement:
+		return s.Duration
+	case *SleepStatement:
+		return s.Duration
+	case *CapStatement:
+		return s.Value
+	case *RetentionPolicyStatement:
+		return strings.TrimSpace(s.clauses())
+	}
+	return ""
+}
+
+func diffStatement(path string, a, b Statement) []Difference {
+	var diffs []Difference
+	field := func(name, old, new string) {
+		if old != new {
+			diffs = append(diffs, Difference{Path: path + " " + name, Old: old, New: new})
+		}
+	}
+
+	switch a := a.(type) {
+	case *InsertStatement:
+		b := b.(*InsertStatement)
+		field("template", normalize(a.TemplateString), normalize(b.TemplateString))
+		diffs = append(diffs, diffTemplates(path, a.Templates, b.Templates)...)
+		var ta, tb Timestamp
+		if a.Timestamp != nil {
+			ta = *a.Timestamp
+		}
+		if b.Timestamp != nil {
+			tb = *b.Timestamp
+		}
+		field("count", ta.Count, tb.Count)
+		field("duration", ta.Duration, tb.Duration)
+		field("jitter", fmt.Sprint(ta.Jitter), fmt.Sprint(tb.Jitter))
+		field("realtime", fmt.Sprint(ta.RealTime), fmt.Sprint(tb.RealTime))
+		field("grow", strings.TrimSpace(ta.Grow+" "+ta.GrowUnit), strings.TrimSpace(tb.Grow+" "+tb.GrowUnit))
+		field("rate", rate(ta), rate(tb))
+		field("burst", strings.TrimSpace(ta.Burst+" "+ta.BurstFor+" "+ta.BurstEvery), strings.TrimSpace(tb.Burst+" "+tb.BurstFor+" "+tb.BurstEvery))
+		field("steps", strings.TrimSpace(strings.Join(ta.Steps, ",")+" "+ta.StepHold), strings.TrimSpace(strings.Join(tb.Steps, ",")+" "+tb.StepHold))
+		field("anomalies", anomalies(ta.Anomalies), anomalies(tb.Anomalies))
+		field("order", ta.Order, tb.Order)
+		field("into", target(a.Database, a.RetentionPolicy), target(b.Database, b.RetentionPolicy))
+		field("across", a.Across, b.Across)
+	case *QueryStatement:
+		b := b.(*QueryStatement)
+		field("template", normalize(a.TemplateString), normalize(b.TemplateString))
+		field("args", strings.Join(a.Args, " "), strings.Join(b.Args, " "))
+		field("count", a.Count, b.Count)
+		field("fanout", a.Fanout, b.Fanout)
+		field("expect series", a.ExpectSeries, b.ExpectSeries)
+		field("expect nonempty", fmt.Sprint(a.ExpectNonEmpty), fmt.Sprint(b.ExpectNonEmpty))
+	case *ExecStatement:
+		b := b.(*ExecStatement)
+		field("args", strings.Join(a.Args, " "), strings.Join(b.Args, " "))
+	case *SetStatement:
+		b := b.(*SetStatement)
+		field("value", a.Value, b.Value)
+	case *WaitStatement:
+		b := b.(*WaitStatement)
+		field("for", strings.TrimPrefix(a.For, "ALL"), strings.TrimPrefix(b.For, "ALL"))
+	case *UseStatement:
+		b := b.(*UseStatement)
+		field("target", target(a.Database, a.RetentionPolicy), target(b.Database, b.RetentionPolicy))
+		field("addresses", a.Addresses, b.Addresses)
+	case *ContinuousQueryStatement:
+		b := b.(*ContinuousQueryStatement)
+		field("database", a.Database, b.Database)
+		field("query", normalize(a.Query), normalize(b.Query))
+	case *EveryStatement:
+		b := b.(*EveryStatement)
+		field("interval", a.Interval, b.Interval)
+		field("count", a.Count, b.Count)
+	case *SLOStatement:
+		b := b.(*SLOStatement)
+		field("threshold", a.Op+" "+a.Value, b.Op+" "+b.Value)
+	case *GoStatement:
+		b := b.(*GoStatement)
+		field("workers", a.Concurrency, b.Concurrency)
+		field("after", strings.Join(a.After, ", "), strings.Join(b.After, ", "))
+		field("until", strings.TrimSpace(a.Until+" "+a.Equals), strings.TrimSpace(b.Until+" "+b.Equals))
+		diffs = append(diffs, diffStatement(path, a.Statement, b.Statement)...)
+	case *VersionStatement:
+		b := b.(*VersionStatement)
+		field("version", a.Version, b.Version)
+	case *DeadlineStatement:
+		b := b.(*DeadlineStatement)
+		field("duration", a.Duration, b.Duration)
+	case *SleepStatement:
+		b := b.(*SleepStatement)
+		field("duration", a.Duration, b.Duration)
+	case *CapStatement:
+		b := b.(*CapStatement)
+		field("limit", a.Value, b.Value)
+	case *RetentionPolicyStatement:
+		b := b.(*RetentionPolicyStatement)
+		field("database", a.Database, b.Database)
+		field("duration", a.Duration, b.Duration)
+		field("replication", a.Replication, b.Replication)
+		field("shard duration", a.ShardDuration, b.ShardDuration)
+		field("default", fmt.Sprint(a.Default), fmt.Sprint(b.Default))
+	}
+
+	return diffs
+}
+
+// rate returns a timestamp's RATE with its unit, which defaults to
+// points.
+func rate(t Timestamp) string {
+	if t.Rate == "" {
+		return ""
+	}
+	if t.RateUnit == "" {
+		return t.Rate + " pts/s"
+	}
+	return t.Rate + " " + t.RateUnit + "/s"
+}
+
+func diffTemplates(path string, a, b []*Template) []Difference {
+	var diffs []Difference
+	for i := 0; i < len(a) || i < len(b); i++ {
+		p := fmt.Sprintf("%s template[%d]", path, i)
+		switch {
+		case i >= len(a):
+			diffs = append(diffs, Difference{Path: p, New: b[i].String()})
+		case i >= len(b):
+			diffs = append(diffs, Difference{Path: p, Old: a[i].String()})
+		case a[i].String() != b[i].String():
+			diffs = append(diffs, Difference{Path: p, Old: a[i].String(), New: b[i].String()})
+		}
+	}
+	return diffs
+}
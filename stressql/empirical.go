@@ -0,0 +1,165 @@
+package stressql
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Distribution is an empirical distribution, kept as evenly spaced
+// quantiles of a sample. Sampling interpolates between them, so generated
+// values follow the sample's shape without repeating its values.
+type Distribution struct {
+	Quantiles []float64
+}
+
+// DistributionBins is the number of bins FitDistribution divides a sample
+// into.
+const DistributionBins = 100
+
+// FitDistribution fits a Distribution of bins to values.
+func FitDistribution(values []float64, bins int) (*Distribution, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no values to fit")
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	if bins > len(sorted)-1 {
+		bins = len(sorted) - 1
+	}
+	d := &Distribution{Quantiles: make([]float64, bins+1)}
+	for i := range d.Quantiles {
+		if bins == 0 {
+			d.Quantiles[i] = sorted[0]
+			break
+		}
+		pos := float64(i) * float64(len(sorted)-1) / float64(bins)
+		lo := int(pos)
+		if lo == len(sorted)-1 {
+			d.Quantiles[i] = sorted[lo]
+			continue
+		}
+		d.Quantiles[i] = sorted[lo] + (sorted[lo+1]-sorted[lo])*(pos-float64(lo))
+	}
+	return d, nil
+}
+
+// Sample returns the value at quantile u, in [0, 1).
+func (d *Distribution) Sample(u float64) float64 {
+	if len(d.Quantiles) == 1 {
+		return d.Quantiles[0]
+	}
+	pos := u * float64(len(d.Quantiles)-1)
+	i := int(pos)
+	return d.Quantiles[i] + (d.Quantiles[i+1]-d.Quantiles[i])*(pos-float64(i))
+}
+
+// WriteCSV writes the quantiles as a one column CSV. Read back with
+// ReadColumn and fitted again, it yields the same Distribution, so it can
+// stand in for the sample it was fitted to.
+func (d *Distribution) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"value"})
+	for _, q := range d.Quantiles {
+		cw.Write([]string{strconv.FormatFloat(q, 'g', -1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadColumn reads the numeric values of a CSV column, named by its header
+// or numbered from 1. An empty column is the first. Values that do not
+// parse, such as a header over a numbered column, are skipped.
+func ReadColumn(r io.Reader, column string) ([]float64, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	name, col := column, 0
+	if n, err := strconv.Atoi(column); err == nil {
+		if n < 1 {
+			return nil, fmt.Errorf("invalid column %d", n)
+		}
+		col, column = n-1, ""
+	}
+
+	var values []float64
+	for row := 0; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if row == 0 && column != "" {
+			col = -1
+			for i, h := range rec {
+				if strings.TrimSpace(h) == column {
+					col = i
+				}
+			}
+			if col < 0 {
+				return nil, fmt.Errorf("no column %q", column)
+			}
+			continue
+		}
+		if col >= len(rec) {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(rec[col]), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no numeric values in column %q", name)
+	}
+	return values, nil
+}
+
+// LoadDistribution fits a Distribution to a column of a CSV file.
+func LoadDistribution(path, column string) (*Distribution, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values, err := ReadColumn(f, column)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return FitDistribution(values, DistributionBins)
+}
+
+// empiricalValue samples the distribution of a CSV column, as in
+// empirical("latency.csv:p99"). Without a column it uses the first.
+func empiricalValue(kind valueKind, arg string, seed uint64) (value, error) {
+	if kind != kindInt && kind != kindFloat {
+		return nil, fmt.Errorf("empirical needs an int or float type")
+	}
+	if len(arg) < 2 || arg[0] != '"' || arg[len(arg)-1] != '"' {
+		return nil, fmt.Errorf("invalid argument %s, expected a quoted CSV file", arg)
+	}
+	path, column := arg[1:len(arg)-1], ""
+	if i := strings.LastIndexByte(path, ':'); i >= 0 {
+		path, column = path[:i], path[i+1:]
+	}
+	d, err := LoadDistribution(path, column)
+	if err != nil {
+		return nil, err
+	}
+	if kind == kindInt {
+		return func(b []byte, k uint64) []byte {
+			return strconv.AppendInt(b, int64(math.Round(d.Sample(unitFloat(mix(seed+k))))), 10)
+		}, nil
+	}
+	return func(b []byte, k uint64) []byte {
+		return strconv.AppendFloat(b, d.Sample(unitFloat(mix(seed+k))), 'f', -1, 64)
+	}, nil
+}
@@ -0,0 +1,172 @@
+package stressql
+
+import (
+	"strconv"
+)
+
+// Encoder serializes points in line protocol into a reused buffer. Values
+// are appended with strconv and escaped in place, so encoding a point does
+// not allocate once the buffer has grown to the batch size.
+//
+// A point is written as StartPoint or Series, then any tags, then at least
+// one field, then EndPoint.
+type Encoder struct {
+	// Floats is how Float writes values.
+	Floats FloatFormat
+
+	buf    []byte
+	fields int
+	// ts is the timestamp last written and tsText how it ends a point,
+	// which the points of a batch mostly share.
+	ts     int64
+	tsText [22]byte
+	tsLen  int
+}
+
+// NewEncoder returns an Encoder with room for size bytes.
+func NewEncoder(size int) *Encoder {
+	return &Encoder{buf: make([]byte, 0, size)}
+}
+
+// Bytes returns the encoded points. The slice is only valid until the next
+// call to Reset.
+func (e *Encoder) Bytes() []byte { return e.buf }
+
+// Len returns the number of bytes encoded.
+func (e *Encoder) Len() int { return len(e.buf) }
+
+// Reset empties the buffer, keeping its capacity.
+func (e *Encoder) Reset() {
+	e.buf = e.buf[:0]
+	e.fields = 0
+}
+
+// StartPoint begins a point in measurement.
+func (e *Encoder) StartPoint(measurement string) {
+	e.fields = 0
+	e.buf = appendEscaped(e.buf, measurement, measurementEscapes)
+}
+
+// Series begins a point with a precomputed series key, the measurement and
+// tags already escaped and joined by commas.
+func (e *Encoder) Series(key []byte) {
+	e.fields = 0
+	e.buf = append(e.buf, key...)
+}
+
+// Tag adds a tag to the current point. Tags must be added before fields.
+func (e *Encoder) Tag(key, value string) {
+	e.buf = append(e.buf, ',')
+	e.buf = appendEscaped(e.buf, key, keyEscapes)
+	e.buf = append(e.buf, '=')
+	e.buf = appendEscaped(e.buf, value, keyEscapes)
+}
+
+func (e *Encoder) fieldKey(key string) {
+	if e.fields == 0 {
+		e.buf = append(e.buf, ' ')
+	} else {
+		e.buf = append(e.buf, ',')
+	}
+	e.fields++
+	e.buf = appendEscaped(e.buf, key, keyEscapes)
+	e.buf = append(e.buf, '=')
+}
+
+// Int adds an integer field.
+func (e *Encoder) Int(key string, v int64) {
+	e.fieldKey(key)
+	e.buf = strconv.AppendInt(e.buf, v, 10)
+	e.buf = append(e.buf, 'i')
+}
+
+// Float adds a float field.
+func (e *Encoder) Float(key string, v float64) {
+	e.fieldKey(key)
+	e.buf = e.Floats.Append(e.buf, v)
+}
+
+// String adds a string field.
+func (e *Encoder) String(key, v string) {
+	e.fieldKey(key)
+	e.buf = append(e.buf, '"')
+	e.buf = appendEscaped(e.buf, v, stringEscapes)
+	e.buf = append(e.buf, '"')
+}
+
+// Bool adds a boolean field.
+func (e *Encoder) Bool(key string, v bool) {
+	e.fieldKey(key)
+	e.buf = strconv.AppendBool(e.buf, v)
+}
+
+// EndPoint ends the current point with a timestamp in nanoseconds.
+func (e *Encoder) EndPoint(ts int64) {
+	if ts != e.ts || e.tsLen == 0 {
+		t := append(strconv.AppendInt(append(e.tsText[:0], ' '), ts, 10), '\n')
+		e.ts, e.tsLen = ts, len(t)
+	}
+	e.buf = append(e.buf, e.tsText[:e.tsLen]...)
+}
+
+// escapes marks the characters escaped with a backslash in each part of a
+// line.
+type escapes [256]bool
+
+func newEscapes(special string) *escapes {
+	var t escapes
+	for i := 0; i < len(special); i++ {
+		t[special[i]] = true
+	}
+	return &t
+}
+
+var (
+	measurementEscapes = newEscapes(", ")
+	keyEscapes         = newEscapes(",= ")
+	stringEscapes      = newEscapes("\"\\")
+)
+
+// appendEscaped appends s to b, escaping the characters in t. Strings
+// without special characters, the common case, are copied in one append.
+//
+// Where t does not escape backslashes, a run of them ending s, or before a
+// character t escapes, is doubled, so that it cannot escape the separator
+// or character after it.
+func appendEscaped(b []byte, s string, t *escapes) []byte {
+	i := 0
+	for ; i < len(s); i++ {
+		if t[s[i]] || s[i] == '\\' {
+			break
+		}
+	}
+	if i == len(s) {
+		return append(b, s...)
+	}
+
+	b = append(b, s[:i]...)
+	for ; i < len(s); i++ {
+		switch c := s[i]; {
+		case t[c]:
+			b = append(b, '\\', c)
+		case c == '\\':
+			j := i
+			for j < len(s) && s[j] == '\\' {
+				j++
+			}
+			b = append(b, s[i:j]...)
+			if j == len(s) || t[s[j]] {
+				b = append(b, s[i:j]...)
+			}
+			i = j - 1
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// AppendTagValue appends v escaped as a tag key or value.
+func AppendTagValue(b []byte, v string) []byte {
+	return appendEscaped(b, v, keyEscapes)
+}
@@ -0,0 +1,95 @@
+package stressql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultErrorBudgetMin is the number of requests a run makes before its
+// error budget applies, so the first failure of a run does not end it.
+const DefaultErrorBudgetMin = 100
+
+// ErrorBudget ends a run once more of its requests, writes and queries
+// together, have failed than it allows, so a run against a server that
+// has fallen over stops rather than piling up failures.
+//
+// A nil ErrorBudget allows any number of failures.
+type ErrorBudget struct {
+	// Max is the fraction of requests that may fail.
+	Max float64
+	// Min is the number of requests made before Max applies.
+	Min int64
+
+	requests, errors int64
+	exceeded         int32
+	stop             func()
+}
+
+// ErrorBudgetFromVars returns the budget set by SET errorBudget, a quoted
+// percentage such as "5%" or fraction such as "0.05", and SET
+// errorBudgetMin, or nil if none is set.
+func ErrorBudgetFromVars(vars map[string]string) (*ErrorBudget, error) {
+	v := vars["errorBudget"]
+	if v == "" {
+		return nil, nil
+	}
+	s := strings.TrimSuffix(v, "%")
+	max, err := strconv.ParseFloat(s, 64)
+	if s != v {
+		max /= 100
+	}
+	if err != nil || max < 0 || max >= 1 {
+		return nil, fmt.Errorf("invalid errorBudget %q, expected a percentage below 100%%", v)
+	}
+	b := &ErrorBudget{Max: max, Min: DefaultErrorBudgetMin}
+	if v := vars["errorBudgetMin"]; v != "" {
+		if b.Min, err = strconv.ParseInt(v, 10, 64); err != nil || b.Min < 1 {
+			return nil, fmt.Errorf("invalid errorBudgetMin %q, expected a number of requests", v)
+		}
+	}
+	return b, nil
+}
+
+// Record counts a request against the budget, calling the stop function
+// given to Watch the first time the failures exceed it.
+func (b *ErrorBudget) Record(failed bool) {
+	if b == nil || atomic.LoadInt32(&b.exceeded) != 0 {
+		return
+	}
+	n := atomic.AddInt64(&b.requests, 1)
+	e := atomic.LoadInt64(&b.errors)
+	if failed {
+		e = atomic.AddInt64(&b.errors, 1)
+	}
+	if n < b.Min || float64(e) <= b.Max*float64(n) {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&b.exceeded, 0, 1) && b.stop != nil {
+		b.stop()
+	}
+}
+
+// Watch sets the function that stops the run once the budget is
+// exceeded.
+func (b *ErrorBudget) Watch(stop func()) {
+	if b != nil {
+		b.stop = stop
+	}
+}
+
+// Exceeded reports whether the budget has stopped the run.
+func (b *ErrorBudget) Exceeded() bool {
+	return b != nil && atomic.LoadInt32(&b.exceeded) != 0
+}
+
+// Err returns the error a run the budget stopped ends with, or nil.
+func (b *ErrorBudget) Err() error {
+	if !b.Exceeded() {
+		return nil
+	}
+	return &RunError{Status: StatusErrorBudget, Err: fmt.Errorf(
+		"error budget of %g%% exceeded: %d of %d requests failed",
+		b.Max*100, atomic.LoadInt64(&b.errors), atomic.LoadInt64(&b.requests))}
+}
@@ -0,0 +1,32 @@
+package stressql
+
+import "testing"
+
+func TestErrorBudgetStopsOnce(t *testing.T) {
+	b, err := ErrorBudgetFromVars(map[string]string{"errorBudget": "50%", "errorBudgetMin": "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stops := 0
+	b.Watch(func() { stops++ })
+	for _, failed := range []bool{true, true, true, false, true, true} {
+		b.Record(failed)
+	}
+	if stops != 1 {
+		t.Fatalf("stopped %d times, want 1", stops)
+	}
+	if got := ErrorStatus(b.Err()); got != StatusErrorBudget {
+		t.Fatalf("status %q, want %q", got, StatusErrorBudget)
+	}
+	if got, want := b.Err().Error(), "error budget of 50% exceeded: 3 of 4 requests failed"; got != want {
+		t.Fatalf("error %q, want %q", got, want)
+	}
+}
+
+func TestErrorBudgetInvalid(t *testing.T) {
+	for _, v := range []string{"100%", "-1%", "x", "1"} {
+		if _, err := ErrorBudgetFromVars(map[string]string{"errorBudget": v}); err == nil {
+			t.Errorf("errorBudget %q: no error", v)
+		}
+	}
+}
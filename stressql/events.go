@@ -0,0 +1,94 @@
+package stressql
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Types of Event.
+const (
+	EventRunStart = "run_start"
+	EventRunEnd   = "run_end"
+	EventPhase    = "phase"
+	EventBatch    = "batch"
+	EventQuery    = "query"
+	EventError    = "error"
+)
+
+// Event is one thing that happened during a run. Fields that do not apply
+// to the event's type are omitted.
+type Event struct {
+	Time      time.Time     `json:"time"`
+	Type      string        `json:"type"`
+	Statement string        `json:"statement,omitempty"`
+	Phase     string        `json:"phase,omitempty"`
+	Points    int64         `json:"points,omitempty"`
+	Bytes     int64         `json:"bytes,omitempty"`
+	Latency   time.Duration `json:"latency,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// EventLog writes events as JSON lines, for post-processing or auditing a
+// run. It is set in the DSL with
+//
+//	SET eventLog "events.jsonl"
+//
+// and is safe for concurrent use. A nil EventLog records nothing.
+type EventLog struct {
+	// Secrets, if set, are redacted from the errors recorded. Runner.Run
+	// sets them to the run's.
+	Secrets *Redactor
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// NewEventLog returns an EventLog writing to w.
+func NewEventLog(w io.Writer) *EventLog {
+	l := &EventLog{enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		l.c = c
+	}
+	return l
+}
+
+// EventLogFromVars creates the event log set by SET eventLog, or returns
+// nil if none is set.
+func EventLogFromVars(vars map[string]string) (*EventLog, error) {
+	path := vars["eventLog"]
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewEventLog(f), nil
+}
+
+// Emit writes e, stamping it with the current time if it has none.
+func (l *EventLog) Emit(e Event) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Error = l.Secrets.Redact(e.Error)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(e)
+}
+
+// Close closes the underlying file, if any.
+func (l *EventLog) Close() error {
+	if l == nil || l.c == nil {
+		return nil
+	}
+	return l.c.Close()
+}
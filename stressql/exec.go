@@ -0,0 +1,1128 @@
+package stressql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExecEnv is the environment a statement executes in. A Runner creates one
+// for each run; a program executing statements itself gets one from
+// NewExecEnv.
+type ExecEnv struct {
+	// Vars are the SET variables in effect, which SET and USE change. A
+	// statement under GO gets a copy.
+	Vars map[string]string
+	// Store holds the variables SET so far, shared by every statement of
+	// the run, where Vars is this statement's copy; see VarStore.
+	Store *VarStore
+	// Overrides take the place of the values SET and USE give the
+	// variables they name.
+	Overrides map[string]string
+	// Secrets are the values of the secrets SET, to be kept out of logs
+	// and results.
+	Secrets *Redactor
+	// Args supplies the values of query template variables other than
+	// "%t key", keyed as written, such as "%f".
+	Args map[string]string
+	// Starts, if set, are the times the INSERTs they name stamp their
+	// points from, in place of when each starts.
+	Starts map[string]time.Time
+	// Client, if set, sends every request, in place of clients built from
+	// the transport options in Vars.
+	Client *http.Client
+	// Sink, if set, receives every write and query in place of the
+	// servers in SET addresses.
+	Sink Sink
+	// Result collects what each statement did.
+	Result *RunResult
+	// Rand spreads queries over the servers in SET addresses. A statement
+	// under GO gets its own, seeded from this one.
+	Rand *rand.Rand
+	// Logger logs what statements do, with the SET phase and, with SET
+	// workerTag, the worker's ID added to every message.
+	Logger  Logger
+	Events  *EventLog
+	SlowLog *SlowLog
+	Capture *Capturer
+	Budget  *MemoryBudget
+	// Cap, if set, bounds what INSERTs generate.
+	Cap *WriteCap
+	// ErrorBudget, if set, counts every write and query, and stops the
+	// run once too many fail.
+	ErrorBudget *ErrorBudget
+	// Output receives the output of EXEC scripts.
+	Output io.Writer
+	// Clock stamps generated points and events, paces statements and
+	// times them.
+	Clock Clock
+
+	// logBase is Logger as it was given, before the phase and worker were
+	// added.
+	logBase Logger
+	run     *execution
+}
+
+// execution is the state shared by the environments of one run.
+type execution struct {
+	mu sync.Mutex
+	// errs are the failures of statements run under GO, and of the
+	// statement that ended the run.
+	errs MultiError
+	// generators are the Generators of the INSERTs run so far, by name,
+	// for the "%t key" variables of QUERYs of the same name.
+	generators map[string]*Generator
+	clients    map[clientKey]*http.Client
+	// cleanup runs as the run ends, such as dropping its continuous
+	// queries.
+	cleanup []func(context.Context)
+
+	// async tracks statements a WAIT waits for, and background EVERYs
+	// that run until done is closed.
+	async      sync.WaitGroup
+	background sync.WaitGroup
+	done       chan struct{}
+	// settled is closed once the run's own statements have run, after
+	// which no SET is to come for a GO UNTIL to wait on.
+	settled chan struct{}
+
+	// running counts the statements a WAIT waits for that have yet to
+	// finish, and finished those that have since a WAIT last returned for
+	// them. changed is closed when either changes.
+	running, finished int
+	changed           chan struct{}
+	// active are the statements a WAIT waits for that are running, with
+	// how many times each is.
+	active map[Statement]int
+	// failed are the names of the statements run under GO that failed,
+	// for AFTER.
+	failed map[string]bool
+	// live return what each INSERT and QUERY running has done so far, for
+	// a Reporter.
+	live     map[int]func() StatementResult
+	nextLive int
+}
+
+type clientKey struct {
+	addr string
+	opts TransportOptions
+	// conn, if not zero, keys a client with a connection of its own.
+	conn int
+}
+
+// NewExecEnv returns an environment with vars in effect, logging nothing,
+// discarding EXEC output and on SystemClock. Close it when done.
+func NewExecEnv(vars map[string]string) *ExecEnv {
+	return &ExecEnv{
+		Vars:    copyVars(vars),
+		Store:   NewVarStore(vars),
+		Secrets: &Redactor{},
+		Result:  &RunResult{Start: time.Now()},
+		Rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		Logger:  NopLogger,
+		Output:  ioutil.Discard,
+		Clock:   SystemClock,
+		run: &execution{
+			generators: map[string]*Generator{},
+			clients:    map[clientKey]*http.Client{},
+			done:       make(chan struct{}),
+			settled:    make(chan struct{}),
+			changed:    make(chan struct{}),
+			active:     map[Statement]int{},
+			failed:     map[string]bool{},
+			live:       map[int]func() StatementResult{},
+		},
+	}
+}
+
+// fork returns a copy of env for a statement run concurrently with the
+// statements after it.
+func (env *ExecEnv) fork() *ExecEnv {
+	c := *env
+	c.Vars = copyVars(env.Vars)
+	c.Rand = rand.New(rand.NewSource(env.Rand.Int63()))
+	return &c
+}
+
+// Wait waits for the statements started by GO, returning a *MultiError of
+// those that failed, or nil.
+func (env *ExecEnv) Wait() error {
+	env.run.async.Wait()
+	env.run.mu.Lock()
+	defer env.run.mu.Unlock()
+	env.run.finished = 0
+	return env.run.errs.Err()
+}
+
+// WaitN waits until n of the statements started by GO have finished since
+// a wait last returned for them, or none are left running, and returns as
+// Wait does.
+func (env *ExecEnv) WaitN(n int) error {
+	for {
+		env.run.mu.Lock()
+		if env.run.finished >= n || env.run.running == 0 {
+			env.run.finished -= n
+			if env.run.finished < 0 {
+				env.run.finished = 0
+			}
+			err := env.run.errs.Err()
+			env.run.mu.Unlock()
+			return err
+		}
+		changed := env.run.changed
+		env.run.mu.Unlock()
+		<-changed
+	}
+}
+
+// start counts s, a statement a WAIT waits for, as running, until it
+// calls the function returned.
+func (env *ExecEnv) start(s Statement) func() {
+	env.run.async.Add(1)
+	env.run.mu.Lock()
+	env.run.running++
+	env.run.active[s]++
+	env.run.mu.Unlock()
+	return func() {
+		env.run.mu.Lock()
+		env.run.running--
+		if env.run.active[s]--; env.run.active[s] == 0 {
+			delete(env.run.active, s)
+		}
+		env.run.finished++
+		close(env.run.changed)
+		env.run.changed = make(chan struct{})
+		env.run.mu.Unlock()
+		env.run.async.Done()
+	}
+}
+
+// running reports whether s is among the statements a WAIT waits for
+// that have yet to finish.
+func (env *ExecEnv) running(s Statement) bool {
+	env.run.mu.Lock()
+	defer env.run.mu.Unlock()
+	return env.run.active[s] > 0
+}
+
+// fail records err as a failure of s, to be returned by Wait.
+func (env *ExecEnv) fail(s Statement, err error) {
+	name := statementName(s)
+	env.run.mu.Lock()
+	env.run.errs.Add(name, err)
+	if n := dependencyName(s); n != "" {
+		env.run.failed[n] = true
+	}
+	env.run.mu.Unlock()
+}
+
+// dependencyName is the name AFTER refers to s by, or "" if it has none.
+func dependencyName(s Statement) string {
+	switch s := s.(type) {
+	case *InsertStatement:
+		return s.Name
+	case *QueryStatement:
+		return s.Name
+	case *ExecStatement:
+		return s.Script
+	}
+	return ""
+}
+
+// after returns a function waiting until the statements named in names
+// that are running now have finished, which fails if any statement of
+// those names has failed.
+func (env *ExecEnv) after(names []string) func(ctx context.Context) error {
+	named := make(map[string]bool, len(names))
+	for _, n := range names {
+		named[n] = true
+	}
+	var deps []Statement
+	env.run.mu.Lock()
+	for s := range env.run.active {
+		if named[dependencyName(s)] {
+			deps = append(deps, s)
+		}
+	}
+	env.run.mu.Unlock()
+
+	return func(ctx context.Context) error {
+		for {
+			env.run.mu.Lock()
+			waiting := false
+			for _, s := range deps {
+				waiting = waiting || env.run.active[s] > 0
+			}
+			changed := env.run.changed
+			var failed string
+			for _, n := range names {
+				if env.run.failed[n] && failed == "" {
+					failed = n
+				}
+			}
+			env.run.mu.Unlock()
+
+			switch {
+			case waiting:
+			case failed != "":
+				return fmt.Errorf("not started: %s failed", failed)
+			default:
+				return nil
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// statementName names s in errors and results, with its location if it
+// has one.
+func statementName(s Statement) string {
+	name := statementKey(s)
+	if loc := s.Position().Location(); loc != "" {
+		name += " at " + loc
+	}
+	return name
+}
+
+// settle marks the run's own statements as having run, so statements
+// waiting on a SET to come stop waiting.
+func (env *ExecEnv) settle() {
+	close(env.run.settled)
+}
+
+// Close stops statements running in the background, such as an EVERY
+// without a count, undoes what the run set up on the server, such as its
+// continuous queries, and closes idle connections.
+func (env *ExecEnv) Close() {
+	close(env.run.done)
+	env.run.background.Wait()
+	for _, f := range env.run.cleanup {
+		// The run may have ended because the server hung.
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		f(ctx)
+		cancel()
+	}
+	env.run.mu.Lock()
+	defer env.run.mu.Unlock()
+	for _, c := range env.run.clients {
+		c.CloseIdleConnections()
+	}
+}
+
+// track adds a running statement to those progress reports, f returning
+// what it has done so far, until the returned function is called.
+func (env *ExecEnv) track(f func() StatementResult) func() {
+	env.run.mu.Lock()
+	defer env.run.mu.Unlock()
+	id := env.run.nextLive
+	env.run.nextLive++
+	env.run.live[id] = f
+	return func() {
+		env.run.mu.Lock()
+		defer env.run.mu.Unlock()
+		delete(env.run.live, id)
+	}
+}
+
+// progress returns the run's metadata with what each statement has done
+// so far: those running, then those finished.
+func (env *ExecEnv) progress() *RunResult {
+	env.run.mu.Lock()
+	defer env.run.mu.Unlock()
+	r := &RunResult{Metadata: env.Result.Metadata}
+	for _, f := range env.run.live {
+		r.Statements = append(r.Statements, f())
+	}
+	r.Statements = append(r.Statements, env.Result.Statements...)
+	return r
+}
+
+// cleanupTimeout bounds each request Close makes.
+const cleanupTimeout = 10 * time.Second
+
+// record adds a statement's results to env.Result: typed, its
+// *InsertResult, *QueryResult or *ExecResult, and s, its StatementResult
+// if it has one.
+func (env *ExecEnv) record(name string, s *StatementResult, typed interface{}) {
+	env.run.mu.Lock()
+	defer env.run.mu.Unlock()
+	r := env.Result
+	if s != nil {
+		r.Statements = append(r.Statements, *s)
+	}
+	switch t := typed.(type) {
+	case *InsertResult:
+		if r.Inserts == nil {
+			r.Inserts = map[string]*InsertResult{}
+		}
+		r.Inserts[name] = t
+	case *QueryResult:
+		if r.Queries == nil {
+			r.Queries = map[string]*QueryResult{}
+		}
+		r.Queries[name] = t
+	case *ExecResult:
+		if r.Execs == nil {
+			r.Execs = map[string]*ExecResult{}
+		}
+		r.Execs[name] = t
+	}
+}
+
+func copyVars(vars map[string]string) map[string]string {
+	c := make(map[string]string, len(vars))
+	for k, v := range vars {
+		c[k] = v
+	}
+	return c
+}
+
+// set sets a variable, unless it is overridden, to the value v stands for
+// if it names a secret.
+func (env *ExecEnv) set(k, v string) error {
+	if o, ok := env.Overrides[k]; ok {
+		v = o
+	}
+	v, secret, err := ResolveSecret(v)
+	if err != nil {
+		return fmt.Errorf("SET %s: %v", k, err)
+	}
+	if secret {
+		env.Secrets.Add(v)
+	}
+	env.Vars[k] = v
+	env.Store.Set(k, v)
+	switch k {
+	case "phase", "workerTag", "workerID":
+		env.deriveLogger()
+	}
+	return nil
+}
+
+// deriveLogger adds the phase and worker in effect to every message logged
+// through env.Logger.
+func (env *ExecEnv) deriveLogger() {
+	if env.logBase == nil {
+		env.logBase = env.Logger
+	}
+	var fields []interface{}
+	if phase := env.Vars["phase"]; phase != "" {
+		fields = append(fields, "phase", phase)
+	}
+	if key, id, err := WorkerTag(env.Vars); err == nil && key != "" {
+		fields = append(fields, "worker", id)
+	}
+	env.Logger = env.logBase
+	if len(fields) > 0 {
+		env.Logger = WithFields(env.logBase, fields...)
+	}
+}
+
+func (i *SetStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	if err := env.set(i.Var, i.Value); err != nil {
+		return err
+	}
+	if i.Var == "phase" {
+		env.Logger.Info("phase")
+		env.Events.Emit(Event{Time: env.Clock.Now(), Type: EventPhase, Phase: env.Vars["phase"]})
+	}
+	return nil
+}
+
+func (i *UseStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	if err := env.set("database", i.Database); err != nil {
+		return err
+	}
+	if err := env.set("retentionPolicy", i.RetentionPolicy); err != nil {
+		return err
+	}
+	if i.Addresses == "" {
+		return nil
+	}
+	if err := env.set("addresses", i.Addresses); err != nil {
+		return err
+	}
+	env.Logger.Info("target changed", "addresses", env.Vars["addresses"])
+	return nil
+}
+
+// Exec is a no-op: SLOs are evaluated as the run ends.
+func (i *SLOStatement) Exec(ctx context.Context, env *ExecEnv) error { return nil }
+
+func (i *WaitStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	n, err := i.Count()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return env.Wait()
+	}
+	return env.WaitN(n)
+}
+
+func (i *VersionStatement) Exec(ctx context.Context, env *ExecEnv) error { return nil }
+
+// Exec is a no-op: SelectProfile removes PROFILE sections before a run.
+func (i *ProfileStatement) Exec(ctx context.Context, env *ExecEnv) error { return nil }
+
+func (i *EndStatement) Exec(ctx context.Context, env *ExecEnv) error { return nil }
+
+// Exec is a no-op: the Runner applies the deadline to the whole run.
+func (i *DeadlineStatement) Exec(ctx context.Context, env *ExecEnv) error { return nil }
+
+// Exec waits out the sleep on env's clock, returning early if ctx ends.
+func (i *SleepStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	d, err := time.ParseDuration(i.Duration)
+	if err != nil {
+		return fmt.Errorf("sleep: invalid duration %q", i.Duration)
+	}
+	sleep(ctx, env.Clock, d)
+	return nil
+}
+
+// Exec is a no-op: the Runner caps the whole run.
+func (i *CapStatement) Exec(ctx context.Context, env *ExecEnv) error { return nil }
+
+// Exec starts the statement and returns. Its error, if any, is returned by
+// the next WAIT, unless it is only that ctx ended.
+func (i *GoStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	env = env.fork()
+	if i.Concurrency != "" {
+		switch i.Statement.(type) {
+		case *InsertStatement:
+			env.Vars["concurrency"] = i.Concurrency
+		case *QueryStatement:
+			env.Vars["queryConcurrency"] = i.Concurrency
+		}
+	}
+	after := env.after(i.After)
+	done := env.start(i.Statement)
+	go func() {
+		defer done()
+		err := after(ctx)
+		if err == nil && i.Until != "" {
+			err = i.wait(ctx, env)
+		}
+		if err == nil {
+			err = i.Statement.Exec(ctx, env)
+		}
+		if err != nil && !(ctx.Err() != nil && errors.Is(err, ctx.Err())) {
+			env.fail(i.Statement, err)
+		}
+	}()
+	return nil
+}
+
+// wait waits until the variable the statement names is set to the value
+// it waits for, failing if the run's statements end first.
+func (i *GoStatement) wait(ctx context.Context, env *ExecEnv) error {
+	wait, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-env.run.settled:
+			cancel()
+		case <-wait.Done():
+		}
+	}()
+	_, err := env.Store.Wait(wait, i.Until, i.until)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("UNTIL %s: not set before the run's statements ended", i.Until)
+	}
+	return err
+}
+
+// until reports whether v is the value the statement waits for.
+func (i *GoStatement) until(v Value) bool {
+	if i.Equals == "" {
+		b, err := v.Bool()
+		return err == nil && b
+	}
+	return v.Equal(ParseValue(i.Equals))
+}
+
+func (i *ExecStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	cmd := exec.CommandContext(ctx, i.Script, i.Args...)
+	cmd.Stdout, cmd.Stderr = env.Output, env.Output
+	start := env.Clock.Now()
+	err := cmd.Run()
+	res := &ExecResult{ExitCode: -1, Duration: env.Clock.Since(start)}
+	if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		err = fmt.Errorf("exec %s: %w", i.Script, err)
+		res.Error = err.Error()
+	}
+	env.record(i.Script, nil, res)
+	return err
+}
+
+func (i *InfluxqlStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	_, _, err := env.request(ctx, env.address(env.Rand), "POST", i.Value)
+	return err
+}
+
+// Exec creates or alters the retention policy through one of the servers.
+func (i *RetentionPolicyStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	db := env.Vars["database"]
+	if i.Database == "" && db == "" {
+		return fmt.Errorf("rp %q: no database, expected ON or SET database", i.Name)
+	}
+	if _, _, err := env.request(ctx, env.address(env.Rand), "POST", i.InfluxQL(db)); err != nil {
+		return fmt.Errorf("rp %q: %v", i.Name, err)
+	}
+	return nil
+}
+
+// Exec creates the continuous query, which is dropped when env is closed.
+func (i *ContinuousQueryStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	db := env.Vars["database"]
+	addr := env.address(env.Rand)
+	if _, _, err := env.request(ctx, addr, "POST", i.Create(db)); err != nil {
+		return fmt.Errorf("cq %q: %v", i.Name, err)
+	}
+	env = env.fork()
+	env.run.mu.Lock()
+	env.run.cleanup = append(env.run.cleanup, func(ctx context.Context) {
+		if _, _, err := env.request(ctx, addr, "POST", i.Drop(db)); err != nil {
+			env.Logger.Warn("dropping continuous query failed", "cq", i.Name, "err", err)
+		}
+	})
+	env.run.mu.Unlock()
+	return nil
+}
+
+func (i *InsertStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	g, err := Compile(i)
+	if err != nil {
+		return err
+	}
+	key, value, err := WorkerTag(env.Vars)
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		g.AddTag(key, value)
+	}
+	if g.Floats, err = FloatFormatFromVars(env.Vars); err != nil {
+		return err
+	}
+	if g.Calendar, err = CalendarFromVars(env.Vars, g.Interval); err != nil {
+		return fmt.Errorf("insert %q: %v", i.Name, err)
+	}
+	if g.Calendar != nil && g.RealTime {
+		return fmt.Errorf("insert %q: calendar does not apply to REALTIME", i.Name)
+	}
+	at := env.Clock.Now()
+	if t, ok := env.Starts[i.Name]; ok {
+		at = t
+	}
+	g.StartAt(at)
+	env.run.mu.Lock()
+	env.run.generators[i.Name] = g
+	env.run.mu.Unlock()
+
+	dbs, err := i.Databases(env.Vars["database"])
+	if err != nil {
+		return err
+	}
+	rp := i.RetentionPolicy
+	if rp == "" {
+		rp = env.Vars["retentionPolicy"]
+	}
+	w, err := env.writer(dbs, rp)
+	if err != nil {
+		return err
+	}
+
+	batchSize, err := intVar(env.Vars, "batchSize", 5000)
+	if err != nil {
+		return err
+	}
+	concurrency, err := intVar(env.Vars, "concurrency", 1)
+	if err != nil {
+		return err
+	}
+	var errs errorSample
+	p := &Pipeline{
+		Name:        i.Name,
+		Generator:   g,
+		Writer:      w,
+		BatchSize:   batchSize,
+		Concurrency: concurrency,
+		Budget:      env.Budget,
+		Cap:         env.Cap,
+		ErrorBudget: env.ErrorBudget,
+		Logger:      WithFields(env.Logger, "statement", i.Name),
+		Events:      env.Events,
+		Capture:     env.Capture,
+		OnError:     errs.add,
+		Clock:       env.Clock,
+	}
+	compaction, err := CompactionFromVars(env.Vars)
+	if err != nil {
+		return err
+	}
+	if compaction != nil {
+		compaction.Apply(p)
+	}
+	sharded, err := boolVar(env.Vars, "shardBySeries", false)
+	if err != nil {
+		return err
+	}
+	if sharded {
+		// Each sender gets connections of its own.
+		writers := make([]BatchWriter, concurrency)
+		for k := range writers {
+			if writers[k], err = env.connWriter(dbs, rp, k+1); err != nil {
+				return err
+			}
+		}
+		p.Sharded = true
+		p.ShardWriter = func(shard int) BatchWriter { return writers[shard] }
+	}
+	if p.Validate, err = boolVar(env.Vars, "validateLines", false); err != nil {
+		return err
+	}
+
+	estimate := g.Estimate()
+	env.Logger.Info("insert started", "statement", i.Name, "points", g.Points)
+	start := env.Clock.Now()
+	phase := env.Vars["phase"]
+	done := env.track(func() StatementResult {
+		stats := p.Stats()
+		return StatementResult{
+			Name:     i.Name,
+			Kind:     KindWrite,
+			Source:   i.Location(),
+			Phase:    phase,
+			Requests: stats.Batches,
+			Errors:   stats.Errors,
+			Points:   stats.Points,
+			Bytes:    stats.Bytes,
+			Duration: env.Clock.Since(start),
+			Latency:  p.Latency(),
+		}
+	})
+	defer done()
+	err = p.Run(ctx)
+	stats, took, latency := p.Stats(), env.Clock.Since(start), p.Latency()
+	res := &InsertResult{
+		Start:       at,
+		Points:      stats.Points,
+		Accepted:    stats.Points - stats.Failed,
+		Failed:      stats.Failed,
+		Dropped:     stats.Dropped,
+		Batches:     stats.Batches,
+		Errors:      stats.Errors,
+		Bytes:       stats.Bytes,
+		Duration:    took,
+		Latency:     latency,
+		FirstErrors: errs.list(),
+		Estimate:    &estimate,
+	}
+	for _, n := range stats.Dropped {
+		res.Accepted -= n
+	}
+	res.Discrepancies = res.Reconcile()
+	for _, d := range res.Discrepancies {
+		env.Logger.Warn("insert differs from estimate", "statement", i.Name, "kind", d.Kind,
+			"expected", d.Expected, "actual", d.Actual, "detail", d.Detail)
+	}
+	env.record(i.Name, &StatementResult{
+		Name:     i.Name,
+		Kind:     KindWrite,
+		Source:   i.Location(),
+		Phase:    env.Vars["phase"],
+		Requests: stats.Batches,
+		Errors:   stats.Errors,
+		Points:   stats.Points,
+		Bytes:    stats.Bytes,
+		Duration: took,
+		Latency:  latency,
+	}, res)
+	return err
+}
+
+// writer returns the BatchWriter an INSERT writes through, spreading its
+// batches over the servers in SET addresses and the databases dbs, or
+// over dbs of env.Sink.
+func (env *ExecEnv) writer(dbs []string, rp string) (BatchWriter, error) {
+	return env.connWriter(dbs, rp, 0)
+}
+
+// connWriter is writer, writing over one connection to each server of its
+// own if conn is not zero.
+func (env *ExecEnv) connWriter(dbs []string, rp string, conn int) (BatchWriter, error) {
+	fanout := &FanoutWriter{}
+	if env.Sink != nil {
+		for _, db := range dbs {
+			fanout.Writers = append(fanout.Writers, &sinkWriter{sink: env.Sink, db: db, rp: rp})
+		}
+	} else {
+		for _, addr := range addresses(env.Vars) {
+			client, err := env.connClient(addr, conn)
+			if err != nil {
+				return nil, err
+			}
+			for _, db := range dbs {
+				fanout.Writers = append(fanout.Writers, &HTTPWriter{
+					Addr:            addr,
+					Database:        db,
+					RetentionPolicy: rp,
+					Username:        env.Vars["username"],
+					Password:        env.Vars["password"],
+					Client:          client,
+					Header:          HeadersFromVars(env.Vars),
+				})
+			}
+		}
+	}
+	if len(fanout.Writers) == 1 {
+		return fanout.Writers[0], nil
+	}
+	return fanout, nil
+}
+
+// queryStats counts a QUERY's requests as it runs.
+type queryStats struct {
+	requests, errors, violations, bytes int64
+	latency                             Histogram
+	errs                                errorSample
+}
+
+// Exec runs the query's rounds over queryConcurrency workers, each pausing
+// queryInterval between rounds. A round is one query, or FANOUT queries
+// issued at once.
+func (i *QueryStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	count, err := strconv.ParseInt(i.Count, 10, 64)
+	if err != nil {
+		return fmt.Errorf("query %q: invalid count %q", i.Name, i.Count)
+	}
+	expect, err := CompileExpectation(i)
+	if err != nil {
+		return err
+	}
+	var qg *QueryGenerator
+	switch v := env.Vars["queryTags"]; v {
+	case "", "insert":
+		env.run.mu.Lock()
+		g := env.run.generators[i.Name]
+		env.run.mu.Unlock()
+		qg, err = CompileQuery(i, g, env.Args)
+	case "server":
+		var values map[string][]string
+		if values, err = env.serverTagValues(ctx, i); err != nil {
+			return err
+		}
+		qg, err = CompileQueryTags(i, StaticTagValues(values), env.Args)
+	default:
+		return fmt.Errorf("invalid queryTags %q, expected insert or server", v)
+	}
+	if err != nil {
+		return err
+	}
+	concurrency, err := intVar(env.Vars, "queryConcurrency", 1)
+	if err != nil {
+		return err
+	}
+	interval, err := durationVar(env.Vars, "queryInterval", 0)
+	if err != nil {
+		return err
+	}
+
+	env.Logger.Info("query started", "statement", i.Name, "count", count)
+	var st queryStats
+	var next int64
+	start := env.Clock.Now()
+	phase := env.Vars["phase"]
+	done := env.track(func() StatementResult {
+		return StatementResult{
+			Name:       i.Name,
+			Kind:       KindQuery,
+			Source:     i.Location(),
+			Phase:      phase,
+			Requests:   atomic.LoadInt64(&st.requests),
+			Errors:     atomic.LoadInt64(&st.errors),
+			Bytes:      atomic.LoadInt64(&st.bytes),
+			Duration:   env.Clock.Since(start),
+			Latency:    st.latency.Summary(),
+			Violations: atomic.LoadInt64(&st.violations),
+		}
+	})
+	defer done()
+	var wg sync.WaitGroup
+	for n := 0; n < concurrency; n++ {
+		r := rand.New(rand.NewSource(env.Rand.Int63()))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				round := atomic.AddInt64(&next, 1) - 1
+				if round >= count || ctx.Err() != nil {
+					return
+				}
+				var fan sync.WaitGroup
+				for _, q := range qg.AppendRound(nil, round) {
+					fan.Add(1)
+					go func(q, addr string) {
+						defer fan.Done()
+						env.send(ctx, addr, i.Name, q, expect, &st)
+					}(string(q), env.address(r))
+				}
+				fan.Wait()
+
+				if interval > 0 && !sleep(ctx, env.Clock, interval) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	took, latency := env.Clock.Since(start), st.latency.Summary()
+	env.record(i.Name, &StatementResult{
+		Name:       i.Name,
+		Kind:       KindQuery,
+		Source:     i.Location(),
+		Phase:      env.Vars["phase"],
+		Requests:   st.requests,
+		Errors:     st.errors,
+		Bytes:      st.bytes,
+		Duration:   took,
+		Latency:    latency,
+		Violations: st.violations,
+	}, &QueryResult{
+		Queries:     st.requests,
+		Errors:      st.errors,
+		Violations:  st.violations,
+		Bytes:       st.bytes,
+		Duration:    took,
+		Latency:     latency,
+		FirstErrors: st.errs.list(),
+	})
+	return ctx.Err()
+}
+
+// send issues one query of the statement name to addr, counting it in st.
+func (env *ExecEnv) send(ctx context.Context, addr, name, q string, expect *Expectation, st *queryStats) {
+	start := env.Clock.Now()
+	body, id, err := env.request(ctx, addr, "GET", q)
+	took := env.Clock.Since(start)
+	if ctx.Err() != nil {
+		return
+	}
+	st.latency.Record(took)
+	atomic.AddInt64(&st.requests, 1)
+	atomic.AddInt64(&st.bytes, int64(len(body)))
+
+	e := Event{Time: env.Clock.Now(), Type: EventQuery, Statement: name, Bytes: int64(len(body)), Latency: took, RequestID: id}
+	if err == nil && expect != nil {
+		// A response of the wrong shape is a violation, not an error.
+		if err := expect.Check(body); err != nil {
+			atomic.AddInt64(&st.violations, 1)
+			st.errs.add(err)
+			env.Logger.Warn("query expectation failed", "statement", name, "err", err)
+			e.Error = err.Error()
+		}
+	}
+	if err != nil {
+		atomic.AddInt64(&st.errors, 1)
+		st.errs.add(err)
+		env.Logger.Warn("query failed", "statement", name, "err", err)
+		e.Type, e.Error = EventError, err.Error()
+	}
+	env.Events.Emit(e)
+	env.ErrorBudget.Record(err != nil)
+	env.SlowLog.Record(SlowQuery{
+		Time:      start,
+		Statement: name,
+		Database:  env.Vars["database"],
+		Query:     q,
+		Latency:   took,
+		Bytes:     int64(len(body)),
+		RequestID: id,
+	})
+	if env.Capture.Sample() {
+		c := Capture{Time: start, Statement: name, Kind: CaptureQuery, Database: env.Vars["database"], Query: q, RequestID: id, Body: string(body)}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		env.Capture.Record(c)
+	}
+}
+
+// Exec starts repeating the query in the background, once per interval.
+// Without a count it runs until env is closed, and WAIT does not wait for
+// it.
+func (i *EveryStatement) Exec(ctx context.Context, env *ExecEnv) error {
+	interval, err := time.ParseDuration(i.Interval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("every: invalid interval %q", i.Interval)
+	}
+	count := int64(-1)
+	if i.Count != "" {
+		if count, err = strconv.ParseInt(i.Count, 10, 64); err != nil || count < 0 {
+			return fmt.Errorf("every: invalid count %q", i.Count)
+		}
+	}
+
+	env = env.fork()
+	done := env.run.background.Done
+	if count < 0 {
+		env.run.background.Add(1)
+	} else {
+		done = env.start(i)
+	}
+	go func() {
+		defer done()
+		t := env.Clock.NewTicker(interval)
+		defer t.Stop()
+		for n := int64(0); count < 0 || n < count; n++ {
+			select {
+			case <-t.C():
+			case <-ctx.Done():
+				return
+			case <-env.run.done:
+				return
+			}
+			_, _, err := env.request(ctx, env.address(env.Rand), "POST", i.Query)
+			if err != nil && ctx.Err() == nil {
+				env.Logger.Warn("every failed", "query", i.Query, "err", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// request sends q to the /query endpoint of addr, returning the body of a
+// successful response and the request's ID. A response reporting an error
+// in its body fails too.
+func (env *ExecEnv) request(ctx context.Context, addr, method, q string) ([]byte, string, error) {
+	if env.Sink != nil {
+		body, err := env.Sink.Query(ctx, env.Vars["database"], env.Vars["retentionPolicy"], q)
+		if err == nil {
+			err = responseError(body)
+		}
+		if err != nil {
+			return body, "", fmt.Errorf("query: %w", err)
+		}
+		return body, "", nil
+	}
+	client, err := env.clientFor(addr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	v := url.Values{"q": {q}}
+	if db := env.Vars["database"]; db != "" {
+		v.Set("db", db)
+	}
+	if rp := env.Vars["retentionPolicy"]; rp != "" {
+		v.Set("rp", rp)
+	}
+	req, err := http.NewRequest(method, serverURL(addr)+"/query?"+v.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range HeadersFromVars(env.Vars) {
+		req.Header[k] = v
+	}
+	id := NewRequestID()
+	req.Header.Set(RequestIDHeader, id)
+	if u := env.Vars["username"]; u != "" {
+		req.SetBasicAuth(u, env.Vars["password"])
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, id, fmt.Errorf("query %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return body, id, fmt.Errorf("query %s: %w", id, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg := strings.TrimSpace(string(body))
+		if len(msg) > 256 {
+			msg = msg[:256]
+		}
+		return body, id, fmt.Errorf("query %s: %s: %s", id, resp.Status, msg)
+	}
+	if err := responseError(body); err != nil {
+		return body, id, fmt.Errorf("query %s: %v", id, err)
+	}
+	return body, id, nil
+}
+
+func (env *ExecEnv) clientFor(addr string) (*http.Client, error) {
+	return env.connClient(addr, 0)
+}
+
+// connClient is clientFor, keeping to a connection of its own if conn is
+// not zero.
+func (env *ExecEnv) connClient(addr string, conn int) (*http.Client, error) {
+	if env.Client != nil {
+		return env.Client, nil
+	}
+	opts, err := TransportFromVars(env.Vars)
+	if err != nil {
+		return nil, err
+	}
+	if conn != 0 {
+		opts.MaxConnsPerHost = 1
+	}
+	k := clientKey{addr: addr, opts: opts, conn: conn}
+	env.run.mu.Lock()
+	defer env.run.mu.Unlock()
+	c, ok := env.run.clients[k]
+	if !ok {
+		c = opts.ClientFor(addr)
+		env.run.clients[k] = c
+	}
+	return c, nil
+}
+
+// address picks one of the servers in SET addresses with r.
+func (env *ExecEnv) address(r *rand.Rand) string {
+	addrs := addresses(env.Vars)
+	return addrs[r.Intn(len(addrs))]
+}
+
+// addresses returns the servers set with SET addresses, a comma separated
+// list, or localhost:8086.
+func addresses(vars map[string]string) []string {
+	if addrs := splitAddresses(vars["addresses"]); len(addrs) > 0 {
+		return addrs
+	}
+	return []string{"localhost:8086"}
+}
+
+// splitAddresses splits a comma separated list of servers.
+func splitAddresses(s string) []string {
+	var addrs []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,130 @@
+package stressql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Expectation is the shape a QUERY's responses must have, set with EXPECT.
+// A server under load can return truncated or empty results without an
+// error; checking every response catches it.
+type Expectation struct {
+	// Series is the number of series expected, or -1 for any.
+	Series   int
+	NonEmpty bool
+}
+
+// CompileExpectation returns the expectation set on stmt, or nil if it has
+// none.
+func CompileExpectation(stmt *QueryStatement) (*Expectation, error) {
+	if stmt.ExpectSeries == "" && !stmt.ExpectNonEmpty {
+		return nil, nil
+	}
+	e := &Expectation{Series: -1, NonEmpty: stmt.ExpectNonEmpty}
+	if stmt.ExpectSeries != "" {
+		n, err := strconv.Atoi(stmt.ExpectSeries)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("query %q: invalid EXPECT SERIES %q", stmt.Name, stmt.ExpectSeries)
+		}
+		e.Series = n
+	}
+	return e, nil
+}
+
+// ExpectationError is a response that did not have the expected shape.
+type ExpectationError struct {
+	Series  int
+	Values  int
+	Partial bool
+	Reason  string
+}
+
+func (e *ExpectationError) Error() string {
+	s := fmt.Sprintf("unexpected result: %s (%d series, %d values", e.Reason, e.Series, e.Values)
+	if e.Partial {
+		s += ", partial"
+	}
+	return s + ")"
+}
+
+// queryResponse is the JSON body of an InfluxDB /query response, or one
+// chunk of a chunked response.
+type queryResponse struct {
+	Results []struct {
+		Series []struct {
+			Values []json.RawMessage `json:"values"`
+		} `json:"series"`
+		Partial bool   `json:"partial"`
+		Error   string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+// responseError returns the first error reported in a response body.
+// Bodies that are not query responses report none.
+func responseError(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var r queryResponse
+		if err := dec.Decode(&r); err != nil {
+			return nil
+		}
+		if r.Error != "" {
+			return fmt.Errorf("query error: %s", r.Error)
+		}
+		for _, res := range r.Results {
+			if res.Error != "" {
+				return fmt.Errorf("query error: %s", res.Error)
+			}
+		}
+	}
+}
+
+// Check checks a response body against the expectation, returning an
+// *ExpectationError if it does not match or was truncated, ending on a
+// partial result. Chunked responses are read chunk by chunk; a series
+// split across chunks counts once.
+func (e *Expectation) Check(body []byte) error {
+	var series, values int
+	var partial bool
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var r queryResponse
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("invalid query response: %v", err)
+		}
+		if r.Error != "" {
+			return fmt.Errorf("query error: %s", r.Error)
+		}
+		for _, res := range r.Results {
+			if res.Error != "" {
+				return fmt.Errorf("query error: %s", res.Error)
+			}
+			for _, s := range res.Series {
+				values += len(s.Values)
+			}
+			// A partial chunk continues its last series in the next.
+			if !partial || len(res.Series) == 0 {
+				series += len(res.Series)
+			} else {
+				series += len(res.Series) - 1
+			}
+			partial = res.Partial
+		}
+	}
+
+	switch {
+	case partial:
+		return &ExpectationError{Series: series, Values: values, Partial: partial, Reason: "truncated"}
+	case e.NonEmpty && values == 0:
+		return &ExpectationError{Series: series, Values: values, Partial: partial, Reason: "empty"}
+	case e.Series >= 0 && series != e.Series:
+		return &ExpectationError{Series: series, Values: values, Partial: partial, Reason: fmt.Sprintf("expected %d series", e.Series)}
+	}
+	return nil
+}
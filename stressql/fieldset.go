@@ -0,0 +1,101 @@
+package stressql
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// fieldSet compiles a template standing for several fields, whose own
+// template number is seed, into the parts writing them.
+func (g *Generator) fieldSet(t *Template, seed uint64, numeric map[string]*compiledTemplate) ([]part, error) {
+	set := t.Fields
+	count, err := strconv.ParseInt(set.Count, 10, 64)
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid %s count %q", set.Kind, set.Count)
+	}
+
+	switch set.Kind {
+	case "fields":
+		parts := make([]part, 0, count)
+		for k := int64(0); k < count; k++ {
+			// Each field draws values of its own.
+			v, err := compileTemplate(t, seed+uint64(k)<<32)
+			if err != nil {
+				return nil, err
+			}
+			if v.derive != "" || v.churnEvery > 0 {
+				return nil, fmt.Errorf("derive and churn do not apply to fields")
+			}
+			key := set.Prefix + strconv.FormatInt(k, 10)
+			lit := string(escapeFrom([]byte(key), 0, keyEscapes)) + "="
+			if k > 0 {
+				lit = "," + lit
+			}
+			f, err := g.valueField(v, lit, key, numeric)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, f)
+		}
+		return parts, nil
+
+	case "histogram":
+		v, err := compileTemplate(t, seed)
+		if err != nil {
+			return nil, err
+		}
+		if v.kind != kindInt && v.kind != kindFloat {
+			return nil, fmt.Errorf("histogram needs an int or float type")
+		}
+		if v.derive != "" || v.churnEvery > 0 || v.byKey != "" {
+			return nil, fmt.Errorf("derive, churn and by do not apply to a histogram")
+		}
+		bounds := make([]float64, len(set.Bounds))
+		for i, s := range set.Bounds {
+			if bounds[i], err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("invalid histogram bound %q", s)
+			}
+			if i > 0 && bounds[i] <= bounds[i-1] {
+				return nil, fmt.Errorf("histogram bounds must increase, found %s after %s", s, set.Bounds[i-1])
+			}
+		}
+		return []part{histogram(v, bounds, set.Bounds, uint64(count))}, nil
+	}
+	return nil, fmt.Errorf("unknown field set %q", set.Kind)
+}
+
+// histogram writes the buckets of count observations of v per point: for
+// each bound, named by keys, the observations at or below it, then all of
+// them as +Inf and count, and their sum. Like Telegraf's, the counts are
+// floats.
+func histogram(v *compiledTemplate, bounds []float64, keys []string, count uint64) part {
+	return func(b []byte, _, point uint64) []byte {
+		sc := getScratch()
+		defer putScratch(sc)
+		counts := append(sc.counts[:0], make([]uint64, len(bounds)+1)...)
+		sc.counts = counts
+		var sum float64
+		for j := uint64(0); j < count; j++ {
+			x := v.number(point*count + j)
+			counts[sort.SearchFloat64s(bounds, x)]++
+			sum += x
+		}
+
+		var below uint64
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			below += counts[i]
+			b = append(append(b, k...), '=')
+			b = strconv.AppendUint(b, below, 10)
+		}
+		if len(keys) > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendUint(append(b, "+Inf="...), count, 10)
+		b = strconv.AppendUint(append(b, ",count="...), count, 10)
+		return strconv.AppendFloat(append(b, ",sum="...), sum, 'f', -1, 64)
+	}
+}
@@ -0,0 +1,83 @@
+package stressql
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// FloatFormat is how generated float fields are written, which changes
+// the size of a payload, how well it compresses and what it costs the
+// server to parse. The zero value writes the shortest text that parses
+// back to the same value.
+type FloatFormat struct {
+	// Fixed writes Digits digits after the decimal point. Otherwise a
+	// non-zero Digits rounds values to that many significant digits,
+	// written as briefly as they can be.
+	Fixed  bool
+	Digits int
+}
+
+// FloatFormatFromVars reads the float format set in the DSL with
+//
+//	SET floatFormat fixed
+//	SET floatDigits 2
+//
+// floatFormat is shortest, the default, or fixed.
+func FloatFormatFromVars(vars map[string]string) (FloatFormat, error) {
+	var f FloatFormat
+	switch v := vars["floatFormat"]; v {
+	case "", "shortest":
+	case "fixed":
+		f.Fixed = true
+	default:
+		return f, fmt.Errorf("invalid floatFormat %q, expected shortest or fixed", v)
+	}
+	if v := vars["floatDigits"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("invalid floatDigits %q", v)
+		}
+		f.Digits = n
+	}
+	return f, nil
+}
+
+// Append appends v to b as f says.
+func (f FloatFormat) Append(b []byte, v float64) []byte {
+	switch {
+	case f.Fixed:
+		return strconv.AppendFloat(b, v, 'f', f.Digits, 64)
+	case f.Digits > 0:
+		// Rounded in exponent form, which counts significant digits, but
+		// written without an exponent.
+		var buf [32]byte
+		r, err := strconv.ParseFloat(string(strconv.AppendFloat(buf[:0], v, 'e', f.Digits-1, 64)), 64)
+		if err == nil {
+			v = r
+		}
+	}
+	return strconv.AppendFloat(b, v, 'f', -1, 64)
+}
+
+// reformat rewrites the float ending b, from n on, as f says.
+func (f FloatFormat) reformat(b []byte, n int) []byte {
+	if len(b) <= n {
+		return b
+	}
+	v, err := strconv.ParseFloat(string(b[n:]), 64)
+	if err != nil {
+		return b
+	}
+	return f.Append(b[:n], v)
+}
+
+// formatted returns p, a float field, written as g.Floats says.
+func (g *Generator) formatted(p part) part {
+	return func(b []byte, series, point uint64) []byte {
+		if g.Floats == (FloatFormat{}) {
+			return p(b, series, point)
+		}
+		n := len(b)
+		return g.Floats.reformat(p(b, series, point), n)
+	}
+}
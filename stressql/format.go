@@ -0,0 +1,244 @@
+package stressql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Format renders statements back to DSL text, one block per statement.
+func Format(seq []Statement) string {
+	blocks := make([]string, 0, len(seq))
+	for _, s := range seq {
+		blocks = append(blocks, fmt.Sprint(s))
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+func (i *InfluxqlStatement) String() string { return strings.TrimSpace(i.Value) }
+
+func (i *InsertStatement) String() string {
+	args := make([]interface{}, 0, len(i.Templates)+1)
+	for _, t := range i.Templates {
+		args = append(args, t)
+	}
+	if i.Timestamp != nil {
+		args = append(args, i.Timestamp)
+	}
+
+	// Put the measurement, tags, fields and timestamp on their own lines.
+	parts := strings.SplitN(i.TemplateString, " ", 3)
+	for n, part := range parts {
+		c := strings.Count(part, "%v")
+		if c > len(args) {
+			c = len(args)
+		}
+		parts[n] = fmt.Sprintf(part, args[:c]...)
+		args = args[c:]
+	}
+	if n := strings.Index(parts[0], ","); n >= 0 {
+		parts[0] = parts[0][:n+1] + "\n" + parts[0][n+1:]
+	}
+
+	head := "INSERT " + i.Name
+	if i.RetentionPolicy != "" {
+		head += " INTO " + target(i.Database, i.RetentionPolicy)
+	}
+	if i.Across != "" {
+		head += " ACROSS " + i.Across + " DATABASES"
+	}
+
+	return head + "\n" + strings.Join(parts, "\n")
+}
+
+// target renders db.rp, or either alone if the other is empty.
+func target(db, rp string) string {
+	switch {
+	case db == "":
+		return rp
+	case rp == "":
+		return db
+	}
+	return db + "." + rp
+}
+
+func (t *Timestamp) String() string {
+	s := t.Count + " " + t.Duration
+	if t.Jitter {
+		s += " jitter"
+	}
+	if t.RealTime {
+		s += " realtime"
+	}
+	if t.Grow != "" {
+		s += " GROW " + t.Grow + " series/" + t.GrowUnit
+	}
+	if t.Rate != "" {
+		s += " RATE " + t.Rate
+		if t.RateUnit != "" {
+			s += " " + t.RateUnit + "/s"
+		}
+	}
+	if t.Burst != "" {
+		s += " BURST " + t.Burst + "x FOR " + t.BurstFor + " EVERY " + t.BurstEvery
+	}
+	if len(t.Steps) > 0 {
+		s += " STEPS " + strings.Join(t.Steps, ",") + " pts/s HOLD " + t.StepHold
+	}
+	for _, a := range t.Anomalies {
+		s += " " + a.String()
+	}
+	if t.Order != "" {
+		s += " ORDER BY " + t.Order
+	}
+	return s
+}
+
+func (a *AnomalySpec) String() string {
+	s := "ANOMALY " + a.Kind + " " + a.Rate
+	if a.Factor != "" {
+		s += " " + a.Factor + "x"
+	}
+	if a.For != "" {
+		s += " FOR " + a.For
+	}
+	return s
+}
+
+func (t *Template) String() string {
+	if s := t.Fields; s != nil && len(t.Functions) == 1 {
+		if s.Kind == "fields" {
+			return fmt.Sprintf("[fields %s %s %s]", s.Prefix, s.Count, t.Functions[0])
+		}
+		return fmt.Sprintf("[%s %s %s %s]", s.Kind, strings.Join(s.Bounds, ","), s.Count, t.Functions[0])
+	}
+	parts := make([]string, 0, len(t.Tags)+len(t.Functions))
+	if len(t.Tags) > 0 {
+		parts = append(parts, strings.Join(t.Tags, "|"))
+	}
+	for _, fn := range t.Functions {
+		parts = append(parts, fn.String())
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func (f *Function) String() string {
+	s := fmt.Sprintf("%s %s(%s) %s", f.Type, f.Fn, f.Argument, f.Count)
+	if f.Churn != "" {
+		s += fmt.Sprintf(" churn(%s) %s", f.Churn, f.ChurnEvery)
+	}
+	if f.By != "" {
+		s += " by(" + f.By + ")"
+	}
+	return s
+}
+
+func (i *QueryStatement) String() string {
+	args := make([]interface{}, len(i.Args))
+	for n, a := range i.Args {
+		args[n] = a
+	}
+	q := strings.TrimSpace(fmt.Sprintf(i.TemplateString, args...))
+
+	s := fmt.Sprintf("QUERY %s\n%s\nDO %s", i.Name, q, i.Count)
+	if i.Fanout != "" {
+		s += " FANOUT " + i.Fanout
+	}
+	if i.ExpectSeries != "" {
+		s += "\nEXPECT SERIES " + i.ExpectSeries
+	}
+	if i.ExpectNonEmpty {
+		s += "\nEXPECT NONEMPTY"
+	}
+	return s
+}
+
+func (i *ExecStatement) String() string {
+	return strings.Join(append([]string{"EXEC", i.Script}, i.Args...), " ")
+}
+
+func (i *EveryStatement) String() string {
+	s := "EVERY " + i.Interval
+	if i.Count != "" {
+		s += " DO " + i.Count
+	}
+	return s + "\n" + i.Query
+}
+
+func (i *ContinuousQueryStatement) String() string {
+	s := "CQ " + i.Name
+	if i.Database != "" {
+		s += " ON " + i.Database
+	}
+	return s + "\n" + i.Query
+}
+
+func (i *SLOStatement) String() string {
+	s := "SLO " + i.Metric + " " + i.Op + " " + i.Value
+	if i.Scope != "" {
+		s += " FOR " + i.Scope
+	}
+	return s
+}
+
+func (i *UseStatement) String() string {
+	if i.Addresses != "" {
+		return "USE " + target(i.Database, i.RetentionPolicy) + ` ON "` + i.Addresses + `"`
+	}
+	return "USE " + target(i.Database, i.RetentionPolicy)
+}
+
+func (i *WaitStatement) String() string {
+	if i.For != "" {
+		return "WAIT " + i.For
+	}
+	return "WAIT"
+}
+
+func (i *SetStatement) String() string {
+	v := i.Value
+	if st, err := ParseStatement("SET x " + v); err != nil || st.(*SetStatement).Value != v {
+		v = `"` + v + `"`
+	}
+	return "SET " + i.Var + " " + v
+}
+
+func (i *GoStatement) String() string {
+	s := "GO "
+	if i.Concurrency != "" {
+		s += i.Concurrency + " "
+	}
+	if len(i.After) > 0 {
+		s += "AFTER " + strings.Join(i.After, ", ") + " "
+	}
+	if i.Until != "" {
+		s += "UNTIL " + i.Until + " "
+		if i.Equals != "" {
+			s += "= " + i.Equals + " "
+		}
+	}
+	return fmt.Sprint(s, i.Statement)
+}
+
+func (i *VersionStatement) String() string { return "VERSION " + i.Version }
+
+func (i *ProfileStatement) String() string { return "PROFILE " + i.Name }
+
+func (i *EndStatement) String() string { return "END" }
+
+func (i *DeadlineStatement) String() string { return "DEADLINE " + i.Duration }
+
+func (i *SleepStatement) String() string { return "SLEEP " + i.Duration }
+
+func (i *CapStatement) String() string { return i.Kind + " " + i.Value }
+
+func (i *RetentionPolicyStatement) String() string {
+	s := "CREATE RP "
+	if i.Alter {
+		s = "ALTER RP "
+	}
+	s += i.Name
+	if i.Database != "" {
+		s += " ON " + i.Database
+	}
+	return s + i.clauses()
+}
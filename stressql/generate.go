@@ -0,0 +1,938 @@
+package stressql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Generator produces the points described by an INSERT statement. It is
+// compiled once from the statement's templates: literal text, tag sets and
+// series keys are precomputed, so producing a point is a handful of appends.
+//
+// Points are emitted time-major: point i belongs to series i % Series at
+// time step i / Series. Every value is a pure function of the point index,
+// so a Generator is safe for concurrent use and runs are reproducible.
+type Generator struct {
+	Name     string
+	Series   int64
+	Points   int64
+	Interval time.Duration
+	Jitter   bool
+	// RealTime generators are paced by Pipeline to emit one step per
+	// Interval, stamped with the time it is emitted.
+	RealTime bool
+	// Start is the timestamp of the first step in nanoseconds. Compile sets
+	// it so the last step falls on the current time; see StartAt.
+	Start int64
+	// Overlap is the fraction of points rewritten into an earlier, already
+	// written step, and ExtraFields the number of extra fields of rotating
+	// types added to each point. Both exist to create compaction work.
+	Overlap     float64
+	ExtraFields int
+	// GrowBy series become active per GrowEvery of data time, starting
+	// from one; points for series not yet active are skipped.
+	GrowBy    int64
+	GrowEvery time.Duration
+	// Shape, if set, limits the rate Pipeline writes at. It does not apply
+	// to RealTime generators, which are paced by their interval.
+	Shape LoadShape
+	// RateUnit is what Shape's rate counts.
+	RateUnit RateUnit
+	// Order is the order points are emitted in. It does not apply to
+	// RealTime generators, which emit a step at a time.
+	Order EmitOrder
+	// Floats is how float fields are written. It may be changed until the
+	// Generator is used.
+	Floats FloatFormat
+	// Calendar, if set, places steps at local calendar times instead of
+	// every Interval from Start. StartAt must be called after setting it.
+	Calendar *Calendar
+
+	// startSlot is the Calendar slot of the first step.
+	startSlot int64
+	anomalies []anomaly
+	// tags are the generated tags whose values vary by series, and
+	// pointTags those with a new value every point, by key.
+	tags      map[string]*byTag
+	pointTags map[string]*byTag
+	// keys holds every series key when there are few enough to cache;
+	// otherwise key builds them per point.
+	keys    []byte
+	keyOffs []uint32
+	key     []part
+	fields  []part
+	// omits is set if a field may be left out of a point.
+	omits bool
+}
+
+// part appends one piece of a point's line.
+type part func(b []byte, series, point uint64) []byte
+
+// maxCachedKeys bounds the series keys precomputed by Compile.
+const maxCachedKeys = 1 << 18
+
+// Compile builds a Generator for stmt.
+func Compile(stmt *InsertStatement) (*Generator, error) {
+	if stmt.Timestamp == nil {
+		return nil, fmt.Errorf("insert %q: missing timestamp", stmt.Name)
+	}
+	points, err := strconv.ParseInt(stmt.Timestamp.Count, 10, 64)
+	if err != nil || points <= 0 {
+		return nil, fmt.Errorf("insert %q: invalid point count %q", stmt.Name, stmt.Timestamp.Count)
+	}
+	interval, err := time.ParseDuration(stmt.Timestamp.Duration)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("insert %q: invalid interval %q", stmt.Name, stmt.Timestamp.Duration)
+	}
+
+	// The last placeholder is the timestamp; the rest are templates.
+	lits := strings.Split(stmt.TemplateString, "%v")
+	if len(lits) != len(stmt.Templates)+2 {
+		return nil, fmt.Errorf("insert %q: %d templates for %d placeholders", stmt.Name, len(stmt.Templates), len(lits)-1)
+	}
+	lits = lits[:len(lits)-1]
+	lits[len(lits)-1] = strings.TrimRight(lits[len(lits)-1], " ")
+
+	g := &Generator{
+		Name:     stmt.Name,
+		Series:   1,
+		Points:   points,
+		Interval: interval,
+		Jitter:   stmt.Timestamp.Jitter,
+		RealTime: stmt.Timestamp.RealTime,
+	}
+
+	if ts := stmt.Timestamp; ts.Grow != "" {
+		g.GrowBy, err = strconv.ParseInt(ts.Grow, 10, 64)
+		if err != nil || g.GrowBy <= 0 {
+			return nil, fmt.Errorf("insert %q: invalid growth %q", stmt.Name, ts.Grow)
+		}
+		var ok bool
+		if g.GrowEvery, ok = growUnits[strings.ToLower(ts.GrowUnit)]; !ok {
+			return nil, fmt.Errorf("insert %q: unknown growth unit %q", stmt.Name, ts.GrowUnit)
+		}
+	}
+
+	if g.Shape, err = compileShape(stmt.Timestamp); err != nil {
+		return nil, fmt.Errorf("insert %q: %v", stmt.Name, err)
+	}
+	if g.RateUnit, err = parseRateUnit(stmt.Timestamp.RateUnit); err != nil {
+		return nil, fmt.Errorf("insert %q: %v", stmt.Name, err)
+	}
+	if g.anomalies, err = compileAnomalies(stmt.Timestamp.Anomalies, interval); err != nil {
+		return nil, fmt.Errorf("insert %q: %v", stmt.Name, err)
+	}
+	if g.Order, err = parseOrder(stmt.Timestamp.Order); err != nil {
+		return nil, fmt.Errorf("insert %q: %v", stmt.Name, err)
+	}
+	if g.Order != OrderTime && g.RealTime {
+		return nil, fmt.Errorf("insert %q: ORDER BY %s does not apply to REALTIME", stmt.Name, g.Order)
+	}
+
+	inKey, measurement, cacheable := true, true, true
+	numeric := map[string]*compiledTemplate{}
+	derived := map[int]*compiledTemplate{}
+	derivedKeys := map[int]string{}
+	g.tags = map[string]*byTag{}
+	g.pointTags = map[string]*byTag{}
+	for n, lit := range lits {
+		if inKey {
+			if sp := strings.IndexByte(lit, ' '); sp >= 0 {
+				inKey = false
+				if sp > 0 {
+					g.key = append(g.key, literal(lit[:sp]))
+				}
+				lit = lit[sp:]
+			} else if strings.IndexByte(lit, ',') >= 0 {
+				measurement = false
+			}
+		}
+		if lit != "" {
+			if inKey {
+				g.key = append(g.key, literal(lit))
+			} else {
+				g.fields = append(g.fields, literal(lit))
+			}
+		}
+		if n == len(stmt.Templates) {
+			break
+		}
+
+		if set := stmt.Templates[n].Fields; set != nil {
+			if inKey || strings.HasSuffix(lit, "=") {
+				return nil, fmt.Errorf("insert %q: template %d: %s stands for whole fields, in place of key=[...]", stmt.Name, n+1, set.Kind)
+			}
+			parts, err := g.fieldSet(stmt.Templates[n], uint64(n), numeric)
+			if err != nil {
+				return nil, fmt.Errorf("insert %q: template %d: %v", stmt.Name, n+1, err)
+			}
+			g.fields = append(g.fields, parts...)
+			continue
+		}
+
+		v, err := compileTemplate(stmt.Templates[n], uint64(n))
+		if err != nil {
+			return nil, fmt.Errorf("insert %q: template %d: %v", stmt.Name, n+1, err)
+		}
+
+		if v.derive != "" {
+			if inKey || !strings.HasSuffix(lit, "=") {
+				return nil, fmt.Errorf("insert %q: template %d: derive only applies to fields", stmt.Name, n+1)
+			}
+			// Placed once every field is known, so any can be referenced.
+			derived[len(g.fields)], derivedKeys[len(g.fields)] = v, fieldKey(lit)
+			g.fields = append(g.fields, nil)
+			continue
+		}
+
+		if v.byKey != "" && (inKey || !strings.HasSuffix(lit, "=")) {
+			return nil, fmt.Errorf("insert %q: template %d: by only applies to fields", stmt.Name, n+1)
+		}
+
+		if inKey {
+			esc := keyEscapes
+			if measurement {
+				esc = measurementEscapes
+			}
+			if v.churnEvery > 0 {
+				stride := uint64(g.Series)
+				g.key = append(g.key, v.churning(g, stride, esc))
+				if g.Series, err = MulCounts(g.Series, v.count); err != nil {
+					return nil, fmt.Errorf("insert %q: template %d: too many series", stmt.Name, n+1)
+				}
+				cacheable = false
+			} else if v.count > 0 {
+				stride := uint64(g.Series)
+				g.key = append(g.key, v.bySeries(stride, esc))
+				if g.Series, err = MulCounts(g.Series, v.count); err != nil {
+					return nil, fmt.Errorf("insert %q: template %d: too many series", stmt.Name, n+1)
+				}
+				if !measurement {
+					key, prefix := tagKey(lit)
+					g.tags[key] = &byTag{tag: v, prefix: prefix, stride: stride}
+				}
+			} else {
+				g.key = append(g.key, v.byPoint(esc))
+				cacheable = false
+				if !measurement {
+					key, prefix := tagKey(lit)
+					g.pointTags[key] = &byTag{tag: v, prefix: prefix}
+				}
+			}
+		} else if v.churnEvery > 0 {
+			return nil, fmt.Errorf("insert %q: template %d: churn only applies to tags", stmt.Name, n+1)
+		} else if strings.HasSuffix(lit, "=") {
+			// The field writes lit, its key, itself.
+			g.fields = g.fields[:len(g.fields)-1]
+			f, err := g.valueField(v, lit, fieldKey(lit), numeric)
+			if err != nil {
+				return nil, fmt.Errorf("insert %q: template %d: %v", stmt.Name, n+1, err)
+			}
+			g.fields = append(g.fields, f)
+		} else {
+			g.fields = append(g.fields, v.byPoint(keyEscapes))
+		}
+	}
+	if inKey {
+		return nil, fmt.Errorf("insert %q: no fields", stmt.Name)
+	}
+	for i, v := range derived {
+		e, err := compileDerived(v.derive, numeric)
+		if err != nil {
+			return nil, fmt.Errorf("insert %q: derive: %v", stmt.Name, err)
+		}
+		g.fields[i] = derivedField(v.kind, derivedKeys[i], e)
+		g.omits = true
+		if len(g.anomalies) > 0 {
+			g.fields[i] = g.anomalous(g.fields[i], v.kind)
+		}
+		if v.kind != kindInt {
+			g.fields[i] = g.formatted(g.fields[i])
+		}
+	}
+
+	// Every step, including the last, partial one, must be numbered, and
+	// stamped.
+	if _, err := MulCounts(g.Steps(), g.Series); err != nil {
+		return nil, fmt.Errorf("insert %q: %d points over %d series overflow", stmt.Name, g.Points, g.Series)
+	}
+	if _, err := MulCounts(g.Steps()-1, int64(g.Interval)); err != nil {
+		return nil, fmt.Errorf("insert %q: %d steps of %v span longer than a timestamp can", stmt.Name, g.Steps(), g.Interval)
+	}
+
+	if cacheable {
+		g.cacheKeys()
+	}
+
+	g.StartAt(time.Now())
+
+	return g, nil
+}
+
+// StartAt sets Start so the last step falls on t, truncated to the
+// interval, or with a Calendar on its last slot at or before t.
+func (g *Generator) StartAt(t time.Time) {
+	if g.Calendar != nil {
+		g.startSlot = g.Calendar.SlotAt(t) - (g.Steps() - 1)
+		g.Start = g.Calendar.SlotTime(g.startSlot).UnixNano()
+		return
+	}
+	g.Start = t.Truncate(g.Interval).UnixNano() - (g.Steps()-1)*int64(g.Interval)
+}
+
+// stepTime returns the timestamp of step, in nanoseconds.
+func (g *Generator) stepTime(step int64) int64 {
+	if g.Calendar != nil {
+		return g.Calendar.SlotTime(g.startSlot + step).UnixNano()
+	}
+	return g.Start + step*int64(g.Interval)
+}
+
+// cacheKeys precomputes every series key if there are few enough.
+func (g *Generator) cacheKeys() {
+	g.keys, g.keyOffs = nil, nil
+	if g.Series > maxCachedKeys {
+		return
+	}
+
+	g.keyOffs = make([]uint32, g.Series+1)
+	for s := uint64(0); s < uint64(g.Series); s++ {
+		for _, p := range g.key {
+			g.keys = p(g.keys, s, 0)
+		}
+		g.keyOffs[s+1] = uint32(len(g.keys))
+	}
+}
+
+// AddTag adds a tag with a fixed value to every series, such as the worker
+// writing them. It must be called before the Generator is used.
+func (g *Generator) AddTag(key, value string) {
+	var lit []byte
+	lit = append(lit, ',')
+	lit = AppendTagValue(lit, key)
+	lit = append(lit, '=')
+	lit = AppendTagValue(lit, value)
+	g.key = append(g.key, literal(string(lit)))
+
+	if g.keyOffs != nil {
+		g.cacheKeys()
+	}
+}
+
+// Steps returns the number of time steps the points span.
+func (g *Generator) Steps() int64 {
+	return ceilDiv(g.Points, g.Series)
+}
+
+var growUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+}
+
+// Active reports whether the n'th point emitted is active: its series has
+// grown into existence by its time step and the point is not in a gap
+// anomaly. It is always true without a growth schedule or gaps.
+func (g *Generator) Active(n int64) bool {
+	return g.active(g.point(n))
+}
+
+// active reports whether point i is active; see point.
+func (g *Generator) active(i int64) bool {
+	if len(g.anomalies) > 0 && g.gap(i) {
+		return false
+	}
+	if g.GrowBy <= 0 {
+		return true
+	}
+	step := i / g.Series
+	elapsed := float64(step) * float64(g.Interval) / float64(g.GrowEvery)
+	return float64(i%g.Series) < 1+elapsed*float64(g.GrowBy)
+}
+
+// AppendPoint appends the n'th point emitted, in Order, as a line of line
+// protocol to b. Points that are not Active append nothing.
+func (g *Generator) AppendPoint(b []byte, n int64) []byte {
+	e := Encoder{buf: b}
+	g.EncodePoint(&e, n)
+	return e.buf
+}
+
+// AppendPointAt appends point i with timestamp ts, in nanoseconds. It
+// appends nothing if every field of the point is left out.
+func (g *Generator) AppendPointAt(b []byte, i, ts int64) []byte {
+	e := Encoder{buf: b}
+	g.EncodePointAt(&e, i, ts)
+	return e.buf
+}
+
+// EncodePoint is AppendPoint, encoding the point with e. An Encoder kept
+// for a batch of points writes their shared timestamps once.
+func (g *Generator) EncodePoint(e *Encoder, n int64) {
+	i := g.point(n)
+	if !g.active(i) {
+		return
+	}
+	step := i / g.Series
+	series := uint64(i - step*g.Series)
+	if g.Overlap > 0 && step > 0 && unitFloat(mix(uint64(i)^overlapSalt)) < g.Overlap {
+		step = int64(mix(uint64(i)) % uint64(step))
+	}
+	ts := g.stepTime(step)
+	if g.Jitter {
+		ts += int64(mix(uint64(i)) % uint64(g.Interval))
+	}
+	g.encode(e, uint64(i), series, ts)
+}
+
+// EncodePointAt is AppendPointAt, encoding the point with e.
+func (g *Generator) EncodePointAt(e *Encoder, i, ts int64) {
+	g.encode(e, uint64(i), uint64(i)%uint64(g.Series), ts)
+}
+
+// encode encodes point, of series, with timestamp ts.
+func (g *Generator) encode(e *Encoder, point, series uint64, ts int64) {
+	b := e.buf
+	start := len(b)
+
+	if g.keyOffs != nil {
+		b = append(b, g.keys[g.keyOffs[series]:g.keyOffs[series+1]]...)
+	} else {
+		for _, p := range g.key {
+			b = p(b, series, point)
+		}
+	}
+	fields := len(b)
+	for _, p := range g.fields {
+		b = p(b, series, point)
+	}
+	for n := 0; n < g.ExtraFields; n++ {
+		b = appendExtraField(b, n, point)
+	}
+	if g.omits {
+		// A first field left out leaves its space before the next
+		// field's comma; a point with every field left out is dropped.
+		if len(b) == fields+1 {
+			e.buf = b[:start]
+			return
+		}
+		if b[fields+1] == ',' {
+			b = append(b[:fields+1], b[fields+2:]...)
+		}
+	}
+
+	e.buf = b
+	e.EndPoint(ts)
+}
+
+const overlapSalt = 0x6f7665726c6170
+
+// appendExtraField appends the n'th extra field, whose type rotates through
+// integer, float, string and boolean by n so each key keeps one type.
+func appendExtraField(b []byte, n int, point uint64) []byte {
+	b = append(b, ",cp"...)
+	b = strconv.AppendInt(b, int64(n), 10)
+	b = append(b, '=')
+
+	h := mix(point + uint64(n)<<48)
+	switch n % 4 {
+	case 0:
+		b = strconv.AppendUint(b, h%1000, 10)
+		return append(b, 'i')
+	case 1:
+		return strconv.AppendFloat(b, unitFloat(h)*100, 'f', 2, 64)
+	case 2:
+		b = append(b, '"')
+		b = append(b, alphabet[h%uint64(len(alphabet))], alphabet[(h>>8)%uint64(len(alphabet))])
+		return append(b, '"')
+	}
+	return strconv.AppendBool(b, h&1 == 1)
+}
+
+func literal(s string) part {
+	lit := []byte(s)
+	return func(b []byte, _, _ uint64) []byte { return append(b, lit...) }
+}
+
+// valueKind is how a generated value is written as a field value.
+type valueKind int
+
+const (
+	kindRaw valueKind = iota
+	kindInt
+	kindFloat
+	kindString
+)
+
+// A value appends the k'th value of a template, unescaped. The same k always
+// yields the same value.
+type value func(b []byte, k uint64) []byte
+
+// compiledTemplate is a template ready to be placed in a line.
+type compiledTemplate struct {
+	kind  valueKind
+	count int64
+	fn    value
+	table [][]byte
+
+	// derive is the expression of a derived field, computed from the
+	// other fields of the point.
+	derive string
+
+	// churnEvery and churnPer replace churnPer of the count values with new
+	// ones every churnEvery of data time.
+	churnEvery time.Duration
+	churnPer   uint64
+
+	// byKey and byValues replace the value of a field with the one built
+	// from a different argument for series whose byKey tag has one of the
+	// byValues' values. Compile sets by to find the tag.
+	byKey    string
+	byValues map[string]value
+	by       *byTag
+}
+
+// byTag locates a generated tag's value in the series number. The value
+// starts with prefix, any fixed text before the template.
+type byTag struct {
+	tag    *compiledTemplate
+	prefix string
+	stride uint64
+	series uint64
+}
+
+// appendValue appends the tag's value for series.
+func (t *byTag) appendValue(b []byte, series uint64) []byte {
+	return t.tag.appendValue(append(b, t.prefix...), series/t.stride%uint64(t.tag.count))
+}
+
+// tagKey splits the literal before a tag template, as in "cpu,host=server-",
+// into the tag's key and the fixed prefix of its value.
+func tagKey(lit string) (key, prefix string) {
+	kvs := splitUnescaped(lit, ',')
+	key, prefix, _ = strings.Cut(kvs[len(kvs)-1], "=")
+	return unescapeKey(key), unescapeKey(prefix)
+}
+
+// maxTable bounds the values of a template precomputed by Compile.
+const maxTable = 1 << 16
+
+func compileTemplate(t *Template, seed uint64) (*compiledTemplate, error) {
+	if len(t.Tags) > 0 {
+		tags := t.Tags
+		return &compiledTemplate{
+			kind:  kindRaw,
+			count: int64(len(tags)),
+			fn:    func(b []byte, k uint64) []byte { return append(b, strings.Trim(tags[k], `"`)...) },
+		}, nil
+	}
+	if len(t.Functions) != 1 {
+		return nil, fmt.Errorf("expected one function, found %d", len(t.Functions))
+	}
+
+	f := t.Functions[0]
+	kind, ok := functionTypes[strings.ToLower(f.Type)]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q", f.Type)
+	}
+	if strings.EqualFold(f.Fn, "derive") {
+		if kind != kindInt && kind != kindFloat {
+			return nil, fmt.Errorf("derive needs an int or float type")
+		}
+		return &compiledTemplate{kind: kind, derive: f.Argument}, nil
+	}
+	build, ok := functions[strings.ToLower(f.Fn)]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", f.Fn)
+	}
+	fn, err := build(kind, f.Argument, mix(seed+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.Fn, err)
+	}
+	count, err := strconv.ParseInt(f.Count, 10, 64)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid count %q", f.Count)
+	}
+
+	c := &compiledTemplate{kind: kind, count: count, fn: fn}
+	if f.By != "" {
+		if err := c.compileBy(f.By, build, mix(seed+1)); err != nil {
+			return nil, err
+		}
+	}
+	if f.Churn != "" {
+		pct, err := strconv.ParseUint(f.Churn, 10, 64)
+		if err != nil || pct == 0 || pct > 100 {
+			return nil, fmt.Errorf("invalid churn %q, expected a percentage", f.Churn)
+		}
+		every, err := time.ParseDuration(f.ChurnEvery)
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("invalid churn interval %q", f.ChurnEvery)
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("churn needs a count of values")
+		}
+		c.churnEvery = every
+		// Rounded up, without overflowing for large counts.
+		c.churnPer = uint64(count)/100*pct + (uint64(count)%100*pct+99)/100
+	}
+	if count > 0 && count <= maxTable {
+		c.table = make([][]byte, count)
+		for k := range c.table {
+			c.table[k] = fn(nil, uint64(k))
+		}
+	}
+	return c, nil
+}
+
+// compileBy parses the text of by(tag, value=arg, ...), building the value
+// for each tag value the way the template's own was built.
+func (c *compiledTemplate) compileBy(spec string, build func(valueKind, string, uint64) (value, error), seed uint64) error {
+	parts := strings.Split(spec, ",")
+	c.byKey = strings.TrimSpace(parts[0])
+	if c.byKey == "" || len(parts) < 2 {
+		return fmt.Errorf("invalid by(%s), expected a tag and value=argument pairs", spec)
+	}
+	c.byValues = make(map[string]value, len(parts)-1)
+	for _, p := range parts[1:] {
+		tag, arg, ok := strings.Cut(p, "=")
+		tag, arg = strings.Trim(strings.TrimSpace(tag), `"`), strings.TrimSpace(arg)
+		if !ok || tag == "" || arg == "" {
+			return fmt.Errorf("invalid by value %q, expected value=argument", strings.TrimSpace(p))
+		}
+		fn, err := build(c.kind, arg, seed)
+		if err != nil {
+			return fmt.Errorf("by %s=%s: %v", c.byKey, tag, err)
+		}
+		c.byValues[tag] = fn
+	}
+	return nil
+}
+
+func (c *compiledTemplate) appendValue(b []byte, k uint64) []byte {
+	if k < uint64(len(c.table)) {
+		return append(b, c.table[k]...)
+	}
+	return c.fn(b, k)
+}
+
+// bySeries places the template in a series key, varying with the series
+// as one digit of a mixed-radix number whose lower digits span stride.
+func (c *compiledTemplate) bySeries(stride uint64, esc *escapes) part {
+	count := uint64(c.count)
+	return func(b []byte, series, _ uint64) []byte {
+		n := len(b)
+		return escapeFrom(c.appendValue(b, series/stride%count), n, esc)
+	}
+}
+
+// churning is bySeries for a churning template. Replacements sweep the
+// count positions in turn, churnPer each period, and a position replaced g
+// times takes value position + g*count, so retired values never return.
+func (c *compiledTemplate) churning(g *Generator, stride uint64, esc *escapes) part {
+	count := uint64(c.count)
+	return func(b []byte, series, point uint64) []byte {
+		j := series / stride % count
+		step := point / uint64(g.Series)
+		replaced := step * uint64(g.Interval) / uint64(c.churnEvery) * c.churnPer
+		gen := (replaced + count - 1 - j) / count
+
+		n := len(b)
+		return escapeFrom(c.appendValue(b, j+gen*count), n, esc)
+	}
+}
+
+// byPoint places the template where it varies with the point.
+func (c *compiledTemplate) byPoint(esc *escapes) part {
+	return func(b []byte, _, point uint64) []byte {
+		n := len(b)
+		return escapeFrom(c.appendValue(b, c.index(point)), n, esc)
+	}
+}
+
+// valueField places v as the value of the field key, after prefix, the
+// text before the value such as ",key=", formatted by its type, and
+// records it in numeric for derived fields to refer to.
+func (g *Generator) valueField(v *compiledTemplate, prefix, key string, numeric map[string]*compiledTemplate) (part, error) {
+	if v.byKey != "" {
+		by, ok := g.tags[v.byKey]
+		if !ok {
+			return nil, fmt.Errorf("no generated tag %q to vary by", v.byKey)
+		}
+		v.by = &byTag{tag: by.tag, prefix: by.prefix, stride: by.stride, series: uint64(g.Series)}
+	}
+	f := v.field()
+	if v.kind == kindInt || v.kind == kindFloat {
+		numeric[key] = v
+		if len(g.anomalies) > 0 {
+			f = g.anomalous(f, v.kind)
+		}
+	}
+	if v.kind == kindFloat {
+		f = g.formatted(f)
+	}
+	if v.table != nil && v.by == nil && len(g.anomalies) == 0 {
+		return tabled(f, prefix, uint64(v.count)), nil
+	}
+	return func(b []byte, series, point uint64) []byte {
+		return f(append(b, prefix...), series, point)
+	}, nil
+}
+
+// tabled places a field whose value cycles through count values, as f
+// writes them, after prefix. Each value's text, prefix included, is
+// written once, so placing the field is one append. That is done on first
+// use, once the Generator's Floats are set.
+func tabled(f part, prefix string, count uint64) part {
+	var once sync.Once
+	var text []byte
+	var offs []uint32
+	return func(b []byte, _, point uint64) []byte {
+		once.Do(func() {
+			offs = make([]uint32, count+1)
+			for k := uint64(0); k < count; k++ {
+				text = f(append(text, prefix...), 0, k)
+				offs[k+1] = uint32(len(text))
+			}
+		})
+		k := point % count
+		return append(b, text[offs[k]:offs[k+1]]...)
+	}
+}
+
+// field places the template as a field value, formatted by its type.
+func (c *compiledTemplate) field() part {
+	switch c.kind {
+	case kindInt:
+		return func(b []byte, _, point uint64) []byte {
+			return append(c.fieldValue(b, point), 'i')
+		}
+	case kindString:
+		return func(b []byte, _, point uint64) []byte {
+			b = append(b, '"')
+			n := len(b)
+			b = escapeFrom(c.fieldValue(b, point), n, stringEscapes)
+			return append(b, '"')
+		}
+	}
+	return func(b []byte, _, point uint64) []byte {
+		return c.fieldValue(b, point)
+	}
+}
+
+// fieldValue appends the template's value for point as a field, using the
+// value for its series' tag if it varies by one.
+func (c *compiledTemplate) fieldValue(b []byte, point uint64) []byte {
+	if c.by != nil {
+		sc := getScratch()
+		sc.buf = c.by.appendValue(sc.buf[:0], point%c.by.series)
+		fn, ok := c.byValues[string(sc.buf)]
+		putScratch(sc)
+		if ok {
+			return fn(b, c.index(point))
+		}
+	}
+	return c.appendValue(b, c.index(point))
+}
+
+// scratch is working memory for generating one point, for values read from
+// others, as by() tags, derive operands and histogram observations are.
+// It is pooled so generating points does not allocate.
+type scratch struct {
+	buf    []byte
+	counts []uint64
+}
+
+var scratchPool = sync.Pool{New: func() interface{} { return &scratch{buf: make([]byte, 0, 64)} }}
+
+func getScratch() *scratch  { return scratchPool.Get().(*scratch) }
+func putScratch(s *scratch) { scratchPool.Put(s) }
+
+// index is the value used for point: cycling through count values, or a new
+// value for every point if count is zero.
+func (c *compiledTemplate) index(point uint64) uint64 {
+	if c.count > 0 {
+		return point % uint64(c.count)
+	}
+	return point
+}
+
+// escapeFrom escapes the characters in t in b[n:], in place, as
+// appendEscaped would. Generated values rarely need escaping, so the
+// common case only scans.
+func escapeFrom(b []byte, n int, t *escapes) []byte {
+	i := n
+	for ; i < len(b); i++ {
+		if t[b[i]] || b[i] == '\\' {
+			break
+		}
+	}
+	if i == len(b) {
+		return b
+	}
+
+	// Escapes are counted, then written from the end back, where it is
+	// known whether a run of backslashes is doubled: if it ends the value
+	// or comes before an escaped character.
+	end, extra := len(b), 0
+	double := true
+	for j := end - 1; j >= i; j-- {
+		switch c := b[j]; {
+		case t[c]:
+			extra++
+			double = true
+		case c == '\\':
+			if double {
+				extra++
+			}
+		default:
+			double = false
+		}
+	}
+	b = append(b, make([]byte, extra)...)
+	w := len(b) - 1
+	double = true
+	for j := end - 1; j >= i; j-- {
+		c := b[j]
+		b[w] = c
+		w--
+		switch {
+		case t[c]:
+			b[w] = '\\'
+			w--
+			double = true
+		case c == '\\':
+			if double {
+				b[w] = '\\'
+				w--
+			}
+		default:
+			double = false
+		}
+	}
+	return b
+}
+
+var functionTypes = map[string]valueKind{
+	"int":    kindInt,
+	"i":      kindInt,
+	"float":  kindFloat,
+	"f":      kindFloat,
+	"str":    kindString,
+	"string": kindString,
+	"s":      kindString,
+}
+
+// functions builds the value for each generator function from its type,
+// argument, and a seed distinguishing it from other templates.
+var functions = map[string]func(kind valueKind, arg string, seed uint64) (value, error){
+	"rand":      randValue,
+	"special":   specialValue,
+	"inc":       incValue,
+	"pattern":   patternValue,
+	"ipv4":      ipv4Value,
+	"ipv6":      ipv6Value,
+	"mac":       macValue,
+	"empirical": empiricalValue,
+}
+
+func randValue(kind valueKind, arg string, seed uint64) (value, error) {
+	switch kind {
+	case kindFloat:
+		max, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %q", arg)
+		}
+		return func(b []byte, k uint64) []byte {
+			return strconv.AppendFloat(b, unitFloat(mix(seed+k))*max, 'f', -1, 64)
+		}, nil
+	}
+
+	n, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || n == 0 {
+		return nil, fmt.Errorf("invalid argument %q", arg)
+	}
+	if kind == kindString {
+		return func(b []byte, k uint64) []byte {
+			h := mix(seed + k)
+			for j := uint64(0); j < n; j++ {
+				b = append(b, alphabet[mix(h+j)%uint64(len(alphabet))])
+			}
+			return b
+		}, nil
+	}
+	return func(b []byte, k uint64) []byte {
+		return strconv.AppendUint(b, mix(seed+k)%n, 10)
+	}, nil
+}
+
+// specialValue generates random strings of n characters, each with at
+// least one character line protocol escapes, as in special(8), to test
+// how a server parses escaped measurements, tags and string fields.
+func specialValue(kind valueKind, arg string, seed uint64) (value, error) {
+	if kind != kindString {
+		return nil, fmt.Errorf("special needs a str type")
+	}
+	n, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || n == 0 {
+		return nil, fmt.Errorf("invalid argument %q", arg)
+	}
+	return func(b []byte, k uint64) []byte {
+		h := mix(seed + k)
+		at := h % n
+		for j := uint64(0); j < n; j++ {
+			if j == at {
+				b = append(b, specials[mix(h-j)%uint64(len(specials))])
+			} else {
+				b = append(b, specialAlphabet[mix(h+j)%uint64(len(specialAlphabet))])
+			}
+		}
+		return b
+	}, nil
+}
+
+func incValue(kind valueKind, arg string, _ uint64) (value, error) {
+	if kind == kindFloat {
+		start, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %q", arg)
+		}
+		return func(b []byte, k uint64) []byte {
+			return strconv.AppendFloat(b, start+float64(k), 'f', -1, 64)
+		}, nil
+	}
+
+	start, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argument %q", arg)
+	}
+	return func(b []byte, k uint64) []byte {
+		return strconv.AppendInt(b, start+int64(k), 10)
+	}, nil
+}
+
+const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// specials are the characters escaped somewhere in line protocol.
+const (
+	specials        = ",= \"\\"
+	specialAlphabet = alphabet + specials
+)
+
+// mix is the splitmix64 finalizer, used to derive random values from
+// indexes.
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// unitFloat maps h to [0, 1).
+func unitFloat(h uint64) float64 {
+	return float64(h>>11) / (1 << 53)
+}
@@ -0,0 +1,101 @@
+package stressql
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerateNStopsAtPoints(t *testing.T) {
+	for _, order := range []string{"time", "series", "random"} {
+		s, err := ParseStatement("INSERT cpu\ncpu,\nhost=[a|b|c]\nv=[int inc(0) 0]\n3 10s ORDER BY " + order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := GenerateN(s.(*InsertStatement), 5, &buf); err != nil {
+			t.Fatalf("ORDER BY %s: %v", order, err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		seen := map[string]bool{}
+		for _, l := range lines {
+			seen[l] = true
+		}
+		if len(lines) != 3 || len(seen) != 3 {
+			t.Errorf("ORDER BY %s: wrote %q, want the 3 points once each", order, lines)
+		}
+	}
+}
+
+func TestDeriveLeavesOutNonFinite(t *testing.T) {
+	s, err := ParseStatement("INSERT m\nm,\nhost=[a]\nr=[float derive(10 / a) 0],a=[int inc(0) 0],q=[int derive(a / a) 0]\n2 10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := Compile(s.(*InsertStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	for i := int64(0); i < g.Points; i++ {
+		b = g.AppendPointAt(b, i, 0)
+	}
+	if want := "m,host=a a=0i 0\nm,host=a r=10,a=1i,q=1i 0\n"; string(b) != want {
+		t.Errorf("wrote %q, want %q", b, want)
+	}
+	if err := validateBatch(b); err != nil {
+		t.Error(err)
+	}
+
+	s, err = ParseStatement("INSERT m\nm,\nhost=[a]\nr=[float derive(1 / 0) 0]\n2 10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, err = Compile(s.(*InsertStatement)); err != nil {
+		t.Fatal(err)
+	}
+	if b := g.AppendPointAt(nil, 0, 0); len(b) != 0 {
+		t.Errorf("wrote %q for a point with no fields", b)
+	}
+}
+
+func TestEscapeFromMatchesAppendEscaped(t *testing.T) {
+	for _, v := range []string{"plain", "a b", "a,b=c", `a\`, `a\\ b`, `a\b`, `\`, `"q\"`, ` ,=`, `x\\`} {
+		for _, esc := range []*escapes{measurementEscapes, keyEscapes, stringEscapes} {
+			want := string(appendEscaped([]byte("k="), v, esc))
+			if got := string(escapeFrom([]byte("k="+v), 2, esc)); got != want {
+				t.Errorf("escapeFrom(%q) = %q, want %q", v, got, want)
+			}
+		}
+	}
+	b := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		b = escapeFrom(append(b[:0], "a b,c"...), 0, keyEscapes)
+	})
+	if allocs != 0 {
+		t.Errorf("escapeFrom allocated %v times, want 0", allocs)
+	}
+}
+
+// BenchmarkEncodePoint reports the points generated per second on one
+// core, batch by batch as a Pipeline does, which should exceed 10M so the
+// client is not what limits an ingest benchmark.
+func BenchmarkEncodePoint(b *testing.B) {
+	for _, bm := range []struct{ name, src string }{
+		{"cpu", "INSERT cpu\ncpu,\nhost=[us-west|us-east|eu-north],server_id=[str rand(7) 1000]\nbusy=[int rand(1000) 100],free=[float rand(10) 1000]\n100000 10s"},
+		{"escaped", "INSERT log\nlog,\nhost=[str pattern(\"web[ ,=][0-9]{3}\") 1000]\nmsg=[str pattern(\"[a\\\\\\\\]{8}\") 1000]\n100000 10s"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			g := compileInsert(b, bm.src)
+			e := NewEncoder(1 << 20)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if i%5000 == 0 {
+					e.Reset()
+				}
+				g.EncodePoint(e, int64(i)%g.Points)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "points/s")
+		})
+	}
+}
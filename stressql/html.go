@@ -0,0 +1,275 @@
+package stressql
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// WriteHTML writes r as a single HTML page with its charts drawn inline as
+// SVG, so the report can be shared without Grafana or network access.
+func WriteHTML(w io.Writer, r *RunResult, title string) error {
+	var keys []string
+	for k := range r.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return htmlReport.Execute(w, map[string]interface{}{
+		"Title":      title,
+		"Result":     r,
+		"Keys":       keys,
+		"Throughput": throughputChart(r),
+		"Latency":    latencyChart(r),
+		"Errors":     errorChart(r),
+	})
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"rate": func(s StatementResult) string {
+		if s.Points == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.0f/s", throughput(s))
+	},
+	"errorRate": func(s StatementResult) string { return fmt.Sprintf("%.3f%%", errorRate(s)*100) },
+	"time":      func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 4px 12px; border-bottom: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.pass { color: #2a7; } .fail { color: #c33; }
+svg { margin-bottom: 2em; }
+svg text { font-size: 11px; fill: #555; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Result}}<p>{{time .Start}} to {{time .End}} ({{.End.Sub .Start}})</p>{{end}}
+{{if .Keys}}<table>{{range .Keys}}<tr><th>{{.}}</th><td>{{index $.Result.Metadata .}}</td></tr>{{end}}</table>{{end}}
+
+{{if .Result.SLOs}}<h2>SLOs</h2>
+<table><tr><th>SLO</th><th>Result</th></tr>
+{{range .Result.SLOs}}<tr><td>{{.SLO}}</td><td class="{{if .Pass}}pass{{else}}fail{{end}}">{{if .Pass}}pass{{else if .Missing}}no data{{else}}fail{{end}}</td></tr>
+{{end}}</table>{{end}}
+
+<h2>Statements</h2>
+<table><tr><th>Statement</th><th>Phase</th><th>Kind</th><th>Requests</th><th>Throughput</th><th>Errors</th><th>p50</th><th>p95</th><th>p99</th><th>Max</th></tr>
+{{range .Result.Statements}}<tr><td>{{.Name}}</td><td>{{.Phase}}</td><td>{{.Kind}}</td><td>{{.Requests}}</td><td>{{rate .}}</td><td>{{errorRate .}}</td><td>{{.Latency.P50}}</td><td>{{.Latency.P95}}</td><td>{{.Latency.P99}}</td><td>{{.Latency.Max}}</td></tr>
+{{end}}</table>
+
+{{if .Throughput}}<h2>Throughput</h2>
+{{.Throughput}}{{end}}
+{{if .Latency}}<h2>Latency by phase</h2>
+{{.Latency}}{{end}}
+{{if .Errors}}<h2>Errors</h2>
+{{.Errors}}{{end}}
+</body>
+</html>
+`))
+
+const (
+	chartWidth  = 760
+	chartHeight = 240
+	chartLeft   = 70
+	chartRight  = 140
+	chartTop    = 10
+	chartBottom = 30
+)
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+type chartSeries struct {
+	Name string
+	X    []time.Time
+	Y    []float64
+}
+
+// intervalSeries returns a series per statement of f applied to each of
+// the statement's intervals.
+func intervalSeries(r *RunResult, f func(IntervalResult) (float64, bool)) []chartSeries {
+	var series []chartSeries
+	index := map[string]int{}
+	for _, iv := range r.Intervals {
+		y, ok := f(iv)
+		if !ok {
+			continue
+		}
+		i, seen := index[iv.Statement]
+		if !seen {
+			i = len(series)
+			index[iv.Statement] = i
+			series = append(series, chartSeries{Name: iv.Statement})
+		}
+		series[i].X = append(series[i].X, iv.Time)
+		series[i].Y = append(series[i].Y, y)
+	}
+	return series
+}
+
+func throughputChart(r *RunResult) template.HTML {
+	series := intervalSeries(r, func(iv IntervalResult) (float64, bool) {
+		if iv.Points == 0 || iv.Interval <= 0 {
+			return 0, false
+		}
+		return float64(iv.Points) / iv.Interval.Seconds(), true
+	})
+	return lineChart(series, func(v float64) string { return fmt.Sprintf("%.0f/s", v) })
+}
+
+func errorChart(r *RunResult) template.HTML {
+	total := 0.0
+	series := intervalSeries(r, func(iv IntervalResult) (float64, bool) {
+		total += float64(iv.Errors)
+		return float64(iv.Errors), true
+	})
+	if total == 0 {
+		return ""
+	}
+	return lineChart(series, func(v float64) string { return fmt.Sprintf("%.0f", v) })
+}
+
+// latencyChart draws p50, p95 and p99 for each phase, taking the slowest
+// statement in the phase. Without phases, each statement is its own group.
+func latencyChart(r *RunResult) template.HTML {
+	var groups []string
+	values := map[string][]float64{}
+	for _, s := range r.Statements {
+		if s.Latency.Count == 0 && s.Latency.P99 == 0 {
+			continue
+		}
+		g := s.Phase
+		if g == "" {
+			g = s.Name
+		}
+		v, ok := values[g]
+		if !ok {
+			groups = append(groups, g)
+			v = make([]float64, 3)
+			values[g] = v
+		}
+		for i, d := range []time.Duration{s.Latency.P50, s.Latency.P95, s.Latency.P99} {
+			v[i] = math.Max(v[i], float64(d))
+		}
+	}
+	if len(groups) == 0 {
+		return ""
+	}
+
+	max := 0.0
+	for _, v := range values {
+		max = math.Max(max, v[2])
+	}
+	max = niceCeil(max)
+
+	var b bytes.Buffer
+	openChart(&b, max, func(v float64) string { return time.Duration(v).String() })
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	groupW := plotW / float64(len(groups))
+	barW := groupW / 4
+	for gi, g := range groups {
+		x0 := chartLeft + float64(gi)*groupW + barW/2
+		for i, v := range values[g] {
+			h := v / max * plotH
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s %s</title></rect>`+"\n",
+				x0+float64(i)*barW, chartTop+plotH-h, barW*0.9, h, chartColors[i],
+				template.HTMLEscapeString(g), time.Duration(v))
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`+"\n",
+			x0+1.5*barW, chartHeight-10, template.HTMLEscapeString(g))
+	}
+	legend(&b, []string{"p50", "p95", "p99"})
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
+
+func lineChart(series []chartSeries, format func(float64) string) template.HTML {
+	if len(series) == 0 {
+		return ""
+	}
+	var start, end time.Time
+	max := 0.0
+	for _, s := range series {
+		for i, x := range s.X {
+			if start.IsZero() || x.Before(start) {
+				start = x
+			}
+			if x.After(end) {
+				end = x
+			}
+			max = math.Max(max, s.Y[i])
+		}
+	}
+	max = niceCeil(max)
+	span := end.Sub(start).Seconds()
+	if span <= 0 {
+		span = 1
+	}
+
+	var b bytes.Buffer
+	openChart(&b, max, format)
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	names := make([]string, len(series))
+	for i, s := range series {
+		names[i] = s.Name
+		fmt.Fprintf(&b, `<polyline fill="none" stroke-width="1.5" stroke="%s" points="`, chartColors[i%len(chartColors)])
+		for j, x := range s.X {
+			fmt.Fprintf(&b, "%.1f,%.1f ",
+				chartLeft+x.Sub(start).Seconds()/span*plotW,
+				chartTop+plotH-s.Y[j]/max*plotH)
+		}
+		b.WriteString(`"/>` + "\n")
+	}
+	fmt.Fprintf(&b, `<text x="%d" y="%d">0s</text>`+"\n", chartLeft, chartHeight-10)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s</text>`+"\n",
+		chartWidth-chartRight, chartHeight-10, time.Duration(span*float64(time.Second)).Round(time.Second))
+	legend(&b, names)
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
+
+// openChart starts an SVG with its axes and y-axis labels up to max.
+func openChart(b *bytes.Buffer, max float64, format func(float64) string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`+"\n", chartWidth, chartHeight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	for i := 0; i <= 4; i++ {
+		y := chartTop + plotH - float64(i)/4*plotH
+		fmt.Fprintf(b, `<line x1="%d" x2="%d" y1="%.1f" y2="%.1f" stroke="#eee"/>`+"\n", chartLeft, chartWidth-chartRight, y, y)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`+"\n", chartLeft-6, y+4,
+			template.HTMLEscapeString(format(max*float64(i)/4)))
+	}
+}
+
+func legend(b *bytes.Buffer, names []string) {
+	for i, n := range names {
+		y := chartTop + 14*i
+		fmt.Fprintf(b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, chartWidth-chartRight+10, y, chartColors[i%len(chartColors)])
+		fmt.Fprintf(b, `<text x="%d" y="%d">%s</text>`+"\n", chartWidth-chartRight+24, y+9, template.HTMLEscapeString(n))
+	}
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of ten.
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	p := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*p {
+			return m * p
+		}
+	}
+	return 10 * p
+}
@@ -0,0 +1,162 @@
+package stressql
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// HTTPWriter writes batches to an InfluxDB /write endpoint.
+type HTTPWriter struct {
+	// Addr is the server address, with or without a scheme, or the path of
+	// a Unix domain socket as unix:///var/run/influxdb.sock.
+	Addr            string
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+	// Client defaults to http.DefaultClient, or a client dialing the socket
+	// for a unix:// Addr. A custom client for a socket should come from
+	// TransportOptions.ClientFor.
+	Client *http.Client
+	// Header is added to every request.
+	Header http.Header
+
+	proto atomic.Value
+}
+
+// HeaderVarPrefix marks SET variables that add a header to the requests of
+// the statements after them, as in
+//
+//	SET header:X-Scope-OrgID tenant1
+//
+// An empty value, SET header:X-Scope-OrgID "", removes the header again.
+const HeaderVarPrefix = "header:"
+
+// HeadersFromVars returns the headers set by SET variables, or nil if none.
+func HeadersFromVars(vars map[string]string) http.Header {
+	var h http.Header
+	for k, v := range vars {
+		if !strings.HasPrefix(k, HeaderVarPrefix) || v == "" {
+			continue
+		}
+		if h == nil {
+			h = http.Header{}
+		}
+		// Keep the name as written; some gateways are case sensitive.
+		h[strings.TrimPrefix(k, HeaderVarPrefix)] = []string{v}
+	}
+	return h
+}
+
+// RequestIDHeader carries the ID generated for each request. InfluxDB
+// adopts it as its own request ID, so it appears in the server's logs.
+const RequestIDHeader = "Request-Id"
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// serverRequestID returns the ID the server logged the request under, or
+// the trace ID of a server or proxy with tracing enabled.
+func serverRequestID(h http.Header) string {
+	for _, k := range []string{"Trace-Id", "Uber-Trace-Id", "X-Request-Id", "Request-Id"} {
+		if v := h.Get(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Protocol returns the protocol of the last response, such as "HTTP/2.0".
+func (w *HTTPWriter) Protocol() string {
+	s, _ := w.proto.Load().(string)
+	return s
+}
+
+// WriteBatch posts batch with nanosecond precision, which is what Generator
+// produces.
+func (w *HTTPWriter) WriteBatch(ctx context.Context, batch []byte) error {
+	req, err := http.NewRequest("POST", w.url(), bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	id := NewRequestID()
+	req.Header.Set(RequestIDHeader, id)
+	if w.Username != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+
+	client := w.Client
+	if client == nil {
+		client = defaultClient(w.Addr)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if w.Protocol() != resp.Proto {
+		w.proto.Store(resp.Proto)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		e := ParseWriteError(resp.StatusCode, body)
+		e.RequestID, e.ServerRequestID = id, serverRequestID(resp.Header)
+		return e
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// socketClients holds the default client for each Unix domain socket, so
+// connections to it are reused.
+var socketClients sync.Map
+
+func defaultClient(addr string) *http.Client {
+	if _, ok := unixSocket(addr); !ok {
+		return http.DefaultClient
+	}
+	if c, ok := socketClients.Load(addr); ok {
+		return c.(*http.Client)
+	}
+	c, _ := socketClients.LoadOrStore(addr, DefaultTransportOptions.ClientFor(addr))
+	return c.(*http.Client)
+}
+
+func (w *HTTPWriter) url() string {
+	v := url.Values{"db": {w.Database}, "precision": {"n"}}
+	if w.RetentionPolicy != "" {
+		v.Set("rp", w.RetentionPolicy)
+	}
+	return serverURL(w.Addr) + "/write?" + v.Encode()
+}
+
+// serverURL returns the base URL of the server at addr.
+func serverURL(addr string) string {
+	if _, ok := unixSocket(addr); ok {
+		// The host is unused: the client dials the socket.
+		addr = "http://unix"
+	} else if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimRight(addr, "/")
+}
@@ -0,0 +1,117 @@
+package stressql
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// latencySubBuckets is the number of linear buckets per power of two, which
+// bounds the error of a percentile to under 2%.
+const latencySubBuckets = 64
+
+// Histogram records latencies in log-linear buckets, so percentiles of any
+// number of requests take fixed memory. It is safe for concurrent use.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets [64 * latencySubBuckets]int64
+	count   int64
+	sum     time.Duration
+	max     time.Duration
+}
+
+// Record adds one latency.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := latencyBucket(uint64(d))
+	h.mu.Lock()
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+	h.mu.Unlock()
+}
+
+func latencyBucket(v uint64) int {
+	// The top seven bits of v select the bucket: the leading one picks the
+	// power of two, the other six the linear sub-bucket within it.
+	n := bits.Len64(v)
+	if n <= 7 {
+		return int(v)
+	}
+	shift := uint(n - 7)
+	return (n-7)*latencySubBuckets + int(v>>shift)
+}
+
+// latencyBucketValue returns the largest value in bucket i.
+func latencyBucketValue(i int) uint64 {
+	if i < 2*latencySubBuckets {
+		return uint64(i)
+	}
+	shift := uint(i/latencySubBuckets - 1)
+	v := uint64(i%latencySubBuckets + latencySubBuckets)
+	return (v+1)<<shift - 1
+}
+
+// Percentile returns the latency below which p percent of requests fell.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.percentile(p)
+}
+
+func (h *Histogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(p / 100 * float64(h.count))
+	if rank >= h.count {
+		return h.max
+	}
+	var seen int64
+	for i, n := range h.buckets {
+		seen += n
+		if seen > rank {
+			if d := time.Duration(latencyBucketValue(i)); d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// Summary returns the histogram's count and common percentiles.
+func (h *Histogram) Summary() LatencySummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := LatencySummary{
+		Count: h.count,
+		P50:   h.percentile(50),
+		P90:   h.percentile(90),
+		P95:   h.percentile(95),
+		P99:   h.percentile(99),
+		P999:  h.percentile(99.9),
+		Max:   h.max,
+	}
+	if h.count > 0 {
+		s.Mean = h.sum / time.Duration(h.count)
+	}
+	return s
+}
+
+// LatencySummary is a snapshot of a Histogram.
+type LatencySummary struct {
+	Count int64         `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	P999  time.Duration `json:"p999"`
+	Max   time.Duration `json:"max"`
+}
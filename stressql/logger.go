@@ -0,0 +1,160 @@
+package stressql
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger is a structured, leveled logger. Arguments after the message are
+// alternating keys and values, such as "statement", name. Its method set is
+// that of *slog.Logger, so an application can route logs through its own
+// slog handler.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Level is a log level. The values match slog's.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", s)
+}
+
+// NopLogger discards everything.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// TextLogger writes logfmt lines at or above Level. It is safe for
+// concurrent use.
+type TextLogger struct {
+	Level Level
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextLogger returns a TextLogger writing to w.
+func NewTextLogger(w io.Writer, level Level) *TextLogger {
+	return &TextLogger{Level: level, w: w}
+}
+
+// LoggerFromVars returns a TextLogger writing to w at the level set with
+// SET logLevel, which defaults to info.
+func LoggerFromVars(w io.Writer, vars map[string]string) (*TextLogger, error) {
+	level, err := ParseLevel(vars["logLevel"])
+	if err != nil {
+		return nil, err
+	}
+	return NewTextLogger(w, level), nil
+}
+
+func (l *TextLogger) Debug(msg string, args ...interface{}) { l.log(LevelDebug, msg, args) }
+func (l *TextLogger) Info(msg string, args ...interface{})  { l.log(LevelInfo, msg, args) }
+func (l *TextLogger) Warn(msg string, args ...interface{})  { l.log(LevelWarn, msg, args) }
+func (l *TextLogger) Error(msg string, args ...interface{}) { l.log(LevelError, msg, args) }
+
+func (l *TextLogger) log(level Level, msg string, args []interface{}) {
+	if level < l.Level {
+		return
+	}
+
+	b := make([]byte, 0, 128)
+	b = append(b, "time="...)
+	b = time.Now().AppendFormat(b, time.RFC3339Nano)
+	b = append(b, " level="...)
+	b = append(b, level.String()...)
+	b = append(b, " msg="...)
+	b = appendLogValue(b, msg)
+	for i := 0; i < len(args); i += 2 {
+		b = append(b, ' ')
+		if i+1 == len(args) {
+			b = append(b, "!BADKEY="...)
+			b = appendLogValue(b, fmt.Sprint(args[i]))
+			break
+		}
+		b = append(b, fmt.Sprint(args[i])...)
+		b = append(b, '=')
+		b = appendLogValue(b, fmt.Sprint(args[i+1]))
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	l.w.Write(b)
+	l.mu.Unlock()
+}
+
+// appendLogValue appends s, quoted if it would not read back as one value.
+func appendLogValue(b []byte, s string) []byte {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.AppendQuote(b, s)
+	}
+	return append(b, s...)
+}
+
+// WithFields returns a Logger that adds args, such as the statement name
+// and phase, to every message logged through it.
+func WithFields(l Logger, args ...interface{}) Logger {
+	if f, ok := l.(*fieldLogger); ok {
+		return &fieldLogger{l: f.l, args: append(append([]interface{}(nil), f.args...), args...)}
+	}
+	return &fieldLogger{l: l, args: args}
+}
+
+type fieldLogger struct {
+	l    Logger
+	args []interface{}
+}
+
+func (f *fieldLogger) with(args []interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(f.args)+len(args)), f.args...), args...)
+}
+
+func (f *fieldLogger) Debug(msg string, args ...interface{}) { f.l.Debug(msg, f.with(args)...) }
+func (f *fieldLogger) Info(msg string, args ...interface{})  { f.l.Info(msg, f.with(args)...) }
+func (f *fieldLogger) Warn(msg string, args ...interface{})  { f.l.Warn(msg, f.with(args)...) }
+func (f *fieldLogger) Error(msg string, args ...interface{}) { f.l.Error(msg, f.with(args)...) }
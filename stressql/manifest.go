@@ -0,0 +1,158 @@
+package stressql
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Manifest records what it takes to repeat a run exactly: the statements
+// run, after defaults were merged and a profile selected, the variables
+// and arguments given them, the seed of the run's random choices, and the
+// time each INSERT stamped its points from. A run from it sends the same
+// requests, though the servers may answer them differently. Secrets stay
+// the references the config makes to them.
+type Manifest struct {
+	// Version is the build of stressql that made the run; see
+	// BuildVersion.
+	Version string `json:"version"`
+	// Config is the statements, formatted by Format.
+	Config string `json:"config"`
+	// Defaults are in effect before the first statement, as Config.Vars
+	// are, and Vars override the SETs of the statements, as WithVars does.
+	Defaults map[string]string `json:"defaults,omitempty"`
+	Vars     map[string]string `json:"vars,omitempty"`
+	Args     map[string]string `json:"args,omitempty"`
+	Deadline time.Duration     `json:"deadline,omitempty"`
+	Seed     int64             `json:"seed"`
+	// Starts are the Start of each INSERT, by name. Of INSERTs sharing a
+	// name, the one the result kept sets the time of all of them.
+	Starts map[string]time.Time `json:"starts,omitempty"`
+	// Targets are the servers the run was pointed at, for reference.
+	Targets []string `json:"targets,omitempty"`
+}
+
+// Manifest returns the manifest of the run of r that returned res.
+func (r *Runner) Manifest(res *RunResult) *Manifest {
+	m := &Manifest{
+		Version:  BuildVersion(),
+		Config:   Format(r.cfg.Statements),
+		Defaults: r.cfg.Vars,
+		Vars:     copyVars(r.vars),
+		Args:     r.cfg.Args,
+		Deadline: r.deadline,
+		Seed:     res.Seed,
+	}
+	// A worker's ID defaults to the host it runs on.
+	if key, id, err := WorkerTag(r.settings()); err == nil && key != "" {
+		m.Vars["workerID"] = id
+	}
+	if len(m.Vars) == 0 {
+		m.Vars = nil
+	}
+	for name, i := range res.Inserts {
+		if m.Starts == nil {
+			m.Starts = map[string]time.Time{}
+		}
+		m.Starts[name] = i.Start
+	}
+
+	seen := map[string]bool{}
+	add := func(addrs string) {
+		for _, a := range splitAddresses(addrs) {
+			if !seen[a] {
+				seen[a] = true
+				m.Targets = append(m.Targets, a)
+			}
+		}
+	}
+	if a, ok := r.vars["addresses"]; ok {
+		add(a)
+	} else {
+		add(r.cfg.Vars["addresses"])
+		for _, s := range r.cfg.Statements {
+			switch s := s.(type) {
+			case *SetStatement:
+				if s.Var == "addresses" {
+					add(s.Value)
+				}
+			case *UseStatement:
+				add(s.Addresses)
+			}
+		}
+	}
+	return m
+}
+
+// Options returns the Options that make a Runner of m's config repeat its
+// run.
+func (m *Manifest) Options() []Option {
+	return []Option{WithVars(m.Vars), WithDeadline(m.Deadline), WithSeed(m.Seed), WithStarts(m.Starts)}
+}
+
+// SaveManifest writes m to file.
+func SaveManifest(file string, m *Manifest) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadManifest reads a manifest written by SaveManifest.
+func LoadManifest(file string) (*Manifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &Manifest{}
+	if err := json.NewDecoder(f).Decode(m); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return m, nil
+}
+
+// BuildVersion returns the version of the stressql module built into the
+// program, with the commit it was built from when known, or "unknown".
+func BuildVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	pkg := reflect.TypeOf(Manifest{}).PkgPath()
+	mods := append([]*debug.Module{&bi.Main}, bi.Deps...)
+	for _, mod := range mods {
+		if mod.Path == "" || !strings.HasPrefix(pkg, mod.Path+"/") {
+			continue
+		}
+		v := mod.Version
+		if mod.Replace != nil && mod.Replace.Version != "" {
+			v = mod.Replace.Version
+		}
+		if mod == &bi.Main {
+			// Only a program built from the module's own tree has its commit.
+			for _, s := range bi.Settings {
+				switch {
+				case s.Key == "vcs.revision":
+					v += " " + s.Value
+				case s.Key == "vcs.modified" && s.Value == "true":
+					v += " (modified)"
+				}
+			}
+		}
+		return v
+	}
+	return "unknown"
+}
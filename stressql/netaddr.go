@@ -0,0 +1,124 @@
+package stressql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ipv4Value generates addresses in a CIDR block, as in ipv4("10.0.0.0/16").
+// Values are a permutation of the block's addresses, so a template's count
+// of values are all distinct while it fits in the block.
+func ipv4Value(kind valueKind, arg string, seed uint64) (value, error) {
+	base, bits, err := parseCIDR(kind, arg, "IPv4")
+	if err != nil {
+		return nil, err
+	}
+	ip := binary.BigEndian.Uint32(base)
+	return func(b []byte, k uint64) []byte {
+		v := ip | uint32(permute(k, bits, seed))
+		return appendIPv4(b, v)
+	}, nil
+}
+
+// ipv6Value generates addresses under a prefix, as in
+// ipv6("2001:db8::/64"). Like ipv4, values are distinct; at most the low 64
+// bits vary.
+func ipv6Value(kind valueKind, arg string, seed uint64) (value, error) {
+	base, bits, err := parseCIDR(kind, arg, "IPv6")
+	if err != nil {
+		return nil, err
+	}
+	if bits > 64 {
+		bits = 64
+	}
+	hi := binary.BigEndian.Uint64(base[:8])
+	lo := binary.BigEndian.Uint64(base[8:])
+	return func(b []byte, k uint64) []byte {
+		ip := make(net.IP, net.IPv6len)
+		binary.BigEndian.PutUint64(ip[:8], hi)
+		binary.BigEndian.PutUint64(ip[8:], lo|permute(k, bits, seed))
+		return append(b, ip.String()...)
+	}, nil
+}
+
+// macValue generates distinct locally administered unicast MAC addresses,
+// as in mac().
+func macValue(kind valueKind, arg string, seed uint64) (value, error) {
+	if kind != kindString {
+		return nil, fmt.Errorf("mac needs a str type")
+	}
+	if arg != "" {
+		return nil, fmt.Errorf("mac takes no argument")
+	}
+	return func(b []byte, k uint64) []byte {
+		// The first octet has the locally administered bit set and the
+		// multicast bit clear, leaving 46 bits to vary.
+		v := permute(k, 46, seed)
+		b = appendHexByte(b, byte(v>>40)<<2|0x02)
+		for shift := 32; shift >= 0; shift -= 8 {
+			b = append(b, ':')
+			b = appendHexByte(b, byte(v>>uint(shift)))
+		}
+		return b
+	}, nil
+}
+
+func appendHexByte(b []byte, v byte) []byte {
+	return append(b, hexDigits[v>>4], hexDigits[v&0xf])
+}
+
+const hexDigits = "0123456789abcdef"
+
+// parseCIDR parses a quoted CIDR block of the given family, returning its
+// network address and the number of host bits.
+func parseCIDR(kind valueKind, arg, family string) (net.IP, uint, error) {
+	if kind != kindString {
+		return nil, 0, fmt.Errorf("expected a str type")
+	}
+	_, ipnet, err := net.ParseCIDR(strings.Trim(arg, `"`))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid argument %s, expected a quoted CIDR block", arg)
+	}
+	ip := ipnet.IP.To4()
+	if family == "IPv6" {
+		if ip != nil {
+			return nil, 0, fmt.Errorf("invalid argument %s, expected an IPv6 block", arg)
+		}
+		ip = ipnet.IP.To16()
+	} else if ip == nil {
+		return nil, 0, fmt.Errorf("invalid argument %s, expected an IPv4 block", arg)
+	}
+	ones, total := ipnet.Mask.Size()
+	return ip, uint(total - ones), nil
+}
+
+func appendIPv4(b []byte, v uint32) []byte {
+	for shift := 24; shift >= 0; shift -= 8 {
+		b = strconv.AppendUint(b, uint64(byte(v>>uint(shift))), 10)
+		if shift > 0 {
+			b = append(b, '.')
+		}
+	}
+	return b
+}
+
+// permute maps k to a value of the given number of bits, visiting every
+// value once as k counts through them.
+func permute(k uint64, bits uint, seed uint64) uint64 {
+	if bits == 0 {
+		return 0
+	}
+	mask := ^uint64(0) >> (64 - bits)
+	x := k & mask
+	for r := uint64(0); r < 4; r++ {
+		// Multiplying by an odd number, adding and xor-shifting are all
+		// bijections modulo 2^bits.
+		x = (x*0x9e3779b97f4a7c15 + mix(seed+r)) & mask
+		x ^= x >> ((bits + 1) / 2)
+		x = (x ^ x<<(bits/3+1)) & mask
+	}
+	return x
+}
@@ -0,0 +1,79 @@
+package stressql
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// An EmitOrder is the order a Generator emits its points in. The server
+// ingests them at different speeds: points of one series in a row reach
+// one cache entry and index entry after another, interleaved ones touch
+// every series in each batch.
+type EmitOrder int
+
+const (
+	// OrderTime emits every series at one time step, then every series at
+	// the next.
+	OrderTime EmitOrder = iota
+	// OrderSeries emits every point of one series, then every point of
+	// the next.
+	OrderSeries
+	// OrderRandom emits the points shuffled.
+	OrderRandom
+)
+
+func (o EmitOrder) String() string {
+	switch o {
+	case OrderSeries:
+		return "series"
+	case OrderRandom:
+		return "random"
+	}
+	return "time"
+}
+
+// parseOrder parses a Timestamp's Order.
+func parseOrder(s string) (EmitOrder, error) {
+	switch strings.ToLower(s) {
+	case "", "time":
+		return OrderTime, nil
+	case "series":
+		return OrderSeries, nil
+	case "random":
+		return OrderRandom, nil
+	}
+	return 0, fmt.Errorf("unknown order %q, expected time, series or random", s)
+}
+
+const orderSalt = 0x6f72646572
+
+// point returns the index of the n'th point emitted, where point i is of
+// series i%Series at step i/Series.
+func (g *Generator) point(n int64) int64 {
+	switch g.Order {
+	case OrderSeries:
+		// The last step may hold only the first series; they have a point
+		// more than the rest.
+		steps := g.Steps()
+		full := g.Points - (steps-1)*g.Series
+		if n < full*steps || steps == 1 {
+			return n%steps*g.Series + n/steps
+		}
+		n -= full * steps
+		return n%(steps-1)*g.Series + full + n/(steps-1)
+	case OrderRandom:
+		if g.Points <= 1 {
+			return n
+		}
+		// permute shuffles a power of two; those past Points are walked
+		// on from until one is not.
+		b := uint(bits.Len64(uint64(g.Points - 1)))
+		i := permute(uint64(n), b, orderSalt)
+		for i >= uint64(g.Points) {
+			i = permute(i, b, orderSalt)
+		}
+		return int64(i)
+	}
+	return n
+}
@@ -0,0 +1,45 @@
+package stressql
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrOverflow is returned for a count or total too large for an int64.
+var ErrOverflow = errors.New("count overflows int64")
+
+// AddCounts returns a+b, of counts that are not negative, or ErrOverflow.
+func AddCounts(a, b int64) (int64, error) {
+	if a > math.MaxInt64-b {
+		return 0, ErrOverflow
+	}
+	return a + b, nil
+}
+
+// MulCounts returns a*b, of counts that are not negative, or ErrOverflow.
+func MulCounts(a, b int64) (int64, error) {
+	if a != 0 && b > math.MaxInt64/a {
+		return 0, ErrOverflow
+	}
+	return a * b, nil
+}
+
+// ceilDiv returns a/b rounded up, for a not negative and b positive,
+// without the overflow of (a+b-1)/b.
+func ceilDiv(a, b int64) int64 {
+	n := a / b
+	if a%b != 0 {
+		n++
+	}
+	return n
+}
+
+// floatCount converts a count parsed as a float, such as 1e9, to an int64,
+// reporting false if it does not fit.
+func floatCount(f float64) (int64, bool) {
+	// float64(math.MaxInt64) rounds up to 2^63, which does not fit.
+	if f != f || f < 0 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
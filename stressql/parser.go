@@ -1,12 +1,15 @@
 package stressql
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // Token represents a lexical token.
@@ -48,6 +51,9 @@ const (
 	INT
 	FLOAT
 	EXEC
+	EVERY
+	CQ
+	SLO
 	keywordEnd
 )
 
@@ -76,6 +82,9 @@ var tokens = [...]string{
 	QUERY:  "QUERY",
 	INSERT: "INSERT",
 	EXEC:   "EXEC",
+	EVERY:  "EVERY",
+	CQ:     "CQ",
+	SLO:    "SLO",
 	DO:     "DO",
 	GO:     "GO",
 	WAIT:   "WAIT",
@@ -100,31 +109,58 @@ func isDigit(r rune) bool {
 
 func isLetter(ch rune) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') }
 
+// Scanner tokenizes stressql source. Literals are slices of the source
+// string, so scanning does not allocate per token.
 type Scanner struct {
-	r *bufio.Reader
+	src  string
+	pos  int
+	last int
+	// err is the error reading the source, if any; the scanner then
+	// returns only ILLEGAL.
+	err error
 }
 
+// NewScanner returns a scanner of the source read from r. If reading it
+// fails, Err returns why.
 func NewScanner(r io.Reader) *Scanner {
-	return &Scanner{r: bufio.NewReader(r)}
+	buf, err := ioutil.ReadAll(r)
+	s := newScanner(string(buf))
+	s.err = err
+	return s
+}
+
+func newScanner(src string) *Scanner {
+	return &Scanner{src: src}
 }
 
 func (s *Scanner) read() rune {
-	ch, _, err := s.r.ReadRune()
-	if err != nil {
+	s.last = s.pos
+	if s.pos >= len(s.src) {
 		return eof
 	}
+	ch, w := utf8.DecodeRuneInString(s.src[s.pos:])
+	s.pos += w
 	return ch
 }
 
-func (s *Scanner) unread() { _ = s.r.UnreadRune() }
+func (s *Scanner) unread() { s.pos = s.last }
 
 func (s *Scanner) peek() rune {
-	ch := s.read()
-	s.unread()
+	if s.pos >= len(s.src) {
+		return eof
+	}
+	ch, _ := utf8.DecodeRuneInString(s.src[s.pos:])
 	return ch
 }
 
+// Err returns the error reading the scanner's source, if any.
+func (s *Scanner) Err() error { return s.err }
+
 func (s *Scanner) Scan() (tok Token, lit string) {
+	if s.err != nil {
+		return ILLEGAL, ""
+	}
+	start := s.pos
 	ch := s.read()
 
 	if isWhitespace(ch) {
@@ -163,12 +199,12 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 		return PIPE, "|"
 	}
 
-	return ILLEGAL, string(ch)
+	return ILLEGAL, s.src[start:s.pos]
 }
 
 func (s *Scanner) scanWhitespace() (tok Token, lit string) {
-	var buf bytes.Buffer
-	buf.WriteRune(s.read())
+	start := s.pos
+	s.read()
 
 	for {
 		if ch := s.read(); ch == eof {
@@ -176,89 +212,105 @@ func (s *Scanner) scanWhitespace() (tok Token, lit string) {
 		} else if !isWhitespace(ch) {
 			s.unread()
 			break
-		} else {
-			buf.WriteRune(ch)
 		}
 	}
 
-	return WS, buf.String()
+	return WS, s.src[start:s.pos]
 }
 
 func (s *Scanner) scanIdent() (tok Token, lit string) {
-	var buf bytes.Buffer
-	buf.WriteRune(s.read())
+	start := s.pos
+	s.read()
 
 	for {
 		if ch := s.read(); ch == eof {
 			break
-			//		} else if next := s.peek(); next == '"' {
-			//			s.unread()
-			//			_, _ = buf.WriteRune(ch)
-			//			_, _ = buf.WriteRune(next)
-			//			break
 		} else if !isLetter(ch) && !isDigit(ch) && ch != '_' && ch != ':' && ch != '=' && ch != '-' {
 			s.unread()
 			break
-		} else {
-			_, _ = buf.WriteRune(ch)
-		}
-	}
-
-	switch strings.ToUpper(buf.String()) {
-	case "SET":
-		return SET, buf.String()
-	case "USE":
-		return USE, buf.String()
-	case "QUERY":
-		return QUERY, buf.String()
-	case "INSERT":
-		return INSERT, buf.String()
-	case "EXEC":
-		return EXEC, buf.String()
-	case "WAIT":
-		return WAIT, buf.String()
-	case "GO":
-		return GO, buf.String()
-	case "DO":
-		return DO, buf.String()
-	case "STR":
-		return STR, buf.String()
-	case "FLOAT":
-		return FLOAT, buf.String()
-	case "INT":
-		return INT, buf.String()
-	}
-
-	return IDENT, buf.String()
+		}
+	}
+
+	lit = s.src[start:s.pos]
+	return lookupKeyword(lit), lit
+}
+
+var keywords = map[string]Token{
+	"SET":    SET,
+	"USE":    USE,
+	"QUERY":  QUERY,
+	"INSERT": INSERT,
+	"EXEC":   EXEC,
+	"EVERY":  EVERY,
+	"CQ":     CQ,
+	"SLO":    SLO,
+	"WAIT":   WAIT,
+	"GO":     GO,
+	"DO":     DO,
+	"STR":    STR,
+	"FLOAT":  FLOAT,
+	"INT":    INT,
+}
+
+// lookupKeyword returns the keyword token for ident, or IDENT. It upper
+// cases into a stack buffer so the lookup does not allocate.
+func lookupKeyword(ident string) Token {
+	var buf [16]byte
+	if len(ident) > len(buf) {
+		return IDENT
+	}
+	for i := 0; i < len(ident); i++ {
+		c := ident[i]
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		buf[i] = c
+	}
+	if tok, ok := keywords[string(buf[:len(ident)])]; ok {
+		return tok
+	}
+	return IDENT
 }
 
+// scanTemplateVar scans a template variable, a % and a letter. A % before
+// anything else is ILLEGAL.
 func (s *Scanner) scanTemplateVar() (tok Token, lit string) {
-	var buf bytes.Buffer
-	buf.WriteRune(s.read())
-	buf.WriteRune(s.read())
+	start := s.pos
+	s.read()
+	if !isLetter(s.peek()) {
+		return ILLEGAL, s.src[start:s.pos]
+	}
+	s.read()
 
-	return TEMPLATEVAR, buf.String()
+	return TEMPLATEVAR, s.src[start:s.pos]
 }
 
 func (s *Scanner) scanNumber() (tok Token, lit string) {
-	var buf bytes.Buffer
-	buf.WriteRune(s.read())
+	start := s.pos
+	s.read()
 
 	for {
 		if ch := s.read(); ch == eof {
 			break
-		} else if ch == 'n' || ch == 's' || ch == 'm' {
-			_, _ = buf.WriteRune(ch)
-			return DURATIONVAL, buf.String()
+		} else if ch == 's' || ch == 'h' {
+			return DURATIONVAL, s.src[start:s.pos]
+		} else if ch == 'm' || ch == 'n' || ch == 'u' || ch == 'µ' {
+			// m on its own is minutes; ms, ns, us and µs are sub-second.
+			if s.peek() == 's' {
+				s.read()
+				return DURATIONVAL, s.src[start:s.pos]
+			} else if ch == 'm' {
+				return DURATIONVAL, s.src[start:s.pos]
+			}
+			s.unread()
+			break
 		} else if !isDigit(ch) {
 			s.unread()
 			break
-		} else {
-			_, _ = buf.WriteRune(ch)
 		}
 	}
 
-	return NUMBER, buf.String()
+	return NUMBER, s.src[start:s.pos]
 }
 
 /////////////////////////////////
@@ -267,85 +319,460 @@ func (s *Scanner) scanNumber() (tok Token, lit string) {
 
 type Statement interface {
 	node()
-	Exec()
+	// Position returns where the statement was read from, for errors and
+	// reports to point at. Statements built in code have none.
+	Position() *Pos
+	Exec(ctx context.Context, env *ExecEnv) error
+}
+
+// Pos is where a statement came from: its file, first and last lines,
+// and raw text.
+type Pos struct {
+	File    string
+	Line    int
+	EndLine int
+	Text    string
+}
+
+func (p *Pos) Position() *Pos { return p }
+
+// Location returns the file and line as "file:line", the lines as
+// "file:line-endline" if there are several, or "" if unknown.
+func (p *Pos) Location() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	loc := strconv.Itoa(p.Line)
+	if p.EndLine > p.Line {
+		loc += "-" + strconv.Itoa(p.EndLine)
+	}
+	if p.File == "" {
+		return "line " + loc
+	}
+	return p.File + ":" + loc
 }
 
 type InfluxqlStatement struct {
+	Pos
 	Value string
 }
 
 func (i *InfluxqlStatement) node() {}
-func (i *InfluxqlStatement) Exec() {}
 
 type InsertStatement struct {
+	Pos
 	Name           string
 	TemplateString string
 	Templates      []*Template
 	Timestamp      *Timestamp
+	// Database and RetentionPolicy, if set by INTO, override those in
+	// effect for this insert's writes.
+	Database        string
+	RetentionPolicy string
+	// Across fans writes out over this many databases, named after the
+	// database in effect with a numeric suffix.
+	Across string
+}
+
+// Databases returns the databases the insert writes to, given the database
+// in effect where it runs.
+func (i *InsertStatement) Databases(db string) ([]string, error) {
+	if i.Database != "" {
+		db = i.Database
+	}
+	if i.Across == "" {
+		return []string{db}, nil
+	}
+
+	n, err := strconv.Atoi(i.Across)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("insert %q: invalid database count %q", i.Name, i.Across)
+	}
+	dbs := make([]string, n)
+	for j := range dbs {
+		dbs[j] = db + "_" + strconv.Itoa(j)
+	}
+	return dbs, nil
 }
 
 func (i *InsertStatement) node() {}
-func (i *InsertStatement) Exec() {}
 
 type Function struct {
 	Type     string
 	Fn       string
 	Argument string
 	Count    string
+	// Churn is the percentage of values replaced by new ones every
+	// ChurnEvery of data time, as in "churn(1) 1h".
+	Churn      string
+	ChurnEvery string
+	// By varies Argument with the value of a generated tag, as in
+	// "by(env, prod=90, dev=20)". It holds the text between the parens.
+	By string
 }
 
 type Timestamp struct {
 	Count    string
 	Duration string
 	Jitter   bool
+	// RealTime stamps points with the current time and emits each step at
+	// the interval, like a live agent, instead of backfilling.
+	RealTime bool
+	// Grow series are added per GrowUnit of data time, as in
+	// "GROW 10000 series/hour", until the template's cardinality is
+	// reached.
+	Grow     string
+	GrowUnit string
+	// Rate limits writes to a number of RateUnit per second, points by
+	// default, and Burst multiplies it for BurstFor of every BurstEvery,
+	// as in "RATE 5000 BURST 10x FOR 30s EVERY 10m". RateUnit is pts,
+	// req or bytes, as in "RATE 2M bytes/s".
+	Rate       string
+	RateUnit   string
+	Burst      string
+	BurstFor   string
+	BurstEvery string
+	// Steps are rates held for StepHold each, in turn, as in
+	// "STEPS 10k,20k,40k pts/s HOLD 5m".
+	Steps    []string
+	StepHold string
+	// Anomalies inject known outliers, level shifts or gaps, as in
+	// "ANOMALY spike 1/1000 10x" or "ANOMALY gap 1 FOR 5m".
+	Anomalies []*AnomalySpec
+	// Order is the order points are emitted in, time, series or random,
+	// as in "ORDER BY series".
+	Order string
+}
+
+// AnomalySpec is one ANOMALY modifier: a Kind of anomaly hitting a Rate
+// fraction of windows of a series, each lasting For (default one interval),
+// with an optional value Factor.
+type AnomalySpec struct {
+	Kind   string
+	Rate   string
+	Factor string
+	For    string
 }
 
 type Template struct {
 	Tags      []string
 	Functions []*Function
+	// Fields, if set, makes the template stand for several fields.
+	Fields *FieldSet
+}
+
+// FieldSet makes a template stand for several fields, written in place of
+// "key=[...]". A set of Kind "fields" writes Count fields named Prefix0,
+// Prefix1 and on, each with values of its own from the template's
+// function, as in "[fields usage 20 float rand(100) 0]". A "histogram"
+// draws Count observations of the function per point and writes, as
+// Telegraf writes a Prometheus histogram, a field per upper bound counting
+// those at or below it, then +Inf, count and sum, as in
+// "[histogram 0.1,0.5,1,5 100 float rand(10) 0]".
+type FieldSet struct {
+	Kind   string
+	Prefix string
+	Count  string
+	Bounds []string
 }
 
 type QueryStatement struct {
+	Pos
 	Name           string
 	TemplateString string
 	Args           []string
 	Count          string
+	// ExpectSeries and ExpectNonEmpty are checked against every response,
+	// as in "EXPECT SERIES 100" and "EXPECT NONEMPTY" after DO.
+	ExpectSeries   string
+	ExpectNonEmpty bool
+	// Fanout, as in "FANOUT 50" after DO, issues that many queries at
+	// once, each with its own arguments, and waits for all of them before
+	// the next, the way a dashboard's panels load together. DO counts
+	// these rounds.
+	Fanout string
 }
 
 func (i *QueryStatement) node() {}
-func (i *QueryStatement) Exec() {}
 
 type ExecStatement struct {
+	Pos
 	Script string
 	Args   []string
 }
 
 func (i *ExecStatement) node() {}
-func (i *ExecStatement) Exec() {}
 
-type WaitStatement struct{}
+// EveryStatement repeats an InfluxQL DELETE, DROP or SHOW on a schedule,
+// so deletes and metadata queries can be interleaved with writes. An empty
+// Count repeats until the run ends, and WAIT does not wait for it.
+type EveryStatement struct {
+	Pos
+	Interval string
+	Count    string
+	Query    string
+}
+
+func (i *EveryStatement) node() {}
+
+// ContinuousQueryStatement creates a continuous query for the rest of the
+// run, so the cost of downsampling can be measured against ingest. An empty
+// Database is the one in effect where the statement runs.
+type ContinuousQueryStatement struct {
+	Pos
+	Name     string
+	Database string
+	Query    string
+}
+
+func (i *ContinuousQueryStatement) node() {}
+
+// Create returns the InfluxQL creating the continuous query in db.
+func (i *ContinuousQueryStatement) Create(db string) string {
+	if i.Database != "" {
+		db = i.Database
+	}
+	return fmt.Sprintf("CREATE CONTINUOUS QUERY %q ON %q BEGIN %s END", i.Name, db, i.Query)
+}
+
+// Drop returns the InfluxQL dropping the continuous query from db.
+func (i *ContinuousQueryStatement) Drop(db string) string {
+	if i.Database != "" {
+		db = i.Database
+	}
+	return fmt.Sprintf("DROP CONTINUOUS QUERY %q ON %q", i.Name, db)
+}
+
+// RetentionPolicyStatement creates or alters a retention policy, so shard
+// expiry and writes routed by policy can be exercised under load, as in
+//
+//	CREATE RP week ON stress DURATION 7d REPLICATION 1 SHARD DURATION 1d DEFAULT
+//	GO ALTER RP week DURATION 1h
+//
+// An empty Database is the one in effect where the statement runs; an
+// empty clause is left as it is.
+type RetentionPolicyStatement struct {
+	Pos
+	// Alter is set for ALTER RP, and clear for CREATE RP.
+	Alter         bool
+	Name          string
+	Database      string
+	Duration      string
+	Replication   string
+	ShardDuration string
+	Default       bool
+}
+
+func (i *RetentionPolicyStatement) node() {}
+
+// InfluxQL returns the statement as InfluxQL on db.
+func (i *RetentionPolicyStatement) InfluxQL(db string) string {
+	if i.Database != "" {
+		db = i.Database
+	}
+	verb := "CREATE"
+	if i.Alter {
+		verb = "ALTER"
+	}
+	return verb + " RETENTION POLICY " + quoteIdent(i.Name) + " ON " + quoteIdent(db) + i.clauses()
+}
+
+// clauses returns the statement's clauses, each with a leading space, as
+// InfluxQL and the DSL both write them.
+func (i *RetentionPolicyStatement) clauses() string {
+	var s string
+	if i.Duration != "" {
+		s += " DURATION " + i.Duration
+	}
+	if i.Replication != "" {
+		s += " REPLICATION " + i.Replication
+	}
+	if i.ShardDuration != "" {
+		s += " SHARD DURATION " + i.ShardDuration
+	}
+	if i.Default {
+		s += " DEFAULT"
+	}
+	return s
+}
+
+// UseStatement sets the database, and retention policy, the statements
+// after it write to and query. With ON, as in
+//
+//	USE stress ON "node-b:8086"
+//
+// it also sets the servers they do, in place of SET addresses, so one run
+// can write to one node, query another and then fail over to a third.
+type UseStatement struct {
+	Pos
+	Database        string
+	RetentionPolicy string
+	Addresses       string
+}
+
+func (i *UseStatement) node() {}
+
+// SLOStatement declares an objective the run must meet, as in
+//
+//	SLO p99 < 200ms
+//	SLO errors < 0.1% FOR write_cpu
+//	SLO throughput > 50000 FOR ingest
+//
+// Latency metrics are p50, p90, p95, p99, p999, mean and max; errors is the
+// failed fraction of requests; throughput is points per second. FOR scopes
+// the objective to the statements of that name or SET phase; otherwise it
+// covers the whole run.
+type SLOStatement struct {
+	Pos
+	Metric string
+	Op     string
+	Value  string
+	Scope  string
+}
+
+func (i *SLOStatement) node() {}
+
+// WaitStatement waits for the statements started by GO: all of them, or
+// with For set to ANY or a number, until that many have finished since a
+// WAIT last returned for them, as in "WAIT ANY". Those still running carry
+// on.
+type WaitStatement struct {
+	Pos
+	For string
+}
+
+// Count returns the number of statements the WAIT waits for, or 0 for all
+// of them.
+func (i *WaitStatement) Count() (int, error) {
+	switch strings.ToUpper(i.For) {
+	case "", "ALL":
+		return 0, nil
+	case "ANY":
+		return 1, nil
+	}
+	n, err := strconv.Atoi(i.For)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("WAIT: found %q, expected ALL, ANY or a positive number", i.For)
+	}
+	return n, nil
+}
 
 func (i *WaitStatement) node() {}
-func (i *WaitStatement) Exec() {}
 
 type SetStatement struct {
+	Pos
 	Var   string
 	Value string
 }
 
 func (i *SetStatement) node() {}
-func (i *SetStatement) Exec() {}
 
 type GoStatement struct {
+	Pos
 	Statement
+	// Concurrency, if set, is the number of workers running the INSERT or
+	// QUERY, in place of SET concurrency or queryConcurrency, as in
+	// "GO 8 INSERT ...".
+	Concurrency string
+	// After names the INSERTs, QUERYs or EXECs the statement waits for,
+	// as in "GO AFTER backfill QUERY verify ...": those of the names still
+	// running when the GO is reached. If one of them failed, it does not
+	// start.
+	After []string
+	// Until names a variable the statement waits on, as in "GO UNTIL
+	// phase = load QUERY verify ...": it starts once a later SET sets the
+	// variable to Equals, compared as the values' type, or without one
+	// to true. If none has by the end of the run's statements, it fails.
+	Until  string
+	Equals string
 }
 
 func (i *GoStatement) node() {}
-func (i *GoStatement) Exec() {}
+
+// Position is that of the GO statement, which its body shares.
+func (i *GoStatement) Position() *Pos { return &i.Pos }
+
+// VersionStatement declares the version of the grammar a config is written
+// in, as in "VERSION 2". It must come first; a config without one is
+// version 1. Grammar changes that would alter what an existing config
+// means apply only from the version that introduced them.
+type VersionStatement struct {
+	Pos
+	Version string
+}
+
+func (i *VersionStatement) node() {}
+
+// Number returns the version declared, checking it is one this package
+// parses.
+func (i *VersionStatement) Number() (int, error) {
+	n, err := strconv.Atoi(i.Version)
+	if err != nil || n < Version1 || n > LatestVersion {
+		return 0, fmt.Errorf("unsupported VERSION %s; expected %d to %d", i.Version, Version1, LatestVersion)
+	}
+	return n, nil
+}
+
+// ProfileStatement begins a section of statements run only when its
+// profile is selected, as in "PROFILE small", up to an EndStatement. Like
+// any statement, each is a block of its own.
+type ProfileStatement struct {
+	Pos
+	Name string
+}
+
+func (i *ProfileStatement) node() {}
+
+// EndStatement ends a PROFILE section.
+type EndStatement struct {
+	Pos
+}
+
+func (i *EndStatement) node() {}
+
+// DeadlineStatement bounds the whole run, wherever it appears, as in
+// "DEADLINE 2h". A run still going when it passes is stopped.
+type DeadlineStatement struct {
+	Pos
+	Duration string
+}
+
+func (i *DeadlineStatement) node() {}
+
+// SleepStatement pauses the statements that follow it, as in "SLEEP 30s",
+// so a server can settle between phases. Statements started by GO carry
+// on.
+type SleepStatement struct {
+	Pos
+	Duration string
+}
+
+func (i *SleepStatement) node() {}
+
+// CapStatement caps the points or bytes the whole run generates, wherever
+// it appears, as in "MAXPOINTS 1e9" or "MAXBYTES 500GB". Kind is MAXPOINTS
+// or MAXBYTES.
+type CapStatement struct {
+	Pos
+	Kind  string
+	Value string
+}
+
+func (i *CapStatement) node() {}
+
+// Grammar versions. Version 2 reads a SET value as the rest of its line,
+// so SET addresses 10.0.0.1:8086 needs no quotes; version 1 stops at the
+// first character an identifier cannot hold.
+const (
+	Version1      = 1
+	Version2      = 2
+	LatestVersion = Version2
+)
 
 type Parser struct {
-	s   *Scanner
-	buf struct {
+	s       *Scanner
+	version int
+	buf     struct {
 		tok Token
 		lit string
 		n   int
@@ -353,10 +780,60 @@ type Parser struct {
 }
 
 func NewParser(r io.Reader) *Parser {
-	return &Parser{s: NewScanner(r)}
+	return &Parser{s: NewScanner(r), version: Version1}
+}
+
+// SetVersion makes p parse the given version of the grammar.
+func (p *Parser) SetVersion(v int) error {
+	if _, err := (&VersionStatement{Version: strconv.Itoa(v)}).Number(); err != nil {
+		return err
+	}
+	p.version = v
+	return nil
 }
 
-func (p *Parser) Parse() (Statement, error) {
+// ParseStatement parses a single statement from a string without copying it.
+// It is safe to call on untrusted input: malformed input of any kind is
+// an error.
+func ParseStatement(s string) (Statement, error) {
+	return ParseStatementVersion(s, Version1)
+}
+
+// ParseStatementVersion is ParseStatement for a version of the grammar.
+func ParseStatementVersion(s string, version int) (Statement, error) {
+	p := &Parser{s: newScanner(s)}
+	if err := p.SetVersion(version); err != nil {
+		return nil, err
+	}
+	return p.Parse()
+}
+
+// MaxStatementSize is the size in bytes of the largest statement Parse
+// accepts.
+var MaxStatementSize = 1 << 20
+
+// Parse parses a statement. Statements larger than MaxStatementSize or
+// not valid UTF-8 are rejected before they are scanned, as is source the
+// scanner failed to read.
+func (p *Parser) Parse() (stmt Statement, err error) {
+	if err := p.s.err; err != nil {
+		return nil, err
+	}
+	if n := len(p.s.src); n > MaxStatementSize {
+		return nil, fmt.Errorf("statement of %d bytes exceeds the maximum of %d", n, MaxStatementSize)
+	}
+	if !utf8.ValidString(p.s.src) {
+		return nil, fmt.Errorf("invalid UTF-8 at byte %d", invalidUTF8(p.s.src))
+	}
+	defer func() {
+		// A parser bug must not crash a program parsing configs it was
+		// sent. This is only a backstop: FuzzParse fails on what it
+		// recovers from.
+		if r := recover(); r != nil {
+			stmt, err = nil, fmt.Errorf("parse: internal error: %v", r)
+		}
+	}()
+
 	tok, lit := p.scanIgnoreWhitespace()
 
 	switch tok {
@@ -378,6 +855,47 @@ func (p *Parser) Parse() (Statement, error) {
 	case WAIT:
 		p.unscan()
 		return p.ParseWaitStatement()
+	case EVERY:
+		p.unscan()
+		return p.ParseEveryStatement()
+	case USE:
+		p.unscan()
+		return p.ParseUseStatement()
+	case CQ:
+		p.unscan()
+		return p.ParseContinuousQueryStatement()
+	case SLO:
+		p.unscan()
+		return p.ParseSLOStatement()
+	case IDENT:
+		if strings.EqualFold(lit, "version") {
+			p.unscan()
+			return p.ParseVersionStatement()
+		}
+		if strings.EqualFold(lit, "profile") {
+			p.unscan()
+			return p.ParseProfileStatement()
+		}
+		if strings.EqualFold(lit, "end") {
+			p.unscan()
+			return p.ParseEndStatement()
+		}
+		if strings.EqualFold(lit, "deadline") {
+			p.unscan()
+			return p.ParseDeadlineStatement()
+		}
+		if strings.EqualFold(lit, "sleep") {
+			p.unscan()
+			return p.ParseSleepStatement()
+		}
+		if strings.EqualFold(lit, "maxpoints") || strings.EqualFold(lit, "maxbytes") {
+			p.unscan()
+			return p.ParseCapStatement()
+		}
+		if strings.EqualFold(lit, "create") || strings.EqualFold(lit, "alter") {
+			p.unscan()
+			return p.ParseRetentionPolicyStatement()
+		}
 	}
 
 	return nil, fmt.Errorf("found %q, unknown token", lit)
@@ -394,29 +912,77 @@ func (p *Parser) ParseQueryStatement() (*QueryStatement, error) {
 		return nil, fmt.Errorf("found %q, expected IDENT", lit)
 	}
 
+	stmt.Name = lit
+
+	// The template is built up token by token; a Builder keeps that
+	// linear in its length.
+	var tmpl strings.Builder
 	for {
 		tok, lit := p.scan()
 		if tok == TEMPLATEVAR {
-			stmt.TemplateString += "%v"
+			if lit == "%t" {
+				lit += p.scanTagArg()
+			}
+			tmpl.WriteString("%v")
 			stmt.Args = append(stmt.Args, lit)
+		} else if tok == ILLEGAL && lit == "%" {
+			return nil, fmt.Errorf("found %q, expected a template variable", lit)
 		} else if tok == DO {
 			tok, lit := p.scanIgnoreWhitespace()
 			if tok != NUMBER {
 				return nil, fmt.Errorf("found %q, expected NUMBER", lit)
 			}
 			stmt.Count = lit
+			if err := p.parseQueryOptions(stmt); err != nil {
+				return nil, err
+			}
 			break
+		} else if tok == EOF {
+			return nil, fmt.Errorf("found EOF, expected DO")
 		} else if tok == WS && lit == "\n" {
 			continue
 		} else {
-			stmt.TemplateString += lit
+			tmpl.WriteString(lit)
 		}
 	}
+	stmt.TemplateString = tmpl.String()
 
 	return stmt, nil
 
 }
 
+// parseQueryOptions parses any "FANOUT n", "EXPECT SERIES n" and
+// "EXPECT NONEMPTY" clauses after a query's DO count.
+func (p *Parser) parseQueryOptions(stmt *QueryStatement) error {
+	for {
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok == IDENT && strings.EqualFold(lit, "fanout") {
+			if tok, lit = p.scanIgnoreWhitespace(); tok != NUMBER {
+				return fmt.Errorf("found %q, expected NUMBER", lit)
+			}
+			stmt.Fanout = lit
+			continue
+		}
+		if tok != IDENT || !strings.EqualFold(lit, "expect") {
+			p.unscan()
+			return nil
+		}
+
+		tok, lit = p.scanIgnoreWhitespace()
+		switch {
+		case tok == IDENT && strings.EqualFold(lit, "series"):
+			if tok, lit = p.scanIgnoreWhitespace(); tok != NUMBER {
+				return fmt.Errorf("found %q, expected NUMBER", lit)
+			}
+			stmt.ExpectSeries = lit
+		case tok == IDENT && strings.EqualFold(lit, "nonempty"):
+			stmt.ExpectNonEmpty = true
+		default:
+			return fmt.Errorf("found %q, expected SERIES or NONEMPTY", lit)
+		}
+	}
+}
+
 func (p *Parser) ParseInsertStatement() (*InsertStatement, error) {
 	stmt := &InsertStatement{}
 
@@ -436,7 +1002,42 @@ func (p *Parser) ParseInsertStatement() (*InsertStatement, error) {
 		return nil, fmt.Errorf("found %q, expected WS", lit)
 	}
 
+	// INTO and ACROSS must follow the name on the same line, so a
+	// measurement named "into" on the next line is still a template.
+header:
+	for !strings.Contains(lit, "\n") {
+		tok, word := p.scan()
+		switch {
+		case tok == IDENT && strings.EqualFold(word, "into"):
+			db, rp, err := p.parseTarget()
+			if err != nil {
+				return nil, err
+			}
+			if rp == "" {
+				db, rp = "", db
+			}
+			stmt.Database, stmt.RetentionPolicy = db, rp
+		case tok == IDENT && strings.EqualFold(word, "across"):
+			tok, lit := p.scanIgnoreWhitespace()
+			if tok != NUMBER {
+				return nil, fmt.Errorf("found %q, expected NUMBER", lit)
+			}
+			stmt.Across = lit
+			if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "databases") {
+				return nil, fmt.Errorf("found %q, expected DATABASES", lit)
+			}
+		default:
+			p.unscan()
+			break header
+		}
+
+		if tok, lit = p.scan(); tok != WS {
+			return nil, fmt.Errorf("found %q, expected WS", lit)
+		}
+	}
+
 	var prev Token
+	var tmpl strings.Builder
 
 	for {
 		tok, lit = p.scan()
@@ -445,37 +1046,41 @@ func (p *Parser) ParseInsertStatement() (*InsertStatement, error) {
 			if prev == COMMA {
 				continue
 			}
-			stmt.TemplateString += " "
+			tmpl.WriteString(" ")
 		} else if tok == LBRACKET {
 
-			stmt.TemplateString += "%v"
+			tmpl.WriteString("%v")
 
 			// parse template should return a template type
 			expr, err := p.ParseTemplate()
-			// Add template to parsed select statement
-			stmt.Templates = append(stmt.Templates, expr)
-
 			if err != nil {
-				fmt.Println(err)
-				return nil, fmt.Errorf("TEMPLATE ERROR")
+				return nil, fmt.Errorf("template: %v", err)
 			}
+			// Add template to parsed select statement
+			stmt.Templates = append(stmt.Templates, expr)
+			prev = tok
 		} else if tok == NUMBER {
-			stmt.TemplateString += "%v"
+			tmpl.WriteString("%v")
 			p.unscan()
 			ts, err := p.ParseTimestamp()
 			if err != nil {
 				return nil, fmt.Errorf("TIME ERROR")
 			}
 			stmt.Timestamp = ts
+			// Whatever the timestamp line did not take would be lost.
+			if tok, lit := p.scanIgnoreWhitespace(); tok != EOF {
+				return nil, fmt.Errorf("found %q, expected EOF", lit)
+			}
 			break
 		} else if tok != IDENT && tok != COMMA {
 			return nil, fmt.Errorf("found %q, expected IDENT or COMMA", lit)
 		} else {
 			prev = tok
-			stmt.TemplateString += lit
+			tmpl.WriteString(lit)
 		}
 
 	}
+	stmt.TemplateString = tmpl.String()
 
 	return stmt, nil
 	// Pull stuff til right bracket
@@ -490,6 +1095,16 @@ func (p *Parser) ParseTemplate() (*Template, error) {
 	//		return nil, fmt.Errorf("found %q, expected LBRACKET", lit)
 	//	}
 
+	// "fields" and "histogram" alone are tag values.
+	if tok, lit := p.scanIgnoreWhitespace(); tok == IDENT && (strings.EqualFold(lit, "fields") || strings.EqualFold(lit, "histogram")) {
+		if next, _ := p.scanIgnoreWhitespace(); next != PIPE && next != RBRACKET {
+			p.unscan()
+			return p.parseFieldSet(strings.ToLower(lit))
+		}
+		tmplt.Tags = append(tmplt.Tags, lit)
+	}
+	p.unscan()
+
 	for {
 		tok, lit := p.scanIgnoreWhitespace()
 		if tok == IDENT {
@@ -498,20 +1113,78 @@ func (p *Parser) ParseTemplate() (*Template, error) {
 			p.unscan()
 			fn, err := p.ParseFunction()
 			if err != nil {
-				fmt.Println(err)
-				return nil, fmt.Errorf("FUNCTION ERROR")
+				return nil, fmt.Errorf("function: %v", err)
 			}
 
 			tmplt.Functions = append(tmplt.Functions, fn)
 
 		} else if tok == RBRACKET {
 			break
+		} else if tok == EOF {
+			return nil, fmt.Errorf("found EOF, expected RBRACKET")
 		}
 	}
 
 	return tmplt, nil
 }
 
+// parseFieldSet parses the rest of "[fields usage 20 float rand(100) 0]"
+// or "[histogram 0.1,0.5,1,5 100 float rand(10) 0]".
+func (p *Parser) parseFieldSet(kind string) (*Template, error) {
+	set := &FieldSet{Kind: kind}
+	if kind == "fields" {
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok != IDENT {
+			return nil, fmt.Errorf("found %q, expected IDENT", lit)
+		}
+		set.Prefix = lit
+	} else {
+		for {
+			bound, err := p.parseDecimal()
+			if err != nil {
+				return nil, err
+			}
+			set.Bounds = append(set.Bounds, bound)
+			if tok, _ := p.scan(); tok != COMMA {
+				p.unscan()
+				break
+			}
+		}
+	}
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return nil, fmt.Errorf("found %q, expected NUMBER", lit)
+	}
+	set.Count = lit
+
+	fn, err := p.ParseFunction()
+	if err != nil {
+		return nil, fmt.Errorf("function: %v", err)
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != RBRACKET {
+		return nil, fmt.Errorf("found %q, expected RBRACKET", lit)
+	}
+	return &Template{Functions: []*Function{fn}, Fields: set}, nil
+}
+
+// parseDecimal parses a number that may have a fractional part, as in
+// "0.25".
+func (p *Parser) parseDecimal() (string, error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return "", fmt.Errorf("found %q, expected NUMBER", lit)
+	}
+	if tok, _ := p.scan(); tok != PERIOD {
+		p.unscan()
+		return lit, nil
+	}
+	tok, frac := p.scan()
+	if tok != NUMBER {
+		return "", fmt.Errorf("found %q, expected NUMBER", frac)
+	}
+	return lit + "." + frac, nil
+}
+
 func (p *Parser) ParseExecStatement() (*ExecStatement, error) {
 	// NEEDS TO PARSE ACTUAL PATH TO SCRIPT CURRENTLY ONLY DOES
 	// IDENT SCRIPT NAMES
@@ -532,60 +1205,479 @@ func (p *Parser) ParseExecStatement() (*ExecStatement, error) {
 	return stmt, nil
 }
 
-func (p *Parser) ParseSetStatement() (*SetStatement, error) {
-	// NEEDS TO PARSE ALL TYPES OF VALUES
-
-	stmt := &SetStatement{}
+func (p *Parser) ParseEveryStatement() (*EveryStatement, error) {
+	stmt := &EveryStatement{}
 
-	if tok, lit := p.scanIgnoreWhitespace(); tok != SET {
-		return nil, fmt.Errorf("found %q, expected SET", lit)
+	if tok, lit := p.scanIgnoreWhitespace(); tok != EVERY {
+		return nil, fmt.Errorf("found %q, expected EVERY", lit)
 	}
 
 	tok, lit := p.scanIgnoreWhitespace()
-	if tok != IDENT {
-		return nil, fmt.Errorf("found %q, expected IDENT", lit)
+	if tok != DURATIONVAL {
+		return nil, fmt.Errorf("found %q, expected DURATION", lit)
 	}
+	stmt.Interval = lit
 
-	stmt.Var = lit
-
-	tok, lit = p.scanIgnoreWhitespace()
-	if tok != IDENT && tok != NUMBER && tok != DURATIONVAL {
-		return nil, fmt.Errorf("found %q, expected IDENT or NUMBER or DURATION", lit)
+	if tok, _ := p.scanIgnoreWhitespace(); tok == DO {
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok != NUMBER {
+			return nil, fmt.Errorf("found %q, expected NUMBER", lit)
+		}
+		stmt.Count = lit
+	} else {
+		p.unscan()
 	}
 
-	stmt.Value = lit
+	stmt.Query = p.rest()
+
+	words := append(strings.Fields(strings.ToUpper(stmt.Query)), "", "")
+	switch {
+	case words[0] == "DELETE" && words[1] != "":
+	case words[0] == "DROP" && (words[1] == "SERIES" || words[1] == "MEASUREMENT"):
+	case words[0] == "SHOW" && (words[1] == "MEASUREMENTS" || words[1] == "SERIES"):
+	case words[0] == "SHOW" && (words[1] == "TAG" || words[1] == "FIELD") && (words[2] == "KEYS" || words[2] == "VALUES"):
+	default:
+		return nil, fmt.Errorf("found %q, expected DELETE, DROP SERIES, DROP MEASUREMENT or SHOW", stmt.Query)
+	}
 
 	return stmt, nil
 }
 
-func (p *Parser) ParseWaitStatement() (*WaitStatement, error) {
-	// NEEDS TO PARSE ACTUAL PATH TO SCRIPT CURRENTLY ONLY DOES
-	// IDENT SCRIPT NAMES
+func (p *Parser) ParseContinuousQueryStatement() (*ContinuousQueryStatement, error) {
+	stmt := &ContinuousQueryStatement{}
 
-	stmt := &WaitStatement{}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != CQ {
+		return nil, fmt.Errorf("found %q, expected CQ", lit)
+	}
 
-	if tok, lit := p.scanIgnoreWhitespace(); tok != WAIT {
-		return nil, fmt.Errorf("found %q, expected WAIT", lit)
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return nil, fmt.Errorf("found %q, expected IDENT", lit)
+	}
+	stmt.Name = lit
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok == IDENT && strings.EqualFold(lit, "on") {
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok != IDENT {
+			return nil, fmt.Errorf("found %q, expected IDENT", lit)
+		}
+		stmt.Database = lit
+	} else {
+		p.unscan()
+	}
+
+	stmt.Query = p.rest()
+
+	q := strings.ToUpper(normalize(stmt.Query))
+	if !strings.HasPrefix(q, "SELECT ") || !strings.Contains(q, " INTO ") || !strings.Contains(q, " GROUP BY ") {
+		return nil, fmt.Errorf("found %q, expected SELECT ... INTO ... GROUP BY", stmt.Query)
 	}
 
 	return stmt, nil
 }
 
-func (p *Parser) ParseGoStatement() (*GoStatement, error) {
+// tagArgPattern matches the tag key, and optional MISS, after %t on the
+// same line, as in "host = '%t host MISS'".
+var tagArgPattern = regexp.MustCompile(`^[ \t]+([A-Za-z_][\w-]*)(?:[ \t]+(?i:MISS)\b)?`)
 
-	stmt := &GoStatement{}
-	//	if tok, lit := p.scanIgnoreWhitespace(); tok != LBRACKET {
-	//		return nil, fmt.Errorf("found %q, expected LBRACKET", lit)
-	//	}
+// queryKeywords are words that may follow a bare %t in a query, which must
+// not be taken as a tag key.
+var queryKeywords = map[string]bool{
+	"AND": true, "OR": true, "GROUP": true, "ORDER": true, "LIMIT": true,
+	"OFFSET": true, "SLIMIT": true, "SOFFSET": true, "FILL": true, "TZ": true,
+}
 
-	if tok, lit := p.scanIgnoreWhitespace(); tok != GO {
-		return nil, fmt.Errorf("found %q, expected GO", lit)
+// scanTagArg consumes the tag key, and MISS, that may follow %t, returning
+// them with their leading space. A bare %t stays a whole tag predicate.
+func (p *Parser) scanTagArg() string {
+	if p.buf.n != 0 {
+		return ""
+	}
+	m := tagArgPattern.FindStringSubmatch(p.s.src[p.s.pos:])
+	if m == nil || queryKeywords[strings.ToUpper(m[1])] || lookupKeyword(m[1]) != IDENT {
+		return ""
+	}
+	p.s.pos += len(m[0])
+	return " " + strings.Join(strings.Fields(m[0]), " ")
+}
+
+var sloPattern = regexp.MustCompile(`(?i)^(\w+)\s*(<=|>=|<|>)\s*(\S+)(?:\s+FOR\s+(\S+))?$`)
+
+func (p *Parser) ParseSLOStatement() (*SLOStatement, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); tok != SLO {
+		return nil, fmt.Errorf("found %q, expected SLO", lit)
+	}
+
+	src := normalize(p.rest())
+	m := sloPattern.FindStringSubmatch(src)
+	if m == nil {
+		return nil, fmt.Errorf("found %q, expected METRIC < VALUE [FOR NAME]", src)
+	}
+	stmt := &SLOStatement{Metric: strings.ToLower(m[1]), Op: m[2], Value: m[3], Scope: m[4]}
+	if _, err := stmt.Threshold(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *Parser) ParseUseStatement() (*UseStatement, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); tok != USE {
+		return nil, fmt.Errorf("found %q, expected USE", lit)
+	}
+
+	db, rp, err := p.parseTarget()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &UseStatement{Database: db, RetentionPolicy: rp}
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok == IDENT && strings.EqualFold(lit, "on") {
+		if tok, _ = p.scan(); tok != WS {
+			p.unscan()
+		}
+		addrs, ok := p.scanString()
+		if !ok {
+			if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT {
+				return nil, fmt.Errorf("found %q, expected the servers to USE ON", lit)
+			}
+			addrs = lit
+		}
+		if len(splitAddresses(addrs)) == 0 {
+			return nil, fmt.Errorf("USE %s ON: no servers", target(db, rp))
+		}
+		stmt.Addresses = addrs
+	} else {
+		p.unscan()
+	}
+	return stmt, nil
+}
+
+// parseTarget parses a database with an optional retention policy, db[.rp].
+func (p *Parser) parseTarget() (db, rp string, err error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return "", "", fmt.Errorf("found %q, expected IDENT", lit)
+	}
+	db = lit
+
+	if tok, _ := p.scan(); tok != PERIOD {
+		p.unscan()
+		return db, "", nil
+	}
+
+	tok, lit = p.scan()
+	if tok != IDENT {
+		return "", "", fmt.Errorf("found %q, expected IDENT", lit)
+	}
+	return db, lit, nil
+}
+
+func (p *Parser) ParseSetStatement() (*SetStatement, error) {
+	// NEEDS TO PARSE ALL TYPES OF VALUES
+
+	stmt := &SetStatement{}
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok != SET {
+		return nil, fmt.Errorf("found %q, expected SET", lit)
+	}
+
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return nil, fmt.Errorf("found %q, expected IDENT", lit)
+	}
+
+	stmt.Var = lit
+
+	// A quoted value may hold characters identifiers cannot, as in
+	// SET proxy "http://proxy:3128".
+	if tok, _ = p.scan(); tok != WS {
+		p.unscan()
+	}
+	if v, ok := p.scanString(); ok {
+		stmt.Value = v
+		return stmt, nil
+	}
+	if p.version >= Version2 {
+		if stmt.Value = p.rest(); stmt.Value == "" {
+			return nil, fmt.Errorf("SET %s: missing value", stmt.Var)
+		}
+		return stmt, nil
+	}
+
+	tok, lit = p.scanIgnoreWhitespace()
+	if tok != IDENT && tok != NUMBER && tok != DURATIONVAL {
+		return nil, fmt.Errorf("found %q, expected IDENT or NUMBER or DURATION", lit)
+	}
+
+	stmt.Value = lit
+
+	// A secret("path") or keyring("service", "account") reference,
+	// resolved as the statement runs.
+	if tok == IDENT && (lit == "secret" || lit == "keyring") {
+		if tok, _ := p.scan(); tok != LPAREN {
+			p.unscan()
+			return stmt, nil
+		}
+		args, ok := p.scanParens()
+		if !ok {
+			return nil, fmt.Errorf("SET %s: unterminated %s(", stmt.Var, lit)
+		}
+		stmt.Value = lit + "(" + args + ")"
+		if _, _, ok := parseSecret(stmt.Value); !ok {
+			return nil, fmt.Errorf("SET %s: found %q, expected %s(\"...\")", stmt.Var, stmt.Value, lit)
+		}
+	}
+
+	return stmt, nil
+}
+
+func (p *Parser) ParseVersionStatement() (*VersionStatement, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "version") {
+		return nil, fmt.Errorf("found %q, expected VERSION", lit)
+	}
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return nil, fmt.Errorf("found %q, expected NUMBER", lit)
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, fmt.Errorf("found %q, expected EOF", lit)
+	}
+	stmt := &VersionStatement{Version: lit}
+	if _, err := stmt.Number(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *Parser) ParseProfileStatement() (*ProfileStatement, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "profile") {
+		return nil, fmt.Errorf("found %q, expected PROFILE", lit)
+	}
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return nil, fmt.Errorf("found %q, expected IDENT", lit)
+	}
+	stmt := &ProfileStatement{Name: lit}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, fmt.Errorf("found %q, expected EOF", lit)
+	}
+	return stmt, nil
+}
+
+func (p *Parser) ParseEndStatement() (*EndStatement, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "end") {
+		return nil, fmt.Errorf("found %q, expected END", lit)
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, fmt.Errorf("found %q, expected EOF", lit)
+	}
+	return &EndStatement{}, nil
+}
+
+func (p *Parser) ParseDeadlineStatement() (*DeadlineStatement, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "deadline") {
+		return nil, fmt.Errorf("found %q, expected DEADLINE", lit)
+	}
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != DURATIONVAL {
+		return nil, fmt.Errorf("found %q, expected DURATION", lit)
+	}
+	stmt := &DeadlineStatement{Duration: lit}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, fmt.Errorf("found %q, expected EOF", lit)
+	}
+	return stmt, nil
+}
+
+func (p *Parser) ParseSleepStatement() (*SleepStatement, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "sleep") {
+		return nil, fmt.Errorf("found %q, expected SLEEP", lit)
+	}
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != DURATIONVAL {
+		return nil, fmt.Errorf("found %q, expected DURATION", lit)
+	}
+	stmt := &SleepStatement{Duration: lit}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, fmt.Errorf("found %q, expected EOF", lit)
+	}
+	return stmt, nil
+}
+
+func (p *Parser) ParseCapStatement() (*CapStatement, error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT || !strings.EqualFold(lit, "maxpoints") && !strings.EqualFold(lit, "maxbytes") {
+		return nil, fmt.Errorf("found %q, expected MAXPOINTS or MAXBYTES", lit)
+	}
+	stmt := &CapStatement{Kind: strings.ToUpper(lit), Value: p.rest()}
+	if _, err := stmt.Limit(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *Parser) ParseRetentionPolicyStatement() (*RetentionPolicyStatement, error) {
+	stmt := &RetentionPolicyStatement{}
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT || !strings.EqualFold(lit, "create") && !strings.EqualFold(lit, "alter") {
+		return nil, fmt.Errorf("found %q, expected CREATE or ALTER", lit)
+	}
+	stmt.Alter = strings.EqualFold(lit, "alter")
+	if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "rp") {
+		return nil, fmt.Errorf("found %q, expected RP", lit)
+	}
+	if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT {
+		return nil, fmt.Errorf("found %q, expected IDENT", lit)
+	}
+	stmt.Name = lit
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok == IDENT && strings.EqualFold(lit, "on") {
+		if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT {
+			return nil, fmt.Errorf("found %q, expected IDENT", lit)
+		}
+		stmt.Database = lit
+	} else {
+		p.unscan()
+	}
+
+	// Retention policies are kept for days and weeks, which InfluxQL
+	// writes as 7d or 1w but the scanner does not read as durations.
+	duration := func() (string, error) {
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok == NUMBER {
+			if tok, unit := p.scan(); tok == IDENT && (unit == "d" || unit == "w") {
+				return lit + unit, nil
+			}
+			p.unscan()
+		}
+		if tok != DURATIONVAL && (tok != IDENT || !strings.EqualFold(lit, "inf")) {
+			return "", fmt.Errorf("found %q, expected DURATION", lit)
+		}
+		return lit, nil
+	}
+	var err error
+	for {
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok == EOF {
+			break
+		}
+		switch {
+		case tok != IDENT:
+			return nil, fmt.Errorf("found %q, expected DURATION, REPLICATION, SHARD DURATION or DEFAULT", lit)
+		case strings.EqualFold(lit, "duration"):
+			stmt.Duration, err = duration()
+		case strings.EqualFold(lit, "replication"):
+			tok, lit := p.scanIgnoreWhitespace()
+			if n, e := strconv.Atoi(lit); tok != NUMBER || e != nil || n < 1 {
+				return nil, fmt.Errorf("found %q, expected a replication factor", lit)
+			}
+			stmt.Replication = lit
+		case strings.EqualFold(lit, "shard"):
+			if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "duration") {
+				return nil, fmt.Errorf("found %q, expected DURATION", lit)
+			}
+			stmt.ShardDuration, err = duration()
+		case strings.EqualFold(lit, "default"):
+			stmt.Default = true
+		default:
+			return nil, fmt.Errorf("found %q, expected DURATION, REPLICATION, SHARD DURATION or DEFAULT", lit)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case !stmt.Alter && (stmt.Duration == "" || stmt.Replication == ""):
+		return nil, fmt.Errorf("CREATE RP %s: expected DURATION and REPLICATION", stmt.Name)
+	case stmt.Alter && stmt.clauses() == "":
+		return nil, fmt.Errorf("ALTER RP %s: nothing to alter", stmt.Name)
+	}
+	return stmt, nil
+}
+
+func (p *Parser) ParseWaitStatement() (*WaitStatement, error) {
+	// NEEDS TO PARSE ACTUAL PATH TO SCRIPT CURRENTLY ONLY DOES
+	// IDENT SCRIPT NAMES
+
+	stmt := &WaitStatement{}
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok != WAIT {
+		return nil, fmt.Errorf("found %q, expected WAIT", lit)
+	}
+
+	switch tok, lit := p.scanIgnoreWhitespace(); tok {
+	case EOF:
+		return stmt, nil
+	case IDENT:
+		stmt.For = strings.ToUpper(lit)
+	case NUMBER:
+		stmt.For = lit
+	default:
+		return nil, fmt.Errorf("found %q, expected ALL, ANY or NUMBER", lit)
+	}
+	if _, err := stmt.Count(); err != nil {
+		return nil, err
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, fmt.Errorf("found %q, expected EOF", lit)
+	}
+	return stmt, nil
+}
+
+func (p *Parser) ParseGoStatement() (*GoStatement, error) {
+
+	stmt := &GoStatement{}
+	//	if tok, lit := p.scanIgnoreWhitespace(); tok != LBRACKET {
+	//		return nil, fmt.Errorf("found %q, expected LBRACKET", lit)
+	//	}
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok != GO {
+		return nil, fmt.Errorf("found %q, expected GO", lit)
 	}
 
 	var body Statement
 	var err error
 
-	tok, _ := p.scanIgnoreWhitespace()
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok == NUMBER {
+		if n, err := strconv.Atoi(lit); err != nil || n <= 0 {
+			return nil, fmt.Errorf("found %q, expected a positive number of workers", lit)
+		}
+		stmt.Concurrency = lit
+		tok, lit = p.scanIgnoreWhitespace()
+	}
+	if tok == IDENT && strings.EqualFold(lit, "after") {
+		for {
+			if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT {
+				return nil, fmt.Errorf("found %q, expected the name of a statement after AFTER", lit)
+			}
+			stmt.After = append(stmt.After, lit)
+			if tok, lit = p.scanIgnoreWhitespace(); tok != COMMA {
+				break
+			}
+		}
+	}
+	if tok == IDENT && strings.EqualFold(lit, "until") {
+		if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT {
+			return nil, fmt.Errorf("found %q, expected the name of a variable after UNTIL", lit)
+		}
+		// An identifier may hold =, so "phase=load" is one.
+		name, value, eq := strings.Cut(lit, "=")
+		stmt.Until, stmt.Equals = name, value
+		tok, lit = p.scanIgnoreWhitespace()
+		if !eq && lit == "=" {
+			eq = true
+			tok, lit = p.scanIgnoreWhitespace()
+		}
+		if eq && value == "" {
+			if tok != IDENT && tok != NUMBER && tok != DURATIONVAL {
+				return nil, fmt.Errorf("found %q, expected a value after UNTIL %s =", lit, name)
+			}
+			stmt.Equals = lit
+			tok, lit = p.scanIgnoreWhitespace()
+		}
+	}
 	switch tok {
 	case QUERY:
 		p.unscan()
@@ -596,11 +1688,19 @@ func (p *Parser) ParseGoStatement() (*GoStatement, error) {
 	case EXEC:
 		p.unscan()
 		body, err = p.ParseExecStatement()
+	case EVERY:
+		p.unscan()
+		body, err = p.ParseEveryStatement()
+	default:
+		return nil, fmt.Errorf("found %q, expected QUERY, INSERT, EXEC or EVERY after GO", lit)
 	}
 
 	if err != nil {
 		return nil, fmt.Errorf("found %q", err)
 	}
+	if stmt.Concurrency != "" && tok != INSERT && tok != QUERY {
+		return nil, fmt.Errorf("GO %s: only an INSERT or QUERY takes a number of workers", stmt.Concurrency)
+	}
 
 	stmt.Statement = body
 
@@ -625,15 +1725,26 @@ func (p *Parser) ParseFunction() (*Function, error) {
 		return nil, fmt.Errorf("LPAREN ERROR")
 	}
 
-	tok, lit = p.scanIgnoreWhitespace()
-	if tok != NUMBER {
-		return nil, fmt.Errorf("NUMBER ERROR")
-	}
-	fn.Argument = lit
+	if strings.EqualFold(fn.Fn, "derive") {
+		arg, ok := p.scanParens()
+		if !ok {
+			return nil, fmt.Errorf("missing RPAREN in derive")
+		}
+		fn.Argument = arg
+	} else {
+		if str, ok := p.scanString(); ok {
+			fn.Argument = `"` + str + `"`
+		} else if tok, lit = p.scanIgnoreWhitespace(); tok == NUMBER {
+			fn.Argument = lit
+		} else if tok != RPAREN {
+			return nil, fmt.Errorf("NUMBER ERROR")
+		}
 
-	tok, _ = p.scanIgnoreWhitespace()
-	if tok != RPAREN {
-		return nil, fmt.Errorf("RPAREN ERROR")
+		if tok != RPAREN {
+			if tok, _ = p.scanIgnoreWhitespace(); tok != RPAREN {
+				return nil, fmt.Errorf("RPAREN ERROR")
+			}
+		}
 	}
 
 	tok, lit = p.scanIgnoreWhitespace()
@@ -642,6 +1753,38 @@ func (p *Parser) ParseFunction() (*Function, error) {
 	}
 	fn.Count = lit
 
+	for {
+		tok, lit = p.scanIgnoreWhitespace()
+		if tok == IDENT && strings.EqualFold(lit, "churn") {
+			if tok, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+				return nil, fmt.Errorf("found %q, expected LPAREN", lit)
+			}
+			if tok, lit = p.scanIgnoreWhitespace(); tok != NUMBER {
+				return nil, fmt.Errorf("found %q, expected NUMBER", lit)
+			}
+			fn.Churn = lit
+			if tok, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+				return nil, fmt.Errorf("found %q, expected RPAREN", lit)
+			}
+			if tok, lit = p.scanIgnoreWhitespace(); tok != DURATIONVAL {
+				return nil, fmt.Errorf("found %q, expected DURATION", lit)
+			}
+			fn.ChurnEvery = lit
+		} else if tok == IDENT && strings.EqualFold(lit, "by") {
+			if tok, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+				return nil, fmt.Errorf("found %q, expected LPAREN", lit)
+			}
+			by, ok := p.scanParens()
+			if !ok {
+				return nil, fmt.Errorf("missing RPAREN in by")
+			}
+			fn.By = by
+		} else {
+			p.unscan()
+			break
+		}
+	}
+
 	return fn, nil
 }
 
@@ -663,9 +1806,215 @@ func (p *Parser) ParseTimestamp() (*Timestamp, error) {
 	}
 	ts.Duration = lit
 
+	for {
+		tok, lit = p.scanIgnoreWhitespace()
+		if tok == IDENT && strings.EqualFold(lit, "jitter") {
+			ts.Jitter = true
+		} else if tok == IDENT && strings.EqualFold(lit, "realtime") {
+			ts.RealTime = true
+		} else if tok == IDENT && strings.EqualFold(lit, "grow") {
+			if tok, lit = p.scanIgnoreWhitespace(); tok != NUMBER {
+				return nil, fmt.Errorf("found %q, expected NUMBER", lit)
+			}
+			ts.Grow = lit
+			if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "series") {
+				return nil, fmt.Errorf("found %q, expected series", lit)
+			}
+			if tok, lit = p.scan(); lit != "/" {
+				return nil, fmt.Errorf("found %q, expected /", lit)
+			}
+			if tok, lit = p.scan(); tok != IDENT {
+				return nil, fmt.Errorf("found %q, expected IDENT", lit)
+			}
+			ts.GrowUnit = lit
+		} else if tok == IDENT && strings.EqualFold(lit, "rate") {
+			if err := p.parseRate(ts); err != nil {
+				return nil, err
+			}
+		} else if tok == IDENT && strings.EqualFold(lit, "burst") {
+			if err := p.parseBurst(ts); err != nil {
+				return nil, err
+			}
+		} else if tok == IDENT && strings.EqualFold(lit, "steps") {
+			if err := p.parseSteps(ts); err != nil {
+				return nil, err
+			}
+		} else if tok == IDENT && strings.EqualFold(lit, "anomaly") {
+			a, err := p.parseAnomaly()
+			if err != nil {
+				return nil, err
+			}
+			ts.Anomalies = append(ts.Anomalies, a)
+		} else if tok == IDENT && strings.EqualFold(lit, "order") {
+			if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "by") {
+				return nil, fmt.Errorf("found %q, expected BY", lit)
+			}
+			if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT {
+				return nil, fmt.Errorf("found %q, expected time, series or random", lit)
+			}
+			ts.Order = strings.ToLower(lit)
+		} else {
+			p.unscan()
+			break
+		}
+	}
+
 	return ts, nil
 }
 
+// rateUnits maps the units a RATE may be written in to those of
+// Timestamp.RateUnit.
+var rateUnits = map[string]string{
+	"pts":      "pts",
+	"points":   "pts",
+	"req":      "req",
+	"requests": "req",
+	"batches":  "req",
+	"bytes":    "bytes",
+}
+
+// parseRate parses the rest of "RATE 5000" or "RATE 2M bytes/s". The rate
+// may have a k or M suffix.
+func (p *Parser) parseRate(ts *Timestamp) error {
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return fmt.Errorf("found %q, expected NUMBER", lit)
+	}
+	if tok, suffix := p.scan(); tok == IDENT && (suffix == "k" || suffix == "M") {
+		lit += suffix
+	} else {
+		p.unscan()
+	}
+	ts.Rate = lit
+
+	tok, lit = p.scanIgnoreWhitespace()
+	unit, ok := rateUnits[strings.ToLower(lit)]
+	if tok != IDENT || !ok {
+		p.unscan()
+		return nil
+	}
+	if _, lit := p.scan(); lit != "/" {
+		return fmt.Errorf("found %q, expected /", lit)
+	}
+	if tok, lit := p.scan(); tok != IDENT || lit != "s" {
+		return fmt.Errorf("found %q, expected s", lit)
+	}
+	ts.RateUnit = unit
+	return nil
+}
+
+// parseBurst parses the rest of "BURST 10x FOR 30s EVERY 10m".
+func (p *Parser) parseBurst(ts *Timestamp) error {
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return fmt.Errorf("found %q, expected NUMBER", lit)
+	}
+	ts.Burst = lit
+	if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "x") {
+		return fmt.Errorf("found %q, expected x", lit)
+	}
+	if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "for") {
+		return fmt.Errorf("found %q, expected FOR", lit)
+	}
+	if tok, lit = p.scanIgnoreWhitespace(); tok != DURATIONVAL {
+		return fmt.Errorf("found %q, expected DURATION", lit)
+	}
+	ts.BurstFor = lit
+	if tok, lit = p.scanIgnoreWhitespace(); tok != EVERY {
+		return fmt.Errorf("found %q, expected EVERY", lit)
+	}
+	if tok, lit = p.scanIgnoreWhitespace(); tok != DURATIONVAL {
+		return fmt.Errorf("found %q, expected DURATION", lit)
+	}
+	ts.BurstEvery = lit
+	return nil
+}
+
+// parseAnomaly parses the rest of "ANOMALY spike 1/1000 10x FOR 1m", where
+// the factor and duration are optional and the rate is a fraction or a
+// percentage, as in "5" or "5%".
+func (p *Parser) parseAnomaly() (*AnomalySpec, error) {
+	a := &AnomalySpec{}
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return nil, fmt.Errorf("found %q, expected spike, shift or gap", lit)
+	}
+	a.Kind = strings.ToLower(lit)
+	if tok, lit = p.scanIgnoreWhitespace(); tok != NUMBER {
+		return nil, fmt.Errorf("found %q, expected NUMBER", lit)
+	}
+	a.Rate = lit
+	switch _, sym := p.scan(); sym {
+	case "/":
+		if tok, lit = p.scan(); tok != NUMBER {
+			return nil, fmt.Errorf("found %q, expected NUMBER", lit)
+		}
+		a.Rate += "/" + lit
+	case "%":
+		a.Rate += "%"
+	default:
+		p.unscan()
+	}
+
+	if tok, lit = p.scanIgnoreWhitespace(); tok == NUMBER {
+		a.Factor = lit
+		if tok, lit = p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "x") {
+			return nil, fmt.Errorf("found %q, expected x", lit)
+		}
+		tok, lit = p.scanIgnoreWhitespace()
+	}
+	if tok == IDENT && strings.EqualFold(lit, "for") {
+		if tok, lit = p.scanIgnoreWhitespace(); tok != DURATIONVAL {
+			return nil, fmt.Errorf("found %q, expected DURATION", lit)
+		}
+		a.For = lit
+	} else {
+		p.unscan()
+	}
+	return a, nil
+}
+
+// parseSteps parses the rest of "STEPS 10k,20k,40k pts/s HOLD 5m". Rates
+// may have a k or M suffix.
+func (p *Parser) parseSteps(ts *Timestamp) error {
+	for {
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok != NUMBER {
+			return fmt.Errorf("found %q, expected NUMBER", lit)
+		}
+		if tok, suffix := p.scan(); tok == IDENT && (suffix == "k" || suffix == "M") {
+			lit += suffix
+		} else {
+			p.unscan()
+		}
+		ts.Steps = append(ts.Steps, lit)
+
+		if tok, _ := p.scan(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+
+	if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "pts") {
+		return fmt.Errorf("found %q, expected pts/s", lit)
+	}
+	if _, lit := p.scan(); lit != "/" {
+		return fmt.Errorf("found %q, expected /", lit)
+	}
+	if tok, lit := p.scan(); tok != IDENT || lit != "s" {
+		return fmt.Errorf("found %q, expected s", lit)
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != IDENT || !strings.EqualFold(lit, "hold") {
+		return fmt.Errorf("found %q, expected HOLD", lit)
+	}
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != DURATIONVAL {
+		return fmt.Errorf("found %q, expected DURATION", lit)
+	}
+	ts.StepHold = lit
+	return nil
+}
+
 func (p *Parser) scan() (tok Token, lit string) {
 	// If we have a token on the buffer, then return it.
 	if p.buf.n != 0 {
@@ -691,6 +2040,69 @@ func (p *Parser) scanIgnoreWhitespace() (tok Token, lit string) {
 	return
 }
 
+// rest consumes and returns the remaining source, including any unscanned
+// token, for statements that embed InfluxQL.
+func (p *Parser) rest() string {
+	pos := p.s.pos
+	if p.buf.n != 0 {
+		pos -= len(p.buf.lit)
+		p.buf.n = 0
+	}
+	p.s.pos = len(p.s.src)
+	return strings.TrimSpace(p.s.src[pos:])
+}
+
+// scanString consumes a double-quoted string and returns its contents. It
+// reports false, consuming nothing, if the next character is not a quote.
+func (p *Parser) scanString() (string, bool) {
+	if p.buf.n != 0 || p.s.peek() != '"' {
+		return "", false
+	}
+	src := p.s.src[p.s.pos+1:]
+	end := strings.IndexByte(src, '"')
+	if end < 0 {
+		return "", false
+	}
+	p.s.pos += end + 2
+	return src[:end], true
+}
+
+// scanParens consumes the rest of a parenthesized expression, after its
+// LPAREN, and returns its contents.
+func (p *Parser) scanParens() (string, bool) {
+	if p.buf.n != 0 {
+		return "", false
+	}
+	src := p.s.src[p.s.pos:]
+	depth := 1
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				p.s.pos += i + 1
+				return strings.TrimSpace(src[:i]), true
+			}
+		case ']', '\n':
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// invalidUTF8 returns the offset of the first invalid UTF-8 in s.
+func invalidUTF8(s string) int {
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && w == 1 {
+			return i
+		}
+		i += w
+	}
+	return -1
+}
+
 // unscan pushes the previously read token back onto the buffer.
 func (p *Parser) unscan() { p.buf.n = 1 }
 
@@ -0,0 +1,204 @@
+package stressql
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const scanSource = `INSERT cpu
+cpu,
+host=server-[int inc(0) 1000],region=[us-west|us-east]
+busy=[float rand(100) 0],free=[int rand(1000) 0]
+100000 10s jitter`
+
+func TestScanDoesNotAllocate(t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func() {
+		s := Scanner{src: scanSource}
+		for tok, _ := s.Scan(); tok != EOF; tok, _ = s.Scan() {
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("scanning allocated %v times, want 0", allocs)
+	}
+}
+
+func TestParseReadError(t *testing.T) {
+	// A source cut short by a read error must not parse as a statement.
+	boom := errors.New("boom")
+	p := NewParser(io.MultiReader(strings.NewReader("SET database stress"), iotest.ErrReader(boom)))
+	if s, err := p.Parse(); err != boom {
+		t.Fatalf("Parse returned %v, %v, want %v", s, err, boom)
+	}
+}
+
+func TestParseRetentionPolicy(t *testing.T) {
+	for _, tt := range []struct{ src, want, err string }{
+		{src: "create rp week on stress duration 7d replication 1 shard duration 1d default",
+			want: "CREATE RP week ON stress DURATION 7d REPLICATION 1 SHARD DURATION 1d DEFAULT"},
+		{src: "CREATE RP forever DURATION INF REPLICATION 2", want: "CREATE RP forever DURATION INF REPLICATION 2"},
+		{src: "ALTER RP week DURATION 1h", want: "ALTER RP week DURATION 1h"},
+		{src: "ALTER RP week ON stress DEFAULT", want: "ALTER RP week ON stress DEFAULT"},
+		{src: "CREATE RP week DURATION 7d", err: "expected DURATION and REPLICATION"},
+		{src: "ALTER RP week", err: "nothing to alter"},
+		{src: "ALTER RP week REPLICATION 0", err: "expected a replication factor"},
+		{src: "CREATE RP week DURATION 7d REPLICATION 1 NOW", err: `found "NOW"`},
+		{src: "CREATE DATABASE stress", err: `expected RP`},
+	} {
+		s, err := ParseStatement(tt.src)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got %v, %v, want error %q", tt.src, s, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if got := s.(*RetentionPolicyStatement).String(); got != tt.want {
+			t.Errorf("%q: formatted as %q, want %q", tt.src, got, tt.want)
+		}
+		if again, err := ParseStatement(tt.want); err != nil || again.(*RetentionPolicyStatement).String() != tt.want {
+			t.Errorf("%q: reparsed as %v, %v", tt.want, again, err)
+		}
+	}
+}
+
+func TestParseGoUntil(t *testing.T) {
+	const insert = "INSERT cpu\ncpu,\nhost=server-0\nv=[int inc(0) 0]\n10 10s"
+	ins, err := ParseStatement(insert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// want is the GO's head, before the INSERT.
+	for _, tt := range []struct{ src, want, err string }{
+		{src: "GO UNTIL ready " + insert, want: "GO UNTIL ready "},
+		{src: "GO UNTIL phase = load " + insert, want: "GO UNTIL phase = load "},
+		{src: "GO UNTIL phase=load " + insert, want: "GO UNTIL phase = load "},
+		{src: "go 2 after a until workers = 8 " + insert, want: "GO 2 AFTER a UNTIL workers = 8 "},
+		{src: "GO UNTIL warmup = 30s " + insert, want: "GO UNTIL warmup = 30s "},
+		{src: "GO UNTIL " + insert, err: "expected the name of a variable"},
+		{src: "GO UNTIL phase = " + insert, err: "expected a value after UNTIL phase ="},
+		{src: "GO UNTIL phase = load, " + insert, err: "expected QUERY, INSERT"},
+	} {
+		s, err := ParseStatement(tt.src)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got %v, %v, want error %q", tt.src, s, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if got, want := s.(*GoStatement).String(), tt.want+ins.(*InsertStatement).String(); got != want {
+			t.Errorf("%q: formatted as %q, want %q", tt.src, got, want)
+		}
+		if again, err := ParseStatement(s.(*GoStatement).String()); err != nil || again.(*GoStatement).String() != s.(*GoStatement).String() {
+			t.Errorf("%q: reparsed as %v, %v", tt.src, again, err)
+		}
+	}
+}
+
+func TestParseAnomaly(t *testing.T) {
+	const insert = "INSERT cpu\ncpu,\nhost=[a|b]\nv=[float rand(100) 0]\n100 10s "
+	for _, tt := range []struct {
+		src, want string
+		rate      float64
+	}{
+		{src: "ANOMALY spike 1% 10x", want: "ANOMALY spike 1% 10x", rate: 0.01},
+		{src: "ANOMALY spike 1 10x", want: "ANOMALY spike 1 10x", rate: 0.01},
+		{src: "ANOMALY gap 1/1000 FOR 5m", want: "ANOMALY gap 1/1000 FOR 5m", rate: 0.001},
+	} {
+		s, err := ParseStatement(insert + tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		a := s.(*InsertStatement).Timestamp.Anomalies
+		if len(a) != 1 || a[0].String() != tt.want {
+			t.Errorf("%q: parsed as %v, want %s", tt.src, a, tt.want)
+			continue
+		}
+		if r, err := anomalyRate(a[0].Rate); err != nil || r != tt.rate {
+			t.Errorf("%q: rate %v, %v, want %v", tt.src, r, err, tt.rate)
+		}
+	}
+
+	// Nothing after the timestamp line is dropped unread.
+	for _, src := range []string{"ANOMALY spike 1 10x 2", "ANOMALY spike 1 10x FOR 1m now", "jitter 5"} {
+		if s, err := ParseStatement(insert + src); err == nil {
+			t.Errorf("%q: parsed as %v, want an error", src, s)
+		}
+	}
+}
+
+// parseCrashers once panicked in the parser, before Parse recovered.
+var parseCrashers = []string{
+	// scanTemplateVar took a % and whatever followed it as a variable.
+	"%",
+	"QUERY q\nSELECT %",
+	"QUERY q\nSELECT % FROM cpu\nDO 1",
+	// ParseTemplate's errors left a nil template behind.
+	"INSERT cpu\ncpu,host=[a|b",
+	"INSERT cpu\ncpu,host=[str rand(",
+	"INSERT cpu\ncpu,host=[int inc(0) 10]\nv=[float",
+	"GO INSERT cpu\ncpu,\nhost=[]\nv=[int rand(\n10 1s",
+}
+
+func TestParseCrashers(t *testing.T) {
+	for _, src := range parseCrashers {
+		// Each is an error of its own, not one Parse recovered from.
+		if s, err := ParseStatement(src); err == nil || strings.Contains(err.Error(), "internal error") {
+			t.Errorf("%q: parsed as %v, %v", src, s, err)
+		}
+	}
+}
+
+// FuzzParse checks that no input makes the parser panic, which Parse would
+// otherwise hide as an internal error. It is seeded with the statements of
+// the sample configs.
+func FuzzParse(f *testing.F) {
+	paths, err := filepath.Glob("*.iql")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, s := range strings.Split(string(src), "\n\n") {
+			f.Add(strings.TrimSpace(s))
+		}
+	}
+	for _, s := range parseCrashers {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		s, err := ParseStatement(src)
+		if err != nil {
+			if strings.Contains(err.Error(), "internal error") {
+				t.Fatalf("%q: %v", src, err)
+			}
+			return
+		}
+		_ = Format([]Statement{s})
+	})
+}
+
+func BenchmarkScan(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(scanSource)))
+	for i := 0; i < b.N; i++ {
+		s := Scanner{src: scanSource}
+		for tok, _ := s.Scan(); tok != EOF; tok, _ = s.Scan() {
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package stressql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// patternNode is one element of a pattern: a character class, or a group of
+// alternatives, repeated between min and max times.
+type patternNode struct {
+	class    []byte
+	group    [][]patternNode
+	min, max int
+}
+
+// patternValue generates strings matching a regular expression, as in
+// pattern("srv-[a-z]{3}-[0-9]{2}"). The supported subset is literals,
+// escapes, character classes with ranges, \d and \w, groups with
+// alternation, and the quantifiers ?, {n} and {n,m}.
+func patternValue(kind valueKind, arg string, seed uint64) (value, error) {
+	if kind != kindString {
+		return nil, fmt.Errorf("pattern needs a str type")
+	}
+	if len(arg) < 2 || arg[0] != '"' || arg[len(arg)-1] != '"' {
+		return nil, fmt.Errorf("invalid argument %s, expected a quoted pattern", arg)
+	}
+	src := arg[1 : len(arg)-1]
+	pp := &patternParser{src: src}
+	nodes, err := pp.sequence()
+	if err != nil {
+		return nil, err
+	}
+	if pp.pos < len(src) {
+		return nil, fmt.Errorf("unexpected %q in pattern %q", src[pp.pos], src)
+	}
+	return func(b []byte, k uint64) []byte {
+		h := mix(seed + k)
+		return appendPattern(b, nodes, &h)
+	}, nil
+}
+
+func appendPattern(b []byte, nodes []patternNode, h *uint64) []byte {
+	for i := range nodes {
+		n := &nodes[i]
+		reps := n.min
+		if n.max > n.min {
+			reps += int(nextRand(h) % uint64(n.max-n.min+1))
+		}
+		for r := 0; r < reps; r++ {
+			if n.group != nil {
+				b = appendPattern(b, n.group[nextRand(h)%uint64(len(n.group))], h)
+			} else {
+				b = append(b, n.class[nextRand(h)%uint64(len(n.class))])
+			}
+		}
+	}
+	return b
+}
+
+// nextRand advances h and returns a new random value.
+func nextRand(h *uint64) uint64 {
+	*h = mix(*h)
+	return *h
+}
+
+const (
+	digitClass = "0123456789"
+	wordClass  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_"
+)
+
+type patternParser struct {
+	src string
+	pos int
+}
+
+// sequence parses nodes up to the end of the pattern, a | or a ).
+func (p *patternParser) sequence() ([]patternNode, error) {
+	var nodes []patternNode
+	for p.pos < len(p.src) {
+		var n patternNode
+		switch c := p.src[p.pos]; c {
+		case '|', ')':
+			return nodes, nil
+		case '(':
+			p.pos++
+			for {
+				alt, err := p.sequence()
+				if err != nil {
+					return nil, err
+				}
+				n.group = append(n.group, alt)
+				if p.pos == len(p.src) {
+					return nil, fmt.Errorf("missing ) in pattern %q", p.src)
+				}
+				p.pos++
+				if p.src[p.pos-1] == ')' {
+					break
+				}
+			}
+		case '[':
+			class, err := p.class()
+			if err != nil {
+				return nil, err
+			}
+			n.class = class
+		case '\\':
+			class, err := p.escape()
+			if err != nil {
+				return nil, err
+			}
+			n.class = []byte(class)
+		case '.':
+			p.pos++
+			n.class = []byte(wordClass)
+		case '*', '+', '?', '{':
+			return nil, fmt.Errorf("nothing to repeat in pattern %q", p.src)
+		default:
+			p.pos++
+			n.class = []byte{c}
+		}
+		if err := p.quantifier(&n); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+func (p *patternParser) escape() (string, error) {
+	p.pos++
+	if p.pos == len(p.src) {
+		return "", fmt.Errorf("trailing \\ in pattern %q", p.src)
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'd':
+		return digitClass, nil
+	case 'w':
+		return wordClass, nil
+	}
+	return string(c), nil
+}
+
+func (p *patternParser) class() ([]byte, error) {
+	p.pos++
+	var class []byte
+	for {
+		if p.pos == len(p.src) {
+			return nil, fmt.Errorf("missing ] in pattern %q", p.src)
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == ']':
+			p.pos++
+			if len(class) == 0 {
+				return nil, fmt.Errorf("empty class in pattern %q", p.src)
+			}
+			return class, nil
+		case c == '\\':
+			s, err := p.escape()
+			if err != nil {
+				return nil, err
+			}
+			class = append(class, s...)
+		case p.pos+2 < len(p.src) && p.src[p.pos+1] == '-' && p.src[p.pos+2] != ']':
+			hi := p.src[p.pos+2]
+			if hi < c {
+				return nil, fmt.Errorf("invalid range %c-%c in pattern %q", c, hi, p.src)
+			}
+			for r := int(c); r <= int(hi); r++ {
+				class = append(class, byte(r))
+			}
+			p.pos += 3
+		default:
+			class = append(class, c)
+			p.pos++
+		}
+	}
+}
+
+func (p *patternParser) quantifier(n *patternNode) error {
+	n.min, n.max = 1, 1
+	if p.pos == len(p.src) {
+		return nil
+	}
+	switch p.src[p.pos] {
+	case '?':
+		p.pos++
+		n.min = 0
+	case '*', '+':
+		return fmt.Errorf("unbounded %c in pattern %q, use {n,m}", p.src[p.pos], p.src)
+	case '{':
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return fmt.Errorf("missing } in pattern %q", p.src)
+		}
+		spec := p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		lo, hi, ok := strings.Cut(spec, ",")
+		var err error
+		if n.min, err = strconv.Atoi(lo); err != nil || n.min < 0 {
+			return fmt.Errorf("invalid repeat {%s} in pattern %q", spec, p.src)
+		}
+		n.max = n.min
+		if ok {
+			if n.max, err = strconv.Atoi(hi); err != nil || n.max < n.min {
+				return fmt.Errorf("invalid repeat {%s} in pattern %q", spec, p.src)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,569 @@
+package stressql
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// BatchWriter sends a batch of line protocol to a server.
+type BatchWriter interface {
+	WriteBatch(ctx context.Context, batch []byte) error
+}
+
+// FanoutWriter spreads batches over several writers in turn, such as one
+// per database of an INSERT ... ACROSS.
+type FanoutWriter struct {
+	Writers []BatchWriter
+	next    uint64
+}
+
+func (f *FanoutWriter) WriteBatch(ctx context.Context, batch []byte) error {
+	n := atomic.AddUint64(&f.next, 1) - 1
+	return f.Writers[n%uint64(len(f.Writers))].WriteBatch(ctx, batch)
+}
+
+var newline = []byte{'\n'}
+
+// Pipeline writes a Generator's points through a bounded queue: generator
+// goroutines encode batches and queue them, and sender goroutines write
+// them, so generation, encoding and sending overlap across cores. When the
+// queue is full, or the memory budget spent, generation waits for senders.
+//
+// A real-time Generator is instead paced to emit one step per interval.
+type Pipeline struct {
+	// Name identifies the pipeline's statement in events.
+	Name      string
+	Generator *Generator
+	Writer    BatchWriter
+
+	// BatchSize is the number of points per batch.
+	BatchSize int
+	// Concurrency is the number of sender goroutines.
+	Concurrency int
+	// Generators is the number of goroutines producing batches. It defaults
+	// to GOMAXPROCS.
+	Generators int
+	// QueueSize is the number of batches that may wait for a sender. It
+	// defaults to twice Concurrency.
+	QueueSize int
+	// Budget, if set, bounds the bytes held in queued batches.
+	Budget *MemoryBudget
+	// Cap, if set, stops generation once the run has generated as much as
+	// it allows.
+	Cap *WriteCap
+	// ErrorBudget, if set, counts every write sent.
+	ErrorBudget *ErrorBudget
+	// OnError, if set, is called with each failed write, which for an
+	// HTTPWriter carries the request's ID.
+	OnError func(error)
+	// Logger defaults to NopLogger.
+	Logger Logger
+	// Events, if set, records every batch sent and every failure.
+	Events *EventLog
+	// Capture, if set, keeps a sample of the batches sent.
+	Capture *Capturer
+	// Validate parses each batch as InfluxDB would before sending it. A
+	// batch that does not parse fails without being sent, so a template
+	// that generates bad line protocol is caught here rather than by the
+	// server.
+	Validate bool
+	// Clock paces the pipeline and times its writes. It defaults to
+	// SystemClock.
+	Clock Clock
+	// Sharded sends all the points of a series through one sender, in
+	// order: series keys are hashed to the Concurrency senders, each with
+	// a queue of its own. Batches are then generated by one goroutine.
+	Sharded bool
+	// ShardWriter, if set, returns the writer of each sender of a Sharded
+	// pipeline, such as one with a connection of its own. By default the
+	// senders share Writer.
+	ShardWriter func(shard int) BatchWriter
+
+	stats   PipelineStats
+	latency Histogram
+	limiter *rateLimiter
+	steps   []stepStats
+	mu      sync.Mutex
+	dropped map[string]int64
+	queue   chan *batch
+	pool    batchPool
+	// shards are the queues of a Sharded pipeline's senders, and pending
+	// the batches each is filling.
+	shards  []chan *batch
+	pending []*batch
+}
+
+// PipelineStats counts a pipeline's progress. Fields are updated atomically
+// while the pipeline runs; use Pipeline.Stats for a consistent copy.
+type PipelineStats struct {
+	Points  int64
+	Bytes   int64
+	Batches int64
+	Errors  int64
+	// Failed counts the points of batches that failed whole.
+	Failed int64
+	// QueueDepth is the number of batches waiting for a sender, and
+	// MaxQueueDepth the most seen waiting at once.
+	QueueDepth    int64
+	MaxQueueDepth int64
+	// Dropped counts points the server rejected from partial writes, by
+	// cause, such as DropFieldTypeConflict.
+	Dropped map[string]int64
+	// Protocol is the transport protocol the writer last used, if it
+	// reports one.
+	Protocol string
+	// Steps has the share of each step of a STEPS profile.
+	Steps []StepStats
+}
+
+// StepStats counts the batches sent during one step of a STEPS profile.
+// Rate is the step's target; the achieved rate is Points over the hold.
+type StepStats struct {
+	Rate    float64
+	Points  int64
+	Batches int64
+	Errors  int64
+	Latency LatencySummary
+}
+
+type stepStats struct {
+	points, batches, errors int64
+	latency                 Histogram
+}
+
+// Stats returns a snapshot of the pipeline's counters.
+func (p *Pipeline) Stats() PipelineStats {
+	s := PipelineStats{
+		Points:        atomic.LoadInt64(&p.stats.Points),
+		Bytes:         atomic.LoadInt64(&p.stats.Bytes),
+		Batches:       atomic.LoadInt64(&p.stats.Batches),
+		Errors:        atomic.LoadInt64(&p.stats.Errors),
+		Failed:        atomic.LoadInt64(&p.stats.Failed),
+		MaxQueueDepth: atomic.LoadInt64(&p.stats.MaxQueueDepth),
+	}
+	s.QueueDepth = p.queueDepth()
+
+	p.mu.Lock()
+	if len(p.dropped) > 0 {
+		s.Dropped = make(map[string]int64, len(p.dropped))
+		for k, v := range p.dropped {
+			s.Dropped[k] = v
+		}
+	}
+	p.mu.Unlock()
+
+	if w, ok := p.Writer.(interface{ Protocol() string }); ok {
+		s.Protocol = w.Protocol()
+	}
+
+	if steps, ok := p.Generator.Shape.(*Steps); ok && p.steps != nil {
+		for i := range p.steps {
+			st := &p.steps[i]
+			s.Steps = append(s.Steps, StepStats{
+				Rate:    steps.Rates[i],
+				Points:  atomic.LoadInt64(&st.points),
+				Batches: atomic.LoadInt64(&st.batches),
+				Errors:  atomic.LoadInt64(&st.errors),
+				Latency: st.latency.Summary(),
+			})
+		}
+	}
+	return s
+}
+
+// Latency summarizes the time taken to write each batch.
+func (p *Pipeline) Latency() LatencySummary {
+	return p.latency.Summary()
+}
+
+// Run writes all of the generator's points and returns when they have been
+// sent or ctx is done. Failed batches are counted in Stats rather than
+// stopping the run. When ctx is done, writes in flight are aborted and
+// queued batches dropped, neither counted as failures.
+func (p *Pipeline) Run(ctx context.Context) error {
+	if p.BatchSize <= 0 {
+		p.BatchSize = 5000
+	}
+	if p.Concurrency <= 0 {
+		p.Concurrency = 1
+	}
+	if p.Generators <= 0 {
+		p.Generators = runtime.GOMAXPROCS(0)
+	}
+	if p.QueueSize <= 0 {
+		p.QueueSize = 2 * p.Concurrency
+	}
+	if p.Logger == nil {
+		p.Logger = NopLogger
+	}
+	p.Clock = clockOrSystem(p.Clock)
+	if p.Sharded {
+		p.Generators = 1
+		p.shards = make([]chan *batch, p.Concurrency)
+		for i := range p.shards {
+			p.shards[i] = make(chan *batch, (p.QueueSize+p.Concurrency-1)/p.Concurrency)
+		}
+		p.pending = make([]*batch, p.Concurrency)
+	} else {
+		p.queue = make(chan *batch, p.QueueSize)
+	}
+	p.Logger.Debug("pipeline started", "points", p.Generator.Points, "batch_size", p.BatchSize,
+		"concurrency", p.Concurrency, "generators", p.Generators)
+
+	var gen sync.WaitGroup
+	if p.Generator.RealTime {
+		gen.Add(1)
+		go func() {
+			defer gen.Done()
+			p.pace(ctx)
+		}()
+	} else {
+		if p.Generator.Shape != nil {
+			p.limiter = newRateLimiter(p.Generator.Shape, p.Generator.RateUnit, p.Clock)
+		}
+		if steps, ok := p.Generator.Shape.(*Steps); ok {
+			p.steps = make([]stepStats, len(steps.Rates))
+		}
+		batches := ceilDiv(p.Generator.Points, int64(p.BatchSize))
+		var next int64
+
+		for i := 0; i < p.Generators; i++ {
+			gen.Add(1)
+			go func() {
+				defer gen.Done()
+				var e Encoder
+				for {
+					n := atomic.AddInt64(&next, 1) - 1
+					if n >= batches || ctx.Err() != nil {
+						return
+					}
+					start := n * int64(p.BatchSize)
+					if !p.produce(ctx, &e, start, start+int64(p.BatchSize), 0) {
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	var send sync.WaitGroup
+	for i := 0; i < p.Concurrency; i++ {
+		q, w := p.queue, p.Writer
+		if p.Sharded {
+			q = p.shards[i]
+			if p.ShardWriter != nil {
+				w = p.ShardWriter(i)
+			}
+		}
+		send.Add(1)
+		go func() {
+			defer send.Done()
+			for b := range q {
+				if ctx.Err() != nil {
+					// Drop what is still queued rather than fail it
+					// batch by batch.
+					p.discard(b)
+					continue
+				}
+				p.send(ctx, w, b)
+			}
+		}()
+	}
+
+	gen.Wait()
+	if p.Sharded {
+		p.flush(ctx)
+		for _, q := range p.shards {
+			close(q)
+		}
+	} else {
+		close(p.queue)
+	}
+	send.Wait()
+
+	s := p.Stats()
+	p.Logger.Debug("pipeline finished", "points", s.Points, "batches", s.Batches, "errors", s.Errors)
+	return ctx.Err()
+}
+
+// pace emits one time step of a real-time generator per interval, every
+// point in the step stamped with the time the step is emitted.
+func (p *Pipeline) pace(ctx context.Context) {
+	g := p.Generator
+	t := p.Clock.NewTicker(g.Interval)
+	defer t.Stop()
+	var e Encoder
+
+	for step := int64(0); step < g.Steps(); step++ {
+		if step > 0 {
+			select {
+			case <-t.C():
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		now := p.Clock.Now().UnixNano()
+		end := (step + 1) * g.Series
+		for start := step * g.Series; start < end; start += int64(p.BatchSize) {
+			n := start + int64(p.BatchSize)
+			if n > end {
+				n = end
+			}
+			if !p.produce(ctx, &e, start, n, now) {
+				return
+			}
+		}
+		// A step is due now, not once a shard's batch fills.
+		if p.Sharded && !p.flush(ctx) {
+			return
+		}
+	}
+}
+
+// produce generates points [start, end) as a batch with e and queues it,
+// reporting false if ctx ended first. A non-zero now stamps every point
+// with it.
+func (p *Pipeline) produce(ctx context.Context, e *Encoder, start, end, now int64) bool {
+	if p.Sharded {
+		return p.produceSharded(ctx, e, start, end, now)
+	}
+	if end > p.Generator.Points {
+		end = p.Generator.Points
+	}
+	if start >= end {
+		return true
+	}
+	b := p.pool.get()
+	e.buf = b.buf
+	for i := start; i < end; i++ {
+		if !p.Generator.Active(i) {
+			continue
+		}
+		n := len(e.buf)
+		if now != 0 {
+			p.Generator.EncodePointAt(e, i, now)
+		} else {
+			p.Generator.EncodePoint(e, i)
+		}
+		if len(e.buf) > n {
+			b.points++
+		}
+	}
+	// The batch's buffer is its own once queued.
+	b.buf, e.buf = e.buf, nil
+	if b.points == 0 {
+		p.pool.put(b)
+		return true
+	}
+	return p.enqueue(ctx, p.queue, b)
+}
+
+// produceSharded generates points [start, end) with e into the batches of
+// the shards their series hash to, queueing those that fill.
+func (p *Pipeline) produceSharded(ctx context.Context, e *Encoder, start, end, now int64) bool {
+	if end > p.Generator.Points {
+		end = p.Generator.Points
+	}
+	for i := start; i < end; i++ {
+		if !p.Generator.Active(i) {
+			continue
+		}
+		e.Reset()
+		if now != 0 {
+			p.Generator.EncodePointAt(e, i, now)
+		} else {
+			p.Generator.EncodePoint(e, i)
+		}
+		line := e.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		k := shardOf(seriesKey(line), len(p.shards))
+		b := p.pending[k]
+		if b == nil {
+			b = p.pool.get()
+			p.pending[k] = b
+		}
+		b.buf = append(b.buf, line...)
+		b.points++
+		if b.points >= int64(p.BatchSize) {
+			p.pending[k] = nil
+			if !p.enqueue(ctx, p.shards[k], b) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// flush queues the shards' partly filled batches.
+func (p *Pipeline) flush(ctx context.Context) bool {
+	for k, b := range p.pending {
+		if b == nil {
+			continue
+		}
+		p.pending[k] = nil
+		if ctx.Err() != nil {
+			p.pool.put(b)
+			continue
+		}
+		if !p.enqueue(ctx, p.shards[k], b) {
+			return false
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// shardOf hashes a series key to one of n shards with FNV-1a.
+func shardOf(key []byte, n int) int {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
+
+// enqueue queues a batch of points on q once the rate limit and memory
+// budget allow, reporting false if ctx ended first or the cap was reached.
+// The points are counted once queued; a batch not queued goes back to the
+// pool.
+func (p *Pipeline) enqueue(ctx context.Context, q chan *batch, b *batch) bool {
+	points, size := b.points, int64(cap(b.buf))
+	if !p.Cap.Take(points, int64(len(b.buf))) {
+		p.pool.put(b)
+		return false
+	}
+	if p.limiter != nil && !p.limiter.wait(ctx, points, int64(len(b.buf))) {
+		p.pool.put(b)
+		return false
+	}
+	if err := p.Budget.Acquire(ctx, size); err != nil {
+		p.pool.put(b)
+		return false
+	}
+
+	select {
+	case q <- b:
+	case <-ctx.Done():
+		p.Budget.Release(size)
+		p.pool.put(b)
+		return false
+	}
+	atomic.AddInt64(&p.stats.Points, points)
+
+	depth := p.queueDepth()
+	for {
+		max := atomic.LoadInt64(&p.stats.MaxQueueDepth)
+		if depth <= max || atomic.CompareAndSwapInt64(&p.stats.MaxQueueDepth, max, depth) {
+			break
+		}
+	}
+	return true
+}
+
+// queueDepth returns the number of batches waiting for a sender.
+func (p *Pipeline) queueDepth() int64 {
+	n := len(p.queue)
+	for _, q := range p.shards {
+		n += len(q)
+	}
+	return int64(n)
+}
+
+func (p *Pipeline) send(ctx context.Context, w BatchWriter, b *batch) {
+	start := p.Clock.Now()
+	var err error
+	if p.Validate {
+		err = validateBatch(b.buf)
+	}
+	if err == nil {
+		err = w.WriteBatch(ctx, b.buf)
+	}
+	took := p.Clock.Since(start)
+	if err != nil && ctx.Err() != nil {
+		// The write was aborted, not failed by the server.
+		p.discard(b)
+		return
+	}
+	p.latency.Record(took)
+	p.ErrorBudget.Record(err != nil)
+
+	if p.Capture.Sample() {
+		c := Capture{Time: start, Statement: p.Name, Kind: CaptureWrite, Body: string(b.buf)}
+		if err != nil {
+			c.Error = err.Error()
+			if we, ok := err.(*WriteError); ok {
+				c.RequestID = we.RequestID
+			}
+		}
+		p.Capture.Record(c)
+	}
+
+	if p.steps != nil {
+		st := &p.steps[p.Generator.Shape.(*Steps).StepAt(p.limiter.elapsed())]
+		atomic.AddInt64(&st.points, b.points)
+		atomic.AddInt64(&st.batches, 1)
+		if err != nil {
+			atomic.AddInt64(&st.errors, 1)
+		}
+		st.latency.Record(took)
+	}
+
+	if p.Events != nil {
+		e := Event{Time: p.Clock.Now(), Type: EventBatch, Statement: p.Name, Points: b.points, Bytes: int64(len(b.buf)), Latency: took}
+		if err != nil {
+			e.Type, e.Error = EventError, err.Error()
+			if we, ok := err.(*WriteError); ok {
+				e.RequestID = we.RequestID
+			}
+		}
+		p.Events.Emit(e)
+	}
+
+	if err != nil {
+		atomic.AddInt64(&p.stats.Errors, 1)
+		p.Logger.Warn("write failed", "err", err)
+		if p.OnError != nil {
+			p.OnError(err)
+		}
+		if we, ok := err.(*WriteError); !ok || !we.Partial {
+			atomic.AddInt64(&p.stats.Failed, b.points)
+		} else {
+			p.mu.Lock()
+			if p.dropped == nil {
+				p.dropped = map[string]int64{}
+			}
+			for cause, n := range we.Dropped {
+				p.dropped[cause] += n
+			}
+			p.mu.Unlock()
+		}
+	}
+	atomic.AddInt64(&p.stats.Batches, 1)
+	atomic.AddInt64(&p.stats.Bytes, int64(len(b.buf)))
+
+	p.Budget.Release(int64(cap(b.buf)))
+	p.pool.put(b)
+}
+
+// validateBatch parses b with InfluxDB's line protocol parser.
+func validateBatch(b []byte) error {
+	if _, err := models.ParsePoints(b); err != nil {
+		return fmt.Errorf("invalid line protocol: %v", err)
+	}
+	return nil
+}
+
+// discard drops a queued batch unsent, uncounting its points.
+func (p *Pipeline) discard(b *batch) {
+	atomic.AddInt64(&p.stats.Points, -b.points)
+	p.Budget.Release(int64(cap(b.buf)))
+	p.pool.put(b)
+}
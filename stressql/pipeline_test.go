@@ -0,0 +1,80 @@
+package stressql
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// compileInsert compiles an INSERT for the tests.
+func compileInsert(t testing.TB, src string) *Generator {
+	t.Helper()
+	s, err := ParseStatement(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := Compile(s.(*InsertStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// stallWriter fails its writes once ctx is done, after signaling the
+// first has started.
+type stallWriter struct{ started chan struct{} }
+
+func (w stallWriter) WriteBatch(ctx context.Context, b []byte) error {
+	select {
+	case w.started <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestPipelineUncountsBatchesNotQueued(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := stallWriter{started: make(chan struct{}, 1)}
+	p := &Pipeline{
+		Generator:   compileInsert(t, "INSERT cpu\ncpu,\nhost=[str rand(8) 100]\nv=[int rand(100) 0]\n10000 10s"),
+		Writer:      w,
+		BatchSize:   100,
+		Concurrency: 1,
+		QueueSize:   1,
+		Generators:  1,
+		// The first batch holds the whole budget, so the next waits for
+		// it in Acquire until the run is canceled.
+		Budget: NewMemoryBudget(1),
+	}
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx) }()
+	<-w.started
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+	if s := p.Stats(); s.Points != 0 || s.Batches != 0 {
+		t.Errorf("counted %d points in %d batches, want none: nothing was sent", s.Points, s.Batches)
+	}
+	if n := p.Budget.InUse(); n != 0 {
+		t.Errorf("%d bytes of the budget still held", n)
+	}
+}
+
+type nopWriter struct{}
+
+func (nopWriter) WriteBatch(context.Context, []byte) error { return nil }
+
+// BenchmarkPipeline reports the allocations of a run per point, which
+// pooled batches keep near zero however many points the run writes.
+func BenchmarkPipeline(b *testing.B) {
+	g := compileInsert(b, "INSERT cpu\ncpu,\nhost=[str rand(8) 100]\nv=[int rand(100) 0]\n100000 10s")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := &Pipeline{Generator: g, Writer: nopWriter{}, BatchSize: 5000, Concurrency: 2}
+		if err := p.Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(g.Points*int64(b.N))/b.Elapsed().Seconds(), "points/s")
+}
@@ -0,0 +1,31 @@
+package stressql
+
+import "sync"
+
+// A batch is a buffer of line protocol points to be sent together, with
+// the number of points in it.
+type batch struct {
+	buf    []byte
+	points int64
+}
+
+// batchPool reuses batches, and the buffers they hold, so generating a
+// run's points allocates as many buffers as are in flight at once rather
+// than one per batch. It pools pointers, so neither get nor put allocates.
+type batchPool struct {
+	p sync.Pool
+}
+
+// get returns an empty batch, reusing one put back if there is one.
+func (p *batchPool) get() *batch {
+	if b, ok := p.p.Get().(*batch); ok {
+		return b
+	}
+	return &batch{}
+}
+
+// put empties b, keeping its buffer, for get to return again.
+func (p *batchPool) put(b *batch) {
+	b.buf, b.points = b.buf[:0], 0
+	p.p.Put(b)
+}
@@ -0,0 +1,22 @@
+package stressql
+
+import "testing"
+
+func TestBatchPoolReuses(t *testing.T) {
+	var p batchPool
+	b := p.get()
+	b.buf, b.points = append(b.buf, "m v=1 0\n"...), 1
+	p.put(b)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		b := p.get()
+		if len(b.buf) != 0 || b.points != 0 {
+			t.Fatalf("get returned a batch holding %q and %d points", b.buf, b.points)
+		}
+		b.buf = append(b.buf, "m v=1 0\n"...)
+		p.put(b)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per batch, want 0", allocs)
+	}
+}
@@ -0,0 +1,259 @@
+package stressql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sources of a ServerProbe's metrics.
+const (
+	// ProbeDebugVars reads the server's /debug/vars.
+	ProbeDebugVars = "vars"
+	// ProbeInternal queries the server's _internal database, for servers
+	// that do not expose /debug/vars to the client.
+	ProbeInternal = "internal"
+)
+
+// ServerSample is the storage engine's state on one server at one time,
+// summed over its shards.
+type ServerSample struct {
+	Time    time.Time `json:"time"`
+	Address string    `json:"address"`
+	// DiskBytes is the size of the TSM files and the WAL.
+	DiskBytes int64 `json:"diskBytes"`
+	// CacheBytes is the size of the in-memory cache.
+	CacheBytes int64 `json:"cacheBytes"`
+	// Compactions counts the cache snapshots and TSM compactions completed
+	// since the server started.
+	Compactions int64  `json:"compactions"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ServerProbe samples the servers' own metrics while a run goes on, so
+// what the storage engine did can be read against the load that caused
+// it, in the run's result. It is set in the DSL with
+//
+//	SET probeInterval 10s
+//	SET probeSource internal
+//
+// probeSource is vars, the default, or internal.
+type ServerProbe struct {
+	Interval time.Duration
+	Source   string
+}
+
+// ServerProbeFromVars returns the probe set by SET variables, or nil if
+// none is.
+func ServerProbeFromVars(vars map[string]string) (*ServerProbe, error) {
+	d, err := durationVar(vars, "probeInterval", 0)
+	if err != nil || d == 0 {
+		return nil, err
+	}
+	p := &ServerProbe{Interval: d, Source: ProbeDebugVars}
+	switch s := vars["probeSource"]; s {
+	case "", ProbeDebugVars:
+	case ProbeInternal:
+		p.Source = s
+	default:
+		return nil, fmt.Errorf("invalid probeSource %q, expected %s or %s", s, ProbeDebugVars, ProbeInternal)
+	}
+	return p, nil
+}
+
+// run samples every server in env's addresses when it starts, every
+// Interval, and once more when stop is closed, and returns the samples.
+// A sample that fails is kept with its error; the run goes on.
+func (p *ServerProbe) run(env *ExecEnv, stop <-chan struct{}) []ServerSample {
+	var samples []ServerSample
+	sample := func() {
+		// A sample is taken after the run ends too, so is not bound by it.
+		ctx, cancel := context.WithTimeout(context.Background(), p.Interval+cleanupTimeout)
+		defer cancel()
+		for _, addr := range addresses(env.Vars) {
+			s := ServerSample{Time: env.Clock.Now(), Address: addr}
+			stats, err := p.stats(ctx, env, addr)
+			if err != nil {
+				s.Error = err.Error()
+				env.Logger.Warn("server probe failed", "address", addr, "err", err)
+			} else {
+				s.fill(stats)
+			}
+			samples = append(samples, s)
+		}
+	}
+
+	sample()
+	t := env.Clock.NewTicker(p.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			sample()
+		case <-stop:
+			sample()
+			return samples
+		}
+	}
+}
+
+// engineStats are the storage engine's statistics, by measurement, such
+// as tsm1_cache, then by field, summed over shards.
+type engineStats map[string]map[string]float64
+
+func (s engineStats) add(name, field string, v float64) {
+	if s[name] == nil {
+		s[name] = map[string]float64{}
+	}
+	s[name][field] += v
+}
+
+func (s *ServerSample) fill(stats engineStats) {
+	wal := stats["tsm1_wal"]
+	s.DiskBytes = int64(stats["tsm1_filestore"]["diskBytes"] + wal["currentSegmentDiskBytes"] + wal["oldSegmentsDiskBytes"])
+	s.CacheBytes = int64(stats["tsm1_cache"]["memBytes"])
+	for field, v := range stats["tsm1_engine"] {
+		// Counts of those completed; the others are Active, Err and
+		// Duration.
+		if strings.HasSuffix(field, "Compactions") {
+			s.Compactions += int64(v)
+		}
+	}
+}
+
+func (p *ServerProbe) stats(ctx context.Context, env *ExecEnv, addr string) (engineStats, error) {
+	if p.Source == ProbeInternal {
+		return internalStats(ctx, env, addr)
+	}
+	return debugVarsStats(ctx, env, addr)
+}
+
+// debugVarsStats reads /debug/vars, whose statistics are keyed by
+// measurement and tags, as in
+//
+//	"tsm1_cache:/var/lib/influxdb/data/db/autogen/1": {"name": "tsm1_cache", "tags": {...}, "values": {"memBytes": 1024, ...}}
+func debugVarsStats(ctx context.Context, env *ExecEnv, addr string) (engineStats, error) {
+	client, err := env.clientFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", serverURL(addr)+"/debug/vars", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range HeadersFromVars(env.Vars) {
+		req.Header[k] = v
+	}
+	if u := env.Vars["username"]; u != "" {
+		req.SetBasicAuth(u, env.Vars["password"])
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("/debug/vars: %s", resp.Status)
+	}
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(body, &vars); err != nil {
+		return nil, fmt.Errorf("/debug/vars: %v", err)
+	}
+	stats := engineStats{}
+	for _, raw := range vars {
+		var v struct {
+			Name   string                 `json:"name"`
+			Values map[string]json.Number `json:"values"`
+		}
+		// Entries such as memstats have another shape.
+		if json.Unmarshal(raw, &v) != nil || !strings.HasPrefix(v.Name, "tsm1_") {
+			continue
+		}
+		for field, n := range v.Values {
+			if f, err := n.Float64(); err == nil {
+				stats.add(v.Name, field, f)
+			}
+		}
+	}
+	return stats, nil
+}
+
+// internalMeasurements are the _internal measurements internalStats
+// queries.
+var internalMeasurements = []string{"tsm1_filestore", "tsm1_wal", "tsm1_cache", "tsm1_engine"}
+
+// internalStats queries the latest statistics of each shard in _internal,
+// which the server records every 10 seconds by default.
+func internalStats(ctx context.Context, env *ExecEnv, addr string) (engineStats, error) {
+	stats := engineStats{}
+	for _, m := range internalMeasurements {
+		q := fmt.Sprintf(`SELECT sum(*) FROM (SELECT last(*) FROM "_internal"."monitor".%s WHERE time > now() - 1m GROUP BY *)`, quoteIdent(m))
+		body, _, err := env.request(ctx, addr, "GET", q)
+		if err != nil {
+			return nil, err
+		}
+		var r struct {
+			Results []struct {
+				Series []struct {
+					Columns []string        `json:"columns"`
+					Values  [][]interface{} `json:"values"`
+				} `json:"series"`
+			} `json:"results"`
+		}
+		if err := json.Unmarshal(body, &r); err != nil {
+			return nil, fmt.Errorf("_internal: %v", err)
+		}
+		for _, res := range r.Results {
+			for _, s := range res.Series {
+				for _, row := range s.Values {
+					for i, c := range s.Columns {
+						if i >= len(row) {
+							break
+						}
+						f, ok := row[i].(float64)
+						if !ok {
+							continue
+						}
+						// Columns are named sum_last_<field>.
+						stats.add(m, strings.TrimPrefix(c, "sum_last_"), f)
+					}
+				}
+			}
+		}
+	}
+	return stats, nil
+}
+
+// Amplification returns the bytes the servers' disk usage grew by over
+// the run, from the first to the last good sample of each, per byte of
+// line protocol the run's INSERTs sent, or 0 if it cannot tell.
+func Amplification(samples []ServerSample, sent int64) float64 {
+	first, last := map[string]ServerSample{}, map[string]ServerSample{}
+	for _, s := range samples {
+		if s.Error != "" {
+			continue
+		}
+		if _, ok := first[s.Address]; !ok {
+			first[s.Address] = s
+		}
+		last[s.Address] = s
+	}
+	var grown int64
+	for addr, f := range first {
+		grown += last[addr].DiskBytes - f.DiskBytes
+	}
+	if sent <= 0 || len(first) == 0 {
+		return 0
+	}
+	return float64(grown) / float64(sent)
+}
@@ -0,0 +1,76 @@
+package stressql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Profiles returns the names of the profiles seq has PROFILE sections for,
+// in the order they first appear.
+func Profiles(seq []Statement) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, s := range seq {
+		if p, ok := s.(*ProfileStatement); ok && !seen[p.Name] {
+			seen[p.Name] = true
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// SelectProfile returns seq with the PROFILE sections of the named profile
+// in place of their markers, and those of any other profile removed. A
+// profile may have several sections, so statements it shares with others
+// need be written once. A config with profiles must have one selected;
+// one without them must not.
+func SelectProfile(seq []Statement, name string) ([]Statement, error) {
+	selected := []Statement{}
+	var in *ProfileStatement
+	for _, s := range seq {
+		switch s := s.(type) {
+		case *ProfileStatement:
+			if in != nil {
+				return nil, located(&s.Pos, fmt.Errorf("PROFILE %s inside PROFILE %s", s.Name, in.Name))
+			}
+			in = s
+		case *EndStatement:
+			if in == nil {
+				return nil, located(&s.Pos, fmt.Errorf("END outside a PROFILE"))
+			}
+			in = nil
+		default:
+			if in == nil || in.Name == name {
+				selected = append(selected, s)
+			}
+		}
+	}
+	if in != nil {
+		return nil, located(&in.Pos, fmt.Errorf("PROFILE %s has no END", in.Name))
+	}
+
+	names := Profiles(seq)
+	switch {
+	case len(names) == 0 && name != "":
+		return nil, fmt.Errorf("profile %q: the config has no profiles", name)
+	case len(names) > 0 && name == "":
+		return nil, fmt.Errorf("no profile selected; the config has %s", strings.Join(names, ", "))
+	}
+	for _, n := range names {
+		if n == name {
+			return selected, nil
+		}
+	}
+	if len(names) > 0 {
+		return nil, fmt.Errorf("unknown profile %q; the config has %s", name, strings.Join(names, ", "))
+	}
+	return selected, nil
+}
+
+// located prefixes err with the location of p, if it has one.
+func located(p *Pos, err error) error {
+	if loc := p.Location(); loc != "" {
+		return fmt.Errorf("%s: %v", loc, err)
+	}
+	return err
+}
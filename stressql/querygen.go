@@ -0,0 +1,190 @@
+package stressql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A QueryGenerator produces the queries of a QUERY statement. Template
+// variables written "%t host" are replaced by values of the host tag that
+// the INSERT statement's Generator writes, all from one series picked at
+// random per query, so queries hit series that exist. Written "%t host MISS", they are replaced
+// by values it never writes, to measure queries for missing series. With
+// SET queryTags server, values are instead those the server already holds;
+// see serverTagValues. Other variables take fixed values.
+//
+// Like a Generator, query i is a pure function of i.
+type QueryGenerator struct {
+	Name string
+	// Fanout is the number of queries issued at once, 1 unless set by
+	// FANOUT.
+	Fanout int
+	parts  []func(b []byte, i uint64) []byte
+}
+
+// TagValues returns, for a tag key, a function appending the value of
+// the tag picked by r that "%t key" variables are replaced by.
+type TagValues func(key string) (func(b []byte, r uint64) []byte, error)
+
+// CompileQuery builds a QueryGenerator for stmt. g is the Generator of the
+// INSERT whose tags "%t key" variables draw from, and may be nil if there
+// are none; args supplies the values of other variables, keyed as written,
+// such as "%f".
+func CompileQuery(stmt *QueryStatement, g *Generator, args map[string]string) (*QueryGenerator, error) {
+	var tags TagValues
+	if g != nil {
+		tags = g.tagValues
+	}
+	return CompileQueryTags(stmt, tags, args)
+}
+
+// CompileQueryTags builds a QueryGenerator for stmt whose "%t key"
+// variables draw from tags, which may be nil if there are none.
+func CompileQueryTags(stmt *QueryStatement, tags TagValues, args map[string]string) (*QueryGenerator, error) {
+	lits := strings.Split(stmt.TemplateString, "%v")
+	if len(lits) != len(stmt.Args)+1 {
+		return nil, fmt.Errorf("query %q: %d arguments for %d placeholders", stmt.Name, len(stmt.Args), len(lits)-1)
+	}
+
+	// Leading space comes from the newline after the statement name.
+	lits[0] = strings.TrimLeft(lits[0], " \n")
+
+	q := &QueryGenerator{Name: stmt.Name, Fanout: 1}
+	if stmt.Fanout != "" {
+		n, err := strconv.Atoi(stmt.Fanout)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("query %q: invalid FANOUT %q", stmt.Name, stmt.Fanout)
+		}
+		q.Fanout = n
+	}
+
+	for n, arg := range stmt.Args {
+		if lit := lits[n]; lit != "" {
+			q.parts = append(q.parts, func(b []byte, _ uint64) []byte { return append(b, lit...) })
+		}
+
+		key, miss, ok := parseTagArg(arg)
+		if !ok {
+			v, ok := args[arg]
+			if !ok {
+				return nil, fmt.Errorf("query %q: no value for %s", stmt.Name, arg)
+			}
+			q.parts = append(q.parts, func(b []byte, _ uint64) []byte { return append(b, v...) })
+			continue
+		}
+
+		if tags == nil {
+			return nil, fmt.Errorf("query %q: no INSERT %q for %s", stmt.Name, stmt.Name, arg)
+		}
+		tag, err := tags(key)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %v", stmt.Name, err)
+		}
+		q.parts = append(q.parts, func(b []byte, i uint64) []byte {
+			n := len(b)
+			b = tag(b, mix(querySalt^i))
+			if miss {
+				b = append(b, missSuffix...)
+			}
+			return escapeFrom(b, n, queryStringEscapes)
+		})
+	}
+	if lit := strings.TrimRight(lits[len(lits)-1], " \n"); lit != "" {
+		q.parts = append(q.parts, func(b []byte, _ uint64) []byte { return append(b, lit...) })
+	}
+	return q, nil
+}
+
+// AppendQuery appends query i to b.
+func (q *QueryGenerator) AppendQuery(b []byte, i int64) []byte {
+	for _, p := range q.parts {
+		b = p(b, uint64(i))
+	}
+	return b
+}
+
+// AppendRound appends the Fanout queries of round r to qs, each with its
+// own arguments: queries r*Fanout through (r+1)*Fanout-1.
+func (q *QueryGenerator) AppendRound(qs [][]byte, r int64) [][]byte {
+	for j := 0; j < q.Fanout; j++ {
+		qs = append(qs, q.AppendQuery(nil, r*int64(q.Fanout)+int64(j)))
+	}
+	return qs
+}
+
+// parseTagArg splits a "%t key [MISS]" template variable.
+func parseTagArg(arg string) (key string, miss bool, ok bool) {
+	f := strings.Fields(arg)
+	if len(f) < 2 || f[0] != "%t" {
+		return "", false, false
+	}
+	return f[1], len(f) == 3 && strings.EqualFold(f[2], "miss"), true
+}
+
+// missSuffix is appended to a tag value to make one the Generator never
+// writes.
+const missSuffix = "-miss"
+
+const querySalt = 0x7175657279
+
+// queryStringEscapes are escaped in single-quoted InfluxQL strings.
+var queryStringEscapes = newEscapes(`'\`)
+
+// tagValues returns a function appending the value of the tag key that g
+// writes for the series, or point, chosen by r.
+func (g *Generator) tagValues(key string) (func(b []byte, r uint64) []byte, error) {
+	if t, ok := g.tags[key]; ok {
+		series := uint64(g.Series)
+		return func(b []byte, r uint64) []byte { return t.appendValue(b, r%series) }, nil
+	}
+	if t, ok := g.pointTags[key]; ok {
+		points := uint64(g.Points)
+		return func(b []byte, r uint64) []byte {
+			return t.tag.appendValue(append(b, t.prefix...), t.tag.index(r%points))
+		}, nil
+	}
+
+	// A tag with a fixed value has it in every series key.
+	var k []byte
+	for _, p := range g.key {
+		k = p(k, 0, 0)
+	}
+	for _, kv := range splitUnescaped(string(k), ',')[1:] {
+		if eq := strings.IndexByte(kv, '='); eq > 0 && unescapeKey(kv[:eq]) == key {
+			v := unescapeKey(kv[eq+1:])
+			return func(b []byte, _ uint64) []byte { return append(b, v...) }, nil
+		}
+	}
+	return nil, fmt.Errorf("INSERT %q writes no tag %q", g.Name, key)
+}
+
+// splitUnescaped splits s at each sep not escaped by a backslash.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeKey(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,151 @@
+package stressql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// InsertEstimate is what an INSERT is expected to write, worked out from
+// its Generator before it runs.
+type InsertEstimate struct {
+	// Points are those Active, counted, or if there are too many to count
+	// quickly, estimated from a sample, when Sampled is set.
+	Points  int64 `json:"points"`
+	Sampled bool  `json:"sampled,omitempty"`
+	// Series are those active by the last step. The client does not count
+	// the series it writes, so they are not reconciled.
+	Series int64 `json:"series"`
+	// Bytes is Points times the average size of a sample of them.
+	Bytes int64 `json:"bytes"`
+}
+
+// Discrepancy kinds.
+const (
+	// DiscrepancyUnsent is fewer points sent than estimated, or more: the
+	// run was stopped, or the client generated what it should not have.
+	DiscrepancyUnsent = "unsent"
+	// DiscrepancyRejected is points sent that the server did not accept.
+	DiscrepancyRejected = "rejected"
+	// DiscrepancyBytes is points sent larger or smaller than estimated.
+	DiscrepancyBytes = "bytes"
+)
+
+// Discrepancy is a difference between an INSERT's estimate and what it
+// did.
+type Discrepancy struct {
+	Kind     string `json:"kind"`
+	Expected int64  `json:"expected"`
+	Actual   int64  `json:"actual"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+const (
+	// maxCountedPoints bounds the points Estimate checks one by one.
+	maxCountedPoints = 1 << 22
+	// estimateSample is the number of points Estimate sizes.
+	estimateSample = 1000
+	// sampledTolerance is the fraction by which the points sent may differ
+	// from a sampled estimate, and bytesTolerance that by which their
+	// bytes may differ from the estimate.
+	sampledTolerance = 0.01
+	bytesTolerance   = 0.10
+)
+
+// Estimate returns what g is expected to write. It must be called once g
+// is set up as it will run, as points it skips, and how they are written,
+// change what it writes.
+func (g *Generator) Estimate() InsertEstimate {
+	e := InsertEstimate{Points: g.Points, Series: g.Series}
+	if g.GrowBy > 0 {
+		elapsed := float64(g.Steps()-1) * float64(g.Interval) / float64(g.GrowEvery)
+		if n := 1 + int64(elapsed*float64(g.GrowBy)); n < e.Series {
+			e.Series = n
+		}
+	}
+
+	stride := int64(1)
+	if g.Points > estimateSample {
+		stride = g.Points / estimateSample
+	}
+	var line []byte
+	var size, sized int64
+	for i := int64(0); i < g.Points; i += stride {
+		if line = g.AppendPoint(line[:0], i); len(line) > 0 {
+			size += int64(len(line))
+			sized++
+		}
+	}
+
+	if g.GrowBy > 0 || len(g.anomalies) > 0 {
+		if g.Points <= maxCountedPoints {
+			e.Points = 0
+			for i := int64(0); i < g.Points; i++ {
+				if g.Active(i) {
+					e.Points++
+				}
+			}
+		} else {
+			e.Points = int64(float64(g.Points) * float64(sized) / float64(ceilDiv(g.Points, stride)))
+			e.Sampled = true
+		}
+	}
+	if sized > 0 {
+		e.Bytes = int64(float64(e.Points) * float64(size) / float64(sized))
+	}
+	return e
+}
+
+// Reconcile compares what the INSERT did with its Estimate, returning
+// where they differ. It returns nil if r has no Estimate.
+func (r *InsertResult) Reconcile() []Discrepancy {
+	e := r.Estimate
+	if e == nil {
+		return nil
+	}
+	var ds []Discrepancy
+
+	unsent := r.Points != e.Points
+	if e.Sampled {
+		unsent = float64(abs(r.Points-e.Points)) > sampledTolerance*float64(e.Points)
+	}
+	if unsent {
+		d := Discrepancy{Kind: DiscrepancyUnsent, Expected: e.Points, Actual: r.Points}
+		if r.Points < e.Points {
+			d.Detail = fmt.Sprintf("%d points not sent", e.Points-r.Points)
+		}
+		ds = append(ds, d)
+	}
+
+	if r.Accepted < r.Points {
+		var causes []string
+		for cause, n := range r.Dropped {
+			causes = append(causes, fmt.Sprintf("%d %s", n, cause))
+		}
+		sort.Strings(causes)
+		if r.Failed > 0 {
+			causes = append(causes, fmt.Sprintf("%d in failed batches", r.Failed))
+		}
+		ds = append(ds, Discrepancy{Kind: DiscrepancyRejected, Expected: r.Points, Actual: r.Accepted,
+			Detail: strings.Join(causes, ", ")})
+	}
+
+	// Bytes are compared for the points sent, so a run stopped early
+	// differs only in its points.
+	if r.Points > 0 && e.Points > 0 {
+		want := int64(float64(e.Bytes) * float64(r.Points) / float64(e.Points))
+		if float64(abs(r.Bytes-want)) > bytesTolerance*float64(want) {
+			ds = append(ds, Discrepancy{Kind: DiscrepancyBytes, Expected: want, Actual: r.Bytes,
+				Detail: fmt.Sprintf("%.1f bytes per point, estimated %.1f",
+					float64(r.Bytes)/float64(r.Points), float64(e.Bytes)/float64(e.Points))})
+		}
+	}
+	return ds
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,175 @@
+package stressql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ReplayOptions controls how recorded points are shifted in time.
+type ReplayOptions struct {
+	// Base is the new time of the first point read. It defaults to now.
+	Base time.Time
+	// Speed compresses time: at 24, a day of data is written over an hour
+	// of timestamps. It defaults to 1.
+	Speed float64
+	// RealTime paces writes so each point is sent when the wall clock
+	// reaches its shifted timestamp. It assumes the input is in time order.
+	RealTime bool
+	// Clock is the wall clock. It defaults to SystemClock.
+	Clock Clock
+}
+
+// Replayer reads line protocol, or the output of influx_inspect export, and
+// re-emits each point with its timestamp shifted relative to the first
+// point read.
+type Replayer struct {
+	// Database and RetentionPolicy are the last export context seen.
+	Database        string
+	RetentionPolicy string
+
+	r      *bufio.Reader
+	opts   ReplayOptions
+	base   int64
+	origin int64
+	start  bool
+}
+
+// NewReplayer returns a Replayer reading from r.
+func NewReplayer(r io.Reader, opts ReplayOptions) *Replayer {
+	opts.Clock = clockOrSystem(opts.Clock)
+	if opts.Base.IsZero() {
+		opts.Base = opts.Clock.Now()
+	}
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	return &Replayer{r: bufio.NewReaderSize(r, 64<<10), opts: opts, base: opts.Base.UnixNano()}
+}
+
+var (
+	contextDatabase        = []byte("# CONTEXT-DATABASE:")
+	contextRetentionPolicy = []byte("# CONTEXT-RETENTION-POLICY:")
+)
+
+// Next appends the next point to b, its timestamp shifted, and returns the
+// shifted timestamp. It returns io.EOF when the input is exhausted.
+func (p *Replayer) Next(b []byte) ([]byte, int64, error) {
+	for {
+		line, err := p.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			rest, err2 := p.r.ReadBytes('\n')
+			line, err = append(append([]byte(nil), line...), rest...), err2
+		}
+		if len(line) == 0 && err != nil {
+			return b, 0, err
+		}
+		line = bytes.TrimSpace(line)
+
+		switch {
+		case len(line) == 0:
+			continue
+		case bytes.HasPrefix(line, contextDatabase):
+			p.Database = string(bytes.TrimSpace(line[len(contextDatabase):]))
+			continue
+		case bytes.HasPrefix(line, contextRetentionPolicy):
+			p.RetentionPolicy = string(bytes.TrimSpace(line[len(contextRetentionPolicy):]))
+			continue
+		case line[0] == '#', isDDL(line):
+			continue
+		}
+
+		head, ts, ok := splitTimestamp(line)
+		if !ok {
+			// Points without a timestamp are written at the base time.
+			head, ts = line, p.origin
+		}
+		if !p.start {
+			p.origin, p.start = ts, true
+		}
+
+		shifted := p.base + int64(float64(ts-p.origin)/p.opts.Speed)
+		b = append(b, head...)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, shifted, 10)
+		return append(b, '\n'), shifted, nil
+	}
+}
+
+// isDDL reports whether line is one of the statements an export writes
+// before its data.
+func isDDL(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("CREATE ")) || bytes.HasPrefix(line, []byte("DROP "))
+}
+
+// splitTimestamp splits a trailing integer timestamp from a line.
+func splitTimestamp(line []byte) ([]byte, int64, bool) {
+	i := bytes.LastIndexByte(line, ' ')
+	if i < 0 {
+		return line, 0, false
+	}
+	ts, err := strconv.ParseInt(string(line[i+1:]), 10, 64)
+	if err != nil {
+		return line, 0, false
+	}
+	return line[:i], ts, true
+}
+
+// WriteTo replays every point to w in batches of batchSize, returning the
+// number of points written. Batches that fail are counted in errs and the
+// replay continues.
+func (p *Replayer) WriteTo(ctx context.Context, w BatchWriter, batchSize int) (points, errs int64, err error) {
+	if batchSize <= 0 {
+		batchSize = 5000
+	}
+	wall := p.opts.Clock.Now()
+
+	buf := make([]byte, 0, 1<<20)
+	n := 0
+	flush := func() {
+		if n == 0 {
+			return
+		}
+		if err := w.WriteBatch(ctx, buf); err != nil {
+			errs++
+		}
+		points += int64(n)
+		buf, n = buf[:0], 0
+	}
+
+	for ctx.Err() == nil {
+		var ts int64
+		var err error
+		mark := len(buf)
+		buf, ts, err = p.Next(buf)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return points, errs, err
+		}
+
+		if p.opts.RealTime {
+			if d := time.Duration(ts-p.base) - p.opts.Clock.Since(wall); d > 0 {
+				// Send what is due before waiting for this point.
+				line := append([]byte(nil), buf[mark:]...)
+				buf = buf[:mark]
+				flush()
+				if !sleep(ctx, p.opts.Clock, d) {
+					return points, errs, ctx.Err()
+				}
+				buf = append(buf, line...)
+			}
+		}
+
+		n++
+		if n >= batchSize {
+			flush()
+		}
+	}
+	flush()
+
+	return points, errs, ctx.Err()
+}
@@ -0,0 +1,181 @@
+package stressql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Run statistics are reported to the reporting database under these
+// measurements, tagged with the statement name and the run's metadata.
+// Counters are cumulative over the statement; latency percentiles are in
+// nanoseconds over the statement so far.
+const (
+	ReportWriteMeasurement = "stress_write"
+	ReportQueryMeasurement = "stress_query"
+	ReportStatementTag     = "statement"
+	ReportPhaseTag         = "phase"
+
+	ReportPointsField     = "points"
+	ReportBytesField      = "bytes"
+	ReportRequestsField   = "requests"
+	ReportErrorsField     = "errors"
+	ReportViolationsField = "violations"
+	ReportP50Field        = "p50"
+	ReportP99Field        = "p99"
+)
+
+// MetadataVarPrefix marks SET variables that stamp the run with metadata,
+// as in
+//
+//	SET meta:version 1.8.10
+//	SET meta:scenario "ingest, 8 writers"
+//
+// so results can be grouped by server version or hardware profile.
+const MetadataVarPrefix = "meta:"
+
+// MetadataFromVars returns the metadata set by SET variables, or nil if none.
+func MetadataFromVars(vars map[string]string) map[string]string {
+	var m map[string]string
+	for k, v := range vars {
+		if !strings.HasPrefix(k, MetadataVarPrefix) || v == "" {
+			continue
+		}
+		if m == nil {
+			m = map[string]string{}
+		}
+		m[strings.TrimPrefix(k, MetadataVarPrefix)] = v
+	}
+	return m
+}
+
+// EncodeReport encodes a report point for each statement of r at ts.
+func EncodeReport(e *Encoder, r *RunResult, ts int64) {
+	var tags [][2]string
+	for _, s := range r.Statements {
+		tags = tags[:0]
+		for k, v := range r.Metadata {
+			if k != ReportStatementTag && k != ReportPhaseTag {
+				tags = append(tags, [2]string{k, v})
+			}
+		}
+		tags = append(tags, [2]string{ReportStatementTag, s.Name})
+		if s.Phase != "" {
+			tags = append(tags, [2]string{ReportPhaseTag, s.Phase})
+		}
+		// Tags in key order are what the server would sort them to.
+		sort.Slice(tags, func(i, j int) bool { return tags[i][0] < tags[j][0] })
+
+		m := ReportWriteMeasurement
+		if s.Kind == KindQuery {
+			m = ReportQueryMeasurement
+		}
+		e.StartPoint(m)
+		for _, t := range tags {
+			e.Tag(t[0], t[1])
+		}
+		if s.Kind != KindQuery {
+			e.Int(ReportPointsField, s.Points)
+		} else {
+			e.Int(ReportViolationsField, s.Violations)
+		}
+		e.Int(ReportBytesField, s.Bytes)
+		e.Int(ReportRequestsField, s.Requests)
+		e.Int(ReportErrorsField, s.Errors)
+		e.Int(ReportP50Field, int64(s.Latency.P50))
+		e.Int(ReportP99Field, int64(s.Latency.P99))
+		e.EndPoint(ts)
+	}
+}
+
+// DefaultReportInterval is how often a Reporter reports unless SET
+// reportInterval says otherwise.
+const DefaultReportInterval = 10 * time.Second
+
+// Reporter writes each statement's statistics, as EncodeReport encodes
+// them, to a reporting database every report interval while a run goes
+// on, and once more as it ends. It is set in the DSL with
+//
+//	SET reportDatabase stress_results
+//	SET reportAddress "metrics:8086"
+//	SET reportInterval 10s
+//
+// reportAddress defaults to the first of SET addresses. A run writing to
+// a Sink reports to the sink.
+type Reporter struct {
+	Database string
+	Address  string
+	Interval time.Duration
+}
+
+// ReporterFromVars returns the Reporter set by SET variables, or nil if
+// none is.
+func ReporterFromVars(vars map[string]string) (*Reporter, error) {
+	db := vars["reportDatabase"]
+	if db == "" {
+		return nil, nil
+	}
+	d, err := durationVar(vars, "reportInterval", DefaultReportInterval)
+	if err != nil {
+		return nil, err
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("invalid reportInterval %q", vars["reportInterval"])
+	}
+	addr := vars["reportAddress"]
+	if addr == "" {
+		addr = addresses(vars)[0]
+	}
+	return &Reporter{Database: db, Address: addr, Interval: d}, nil
+}
+
+// run reports when every Interval passes and once more when stop is
+// closed. A report that fails is logged; the run goes on.
+func (r *Reporter) run(env *ExecEnv, stop <-chan struct{}) {
+	var w BatchWriter = &sinkWriter{sink: env.Sink, db: r.Database}
+	if env.Sink == nil {
+		client, err := env.clientFor(r.Address)
+		if err != nil {
+			env.Logger.Warn("reporting disabled", "err", err)
+			return
+		}
+		w = &HTTPWriter{
+			Addr:     r.Address,
+			Database: r.Database,
+			Username: env.Vars["username"],
+			Password: env.Vars["password"],
+			Client:   client,
+			Header:   HeadersFromVars(env.Vars),
+		}
+	}
+
+	e := NewEncoder(4096)
+	report := func() {
+		e.Reset()
+		EncodeReport(e, env.progress(), env.Clock.Now().UnixNano())
+		if e.Len() == 0 {
+			return
+		}
+		// The last report is made after the run ends, so is not bound by
+		// it.
+		ctx, cancel := context.WithTimeout(context.Background(), r.Interval+cleanupTimeout)
+		defer cancel()
+		if err := w.WriteBatch(ctx, e.Bytes()); err != nil {
+			env.Logger.Warn("report failed", "database", r.Database, "err", err)
+		}
+	}
+
+	t := env.Clock.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			report()
+		case <-stop:
+			report()
+			return
+		}
+	}
+}
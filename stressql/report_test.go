@@ -0,0 +1,47 @@
+package stressql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReporterStampsMetadata(t *testing.T) {
+	sink := &MemorySink{}
+	_, err := runConfig(t, sink, nil,
+		`SET meta:version "1.8.10"`,
+		`SET meta:scenario ingest`,
+		`SET reportDatabase stress`,
+		"INSERT cpu\ncpu,\nhost=[str rand(8) 10]\nv=[int rand(100) 0]\n100 10s",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := linesTo(sink, "stress")
+	if len(lines) == 0 {
+		t.Fatal("nothing reported")
+	}
+	// The last report is made once the INSERT has finished.
+	last := lines[len(lines)-1]
+	if key, want := string(seriesKey([]byte(last))), "stress_write,scenario=ingest,statement=cpu,version=1.8.10"; key != want {
+		t.Errorf("reported series %q, want %q", key, want)
+	}
+	if !strings.Contains(last, " points=100i,") || !strings.Contains(last, ",errors=0i,") {
+		t.Errorf("report %q does not count 100 points without errors", last)
+	}
+}
+
+func TestReporterFromVars(t *testing.T) {
+	r, err := ReporterFromVars(map[string]string{"reportDatabase": "stress", "addresses": "a:8086,b:8086"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Address != "a:8086" || r.Interval != DefaultReportInterval {
+		t.Errorf("got %+v, want the first address every %v", r, DefaultReportInterval)
+	}
+	if r, err := ReporterFromVars(map[string]string{"reportInterval": "1s"}); r != nil || err != nil {
+		t.Errorf("got %+v, %v without reportDatabase, want nothing", r, err)
+	}
+	if _, err := ReporterFromVars(map[string]string{"reportDatabase": "stress", "reportInterval": "0s"}); err == nil {
+		t.Error("reportInterval 0s accepted")
+	}
+}
@@ -0,0 +1,157 @@
+package stressql
+
+import (
+	"sync"
+	"time"
+)
+
+// RunResult summarizes a run: what each statement did and whether the
+// config's SLOs held. Metadata, such as the server version, is set with
+// SET meta:<key> and stamped on every reported point.
+type RunResult struct {
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Seed seeded the run's random choices; see WithSeed.
+	Seed int64 `json:"seed"`
+	// Status is StatusSuccess, or why the run failed, and Reason the
+	// failure's message.
+	Status     string            `json:"status,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
+	Statements []StatementResult `json:"statements"`
+	SLOs       []SLOResult       `json:"slos,omitempty"`
+	// Intervals is each statement's activity per report interval, in time
+	// order.
+	Intervals []IntervalResult `json:"intervals,omitempty"`
+	// Inserts, Queries and Execs are what each INSERT, QUERY and EXEC did,
+	// by name, or by script for an EXEC. Of statements sharing a name, the
+	// last to finish is kept; Statements has every one.
+	Inserts map[string]*InsertResult `json:"inserts,omitempty"`
+	Queries map[string]*QueryResult  `json:"queries,omitempty"`
+	Execs   map[string]*ExecResult   `json:"execs,omitempty"`
+	// Incomplete are the statements a DEADLINE stopped or kept from
+	// starting, in config order.
+	Incomplete []string `json:"incomplete,omitempty"`
+	// Server is what SET probeInterval sampled of the servers' storage
+	// engines over the run, and Amplification their disk growth per byte
+	// the INSERTs sent.
+	Server        []ServerSample `json:"server,omitempty"`
+	Amplification float64        `json:"amplification,omitempty"`
+}
+
+// MaxResultErrors is the number of errors kept in a statement's result.
+const MaxResultErrors = 10
+
+// InsertResult is what an INSERT did. Errors counts failed batches, the
+// first MaxResultErrors of which are in FirstErrors.
+//
+// Of the Points sent, the server accepted Accepted: not those of batches
+// that Failed whole, nor those Dropped from partial writes. Estimate is
+// what the INSERT was expected to write, and Discrepancies where it did
+// otherwise.
+type InsertResult struct {
+	// Start is the time the INSERT's points were stamped from; see
+	// WithStarts.
+	Start         time.Time        `json:"start"`
+	Points        int64            `json:"points"`
+	Accepted      int64            `json:"accepted"`
+	Failed        int64            `json:"failed,omitempty"`
+	Dropped       map[string]int64 `json:"dropped,omitempty"`
+	Batches       int64            `json:"batches"`
+	Errors        int64            `json:"errors"`
+	Bytes         int64            `json:"bytes"`
+	Duration      time.Duration    `json:"duration"`
+	Latency       LatencySummary   `json:"latency"`
+	FirstErrors   []string         `json:"firstErrors,omitempty"`
+	Estimate      *InsertEstimate  `json:"estimate,omitempty"`
+	Discrepancies []Discrepancy    `json:"discrepancies,omitempty"`
+}
+
+// QueryResult is what a QUERY did. Bytes counts response bodies, and
+// Violations responses that failed its EXPECT clauses.
+type QueryResult struct {
+	Queries     int64          `json:"queries"`
+	Errors      int64          `json:"errors"`
+	Violations  int64          `json:"violations,omitempty"`
+	Bytes       int64          `json:"bytes"`
+	Duration    time.Duration  `json:"duration"`
+	Latency     LatencySummary `json:"latency"`
+	FirstErrors []string       `json:"firstErrors,omitempty"`
+}
+
+// ExecResult is what an EXEC did. ExitCode is -1 if the script did not
+// start or was killed.
+type ExecResult struct {
+	ExitCode int           `json:"exitCode"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// errorSample keeps the first MaxResultErrors errors added to it. It is
+// safe for concurrent use.
+type errorSample struct {
+	mu   sync.Mutex
+	errs []string
+}
+
+func (s *errorSample) add(err error) {
+	s.mu.Lock()
+	if len(s.errs) < MaxResultErrors {
+		s.errs = append(s.errs, err.Error())
+	}
+	s.mu.Unlock()
+}
+
+func (s *errorSample) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.errs...)
+}
+
+// StatementResult is one INSERT's or QUERY's share of a run. Phase is the
+// value of SET phase where the statement ran.
+type StatementResult struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Source is where the statement was read from, as "file:line".
+	Source   string         `json:"source,omitempty"`
+	Phase    string         `json:"phase,omitempty"`
+	Requests int64          `json:"requests"`
+	Errors   int64          `json:"errors"`
+	Points   int64          `json:"points,omitempty"`
+	Bytes    int64          `json:"bytes"`
+	Duration time.Duration  `json:"duration"`
+	Latency  LatencySummary `json:"latency"`
+	// Violations counts query responses that failed the statement's
+	// EXPECT clauses.
+	Violations int64 `json:"violations,omitempty"`
+}
+
+// IntervalResult is one statement's activity over one report interval
+// ending at Time.
+type IntervalResult struct {
+	Time      time.Time     `json:"time"`
+	Statement string        `json:"statement"`
+	Interval  time.Duration `json:"interval"`
+	Points    int64         `json:"points,omitempty"`
+	Requests  int64         `json:"requests"`
+	Errors    int64         `json:"errors"`
+	P50       time.Duration `json:"p50"`
+	P99       time.Duration `json:"p99"`
+}
+
+// Kinds of StatementResult.
+const (
+	KindWrite = "write"
+	KindQuery = "query"
+)
+
+// Passed reports whether every SLO held.
+func (r *RunResult) Passed() bool {
+	for _, s := range r.SLOs {
+		if !s.Pass {
+			return false
+		}
+	}
+	return true
+}
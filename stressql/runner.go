@@ -0,0 +1,485 @@
+package stressql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Config is what a Runner runs.
+type Config struct {
+	Statements []Statement
+	// Vars are in effect before the first statement, as if SET ahead of
+	// it.
+	Vars map[string]string
+	// Args supplies the values of query template variables other than
+	// "%t key", keyed as written, such as "%f".
+	Args map[string]string
+	// Profile selects the PROFILE sections to run, which a config with any
+	// must do.
+	Profile string
+}
+
+// A Runner executes a config against a server. It keeps no global state
+// and never exits the process, so a program can embed it, or run several
+// at once.
+type Runner struct {
+	cfg         Config
+	vars        map[string]string
+	logger      Logger
+	client      *http.Client
+	sink        Sink
+	eventLog    *EventLog
+	output      io.Writer
+	clock       Clock
+	stopTimeout time.Duration
+	deadline    time.Duration
+	seed        int64
+	starts      map[string]time.Time
+}
+
+// An Option configures a Runner.
+type Option func(*Runner)
+
+// WithLogger logs the run to l. By default nothing is logged.
+func WithLogger(l Logger) Option {
+	return func(r *Runner) { r.logger = l }
+}
+
+// WithVars overrides the SET and USE statements of the config that set
+// the variables in vars, so a config can be pointed at another server or
+// run at another scale unedited.
+func WithVars(vars map[string]string) Option {
+	return func(r *Runner) { r.vars = vars }
+}
+
+// WithClient sends every request with c, in place of clients built from
+// the transport options SET in the config.
+func WithClient(c *http.Client) Option {
+	return func(r *Runner) { r.client = c }
+}
+
+// WithSink sends every write and query to s in place of the servers in
+// SET addresses, such as to a MemorySink in a test.
+func WithSink(s Sink) Option {
+	return func(r *Runner) { r.sink = s }
+}
+
+// WithEventLog records the run's events to l, in place of SET eventLog.
+func WithEventLog(l *EventLog) Option {
+	return func(r *Runner) { r.eventLog = l }
+}
+
+// WithOutput sends the output of EXEC scripts to w. By default it is
+// discarded.
+func WithOutput(w io.Writer) Option {
+	return func(r *Runner) { r.output = w }
+}
+
+// WithClock runs on c in place of SystemClock, so a test can control the
+// time points are stamped with and the pace statements run at.
+func WithClock(c Clock) Option {
+	return func(r *Runner) { r.clock = c }
+}
+
+// WithStopTimeout bounds how long Run waits for statements to stop once
+// the run is canceled or a statement fails; it defaults to
+// DefaultStopTimeout. Requests in flight are aborted, so statements stop
+// promptly unless something else blocks them.
+func WithStopTimeout(d time.Duration) Option {
+	return func(r *Runner) { r.stopTimeout = d }
+}
+
+// WithDeadline stops the run once d has passed, in place of the config's
+// DEADLINE, reporting the statements it cut short.
+func WithDeadline(d time.Duration) Option {
+	return func(r *Runner) { r.deadline = d }
+}
+
+// WithSeed seeds the run's random choices, such as the server each query
+// goes to, with seed, to repeat those of the run whose RunResult.Seed it
+// is. By default the seed is drawn from the clock.
+func WithSeed(seed int64) Option {
+	return func(r *Runner) { r.seed = seed }
+}
+
+// WithStarts stamps the points of the INSERTs named in starts as if each
+// started at its time, to repeat those of the run whose
+// InsertResult.Start they are.
+func WithStarts(starts map[string]time.Time) Option {
+	return func(r *Runner) { r.starts = starts }
+}
+
+// DefaultStopTimeout is how long Run waits for statements to stop by
+// default.
+const DefaultStopTimeout = 10 * time.Second
+
+// NewRunner returns a Runner for cfg, checking what it can of the
+// statements before anything runs.
+func NewRunner(cfg Config, opts ...Option) (*Runner, error) {
+	seq, err := SelectProfile(cfg.Statements, cfg.Profile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Statements = seq
+	if len(cfg.Statements) == 0 {
+		return nil, fmt.Errorf("no statements to run")
+	}
+	r := &Runner{cfg: cfg, logger: NopLogger, output: ioutil.Discard, clock: SystemClock, stopTimeout: DefaultStopTimeout}
+	for _, o := range opts {
+		o(r)
+	}
+	// AFTER can only wait for statements reached before it.
+	named := map[string]bool{}
+	for _, s := range cfg.Statements {
+		if err := validate(s); err != nil {
+			return nil, err
+		}
+		body := s
+		if g, ok := s.(*GoStatement); ok {
+			for _, n := range g.After {
+				if !named[n] {
+					return nil, located(&g.Pos, fmt.Errorf("GO AFTER %s: no statement named %s before it", n, n))
+				}
+			}
+			body = g.Statement
+		}
+		named[dependencyName(body)] = true
+		if s, ok := s.(*DeadlineStatement); ok && r.deadline == 0 {
+			r.deadline, _ = time.ParseDuration(s.Duration)
+		}
+	}
+	if err := checkFieldTypes(cfg.Statements); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func validate(s Statement) error {
+	switch s := s.(type) {
+	case *GoStatement:
+		if s.Concurrency != "" {
+			if n, err := strconv.Atoi(s.Concurrency); err != nil || n <= 0 {
+				return fmt.Errorf("GO: invalid number of workers %q", s.Concurrency)
+			}
+		}
+		return validate(s.Statement)
+	case *InsertStatement:
+		if _, err := s.Databases(""); err != nil {
+			return err
+		}
+		_, err := Compile(s)
+		return err
+	case *QueryStatement:
+		if n, err := strconv.ParseInt(s.Count, 10, 64); err != nil || n < 0 {
+			return fmt.Errorf("query %q: invalid count %q", s.Name, s.Count)
+		}
+		_, err := CompileExpectation(s)
+		return err
+	case *EveryStatement:
+		if d, err := time.ParseDuration(s.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("every: invalid interval %q", s.Interval)
+		}
+	case *SLOStatement:
+		_, err := s.Threshold()
+		return err
+	case *CapStatement:
+		_, err := s.Limit()
+		return err
+	case *DeadlineStatement:
+		if d, err := time.ParseDuration(s.Duration); err != nil || d <= 0 {
+			return fmt.Errorf("deadline: invalid duration %q", s.Duration)
+		}
+	case *SleepStatement:
+		if d, err := time.ParseDuration(s.Duration); err != nil || d < 0 {
+			return fmt.Errorf("sleep: invalid duration %q", s.Duration)
+		}
+	}
+	return nil
+}
+
+// Run executes the config's statements in order and returns the run's
+// result, then waits for any statements still running under GO. Failed
+// requests are counted in the result rather than ending the run, unless
+// more fail than SET errorBudget allows; the error is what ended it early,
+// if anything, and is also recorded in the result's Status. SLOs are
+// evaluated as the run ends, and a failed one shows only in the result.
+func (r *Runner) Run(ctx context.Context) (*RunResult, error) {
+	settings := r.settings()
+	res := &RunResult{Start: r.clock.Now(), Metadata: MetadataFromVars(settings)}
+	fail := func(err error) (*RunResult, error) {
+		res.FinishAt(r.clock.Now(), err)
+		return res, err
+	}
+
+	vars := copyVars(r.cfg.Vars)
+	for k, v := range r.vars {
+		vars[k] = v
+	}
+	env := NewExecEnv(vars)
+	env.Overrides = r.vars
+	res.Seed = r.seed
+	if res.Seed == 0 {
+		res.Seed = r.clock.Now().UnixNano()
+	}
+	env.Rand, env.Starts = rand.New(rand.NewSource(res.Seed)), r.starts
+	env.Args, env.Client, env.Sink, env.Result = r.cfg.Args, r.client, r.sink, res
+	env.Logger, env.Events, env.Output, env.Clock = env.Secrets.Logger(r.logger), r.eventLog, r.output, r.clock
+	env.deriveLogger()
+	if env.Events == nil {
+		events, err := EventLogFromVars(settings)
+		if err != nil {
+			return fail(err)
+		}
+		defer events.Close()
+		env.Events = events
+	}
+	if env.Events != nil {
+		env.Events.Secrets = env.Secrets
+	}
+	slow, err := SlowLogFromVars(settings)
+	if err != nil {
+		return fail(err)
+	}
+	defer slow.Close()
+	if slow != nil {
+		slow.Secrets = env.Secrets
+	}
+	env.SlowLog = slow
+	capture, err := CapturerFromVars(settings)
+	if err != nil {
+		return fail(err)
+	}
+	defer capture.Close()
+	if capture != nil {
+		capture.Secrets = env.Secrets
+	}
+	env.Capture = capture
+	if v := settings["memoryLimit"]; v != "" {
+		n, err := ParseSize(v)
+		if err != nil {
+			return fail(fmt.Errorf("memoryLimit: %v", err))
+		}
+		env.Budget = NewMemoryBudget(n)
+	}
+	if env.Cap, err = NewWriteCap(r.cfg.Statements); err != nil {
+		return fail(err)
+	}
+	if env.ErrorBudget, err = ErrorBudgetFromVars(settings); err != nil {
+		return fail(err)
+	}
+	hooks, err := WebhooksFromVars(settings)
+	if err != nil {
+		return fail(err)
+	}
+	probe, err := ServerProbeFromVars(settings)
+	if err != nil {
+		return fail(err)
+	}
+	if probe != nil && env.Sink != nil {
+		r.logger.Warn("server probe disabled: the run writes to a sink")
+		probe = nil
+	}
+	reporter, err := ReporterFromVars(settings)
+	if err != nil {
+		return fail(err)
+	}
+	// The probe samples the servers set anywhere in the config, with the
+	// credentials set anywhere, while the statements run, and the
+	// reporter reports to the database set anywhere.
+	var samples chan []ServerSample
+	stopProbe := make(chan struct{})
+	if probe != nil {
+		penv, err := settingsEnv(env, settings)
+		if err != nil {
+			return fail(err)
+		}
+		samples = make(chan []ServerSample, 1)
+		go func() { samples <- probe.run(penv, stopProbe) }()
+	}
+	reported := make(chan struct{})
+	if reporter != nil {
+		renv, err := settingsEnv(env, settings)
+		if err != nil {
+			return fail(err)
+		}
+		go func() {
+			defer close(reported)
+			reporter.run(renv, stopProbe)
+		}()
+	} else {
+		close(reported)
+	}
+
+	if err := hooks.Notify(ctx, WebhookStart, res, ""); err != nil {
+		r.logger.Warn("webhook failed", "err", err)
+	}
+	env.Events.Emit(Event{Time: r.clock.Now(), Type: EventRunStart})
+
+	// A failed statement stops those still running under GO, and its
+	// error is returned with theirs.
+	run, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// An exceeded error budget stops them too, ending the run in its own
+	// status.
+	env.ErrorBudget.Watch(cancel)
+	// A DEADLINE stops the run as a canceled one, noting the statements
+	// running or yet to start as it passes.
+	var current int64
+	expired, stopped := make(chan []string, 1), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if r.deadline > 0 && sleep(run, r.clock, r.deadline) {
+			expired <- r.incomplete(env, int(atomic.LoadInt64(&current)))
+			cancel()
+		}
+	}()
+	for i, s := range r.cfg.Statements {
+		atomic.StoreInt64(&current, int64(i))
+		if run.Err() != nil {
+			break
+		}
+		if err := s.Exec(run, env); err != nil {
+			// A statement the deadline or error budget stopped has not
+			// failed.
+			if _, ok := s.(*WaitStatement); !ok && len(expired) == 0 && !env.ErrorBudget.Exceeded() {
+				env.fail(s, err)
+			}
+			cancel()
+			break
+		}
+	}
+	env.settle()
+	err = r.wait(run, env)
+	if err == nil {
+		// Statements stopped by the caller return no error of their own.
+		err = ctx.Err()
+	}
+	cancel()
+	close(stopProbe)
+	<-reported
+	if samples != nil {
+		res.Server = <-samples
+		var sent int64
+		for _, i := range res.Inserts {
+			sent += i.Bytes
+		}
+		res.Amplification = Amplification(res.Server, sent)
+	}
+	<-stopped
+	select {
+	case res.Incomplete = <-expired:
+		r.logger.Warn("deadline exceeded", "deadline", r.deadline, "incomplete", res.Incomplete)
+		err = &RunError{Status: StatusDeadline, Err: fmt.Errorf("deadline of %v exceeded with %d statements incomplete", r.deadline, len(res.Incomplete))}
+	default:
+	}
+	if be := env.ErrorBudget.Err(); be != nil {
+		r.logger.Warn("error budget exceeded", "err", be)
+		err = be
+	}
+	if err == nil {
+		err = env.Cap.Err()
+	}
+	env.Close()
+
+	EvaluateSLOs(r.cfg.Statements, res)
+	err = env.Secrets.Error(err)
+	res.FinishAt(r.clock.Now(), err)
+	env.Secrets.Result(res)
+	env.Events.Emit(Event{Time: res.End, Type: EventRunEnd, Error: res.Reason})
+
+	event := WebhookComplete
+	if err != nil {
+		event = WebhookAbort
+	} else if !res.Passed() {
+		event = WebhookSLOFailed
+	}
+	// The run's context may be done; the webhook should still hear of it.
+	if err := hooks.Notify(context.Background(), event, res, res.Reason); err != nil {
+		r.logger.Warn("webhook failed", "err", err)
+	}
+	return res, err
+}
+
+// wait waits for the statements still running under GO, at most
+// stopTimeout once ctx is done.
+func (r *Runner) wait(ctx context.Context, env *ExecEnv) error {
+	done := make(chan error, 1)
+	go func() { done <- env.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	t := time.NewTimer(r.stopTimeout)
+	defer t.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-t.C:
+		return fmt.Errorf("statements still running %v after the run was stopped", r.stopTimeout)
+	}
+}
+
+// incomplete names the statements that do work which are running or have
+// yet to start, the statement at index next being the first of those.
+// Statements running in the background until the run ends, such as an
+// EVERY without a count, are never incomplete.
+func (r *Runner) incomplete(env *ExecEnv, next int) []string {
+	var names []string
+	for i, s := range r.cfg.Statements {
+		switch st := s.(type) {
+		case *GoStatement:
+			if i < next && !env.running(st.Statement) {
+				continue
+			}
+		case *EveryStatement:
+			if st.Count == "" || i < next && !env.running(st) {
+				continue
+			}
+		case *InsertStatement, *QueryStatement, *ExecStatement, *InfluxqlStatement, *ContinuousQueryStatement, *RetentionPolicyStatement:
+			if i < next {
+				continue
+			}
+		default:
+			continue
+		}
+		names = append(names, statementName(s))
+	}
+	return names
+}
+
+// settingsEnv returns a copy of env with settings in effect, for what runs
+// alongside the statements, such as a ServerProbe. Its variables are its
+// own, not those the statements see.
+func settingsEnv(env *ExecEnv, settings map[string]string) (*ExecEnv, error) {
+	e := env.fork()
+	e.Store = NewVarStore(nil)
+	for k, v := range settings {
+		if err := e.set(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// settings returns the variables SET anywhere in the config, for those that
+// apply to the whole run, such as eventLog, wherever they appear.
+func (r *Runner) settings() map[string]string {
+	vars := copyVars(r.cfg.Vars)
+	for _, s := range r.cfg.Statements {
+		if s, ok := s.(*SetStatement); ok {
+			vars[s.Var] = s.Value
+		}
+	}
+	for k, v := range r.vars {
+		vars[k] = v
+	}
+	return vars
+}
@@ -0,0 +1,468 @@
+package stressql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runConfig runs a config of one statement per source against sink.
+func runConfig(t testing.TB, sink *MemorySink, opts []Option, srcs ...string) (*RunResult, error) {
+	t.Helper()
+	return newRunner(t, sink, opts, srcs...).Run(context.Background())
+}
+
+// newRunner returns a Runner of a config of one statement per source,
+// writing to sink.
+func newRunner(t testing.TB, sink *MemorySink, opts []Option, srcs ...string) *Runner {
+	t.Helper()
+	var cfg Config
+	for _, src := range srcs {
+		s, err := ParseStatement(src)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		cfg.Statements = append(cfg.Statements, s)
+	}
+	r, err := NewRunner(cfg, append([]Option{WithSink(sink)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// runOutcome is what a Run returned.
+type runOutcome struct {
+	res *RunResult
+	err error
+}
+
+// runOnClock runs a config of one statement per source against sink on
+// clock, in the background.
+func runOnClock(t testing.TB, sink *MemorySink, clock *FakeClock, srcs ...string) <-chan runOutcome {
+	t.Helper()
+	r := newRunner(t, sink, []Option{WithClock(clock)}, srcs...)
+	done := make(chan runOutcome, 1)
+	go func() {
+		res, err := r.Run(context.Background())
+		done <- runOutcome{res, err}
+	}()
+	return done
+}
+
+// advanceUntilDone advances clock by step whenever the run is blocked on
+// it, until the run is done, returning its outcome and the number of
+// steps it took.
+func advanceUntilDone(t testing.TB, clock *FakeClock, step time.Duration, done <-chan runOutcome) (runOutcome, int) {
+	t.Helper()
+	for steps := 0; ; steps++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		blocked := make(chan error, 1)
+		go func() { blocked <- clock.BlockUntil(ctx, 1) }()
+		select {
+		case o := <-done:
+			cancel()
+			return o, steps
+		case err := <-blocked:
+			cancel()
+			if err != nil {
+				t.Fatalf("after %d steps, the run neither blocked nor finished", steps)
+			}
+		}
+		clock.Advance(step)
+	}
+}
+
+// waitFor waits until cond holds.
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// linesTo returns the lines written to db.
+func linesTo(sink *MemorySink, db string) []string {
+	var lines []string
+	for _, b := range sink.Batches() {
+		if b.Database != db {
+			continue
+		}
+		for _, l := range strings.Split(string(b.Lines), "\n") {
+			if l != "" {
+				lines = append(lines, l)
+			}
+		}
+	}
+	return lines
+}
+
+func TestRetentionPolicyExec(t *testing.T) {
+	sink := &MemorySink{}
+	_, err := runConfig(t, sink, nil,
+		"SET database stress",
+		"CREATE RP week DURATION 7d REPLICATION 1 DEFAULT",
+		"ALTER RP week ON other SHARD DURATION 1h",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`CREATE RETENTION POLICY "week" ON "stress" DURATION 7d REPLICATION 1 DEFAULT`,
+		`ALTER RETENTION POLICY "week" ON "other" SHARD DURATION 1h`,
+	}
+	if got := sink.Queries(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("queries %q, want %q", got, want)
+	}
+}
+
+// t0 is where the tests' fake clocks start.
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestRunSleep(t *testing.T) {
+	clock, sink := NewFakeClock(t0), &MemorySink{}
+	done := runOnClock(t, sink, clock,
+		"SET database stress",
+		"INSERT a\na,\nhost=server-[int inc(0) 2]\nv=[int inc(0) 0]\n4 10s",
+		"SLEEP 1m",
+		"INSERT b\nb,\nhost=server-[int inc(0) 3]\nv=[int inc(0) 0]\n3 10s",
+	)
+	o, steps := advanceUntilDone(t, clock, 30*time.Second, done)
+	if o.err != nil {
+		t.Fatal(o.err)
+	}
+	if steps != 2 {
+		t.Fatalf("run took %d steps of 30s, want 2 for SLEEP 1m", steps)
+	}
+	if a, b := o.res.Inserts["a"], o.res.Inserts["b"]; !a.Start.Equal(t0) || !b.Start.Equal(t0.Add(time.Minute)) {
+		t.Fatalf("INSERTs started at %v and %v, want %v and a minute later", a.Start, b.Start, t0)
+	}
+	// Each INSERT's last step is stamped with its start.
+	want := []string{
+		"a,host=server-0 v=0i 1704067190000000000",
+		"a,host=server-1 v=1i 1704067190000000000",
+		"a,host=server-0 v=2i 1704067200000000000",
+		"a,host=server-1 v=3i 1704067200000000000",
+		"b,host=server-0 v=0i 1704067260000000000",
+		"b,host=server-1 v=1i 1704067260000000000",
+		"b,host=server-2 v=2i 1704067260000000000",
+	}
+	if got := linesTo(sink, "stress"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrote\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRunRateLimit(t *testing.T) {
+	clock, sink := NewFakeClock(t0), &MemorySink{}
+	done := runOnClock(t, sink, clock,
+		"SET database stress",
+		"SET batchSize 10",
+		"INSERT cpu\ncpu,\nhost=server-[int inc(0) 5]\nv=[int rand(100) 0]\n50 10s RATE 10",
+	)
+	// Five batches of 10 points at 10 points/s are due a second apart.
+	o, steps := advanceUntilDone(t, clock, time.Second, done)
+	if o.err != nil {
+		t.Fatal(o.err)
+	}
+	if steps != 4 || clock.Since(t0) != 4*time.Second {
+		t.Fatalf("run took %d steps, to %v, want 4 to 4s", steps, clock.Since(t0))
+	}
+	cpu := o.res.Inserts["cpu"]
+	if cpu.Points != 50 || cpu.Batches != 5 || sink.PointCount() != 50 {
+		t.Fatalf("%d points in %d batches, %d written, want 50 in 5", cpu.Points, cpu.Batches, sink.PointCount())
+	}
+	want := []string{"cpu,host=server-0", "cpu,host=server-1", "cpu,host=server-2", "cpu,host=server-3", "cpu,host=server-4"}
+	if got := sink.SeriesSet(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("series %q, want %q", got, want)
+	}
+}
+
+func TestRunGoWaitAfter(t *testing.T) {
+	clock, sink := NewFakeClock(t0), &MemorySink{}
+	done := runOnClock(t, sink, clock,
+		"SET database stress",
+		"SET batchSize 2",
+		"GO INSERT a\na,\nhost=server-[int inc(0) 2]\nv=[int inc(0) 0]\n6 10s RATE 1",
+		"GO AFTER a INSERT b\nb,\nhost=server-[int inc(0) 2]\nv=[int inc(0) 0]\n2 10s",
+		"WAIT",
+		"INSERT c\nc,\nhost=server-0\nv=[int inc(0) 0]\n1 10s",
+	)
+	// a's three batches of two points at a point a second are due 2s
+	// apart; b starts as a finishes, and c once WAIT has seen both.
+	o, steps := advanceUntilDone(t, clock, time.Second, done)
+	if o.err != nil {
+		t.Fatal(o.err)
+	}
+	if steps != 4 {
+		t.Fatalf("run took %d steps, want 4", steps)
+	}
+	end := t0.Add(4 * time.Second)
+	for name, want := range map[string]struct {
+		points int64
+		start  time.Time
+	}{"a": {6, t0}, "b": {2, end}, "c": {1, end}} {
+		got := o.res.Inserts[name]
+		if got == nil || got.Points != want.points || !got.Start.Equal(want.start) {
+			t.Errorf("INSERT %s: %+v, want %d points from %v", name, got, want.points, want.start)
+		}
+	}
+	lines := linesTo(sink, "stress")
+	if len(lines) != 9 || !strings.HasPrefix(lines[6], "b,") || !strings.HasPrefix(lines[8], "c,") {
+		t.Fatalf("wrote %q, want a's 6 points, then b's 2, then c's", lines)
+	}
+}
+
+func TestRunGoUntil(t *testing.T) {
+	clock, sink := NewFakeClock(t0), &MemorySink{}
+	done := runOnClock(t, sink, clock,
+		"SET database stress",
+		"GO UNTIL phase = load INSERT a\na,\nhost=server-0\nv=[int inc(0) 0]\n2 10s",
+		"GO UNTIL ready INSERT b\nb,\nhost=server-0\nv=[int inc(0) 0]\n1 10s",
+		"GO UNTIL workers = 2 INSERT c\nc,\nhost=server-0\nv=[int inc(0) 0]\n1 10s",
+		"SET phase warmup",
+		"SLEEP 3s",
+		"SET phase load",
+		"SET workers 2",
+		"SLEEP 2s",
+		"SET ready on",
+	)
+	// a and c start as phase and workers are set, 3s in, and b once ready
+	// is, 2s later; none before.
+	for i, d := range []time.Duration{3 * time.Second, 2 * time.Second} {
+		if err := clock.BlockUntil(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(linesTo(sink, "stress")); n != 3*i {
+			t.Fatalf("wrote %d points before %v, want %d", n, d, 3*i)
+		}
+		clock.Advance(d)
+		if i == 0 {
+			waitFor(t, "a and c", func() bool { return len(linesTo(sink, "stress")) == 3 })
+		}
+	}
+	o := <-done
+	if o.err != nil {
+		t.Fatal(o.err)
+	}
+	for name, want := range map[string]struct {
+		points int64
+		start  time.Time
+	}{"a": {2, t0.Add(3 * time.Second)}, "b": {1, t0.Add(5 * time.Second)}, "c": {1, t0.Add(3 * time.Second)}} {
+		got := o.res.Inserts[name]
+		if got == nil || got.Points != want.points || !got.Start.Equal(want.start) {
+			t.Errorf("INSERT %s: %+v, want %d points from %v", name, got, want.points, want.start)
+		}
+	}
+	if lines := linesTo(sink, "stress"); len(lines) != 4 {
+		t.Fatalf("wrote %q, want 4 points", lines)
+	}
+}
+
+func TestRunGoUntilNeverSet(t *testing.T) {
+	sink := &MemorySink{}
+	res, err := runConfig(t, sink, nil,
+		"SET database stress",
+		"GO UNTIL ready INSERT a\na,\nhost=server-0\nv=[int inc(0) 0]\n1 10s",
+		"SET ready off",
+	)
+	if err == nil || !strings.Contains(err.Error(), "UNTIL ready: not set before the run's statements ended") {
+		t.Fatalf("run ended with %v, want the GO to fail", err)
+	}
+	if res.Inserts["a"] != nil || len(linesTo(sink, "stress")) != 0 {
+		t.Fatalf("INSERT a ran: %+v", res.Inserts["a"])
+	}
+}
+
+func TestRunEvery(t *testing.T) {
+	clock, sink := NewFakeClock(t0), &MemorySink{}
+	done := runOnClock(t, sink, clock,
+		"SET database stress",
+		"EVERY 10s DO 2 SHOW MEASUREMENTS",
+		"EVERY 10s DROP SERIES FROM tmp",
+		"SLEEP 35s",
+	)
+	// Both EVERYs' tickers and the SLEEP wait on the clock. The counted
+	// EVERY stops after two queries; the other runs until the run ends.
+	if err := clock.BlockUntil(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		clock.Advance(10 * time.Second)
+		n := 2 * i
+		if i == 3 {
+			n = 5
+		}
+		waitFor(t, fmt.Sprintf("%d queries", n), func() bool { return len(sink.Queries()) == n })
+	}
+	clock.Advance(5 * time.Second)
+	if o := <-done; o.err != nil {
+		t.Fatal(o.err)
+	}
+	counts := map[string]int{}
+	for _, q := range sink.Queries() {
+		counts[q]++
+	}
+	if want := map[string]int{"SHOW MEASUREMENTS": 2, "DROP SERIES FROM tmp": 3}; !reflect.DeepEqual(counts, want) {
+		t.Fatalf("queried %v, want %v", counts, want)
+	}
+}
+
+func TestRunPipeline(t *testing.T) {
+	sink := &MemorySink{}
+	res, err := runConfig(t, sink, nil,
+		"SET database stress",
+		"SET batchSize 7",
+		"SET concurrency 4",
+		"INSERT cpu\ncpu,\nhost=server-[int inc(0) 10]\nv=[int inc(0) 0]\n100 10s",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := res.Inserts["cpu"]
+	if cpu.Points != 100 || cpu.Accepted != 100 || cpu.Batches != 15 || len(sink.Batches()) != 15 {
+		t.Fatalf("%d points, %d accepted, in %d batches, %d written, want 100 in 15",
+			cpu.Points, cpu.Accepted, cpu.Batches, len(sink.Batches()))
+	}
+	// However the senders interleave, each point is written once.
+	seen := map[string]bool{}
+	for _, l := range linesTo(sink, "stress") {
+		if seen[l] {
+			t.Fatalf("%q written twice", l)
+		}
+		seen[l] = true
+	}
+	if len(seen) != 100 || len(sink.SeriesSet()) != 10 {
+		t.Fatalf("%d points of %d series written, want 100 of 10", len(seen), len(sink.SeriesSet()))
+	}
+}
+
+func TestRunShardBySeries(t *testing.T) {
+	sink := &MemorySink{}
+	_, err := runConfig(t, sink, nil,
+		"SET database stress",
+		"SET batchSize 5",
+		"SET concurrency 3",
+		"SET shardBySeries true",
+		"INSERT cpu\ncpu,\nhost=server-[int inc(0) 12]\nv=[int inc(0) 0]\n120 10s",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sink.PointCount(); n != 120 {
+		t.Fatalf("%d points written, want 120", n)
+	}
+	// A series is only ever batched with those of its own shard, so the
+	// series batched together fall into one group per sender.
+	group := map[string]string{}
+	var find func(k string) string
+	find = func(k string) string {
+		if p, ok := group[k]; ok && p != k {
+			return find(p)
+		}
+		return k
+	}
+	for _, b := range sink.Batches() {
+		var first string
+		for _, l := range strings.Split(strings.TrimSpace(string(b.Lines)), "\n") {
+			k := string(seriesKey([]byte(l)))
+			if first == "" {
+				first = find(k)
+			}
+			group[find(k)] = first
+		}
+	}
+	groups := map[string]bool{}
+	for k := range group {
+		groups[find(k)] = true
+	}
+	if len(group) != 12 || len(groups) != 3 {
+		t.Fatalf("%d series in %d groups, want 12 in 3", len(group), len(groups))
+	}
+}
+
+func TestRunWaitAny(t *testing.T) {
+	clock, sink := NewFakeClock(t0), &MemorySink{}
+	done := runOnClock(t, sink, clock,
+		"SET database stress",
+		"SET batchSize 2",
+		"GO INSERT fast\nfast,\nhost=server-0\nv=[int inc(0) 0]\n2 10s",
+		"GO INSERT slow\nslow,\nhost=server-0\nv=[int inc(0) 0]\n4 10s RATE 1",
+		"WAIT ANY",
+		"INSERT c\nc,\nhost=server-0\nv=[int inc(0) 0]\n1 10s",
+		"WAIT",
+	)
+	// c waits only for fast, so runs while slow waits on the clock.
+	waitFor(t, "c's point", func() bool {
+		for _, l := range linesTo(sink, "stress") {
+			if strings.HasPrefix(l, "c,") {
+				return true
+			}
+		}
+		return false
+	})
+	o, steps := advanceUntilDone(t, clock, time.Second, done)
+	if o.err != nil {
+		t.Fatal(o.err)
+	}
+	if steps != 2 || !o.res.Inserts["c"].Start.Equal(t0) {
+		t.Fatalf("run took %d steps, c started at %v, want 2 steps, and c at %v", steps, o.res.Inserts["c"].Start, t0)
+	}
+	if n := sink.PointCount(); n != 7 {
+		t.Fatalf("%d points written, want 7", n)
+	}
+}
+
+func TestRunSLO(t *testing.T) {
+	clock, sink := NewFakeClock(t0), &MemorySink{}
+	done := runOnClock(t, sink, clock,
+		"SET database stress",
+		"SET batchSize 10",
+		"INSERT cpu\ncpu,\nhost=server-[int inc(0) 5]\nv=[int rand(100) 0]\n50 10s RATE 10",
+		"SLO errors < 0.1% FOR cpu",
+		"SLO throughput > 10",
+		"SLO throughput > 20 FOR cpu",
+		"SLO p99 < 1s FOR missing",
+	)
+	// 50 points over the 4s their batches are paced across.
+	o, _ := advanceUntilDone(t, clock, time.Second, done)
+	if o.err != nil {
+		t.Fatal(o.err)
+	}
+	want := []SLOResult{
+		{SLO: "SLO errors < 0.1% FOR cpu", Pass: true},
+		{SLO: "SLO throughput > 10", Actual: 12.5, Pass: true},
+		{SLO: "SLO throughput > 20 FOR cpu", Actual: 12.5},
+		{SLO: "SLO p99 < 1s FOR missing", Missing: true},
+	}
+	if !reflect.DeepEqual(o.res.SLOs, want) {
+		t.Fatalf("SLOs %+v, want %+v", o.res.SLOs, want)
+	}
+	if o.res.Status != StatusSLOViolation || o.res.Passed() {
+		t.Fatalf("run ended in %q, want %q", o.res.Status, StatusSLOViolation)
+	}
+}
+
+func TestRunCap(t *testing.T) {
+	sink := &MemorySink{}
+	res, err := runConfig(t, sink, nil,
+		"MAXPOINTS 25",
+		"SET database stress",
+		"SET batchSize 10",
+		"INSERT a\na,\nhost=server-0\nv=[int inc(0) 0]\n20 10s",
+		"INSERT b\nb,\nhost=server-0\nv=[int inc(0) 0]\n20 10s",
+	)
+	var re *RunError
+	if !errors.As(err, &re) || re.Status != StatusCapReached || res.Status != StatusCapReached {
+		t.Fatalf("run ended with %v, status %q, want %s", err, res.Status, StatusCapReached)
+	}
+	// b's first batch would pass the cap, so none of it is sent.
+	if a, b := res.Inserts["a"], res.Inserts["b"]; a.Points != 20 || b.Points != 0 || sink.PointCount() != 20 {
+		t.Fatalf("a sent %d points, b %d, %d written, want 20, 0, 20", a.Points, b.Points, sink.PointCount())
+	}
+}
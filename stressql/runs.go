@@ -0,0 +1,103 @@
+package stressql
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// RunTable lays out the same metrics from several runs side by side, one
+// row per statement and metric and one column per run.
+type RunTable struct {
+	Runs []string
+	Rows []RunTableRow
+}
+
+// RunTableRow is one metric of one statement across runs. A run without
+// the statement has no value for it.
+type RunTableRow struct {
+	Statement string
+	Metric    string
+	Values    []float64
+	Present   []bool
+}
+
+// runMetrics are the metrics tabulated for each statement.
+var runMetrics = []string{"throughput", "p50", "p95", "p99", "max", "errors"}
+
+// TabulateRuns builds a RunTable from runs named by names. Statements are
+// matched by name and phase, in the order they first appear.
+func TabulateRuns(names []string, runs []*RunResult) *RunTable {
+	t := &RunTable{Runs: names}
+
+	var keys []string
+	byKey := map[string][]*StatementResult{}
+	for i, r := range runs {
+		for j := range r.Statements {
+			s := &r.Statements[j]
+			key := resultKey(*s)
+			if _, ok := byKey[key]; !ok {
+				keys = append(keys, key)
+				byKey[key] = make([]*StatementResult, len(runs))
+			}
+			byKey[key][i] = s
+		}
+	}
+
+	for _, key := range keys {
+		for _, metric := range runMetrics {
+			row := RunTableRow{
+				Statement: key,
+				Metric:    metric,
+				Values:    make([]float64, len(runs)),
+				Present:   make([]bool, len(runs)),
+			}
+			found := false
+			for i, s := range byKey[key] {
+				if s == nil || (metric == "throughput" && s.Points == 0) {
+					continue
+				}
+				row.Values[i], row.Present[i] = runMetric(*s, metric), true
+				found = true
+			}
+			if found {
+				t.Rows = append(t.Rows, row)
+			}
+		}
+	}
+	return t
+}
+
+func runMetric(s StatementResult, metric string) float64 {
+	switch metric {
+	case "throughput":
+		return throughput(s)
+	case "errors":
+		return errorRate(s)
+	}
+	return float64(latencyMetric(s.Latency, metric))
+}
+
+// WriteTo writes the table with a column per run.
+func (t *RunTable) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "STATEMENT\tMETRIC\t")
+	for _, r := range t.Runs {
+		fmt.Fprintf(tw, "%s\t", r)
+	}
+	fmt.Fprintln(tw)
+	for _, row := range t.Rows {
+		fmt.Fprintf(tw, "%s\t%s\t", row.Statement, row.Metric)
+		for i, v := range row.Values {
+			if row.Present[i] {
+				fmt.Fprintf(tw, "%s\t", formatMetric(row.Metric, v))
+			} else {
+				fmt.Fprint(tw, "-\t")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	return cw.n, cw.err
+}
@@ -0,0 +1,207 @@
+package stressql
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ResolveSecret returns the value a SET value stands for when it names a
+// secret rather than holding one, so a config can be shared without its
+// credentials:
+//
+//	SET password secret("/run/secrets/influx")
+//	SET header.Authorization keyring("influxdb", "stress")
+//
+// secret reads a file, less a trailing newline, and keyring looks a
+// password up by service and account in the OS keyring, with security(1)
+// on macOS and secret-tool(1) elsewhere. Any other value stands for
+// itself, and ok is false.
+func ResolveSecret(v string) (value string, ok bool, err error) {
+	name, args, ok := parseSecret(v)
+	if !ok {
+		return v, false, nil
+	}
+	switch {
+	case name == "secret" && len(args) == 1:
+		b, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return "", true, err
+		}
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	case name == "keyring" && len(args) == 2:
+		value, err := keyring(args[0], args[1])
+		return value, true, err
+	}
+	return "", true, fmt.Errorf("%s: wrong number of arguments", v)
+}
+
+// parseSecret splits a secret("path") or keyring("service", "account")
+// reference into its function and arguments.
+func parseSecret(v string) (name string, args []string, ok bool) {
+	v = strings.TrimSpace(v)
+	for _, fn := range []string{"secret", "keyring"} {
+		if strings.HasPrefix(v, fn+"(") && strings.HasSuffix(v, ")") {
+			name = fn
+		}
+	}
+	if name == "" {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(v[len(name)+1 : len(v)-1])
+	for rest != "" {
+		if rest[0] != '"' {
+			return "", nil, false
+		}
+		end := strings.IndexByte(rest[1:], '"')
+		if end < 0 {
+			return "", nil, false
+		}
+		args = append(args, rest[1:end+1])
+		rest = strings.TrimSpace(rest[end+2:])
+		if rest != "" {
+			if rest[0] != ',' {
+				return "", nil, false
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+	}
+	return name, args, true
+}
+
+func keyring(service, account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "windows":
+		return "", fmt.Errorf("keyring: not supported on %s", runtime.GOOS)
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "username", account)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", fmt.Errorf("keyring %s/%s: %v", service, account, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// Redacted replaces secrets in logs, errors and results.
+const Redacted = "[REDACTED]"
+
+// Redactor removes the secrets added to it from text. The zero value is
+// ready to use, and it is safe for concurrent use.
+type Redactor struct {
+	mu       sync.Mutex
+	secrets  []string
+	replacer *strings.Replacer
+}
+
+// Add makes r redact s.
+func (r *Redactor) Add(s string) {
+	if s == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets = append(r.secrets, s, Redacted)
+	r.replacer = strings.NewReplacer(r.secrets...)
+}
+
+// Redact returns s with every secret replaced by Redacted. A nil Redactor
+// redacts nothing.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.Lock()
+	rep := r.replacer
+	r.mu.Unlock()
+	if rep == nil {
+		return s
+	}
+	return rep.Replace(s)
+}
+
+// Error returns err with its message redacted. The result wraps err, so
+// its status is unchanged.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := r.Redact(err.Error()); msg != err.Error() {
+		return &redactedError{msg: msg, err: err}
+	}
+	return err
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// Result redacts the messages in res.
+func (r *Redactor) Result(res *RunResult) {
+	res.Reason = r.Redact(res.Reason)
+	for k, v := range res.Metadata {
+		res.Metadata[k] = r.Redact(v)
+	}
+	for _, i := range res.Inserts {
+		r.strings(i.FirstErrors)
+	}
+	for _, q := range res.Queries {
+		r.strings(q.FirstErrors)
+	}
+	for _, e := range res.Execs {
+		e.Error = r.Redact(e.Error)
+	}
+}
+
+func (r *Redactor) strings(l []string) {
+	for i, s := range l {
+		l[i] = r.Redact(s)
+	}
+}
+
+// Logger returns l with the secrets added to r, then or later, redacted
+// from the string and error arguments it logs.
+func (r *Redactor) Logger(l Logger) Logger {
+	return &redactLogger{l: l, r: r}
+}
+
+type redactLogger struct {
+	l Logger
+	r *Redactor
+}
+
+func (l *redactLogger) args(args []interface{}) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, a := range args {
+		switch a := a.(type) {
+		case string:
+			out[i] = l.r.Redact(a)
+		case error:
+			out[i] = l.r.Error(a)
+		default:
+			out[i] = a
+		}
+	}
+	return out
+}
+
+func (l *redactLogger) Debug(msg string, args ...interface{}) { l.l.Debug(msg, l.args(args)...) }
+func (l *redactLogger) Info(msg string, args ...interface{})  { l.l.Info(msg, l.args(args)...) }
+func (l *redactLogger) Warn(msg string, args ...interface{})  { l.l.Warn(msg, l.args(args)...) }
+func (l *redactLogger) Error(msg string, args ...interface{}) { l.l.Error(msg, l.args(args)...) }
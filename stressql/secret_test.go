@@ -0,0 +1,27 @@
+package stressql
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogsRedactSecrets(t *testing.T) {
+	secrets := &Redactor{}
+	var buf bytes.Buffer
+	events, slow, capture := NewEventLog(&buf), NewSlowLog(&buf, 0), NewCapturer(&buf, 1)
+	events.Secrets, slow.Secrets, capture.Secrets = secrets, secrets, secrets
+	// Secrets added once the logs are in use are redacted too.
+	secrets.Add("hunter2")
+
+	events.Emit(Event{Type: EventError, Error: "401: bad password hunter2"})
+	slow.Record(SlowQuery{Query: "CREATE USER u WITH PASSWORD 'hunter2'", Args: []string{"hunter2"}, Latency: time.Second})
+	capture.Record(Capture{Kind: CaptureWrite, Error: "hunter2", Body: "m,token=hunter2 v=1"})
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("logs hold the secret:\n%s", buf.String())
+	}
+	if n := strings.Count(buf.String(), Redacted); n != 5 {
+		t.Errorf("redacted %d times, want 5:\n%s", n, buf.String())
+	}
+}
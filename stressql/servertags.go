@@ -0,0 +1,111 @@
+package stressql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// maxServerTagValues bounds the values of each tag fetched by
+// serverTagValues.
+const maxServerTagValues = 100000
+
+// serverTagValues fetches, with SHOW TAG VALUES, the values the server
+// already holds of each tag that the "%t key" variables of stmt name. It
+// is used in place of an INSERT's Generator when set in the DSL with
+//
+//	SET queryTags server
+//	SET queryMeasurement cpu
+//
+// so read stress can target data this tool did not write. Without
+// queryMeasurement, values are taken from every measurement in the
+// database. Each variable draws its own value, so unlike values from an
+// INSERT, those of several tags in one query need not share a series.
+func (env *ExecEnv) serverTagValues(ctx context.Context, stmt *QueryStatement) (map[string][]string, error) {
+	values := make(map[string][]string)
+	for _, arg := range stmt.Args {
+		key, _, ok := parseTagArg(arg)
+		if !ok || values[key] != nil {
+			continue
+		}
+		q := "SHOW TAG VALUES"
+		if m := env.Vars["queryMeasurement"]; m != "" {
+			q += " FROM " + quoteIdent(m)
+		}
+		q += fmt.Sprintf(" WITH KEY = %s LIMIT %d", quoteIdent(key), maxServerTagValues)
+
+		body, _, err := env.request(ctx, env.address(env.Rand), "GET", q)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: tag values of %q: %v", stmt.Name, key, err)
+		}
+		vs, err := parseTagValues(body)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: tag values of %q: %v", stmt.Name, key, err)
+		}
+		if len(vs) == 0 {
+			return nil, fmt.Errorf("query %q: the server has no values of tag %q", stmt.Name, key)
+		}
+		env.Logger.Info("tag values fetched", "statement", stmt.Name, "key", key, "values", len(vs))
+		values[key] = vs
+	}
+	return values, nil
+}
+
+// parseTagValues returns the distinct values in a SHOW TAG VALUES
+// response, sorted, across all its measurements.
+func parseTagValues(body []byte) ([]string, error) {
+	seen := make(map[string]bool)
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var r struct {
+			Results []struct {
+				Series []struct {
+					Values [][]string `json:"values"`
+				} `json:"series"`
+			} `json:"results"`
+		}
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid response: %v", err)
+		}
+		for _, res := range r.Results {
+			for _, s := range res.Series {
+				// Rows are [key, value].
+				for _, row := range s.Values {
+					if len(row) == 2 {
+						seen[row[1]] = true
+					}
+				}
+			}
+		}
+	}
+	vs := make([]string, 0, len(seen))
+	for v := range seen {
+		vs = append(vs, v)
+	}
+	sort.Strings(vs)
+	return vs, nil
+}
+
+// StaticTagValues returns TagValues drawing from the values listed for
+// each key.
+func StaticTagValues(values map[string][]string) TagValues {
+	return func(key string) (func(b []byte, r uint64) []byte, error) {
+		vs := values[key]
+		if len(vs) == 0 {
+			return nil, fmt.Errorf("no values of tag %q", key)
+		}
+		n := uint64(len(vs))
+		return func(b []byte, r uint64) []byte { return append(b, vs[r%n]...) }, nil
+	}
+}
+
+// quoteIdent quotes an InfluxQL identifier.
+func quoteIdent(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,198 @@
+package stressql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A LoadShape sets the write rate, in RateUnits per second, over the
+// course of a write. A zero rate is unlimited.
+type LoadShape interface {
+	RateAt(elapsed time.Duration) float64
+}
+
+// A RateUnit is what a LoadShape's rate counts. Different units provoke
+// different bottlenecks: points the storage engine, requests the HTTP
+// layer, and bytes the network and parsing.
+type RateUnit int
+
+const (
+	Points RateUnit = iota
+	Requests
+	Bytes
+)
+
+func (u RateUnit) String() string {
+	switch u {
+	case Requests:
+		return "req"
+	case Bytes:
+		return "bytes"
+	}
+	return "pts"
+}
+
+// parseRateUnit parses a Timestamp's RateUnit.
+func parseRateUnit(s string) (RateUnit, error) {
+	switch s {
+	case "", "pts":
+		return Points, nil
+	case "req":
+		return Requests, nil
+	case "bytes":
+		return Bytes, nil
+	}
+	return 0, fmt.Errorf("invalid rate unit %q", s)
+}
+
+// parseRate parses a rate, which may have a k or M suffix.
+func parseRate(v string) (float64, bool) {
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(v, "k"):
+		mult = 1e3
+	case strings.HasSuffix(v, "M"):
+		mult = 1e6
+	}
+	r, err := strconv.ParseFloat(strings.TrimRight(v, "kM"), 64)
+	if err != nil || r <= 0 {
+		return 0, false
+	}
+	return r * mult, true
+}
+
+// ConstantRate writes at a fixed rate, as set by RATE.
+type ConstantRate float64
+
+func (r ConstantRate) RateAt(time.Duration) float64 { return float64(r) }
+
+// Burst multiplies a base rate by Multiplier for the first For of every
+// Every, as in "RATE 5000 BURST 10x FOR 30s EVERY 10m".
+type Burst struct {
+	Base       float64
+	Multiplier float64
+	For        time.Duration
+	Every      time.Duration
+}
+
+func (b *Burst) RateAt(elapsed time.Duration) float64 {
+	if elapsed%b.Every < b.For {
+		return b.Base * b.Multiplier
+	}
+	return b.Base
+}
+
+// Steps holds each of Rates for Hold in turn, staying at the last, to find
+// the highest rate a server sustains.
+type Steps struct {
+	Rates []float64
+	Hold  time.Duration
+}
+
+func (s *Steps) RateAt(elapsed time.Duration) float64 {
+	return s.Rates[s.StepAt(elapsed)]
+}
+
+// StepAt returns the index of the step in effect at elapsed.
+func (s *Steps) StepAt(elapsed time.Duration) int {
+	i := int(elapsed / s.Hold)
+	if i >= len(s.Rates) {
+		i = len(s.Rates) - 1
+	}
+	return i
+}
+
+// compileShape returns the LoadShape set by a timestamp's modifiers, or nil
+// for an unlimited write.
+func compileShape(ts *Timestamp) (LoadShape, error) {
+	if len(ts.Steps) > 0 {
+		if ts.Rate != "" {
+			return nil, fmt.Errorf("STEPS and RATE cannot both be set")
+		}
+		s := &Steps{}
+		for _, v := range ts.Steps {
+			r, ok := parseRate(v)
+			if !ok {
+				return nil, fmt.Errorf("invalid step rate %q", v)
+			}
+			s.Rates = append(s.Rates, r)
+		}
+		var err error
+		if s.Hold, err = time.ParseDuration(ts.StepHold); err != nil || s.Hold <= 0 {
+			return nil, fmt.Errorf("invalid step hold %q", ts.StepHold)
+		}
+		return s, nil
+	}
+
+	if ts.Rate == "" {
+		if ts.Burst != "" {
+			return nil, fmt.Errorf("BURST requires a RATE")
+		}
+		return nil, nil
+	}
+	rate, ok := parseRate(ts.Rate)
+	if !ok {
+		return nil, fmt.Errorf("invalid rate %q", ts.Rate)
+	}
+	var err error
+	if ts.Burst == "" {
+		return ConstantRate(rate), nil
+	}
+
+	b := &Burst{Base: rate}
+	if b.Multiplier, err = strconv.ParseFloat(ts.Burst, 64); err != nil || b.Multiplier <= 0 {
+		return nil, fmt.Errorf("invalid burst %q", ts.Burst)
+	}
+	if b.For, err = time.ParseDuration(ts.BurstFor); err != nil || b.For <= 0 {
+		return nil, fmt.Errorf("invalid burst duration %q", ts.BurstFor)
+	}
+	if b.Every, err = time.ParseDuration(ts.BurstEvery); err != nil || b.Every < b.For {
+		return nil, fmt.Errorf("invalid burst period %q", ts.BurstEvery)
+	}
+	return b, nil
+}
+
+// rateLimiter schedules batches so they are sent at a LoadShape's rate, in
+// its unit. Each batch is scheduled after the one before it, at the rate
+// in effect when that batch was due.
+type rateLimiter struct {
+	shape LoadShape
+	unit  RateUnit
+	clock Clock
+	start time.Time
+
+	mu   sync.Mutex
+	next time.Duration
+}
+
+func newRateLimiter(shape LoadShape, unit RateUnit, clock Clock) *rateLimiter {
+	return &rateLimiter{shape: shape, unit: unit, clock: clock, start: clock.Now()}
+}
+
+// elapsed returns the time since the limiter started.
+func (l *rateLimiter) elapsed() time.Duration { return l.clock.Since(l.start) }
+
+// wait blocks until a batch of the given number of points and bytes may be
+// sent, reporting false if ctx ended first.
+func (l *rateLimiter) wait(ctx context.Context, points, bytes int64) bool {
+	n := points
+	switch l.unit {
+	case Requests:
+		n = 1
+	case Bytes:
+		n = bytes
+	}
+
+	l.mu.Lock()
+	at := l.next
+	if rate := l.shape.RateAt(at); rate > 0 {
+		l.next += time.Duration(float64(n) / rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	return sleep(ctx, l.clock, at-l.elapsed())
+}
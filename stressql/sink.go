@@ -0,0 +1,145 @@
+package stressql
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+)
+
+// A Sink takes the place of the servers a run writes to and queries, such
+// as a MemorySink in a test.
+type Sink interface {
+	// WriteBatch writes a batch of line protocol to a database.
+	WriteBatch(ctx context.Context, db, rp string, batch []byte) error
+	// Query runs q against a database, returning the response body.
+	Query(ctx context.Context, db, rp, q string) ([]byte, error)
+}
+
+// sinkWriter is a BatchWriter writing to one database of a Sink.
+type sinkWriter struct {
+	sink   Sink
+	db, rp string
+}
+
+func (w *sinkWriter) WriteBatch(ctx context.Context, batch []byte) error {
+	return w.sink.WriteBatch(ctx, w.db, w.rp, batch)
+}
+
+// MemorySink is a Sink that records everything written and queried, so a
+// test can assert exactly what a config produces without a server. It is
+// safe for concurrent use.
+type MemorySink struct {
+	// Respond, if set, returns the response body for a query. By default
+	// every query succeeds with an empty result.
+	Respond func(db, q string) []byte
+
+	mu      sync.Mutex
+	batches []RecordedBatch
+	queries []RecordedQuery
+}
+
+// RecordedBatch is a batch written to a MemorySink.
+type RecordedBatch struct {
+	Database        string
+	RetentionPolicy string
+	Lines           []byte
+}
+
+// RecordedQuery is a query run against a MemorySink.
+type RecordedQuery struct {
+	Database string
+	Query    string
+}
+
+// emptyResponse is the body of a query with no results.
+var emptyResponse = []byte(`{"results":[{"statement_id":0}]}`)
+
+func (s *MemorySink) WriteBatch(ctx context.Context, db, rp string, batch []byte) error {
+	// The pipeline reuses batch buffers once written.
+	b := RecordedBatch{Database: db, RetentionPolicy: rp, Lines: append([]byte(nil), batch...)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, b)
+	return nil
+}
+
+func (s *MemorySink) Query(ctx context.Context, db, rp, q string) ([]byte, error) {
+	s.mu.Lock()
+	s.queries = append(s.queries, RecordedQuery{Database: db, Query: q})
+	s.mu.Unlock()
+	if s.Respond != nil {
+		return s.Respond(db, q), nil
+	}
+	return emptyResponse, nil
+}
+
+// Batches returns the batches written, in the order they were.
+func (s *MemorySink) Batches() []RecordedBatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RecordedBatch(nil), s.batches...)
+}
+
+// Queries returns the text of the queries run, in the order they were.
+func (s *MemorySink) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	qs := make([]string, len(s.queries))
+	for i, q := range s.queries {
+		qs[i] = q.Query
+	}
+	return qs
+}
+
+// PointCount returns the number of points written.
+func (s *MemorySink) PointCount() int64 {
+	var n int64
+	s.eachLine(func(line []byte) { n++ })
+	return n
+}
+
+// SeriesSet returns the distinct series keys written, sorted.
+func (s *MemorySink) SeriesSet() []string {
+	seen := map[string]bool{}
+	s.eachLine(func(line []byte) { seen[string(seriesKey(line))] = true })
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reset forgets everything recorded so far.
+func (s *MemorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches, s.queries = nil, nil
+}
+
+func (s *MemorySink) eachLine(f func(line []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.batches {
+		for _, line := range bytes.Split(b.Lines, newline) {
+			if len(line) > 0 {
+				f(line)
+			}
+		}
+	}
+}
+
+// seriesKey returns the measurement and tags of a line of line protocol,
+// up to the first unescaped space.
+func seriesKey(line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ' ':
+			return line[:i]
+		}
+	}
+	return line
+}
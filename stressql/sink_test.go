@@ -0,0 +1,40 @@
+package stressql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemorySinkRecordsRun(t *testing.T) {
+	sink := &MemorySink{Respond: func(db, q string) []byte {
+		return []byte(`{"results":[{"statement_id":0,"series":[{"name":"cpu","columns":["time","count"],"values":[[0,4]]}]}]}`)
+	}}
+	_, err := runConfig(t, sink, nil,
+		"SET database stress",
+		"SET retentionPolicy week",
+		"INSERT cpu\ncpu,\nregion=[us-west|eu-north]\nv=[int inc(0) 0]\n4 10s",
+		"QUERY count\nSELECT count(v) FROM cpu\nDO 2",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range sink.Batches() {
+		if b.Database != "stress" || b.RetentionPolicy != "week" {
+			t.Fatalf("batch written to %s.%s, want stress.week", b.Database, b.RetentionPolicy)
+		}
+	}
+	if n := sink.PointCount(); n != 4 {
+		t.Fatalf("%d points written, want 4", n)
+	}
+	if got, want := sink.SeriesSet(), []string{"cpu,region=eu-north", "cpu,region=us-west"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("series %q, want %q", got, want)
+	}
+	if got, want := sink.Queries(), []string{"SELECT count(v) FROM cpu", "SELECT count(v) FROM cpu"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("queries %q, want %q", got, want)
+	}
+
+	sink.Reset()
+	if len(sink.Batches()) != 0 || len(sink.Queries()) != 0 || sink.PointCount() != 0 {
+		t.Fatal("Reset kept what was recorded")
+	}
+}
@@ -0,0 +1,142 @@
+package stressql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SLOResult is the outcome of one SLO.
+type SLOResult struct {
+	SLO    string  `json:"slo"`
+	Actual float64 `json:"actual"`
+	Pass   bool    `json:"pass"`
+	// Missing is set, and the SLO failed, when no statement matched its
+	// scope.
+	Missing bool `json:"missing,omitempty"`
+}
+
+// Threshold returns the SLO's value in the metric's unit: nanoseconds for
+// latency, a fraction for errors, and points per second for throughput.
+func (i *SLOStatement) Threshold() (float64, error) {
+	switch i.Metric {
+	case "p50", "p90", "p95", "p99", "p999", "mean", "max":
+		d, err := time.ParseDuration(i.Value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s threshold %q, expected a duration", i.Metric, i.Value)
+		}
+		return float64(d), nil
+	case "errors":
+		v := strings.TrimSuffix(i.Value, "%")
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			return 0, fmt.Errorf("invalid errors threshold %q, expected a percentage", i.Value)
+		}
+		if v != i.Value {
+			f /= 100
+		}
+		return f, nil
+	case "throughput":
+		f, err := strconv.ParseFloat(i.Value, 64)
+		if err != nil || f < 0 {
+			return 0, fmt.Errorf("invalid throughput threshold %q, expected points per second", i.Value)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("unknown SLO metric %q", i.Metric)
+}
+
+// Evaluate checks the SLO against r. Over several statements, a latency
+// metric is that of the slowest.
+func (i *SLOStatement) Evaluate(r *RunResult) SLOResult {
+	res := SLOResult{SLO: i.String()}
+	threshold, err := i.Threshold()
+	if err != nil {
+		return res
+	}
+
+	var requests, errors, points int64
+	var latency float64
+	var elapsed time.Duration
+	matched := false
+	for _, s := range r.Statements {
+		if i.Scope != "" && s.Name != i.Scope && s.Phase != i.Scope {
+			continue
+		}
+		matched = true
+		requests += s.Requests
+		errors += s.Errors
+		points += s.Points
+		if s.Duration > elapsed {
+			elapsed = s.Duration
+		}
+		if v := float64(latencyMetric(s.Latency, i.Metric)); v > latency {
+			latency = v
+		}
+	}
+	if !matched {
+		res.Missing = true
+		return res
+	}
+
+	switch i.Metric {
+	case "errors":
+		if requests > 0 {
+			res.Actual = float64(errors) / float64(requests)
+		}
+	case "throughput":
+		if i.Scope == "" && r.End.After(r.Start) {
+			elapsed = r.End.Sub(r.Start)
+		}
+		if elapsed > 0 {
+			res.Actual = float64(points) / elapsed.Seconds()
+		}
+	default:
+		res.Actual = latency
+	}
+
+	switch i.Op {
+	case "<":
+		res.Pass = res.Actual < threshold
+	case "<=":
+		res.Pass = res.Actual <= threshold
+	case ">":
+		res.Pass = res.Actual > threshold
+	case ">=":
+		res.Pass = res.Actual >= threshold
+	}
+	return res
+}
+
+func latencyMetric(s LatencySummary, metric string) time.Duration {
+	switch metric {
+	case "p50":
+		return s.P50
+	case "p90":
+		return s.P90
+	case "p95":
+		return s.P95
+	case "p99":
+		return s.P99
+	case "p999":
+		return s.P999
+	case "mean":
+		return s.Mean
+	case "max":
+		return s.Max
+	}
+	return 0
+}
+
+// EvaluateSLOs evaluates every SLO in seq against r, recording the results
+// in r.SLOs, and reports whether all of them passed.
+func EvaluateSLOs(seq []Statement, r *RunResult) bool {
+	r.SLOs = r.SLOs[:0]
+	for _, s := range seq {
+		if slo, ok := s.(*SLOStatement); ok {
+			r.SLOs = append(r.SLOs, slo.Evaluate(r))
+		}
+	}
+	return r.Passed()
+}
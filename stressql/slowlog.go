@@ -0,0 +1,123 @@
+package stressql
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SlowQuery is one entry of a slow query log: enough to run the query again
+// on its own.
+type SlowQuery struct {
+	Time      time.Time     `json:"time"`
+	Statement string        `json:"statement"`
+	Database  string        `json:"database,omitempty"`
+	Query     string        `json:"query"`
+	Args      []string      `json:"args,omitempty"`
+	Latency   time.Duration `json:"latency"`
+	Bytes     int64         `json:"bytes"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+// SlowLog records queries slower than Threshold as JSON lines. It is set in
+// the DSL with
+//
+//	SET slowQueryThreshold 500ms
+//	SET slowQueryLog "slow.jsonl"
+//
+// and is safe for concurrent use. A nil SlowLog records nothing.
+type SlowLog struct {
+	Threshold time.Duration
+	// Secrets, if set, are redacted from the queries recorded.
+	Secrets *Redactor
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// NewSlowLog returns a SlowLog writing to w.
+func NewSlowLog(w io.Writer, threshold time.Duration) *SlowLog {
+	l := &SlowLog{Threshold: threshold, enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		l.c = c
+	}
+	return l
+}
+
+// SlowLogFromVars opens the slow query log set by SET variables. It returns
+// nil if no threshold is set; the log defaults to slow-queries.jsonl.
+func SlowLogFromVars(vars map[string]string) (*SlowLog, error) {
+	if vars["slowQueryThreshold"] == "" {
+		return nil, nil
+	}
+	d, err := durationVar(vars, "slowQueryThreshold", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	path := vars["slowQueryLog"]
+	if path == "" {
+		path = "slow-queries.jsonl"
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewSlowLog(f, d), nil
+}
+
+// Record logs q if it was at least as slow as the threshold, reporting
+// whether it did.
+func (l *SlowLog) Record(q SlowQuery) (bool, error) {
+	if l == nil || q.Latency < l.Threshold {
+		return false, nil
+	}
+	q.Query, q.Database = l.Secrets.Redact(q.Query), l.Secrets.Redact(q.Database)
+	if q.Args != nil {
+		args := make([]string, len(q.Args))
+		for i, a := range q.Args {
+			args[i] = l.Secrets.Redact(a)
+		}
+		q.Args = args
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return true, l.enc.Encode(q)
+}
+
+// Close closes the underlying file, if any.
+func (l *SlowLog) Close() error {
+	if l == nil || l.c == nil {
+		return nil
+	}
+	return l.c.Close()
+}
+
+// ReadSlowLog reads a slow query log, slowest query first.
+func ReadSlowLog(r io.Reader) ([]SlowQuery, error) {
+	var qs []SlowQuery
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var q SlowQuery
+		if err := json.Unmarshal(s.Bytes(), &q); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		qs = append(qs, q)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(qs, func(i, j int) bool { return qs[i].Latency > qs[j].Latency })
+	return qs, nil
+}
@@ -0,0 +1,143 @@
+package stressql
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// statsdPacketSize keeps packets within a typical Ethernet MTU.
+const statsdPacketSize = 1432
+
+// StatsD sends run metrics to a StatsD or DogStatsD agent over UDP, as the
+// run progresses. It is set in the DSL with
+//
+//	SET statsd "127.0.0.1:8125"
+//	SET statsdPrefix stress
+//	SET statsdDogstatsd on
+//
+// With DogStatsD, the statement and the run's metadata are sent as tags;
+// plain StatsD has no tags, so the statement is part of the metric name.
+// StatsD is safe for concurrent use.
+type StatsD struct {
+	Prefix    string
+	DogStatsD bool
+	// Tags are added to every DogStatsD metric.
+	Tags map[string]string
+
+	mu   sync.Mutex
+	conn net.Conn
+	buf  bytes.Buffer
+}
+
+// NewStatsD returns a StatsD sending to addr.
+func NewStatsD(addr string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{Prefix: "stress", conn: conn}, nil
+}
+
+// StatsDFromVars returns the StatsD set by SET variables, or nil if none.
+func StatsDFromVars(vars map[string]string) (*StatsD, error) {
+	addr := vars["statsd"]
+	if addr == "" {
+		return nil, nil
+	}
+	s, err := NewStatsD(addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+	if v, ok := vars["statsdPrefix"]; ok {
+		s.Prefix = v
+	}
+	if s.DogStatsD, err = boolVar(vars, "statsdDogstatsd", false); err != nil {
+		return nil, err
+	}
+	s.Tags = MetadataFromVars(vars)
+	return s, nil
+}
+
+// Report sends the counters and latencies of one report interval.
+func (s *StatsD) Report(kind string, iv IntervalResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type stat struct{ name, value, typ string }
+	ms := []stat{
+		{"requests", strconv.FormatInt(iv.Requests, 10), "c"},
+		{"errors", strconv.FormatInt(iv.Errors, 10), "c"},
+		{"latency.p50", strconv.FormatFloat(iv.P50.Seconds()*1000, 'f', -1, 64), "ms"},
+		{"latency.p99", strconv.FormatFloat(iv.P99.Seconds()*1000, 'f', -1, 64), "ms"},
+	}
+	if kind == KindWrite {
+		ms = append(ms, stat{"points", strconv.FormatInt(iv.Points, 10), "c"})
+	}
+	for _, m := range ms {
+		if err := s.metric(kind, iv.Statement, m.name, m.value, m.typ); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+// metric appends one metric line, flushing first if it would overflow the
+// packet.
+func (s *StatsD) metric(kind, statement, name, value, typ string) error {
+	var line bytes.Buffer
+	if s.Prefix != "" {
+		line.WriteString(s.Prefix + ".")
+	}
+	line.WriteString(kind + ".")
+	if !s.DogStatsD {
+		line.WriteString(statsdName(statement) + ".")
+	}
+	line.WriteString(name + ":" + value + "|" + typ)
+	if s.DogStatsD {
+		line.WriteString("|#statement:" + statsdTag(statement))
+		keys := make([]string, 0, len(s.Tags))
+		for k := range s.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			line.WriteString("," + statsdTag(k) + ":" + statsdTag(s.Tags[k]))
+		}
+	}
+
+	if s.buf.Len() > 0 && s.buf.Len()+1+line.Len() > statsdPacketSize {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	if s.buf.Len() > 0 {
+		s.buf.WriteByte('\n')
+	}
+	s.buf.Write(line.Bytes())
+	return nil
+}
+
+func (s *StatsD) flush() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(s.buf.Bytes())
+	s.buf.Reset()
+	return err
+}
+
+// Close closes the connection to the agent.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+// statsdName makes s safe as a metric name segment.
+var statsdName = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", "#", "_", " ", "_", "\n", "_").Replace
+
+// statsdTag makes s safe as a DogStatsD tag key or value.
+var statsdTag = strings.NewReplacer(",", "_", "|", "_", "#", "_", " ", "_", "\n", "_").Replace
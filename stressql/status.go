@@ -0,0 +1,172 @@
+package stressql
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Statuses of a run, recorded in RunResult.Status so automation can branch
+// on why a run failed.
+const (
+	StatusSuccess           = "success"
+	StatusError             = "error"
+	StatusParseError        = "parse_error"
+	StatusConnectionFailure = "connection_failure"
+	StatusSLOViolation      = "slo_violation"
+	StatusErrorBudget       = "error_budget"
+	StatusDeadline          = "deadline_exceeded"
+	StatusCapReached        = "cap_reached"
+)
+
+// exitCodes are the process exit codes for each status. 2 is left for
+// command line usage errors.
+var exitCodes = map[string]int{
+	StatusSuccess:           0,
+	StatusError:             1,
+	StatusParseError:        3,
+	StatusConnectionFailure: 4,
+	StatusSLOViolation:      5,
+	StatusErrorBudget:       6,
+	StatusDeadline:          7,
+	StatusCapReached:        8,
+}
+
+// ExitCode returns the exit code for a status, 1 if it is unknown.
+func ExitCode(status string) int {
+	if c, ok := exitCodes[status]; ok {
+		return c
+	}
+	return 1
+}
+
+// RunError is an error that ended a run, with the status it ended in.
+type RunError struct {
+	Status string
+	Err    error
+}
+
+func (e *RunError) Error() string { return e.Err.Error() }
+func (e *RunError) Unwrap() error { return e.Err }
+
+// MultiError is the failures of statements run concurrently, grouped by
+// statement and cause, so a run in which many workers fail the same way
+// reports it once, with a count. errors.Is and errors.As see the first
+// error of each group.
+type MultiError struct {
+	Groups []ErrorGroup
+}
+
+// ErrorGroup is the failures of one statement with one cause, the message
+// of the innermost error they wrap.
+type ErrorGroup struct {
+	Statement string
+	Cause     string
+	Count     int
+	// Err is the group's first error.
+	Err error
+}
+
+// Add records err as a failure of statement.
+func (m *MultiError) Add(statement string, err error) {
+	root := err
+	for u := errors.Unwrap(root); u != nil; u = errors.Unwrap(root) {
+		root = u
+	}
+	cause := root.Error()
+	for i := range m.Groups {
+		if g := &m.Groups[i]; g.Statement == statement && g.Cause == cause {
+			g.Count++
+			return
+		}
+	}
+	m.Groups = append(m.Groups, ErrorGroup{Statement: statement, Cause: cause, Count: 1, Err: err})
+}
+
+// Len returns the number of failures.
+func (m *MultiError) Len() int {
+	n := 0
+	for _, g := range m.Groups {
+		n += g.Count
+	}
+	return n
+}
+
+// Err returns a copy of m, or nil if it has no failures.
+func (m *MultiError) Err() error {
+	if len(m.Groups) == 0 {
+		return nil
+	}
+	return &MultiError{Groups: append([]ErrorGroup(nil), m.Groups...)}
+}
+
+func (m *MultiError) Error() string {
+	if m.Len() == 1 {
+		return m.Groups[0].Statement + ": " + m.Groups[0].Err.Error()
+	}
+	parts := make([]string, len(m.Groups))
+	for i, g := range m.Groups {
+		parts[i] = g.Statement + ": " + g.Cause
+		if g.Count > 1 {
+			parts[i] += " (" + strconv.Itoa(g.Count) + " times)"
+		}
+	}
+	return strconv.Itoa(m.Len()) + " failures: " + strings.Join(parts, "; ")
+}
+
+func (m *MultiError) Unwrap() []error {
+	errs := make([]error, len(m.Groups))
+	for i, g := range m.Groups {
+		errs[i] = g.Err
+	}
+	return errs
+}
+
+// ErrorStatus returns the status a run ending in err should report:
+// a RunError's own status, connection failure for network errors that
+// never reached the server, and a plain error otherwise.
+func ErrorStatus(err error) string {
+	if err == nil {
+		return StatusSuccess
+	}
+	var re *RunError
+	if errors.As(err, &re) {
+		return re.Status
+	}
+	var oe *net.OpError
+	var dnse *net.DNSError
+	if errors.As(err, &oe) || errors.As(err, &dnse) || errors.Is(err, syscall.ECONNREFUSED) {
+		return StatusConnectionFailure
+	}
+	return StatusError
+}
+
+// Finish ends r at the current time with the status for err, or, if the
+// run itself succeeded, for its SLOs.
+func (r *RunResult) Finish(err error) {
+	r.FinishAt(time.Now(), err)
+}
+
+// FinishAt is Finish, ending r at end.
+func (r *RunResult) FinishAt(end time.Time, err error) {
+	r.End = end
+	r.Status, r.Reason = ErrorStatus(err), ""
+	if err != nil {
+		r.Reason = err.Error()
+		return
+	}
+
+	var failed []string
+	for _, s := range r.SLOs {
+		if !s.Pass {
+			failed = append(failed, s.SLO)
+		}
+	}
+	if len(failed) > 0 {
+		r.Status = StatusSLOViolation
+		r.Reason = "failed " + strings.Join(failed, "; ")
+	}
+}
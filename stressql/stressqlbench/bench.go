@@ -0,0 +1,99 @@
+// Package stressqlbench measures how fast stressql generates points, from
+// users' own Go benchmarks, so template shapes can be compared. It is apart
+// from package stressql so programs embedding the runner do not link the
+// testing package.
+package stressqlbench
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// BenchmarkGenerate measures generating stmt's points, one point per
+// iteration, starting over after the last. Besides ns/op it reports bytes
+// per point and points/s:
+//
+//	func BenchmarkCPU(b *testing.B) {
+//		stressqlbench.BenchmarkTemplate(b, "INSERT cpu\ncpu,\nhost=[str rand(8) 1000]\nbusy=[int rand(100) 0]\n1000000 10s")
+//	}
+func BenchmarkGenerate(b *testing.B, stmt *stressql.InsertStatement) {
+	g, err := stressql.Compile(stmt)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	buf := make([]byte, 0, 1<<20)
+	var total int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if len(buf) > 1<<19 {
+			total += int64(len(buf))
+			buf = buf[:0]
+		}
+		buf = g.AppendPoint(buf, int64(i)%g.Points)
+	}
+	b.StopTimer()
+
+	reportGenerate(b, total+int64(len(buf)), time.Since(start))
+}
+
+// BenchmarkGenerateParallel is BenchmarkGenerate with points generated by
+// GOMAXPROCS goroutines sharing one Generator.
+func BenchmarkGenerateParallel(b *testing.B, stmt *stressql.InsertStatement) {
+	g, err := stressql.Compile(stmt)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var next, total int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		buf := make([]byte, 0, 1<<20)
+		var n int64
+		for pb.Next() {
+			if len(buf) > 1<<19 {
+				n += int64(len(buf))
+				buf = buf[:0]
+			}
+			buf = g.AppendPoint(buf, (atomic.AddInt64(&next, 1)-1)%g.Points)
+		}
+		atomic.AddInt64(&total, n+int64(len(buf)))
+	})
+	b.StopTimer()
+
+	reportGenerate(b, total, time.Since(start))
+}
+
+// BenchmarkTemplate parses src as an INSERT statement and runs
+// BenchmarkGenerate on it.
+func BenchmarkTemplate(b *testing.B, src string) {
+	s, err := stressql.ParseStatement(src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stmt, ok := s.(*stressql.InsertStatement)
+	if !ok {
+		b.Fatal(fmt.Errorf("expected INSERT statement, found %T", s))
+	}
+	BenchmarkGenerate(b, stmt)
+}
+
+func reportGenerate(b *testing.B, bytes int64, elapsed time.Duration) {
+	if b.N == 0 {
+		return
+	}
+	b.ReportMetric(float64(bytes)/float64(b.N), "bytes/point")
+	if elapsed > 0 {
+		b.ReportMetric(float64(b.N)/elapsed.Seconds(), "points/s")
+	}
+}
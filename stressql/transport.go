@@ -0,0 +1,156 @@
+package stressql
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransportOptions tune the HTTP connections used to reach the server,
+// which change the load the server sees as much as the points do. They are
+// set in the DSL with
+//
+//	SET maxIdleConnsPerHost 100
+//	SET maxConnsPerHost 0
+//	SET idleConnTimeout 90s
+//	SET keepAlive off
+//	SET protocol http2
+//	SET proxy "socks5://proxy:1080"
+//
+// where zero means no limit.
+type TransportOptions struct {
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	DisableKeepAlives   bool
+	// Protocol is "http1" to force HTTP/1.1, "http2" to attempt HTTP/2 even
+	// with a customized transport, or empty to let the client negotiate.
+	Protocol string
+	// Proxy is the URL of an http, https or socks5 proxy, or "none" to
+	// connect directly. If empty, HTTP_PROXY and friends are honored.
+	Proxy string
+}
+
+// DefaultTransportOptions are used for knobs left unset.
+var DefaultTransportOptions = TransportOptions{
+	MaxIdleConnsPerHost: 100,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// TransportFromVars reads transport options from SET variables.
+func TransportFromVars(vars map[string]string) (TransportOptions, error) {
+	o := DefaultTransportOptions
+	for _, k := range []struct {
+		name string
+		dst  *int
+	}{
+		{"maxIdleConnsPerHost", &o.MaxIdleConnsPerHost},
+		{"maxConnsPerHost", &o.MaxConnsPerHost},
+	} {
+		if v := vars[k.name]; v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return o, fmt.Errorf("invalid %s %q", k.name, v)
+			}
+			*k.dst = n
+		}
+	}
+	var err error
+	if o.IdleConnTimeout, err = durationVar(vars, "idleConnTimeout", o.IdleConnTimeout); err != nil {
+		return o, err
+	}
+	keepAlive, err := boolVar(vars, "keepAlive", true)
+	if err != nil {
+		return o, err
+	}
+	o.DisableKeepAlives = !keepAlive
+	switch v := vars["protocol"]; v {
+	case "", "auto":
+	case "http1", "http2":
+		o.Protocol = v
+	default:
+		return o, fmt.Errorf("invalid protocol %q, expected http1 or http2", v)
+	}
+	if v := vars["proxy"]; v != "" && v != "none" {
+		u, err := url.Parse(v)
+		if err != nil {
+			return o, fmt.Errorf("invalid proxy %q: %v", v, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return o, fmt.Errorf("invalid proxy %q, expected an http, https or socks5 URL", v)
+		}
+	}
+	o.Proxy = vars["proxy"]
+	return o, nil
+}
+
+// Transport returns an http.Transport with the options applied.
+func (o TransportOptions) Transport() *http.Transport {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       o.MaxConnsPerHost,
+		IdleConnTimeout:       o.IdleConnTimeout,
+		DisableKeepAlives:     o.DisableKeepAlives,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	switch o.Proxy {
+	case "":
+	case "none":
+		t.Proxy = nil
+	default:
+		if u, err := url.Parse(o.Proxy); err == nil {
+			t.Proxy = http.ProxyURL(u)
+		}
+	}
+	switch o.Protocol {
+	case "http1":
+		// A non-nil, empty TLSNextProto disables HTTP/2 negotiation.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case "http2":
+		t.ForceAttemptHTTP2 = true
+	}
+	return t
+}
+
+// Client returns an http.Client using the options' transport, suitable for
+// HTTPWriter.Client.
+func (o TransportOptions) Client() *http.Client {
+	return o.ClientFor("")
+}
+
+// ClientFor is like Client, but the client dials the Unix domain socket of a
+// unix:// addr, such as unix:///var/run/influxdb.sock, so a co-located
+// server can be measured without the network stack.
+func (o TransportOptions) ClientFor(addr string) *http.Client {
+	t := o.Transport()
+	if path, ok := unixSocket(addr); ok {
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+	}
+	return &http.Client{Transport: t}
+}
+
+// unixSocket returns the socket path of a unix:// address.
+func unixSocket(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, "unix://"), true
+}
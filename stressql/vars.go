@@ -0,0 +1,242 @@
+package stressql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// VarType is the type of a variable's value.
+type VarType int
+
+// Variable types.
+const (
+	VarString VarType = iota
+	VarInt
+	VarFloat
+	VarDuration
+	VarBool
+)
+
+func (t VarType) String() string {
+	switch t {
+	case VarInt:
+		return "int"
+	case VarFloat:
+		return "float"
+	case VarDuration:
+		return "duration"
+	case VarBool:
+		return "bool"
+	}
+	return "string"
+}
+
+// Value is a variable's value, typed by how it is written: on, off, true
+// and false are bools, 10 an int, 0.5 a float, 10s a duration, and
+// anything else a string.
+type Value struct {
+	Type VarType
+	// Text is the value as written.
+	Text string
+
+	i int64
+	f float64
+	d time.Duration
+}
+
+// ParseValue returns the value written s.
+func ParseValue(s string) Value {
+	v := Value{Type: VarString, Text: s}
+	switch s {
+	case "on", "true":
+		v.Type, v.i = VarBool, 1
+		return v
+	case "off", "false":
+		v.Type = VarBool
+		return v
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		v.Type, v.i, v.f = VarInt, i, float64(i)
+	} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+		v.Type, v.f = VarFloat, f
+	} else if d, err := time.ParseDuration(s); err == nil {
+		v.Type, v.d = VarDuration, d
+	}
+	return v
+}
+
+func (v Value) String() string { return v.Text }
+
+// Int returns an int value.
+func (v Value) Int() (int64, error) {
+	if v.Type != VarInt {
+		return 0, v.typeError(VarInt)
+	}
+	return v.i, nil
+}
+
+// Float returns a float or int value.
+func (v Value) Float() (float64, error) {
+	if v.Type != VarFloat && v.Type != VarInt {
+		return 0, v.typeError(VarFloat)
+	}
+	return v.f, nil
+}
+
+// Duration returns a duration value, or 0 written as an int.
+func (v Value) Duration() (time.Duration, error) {
+	if v.Type == VarInt && v.i == 0 {
+		return 0, nil
+	}
+	if v.Type != VarDuration {
+		return 0, v.typeError(VarDuration)
+	}
+	return v.d, nil
+}
+
+// Bool returns a bool value, or 0 or 1 written as an int. The empty
+// string, as of an unset variable, is false.
+func (v Value) Bool() (bool, error) {
+	switch {
+	case v.Text == "":
+		return false, nil
+	case v.Type == VarBool, v.Type == VarInt && (v.i == 0 || v.i == 1):
+		return v.i == 1, nil
+	}
+	return false, v.typeError(VarBool)
+}
+
+// Equal reports whether v and w are the same value: equal numbers,
+// durations or bools, or otherwise written the same.
+func (v Value) Equal(w Value) bool {
+	switch {
+	case (v.Type == VarInt || v.Type == VarFloat) && (w.Type == VarInt || w.Type == VarFloat):
+		return v.f == w.f
+	case v.Type != w.Type:
+		return false
+	case v.Type == VarDuration:
+		return v.d == w.d
+	case v.Type == VarBool:
+		return v.i == w.i
+	}
+	return v.Text == w.Text
+}
+
+func (v Value) typeError(want VarType) error {
+	return fmt.Errorf("%q is of type %s, not %s", v.Text, v.Type, want)
+}
+
+// intVar returns the positive integer variable name, or def if it is unset.
+func intVar(vars map[string]string, name string, def int) (int, error) {
+	v := vars[name]
+	if v == "" {
+		return def, nil
+	}
+	n, err := ParseValue(v).Int()
+	if err != nil || n <= 0 || int64(int(n)) != n {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return int(n), nil
+}
+
+// boolVar returns the on or off variable name, or def if it is unset.
+func boolVar(vars map[string]string, name string, def bool) (bool, error) {
+	v := vars[name]
+	if v == "" {
+		return def, nil
+	}
+	b, err := ParseValue(v).Bool()
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, expected on or off", name, v)
+	}
+	return b, nil
+}
+
+// durationVar returns the non-negative duration variable name, or def if
+// it is unset.
+func durationVar(vars map[string]string, name string, def time.Duration) (time.Duration, error) {
+	v := vars[name]
+	if v == "" {
+		return def, nil
+	}
+	d, err := ParseValue(v).Duration()
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return d, nil
+}
+
+// VarStore holds a run's variables, shared by its statements, including
+// those GO runs concurrently. SET and USE write it, in config order. A
+// statement reads its variables from the copy in its ExecEnv's Vars, as
+// they were when it started, so what it does is settled then. intVar,
+// boolVar and durationVar type those copies as the store types its own
+// values. Statements that coordinate with others wait on the store
+// itself, as GO UNTIL does. It is safe for concurrent use.
+type VarStore struct {
+	mu   sync.RWMutex
+	vars map[string]Value
+	// changed is closed, and replaced, when a variable is set.
+	changed chan struct{}
+}
+
+// NewVarStore returns a store holding vars.
+func NewVarStore(vars map[string]string) *VarStore {
+	s := &VarStore{vars: make(map[string]Value, len(vars)), changed: make(chan struct{})}
+	for k, v := range vars {
+		s.vars[k] = ParseValue(v)
+	}
+	return s
+}
+
+// Get returns the value of the variable name, and whether it is set.
+func (s *VarStore) Get(name string) (Value, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.vars[name]
+	return v, ok
+}
+
+// Set sets the variable name to the value written v, and returns it.
+func (s *VarStore) Set(name, v string) Value {
+	val := ParseValue(v)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vars[name] = val
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return val
+}
+
+// Snapshot returns the variables as written, as they are now.
+func (s *VarStore) Snapshot() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	vars := make(map[string]string, len(s.vars))
+	for k, v := range s.vars {
+		vars[k] = v.Text
+	}
+	return vars
+}
+
+// Wait waits until the variable name is set to a value ok accepts, which
+// may be the value it has already, and returns it. ok is called with the
+// zero Value while name is unset.
+func (s *VarStore) Wait(ctx context.Context, name string, ok func(Value) bool) (Value, error) {
+	for {
+		s.mu.RLock()
+		v, changed := s.vars[name], s.changed
+		s.mu.RUnlock()
+		if ok(v) {
+			return v, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Value{}, ctx.Err()
+		}
+	}
+}
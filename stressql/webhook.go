@@ -0,0 +1,144 @@
+package stressql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Run lifecycle events sent to webhooks.
+const (
+	WebhookStart     = "start"
+	WebhookComplete  = "complete"
+	WebhookSLOFailed = "slo_failed"
+	WebhookAbort     = "abort"
+)
+
+// Webhook posts a JSON WebhookPayload to URL on run lifecycle events, for
+// chat-ops and incident tooling. Webhooks are set in the DSL with
+//
+//	SET webhook "https://hooks.example.com/stress"
+//	SET webhook:oncall "https://oncall.example.com/hook"
+//	SET webhookEvents "slo_failed,abort"
+//
+// where webhookEvents, if set, limits the events sent.
+type Webhook struct {
+	URL string
+	// Events are the events to send; empty sends all of them.
+	Events []string
+	// Client defaults to one with a 10 second timeout.
+	Client *http.Client
+}
+
+// WebhookPayload is the body posted to a webhook. Result is the run report
+// so far: empty at start, final on completion.
+type WebhookPayload struct {
+	Event  string     `json:"event"`
+	Time   time.Time  `json:"time"`
+	Reason string     `json:"reason,omitempty"`
+	Result *RunResult `json:"result,omitempty"`
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notify posts event to the webhook if it is one of its Events.
+func (w *Webhook) Notify(ctx context.Context, event string, r *RunResult, reason string) error {
+	if !w.wants(event) {
+		return nil
+	}
+
+	body, err := json.Marshal(WebhookPayload{Event: event, Time: time.Now().UTC(), Reason: reason, Result: r})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = webhookClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %v", event, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", event, resp.Status)
+	}
+	return nil
+}
+
+func (w *Webhook) wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhooks notifies several webhooks.
+type Webhooks []*Webhook
+
+// Notify notifies every webhook, returning the first error.
+func (ws Webhooks) Notify(ctx context.Context, event string, r *RunResult, reason string) error {
+	var first error
+	for _, w := range ws {
+		if err := w.Notify(ctx, event, r, reason); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// WebhooksFromVars returns the webhooks set by SET variables.
+func WebhooksFromVars(vars map[string]string) (Webhooks, error) {
+	var events []string
+	if v := vars["webhookEvents"]; v != "" {
+		for _, e := range strings.Split(v, ",") {
+			e = strings.TrimSpace(e)
+			switch e {
+			case WebhookStart, WebhookComplete, WebhookSLOFailed, WebhookAbort:
+				events = append(events, e)
+			default:
+				return nil, fmt.Errorf("invalid webhookEvents %q, expected start, complete, slo_failed or abort", e)
+			}
+		}
+	}
+
+	var keys []string
+	for k := range vars {
+		if k == "webhook" || strings.HasPrefix(k, "webhook:") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var ws Webhooks
+	for _, k := range keys {
+		if vars[k] == "" {
+			continue
+		}
+		if !strings.HasPrefix(vars[k], "http://") && !strings.HasPrefix(vars[k], "https://") {
+			return nil, fmt.Errorf("invalid %s %q, expected an http or https URL", k, vars[k])
+		}
+		ws = append(ws, &Webhook{URL: vars[k], Events: events})
+	}
+	return ws, nil
+}
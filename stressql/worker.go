@@ -0,0 +1,28 @@
+package stressql
+
+import (
+	"os"
+)
+
+// WorkerTag returns the tag to add to every series written by this client,
+// so data from distributed workers never collides and each worker's writes
+// can be counted server-side. It is set with
+//
+//	SET workerTag worker
+//	SET workerID 3
+//
+// where workerID defaults to the hostname. An empty key means no tag.
+func WorkerTag(vars map[string]string) (key, value string, err error) {
+	key = vars["workerTag"]
+	if key == "" {
+		return "", "", nil
+	}
+
+	value = vars["workerID"]
+	if value == "" {
+		if value, err = os.Hostname(); err != nil {
+			return "", "", err
+		}
+	}
+	return key, value, nil
+}
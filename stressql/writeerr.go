@@ -0,0 +1,95 @@
+package stressql
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Causes of points dropped from a write, as reported by the server.
+const (
+	DropFieldTypeConflict = "field type conflict"
+	DropBeyondRetention   = "beyond retention policy"
+	DropMaxValuesPerTag   = "max-values-per-tag"
+	DropMaxSeries         = "max-series-per-database"
+	DropParse             = "unable to parse"
+	DropOther             = "other"
+)
+
+// WriteError is a failed or partially failed write. For a partial write,
+// Dropped attributes the points the server rejected to their causes.
+type WriteError struct {
+	Status  int
+	Message string
+	Partial bool
+	Dropped map[string]int64
+	// RequestID is the ID sent with the request, and ServerRequestID the
+	// request or trace ID the server responded with, for finding the
+	// request in server logs.
+	RequestID       string
+	ServerRequestID string
+}
+
+func (e *WriteError) Error() string {
+	msg := fmt.Sprintf("write: %d: %s", e.Status, e.Message)
+	switch {
+	case e.ServerRequestID != "" && e.ServerRequestID != e.RequestID:
+		msg += fmt.Sprintf(" (request %s, server %s)", e.RequestID, e.ServerRequestID)
+	case e.RequestID != "":
+		msg += fmt.Sprintf(" (request %s)", e.RequestID)
+	}
+	return msg
+}
+
+var (
+	dropCauses = []struct {
+		cause  string
+		phrase string
+	}{
+		{DropFieldTypeConflict, "field type conflict"},
+		{DropBeyondRetention, "beyond retention policy"},
+		{DropMaxValuesPerTag, "max-values-per-tag limit exceeded"},
+		{DropMaxSeries, "max-series-per-database limit exceeded"},
+		{DropParse, "unable to parse"},
+	}
+	droppedCount = regexp.MustCompile(`dropped=(\d+)`)
+)
+
+// ParseWriteError interprets a non-2xx /write response. The body is the
+// server's JSON error, or plain text from a proxy.
+func ParseWriteError(status int, body []byte) *WriteError {
+	msg := strings.TrimSpace(string(body))
+	var v struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &v) == nil && v.Error != "" {
+		msg = v.Error
+	}
+
+	e := &WriteError{Status: status, Message: msg}
+	if !strings.HasPrefix(msg, "partial write") {
+		return e
+	}
+	e.Partial = true
+	e.Dropped = map[string]int64{}
+
+	// Each cause reports its own dropped=N, and a response may carry
+	// several, so attribute every count to the nearest cause before it.
+	prev := 0
+	for _, m := range droppedCount.FindAllStringSubmatchIndex(msg, -1) {
+		seg := msg[prev:m[1]]
+		prev = m[1]
+
+		n, _ := strconv.ParseInt(msg[m[2]:m[3]], 10, 64)
+		cause, at := DropOther, -1
+		for _, c := range dropCauses {
+			if i := strings.LastIndex(seg, c.phrase); i > at {
+				cause, at = c.cause, i
+			}
+		}
+		e.Dropped[cause] += n
+	}
+	return e
+}
@@ -0,0 +1,114 @@
+package stresstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	mdstress "github.com/mjdesa/stress_parser"
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// MarshalAST serializes statements deterministically for golden-file
+// comparison. Each statement is an object keyed by its type, and fields
+// are sorted by name. Fields with zero values are left out, so adding a
+// field to the grammar does not change the golden files of configs that
+// do not use it.
+func MarshalAST(seq []stressql.Statement) ([]byte, error) {
+	nodes := make([]interface{}, len(seq))
+	for i, s := range seq {
+		nodes[i] = astValue(reflect.ValueOf(&s).Elem())
+	}
+	b, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// ParseAST parses a config and serializes it with MarshalAST.
+func ParseAST(src string) ([]byte, error) {
+	seq, err := mdstress.ParseString(src)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalAST(seq)
+}
+
+var posType = reflect.TypeOf(stressql.Pos{})
+
+// astValue returns v as a value json encodes with its keys sorted: structs
+// become maps, and interfaces objects keyed by their dynamic type.
+func astValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		e := v.Elem()
+		t := e.Type()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		return map[string]interface{}{t.Name(): astValue(e)}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return astValue(v.Elem())
+	case reflect.Struct:
+		m := map[string]interface{}{}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := v.Field(i)
+			// Positions change whenever a config is edited above a
+			// statement.
+			if t.Field(i).PkgPath != "" || f.IsZero() || f.Type() == posType {
+				continue
+			}
+			m[t.Field(i).Name] = astValue(f)
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		l := make([]interface{}, v.Len())
+		for i := range l {
+			l[i] = astValue(v.Index(i))
+		}
+		return l
+	case reflect.Map:
+		m := map[string]interface{}{}
+		iter := v.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = astValue(iter.Value())
+		}
+		return m
+	}
+	return v.Interface()
+}
+
+// UpdateGoldenEnv names the environment variable that, set to any value,
+// makes Golden rewrite golden files rather than compare against them.
+const UpdateGoldenEnv = "STRESSTEST_UPDATE_GOLDEN"
+
+// Golden fails t if got differs from the contents of the golden file at
+// path. With UpdateGoldenEnv set, it writes got to path instead.
+func Golden(t testing.TB, path string, got []byte) {
+	t.Helper()
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (set %s=1 to create it)", err, UpdateGoldenEnv)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file (set %s=1 to update it):\n%s", path, UpdateGoldenEnv, got)
+	}
+}
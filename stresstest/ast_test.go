@@ -0,0 +1,32 @@
+package stresstest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseASTGolden locks down how the sample configs parse, or fail to.
+// Set STRESSTEST_UPDATE_GOLDEN=1 to update testdata after changing the
+// grammar.
+func TestParseASTGolden(t *testing.T) {
+	paths, err := filepath.Glob("../stressql/*.iql")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no samples: %v", err)
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".iql")
+		t.Run(name, func(t *testing.T) {
+			src, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseAST(string(src))
+			if err != nil {
+				got = []byte("error: " + err.Error() + "\n")
+			}
+			Golden(t, filepath.Join("testdata", name+".golden"), got)
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package stresstest
+
+import (
+	"github.com/influxdata/influxdb/models"
+)
+
+// ParseLines validates a body of line protocol with InfluxDB's own parser,
+// returning the series key of each point, in order. The error names the
+// lines that do not parse.
+func ParseLines(body []byte) ([]string, error) {
+	points, err := models.ParsePoints(body)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(points))
+	for i, p := range points {
+		keys[i] = string(p.Key())
+	}
+	return keys, nil
+}
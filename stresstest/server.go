@@ -0,0 +1,246 @@
+// Package stresstest helps test stress configs and the programs that run
+// them. Its fake InfluxDB server validates the line protocol written to it,
+// records what was written and queried, and can be configured to respond
+// slowly, fail, or drop part of each write, as a server under load does.
+// MarshalAST and Golden lock down how configs parse.
+package stresstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config shapes how a Server responds.
+type Config struct {
+	// Latency delays every response, and Jitter adds up to that much more
+	// at random.
+	Latency time.Duration
+	Jitter  time.Duration
+	// ErrorRate is the fraction of requests failed with a 500.
+	ErrorRate float64
+	// PartialWriteRate is the fraction of valid writes answered as partial
+	// writes, dropping PartialWriteDrop of their points, or half if unset,
+	// for field type conflicts.
+	PartialWriteRate float64
+	PartialWriteDrop float64
+	// Respond, if set, returns the body of a successful query response. By
+	// default every query returns an empty result.
+	Respond func(db, q string) string
+	// Seed seeds the choice of responses to fail or delay, so runs are
+	// reproducible.
+	Seed int64
+}
+
+// Server is a fake InfluxDB serving /write, /query and /ping. Close it when
+// done.
+type Server struct {
+	*httptest.Server
+	cfg Config
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	points  map[string]int64
+	series  map[string]map[string]bool
+	queries []Query
+	writes  int64
+	errors  int64
+}
+
+// Query is a query received by a Server.
+type Query struct {
+	Database string
+	Query    string
+}
+
+// NewServer starts a Server configured by cfg.
+func NewServer(cfg Config) *Server {
+	s := &Server{
+		cfg:    cfg,
+		rand:   rand.New(rand.NewSource(cfg.Seed)),
+		points: map[string]int64{},
+		series: map[string]map[string]bool{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Addr returns the server's address as SET addresses expects it.
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if id := r.Header.Get("Request-Id"); id != "" {
+		w.Header().Set("Request-Id", id)
+	}
+	if r.URL.Path == "/ping" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	delay := s.cfg.Latency
+	if s.cfg.Jitter > 0 {
+		delay += time.Duration(s.rand.Int63n(int64(s.cfg.Jitter)))
+	}
+	fail := s.rand.Float64() < s.cfg.ErrorRate
+	partial := s.rand.Float64() < s.cfg.PartialWriteRate
+	s.mu.Unlock()
+
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		}
+	}
+	if fail {
+		s.mu.Lock()
+		s.errors++
+		s.mu.Unlock()
+		writeError(w, http.StatusInternalServerError, "simulated failure")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/write":
+		s.write(w, r, body, partial)
+	case "/query":
+		s.query(w, r, body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) write(w http.ResponseWriter, r *http.Request, body []byte, partial bool) {
+	db := r.URL.Query().Get("db")
+	if db == "" {
+		writeError(w, http.StatusBadRequest, "database is required")
+		return
+	}
+	if p := r.URL.Query().Get("precision"); p != "" && p != "n" && p != "ns" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unexpected precision %q", p))
+		return
+	}
+	keys, err := ParseLines(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dropped := 0
+	if partial {
+		frac := s.cfg.PartialWriteDrop
+		if frac <= 0 {
+			frac = 0.5
+		}
+		dropped = int(float64(len(keys)) * frac)
+		if dropped == 0 && len(keys) > 0 {
+			dropped = 1
+		}
+		keys = keys[:len(keys)-dropped]
+	}
+
+	s.mu.Lock()
+	s.writes++
+	s.points[db] += int64(len(keys))
+	if s.series[db] == nil {
+		s.series[db] = map[string]bool{}
+	}
+	for _, k := range keys {
+		s.series[db][k] = true
+	}
+	s.mu.Unlock()
+
+	if dropped > 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("partial write: field type conflict: simulated dropped=%d", dropped))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request, body []byte) {
+	v := r.URL.Query()
+	if form, err := url.ParseQuery(string(body)); err == nil && v.Get("q") == "" {
+		// A form-encoded POST.
+		v = form
+	}
+	q, db := v.Get("q"), v.Get("db")
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "missing required parameter \"q\"")
+		return
+	}
+	s.mu.Lock()
+	s.queries = append(s.queries, Query{Database: db, Query: q})
+	s.mu.Unlock()
+
+	resp := `{"results":[{"statement_id":0}]}`
+	if s.cfg.Respond != nil {
+		resp = s.cfg.Respond(db, q)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, resp)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Influxdb-Error", msg)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// Points returns the number of points written to db, not counting those
+// dropped.
+func (s *Server) Points(db string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.points[db]
+}
+
+// Series returns the distinct series keys written to db, sorted.
+func (s *Server) Series(db string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.series[db]))
+	for k := range s.series[db] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Queries returns the queries received, in the order they were.
+func (s *Server) Queries() []Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Query(nil), s.queries...)
+}
+
+// Writes returns the number of writes accepted, including partial writes.
+func (s *Server) Writes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writes
+}
+
+// Errors returns the number of requests failed at random.
+func (s *Server) Errors() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.errors
+}
@@ -0,0 +1,113 @@
+package stresstest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mdstress "github.com/mjdesa/stress_parser"
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// insertCPU writes 100 points of 10 series to stress, in batches of
+// SET batchSize.
+const insertCPU = `INSERT cpu
+cpu,
+host=server-[int inc(0) 10]
+v=[int rand(100) 0]
+100 10s`
+
+// run runs a config against srv.
+func run(t *testing.T, srv *Server, src string) (*stressql.RunResult, error) {
+	t.Helper()
+	seq, err := mdstress.ParseString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := stressql.NewRunner(stressql.Config{Statements: seq},
+		stressql.WithVars(map[string]string{"addresses": srv.Addr(), "database": "stress"}),
+		stressql.WithSeed(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.Run(context.Background())
+}
+
+func TestServerLatency(t *testing.T) {
+	srv := NewServer(Config{Latency: 20 * time.Millisecond})
+	defer srv.Close()
+
+	res, err := run(t, srv, "SET batchSize 50\n\n"+insertCPU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins := res.Inserts["cpu"]
+	if ins.Batches != 2 || ins.Accepted != 100 || srv.Points("stress") != 100 {
+		t.Fatalf("%d batches, %d points accepted, %d written, want 2, 100, 100",
+			ins.Batches, ins.Accepted, srv.Points("stress"))
+	}
+	if ins.Latency.Count != 2 || ins.Latency.P50 < 20*time.Millisecond {
+		t.Fatalf("latency %+v, want 2 writes of at least 20ms", ins.Latency)
+	}
+	if got := srv.Series("stress"); len(got) != 10 || got[0] != "cpu,host=server-0" {
+		t.Fatalf("series %q, want 10 from cpu,host=server-0", got)
+	}
+}
+
+func TestServerErrorRate(t *testing.T) {
+	srv := NewServer(Config{ErrorRate: 0.3, Seed: 1})
+	defer srv.Close()
+
+	res, _ := run(t, srv, "SET batchSize 1\n\n"+insertCPU)
+	ins := res.Inserts["cpu"]
+	if srv.Errors() == 0 || srv.Errors() == 100 {
+		t.Fatalf("server failed %d of 100 writes, want some", srv.Errors())
+	}
+	if ins.Failed != srv.Errors() || ins.Errors != srv.Errors() {
+		t.Fatalf("%d points failed in %d errors, want %d", ins.Failed, ins.Errors, srv.Errors())
+	}
+	if ins.Accepted != srv.Points("stress") || ins.Accepted+ins.Failed != 100 {
+		t.Fatalf("%d points accepted, %d failed, server has %d, want %d of 100 accepted",
+			ins.Accepted, ins.Failed, srv.Points("stress"), srv.Points("stress"))
+	}
+}
+
+func TestServerPartialWrites(t *testing.T) {
+	srv := NewServer(Config{PartialWriteRate: 1, PartialWriteDrop: 0.2})
+	defer srv.Close()
+
+	res, _ := run(t, srv, "SET batchSize 10\n\n"+insertCPU)
+	ins := res.Inserts["cpu"]
+	if ins.Points != 100 || ins.Failed != 0 {
+		t.Fatalf("%d points sent, %d failed, want 100, 0", ins.Points, ins.Failed)
+	}
+	if n := ins.Dropped[stressql.DropFieldTypeConflict]; n != 20 || len(ins.Dropped) != 1 {
+		t.Fatalf("dropped %v, want 20 to %s", ins.Dropped, stressql.DropFieldTypeConflict)
+	}
+	if ins.Accepted != 80 || srv.Points("stress") != 80 {
+		t.Fatalf("%d points accepted, server has %d, want 80", ins.Accepted, srv.Points("stress"))
+	}
+	if srv.Writes() != 10 {
+		t.Fatalf("server accepted %d writes, want 10 partial writes", srv.Writes())
+	}
+}
+
+func TestServerErrorBudget(t *testing.T) {
+	srv := NewServer(Config{ErrorRate: 1})
+	defer srv.Close()
+
+	res, err := run(t, srv, "SET batchSize 1\n\nSET errorBudget \"10%\"\n\nSET errorBudgetMin 5\n\n"+insertCPU)
+	var re *stressql.RunError
+	if !errors.As(err, &re) || re.Status != stressql.StatusErrorBudget || res.Status != stressql.StatusErrorBudget {
+		t.Fatalf("run ended with %v, status %q, want %s", err, res.Status, stressql.StatusErrorBudget)
+	}
+	// The budget applies from the fifth write, and the writer may have the
+	// next in flight as it stops.
+	if n := srv.Errors(); n < 5 || n > 6 {
+		t.Fatalf("server failed %d writes, want the run stopped after 5", n)
+	}
+	if ins := res.Inserts["cpu"]; ins.Accepted != 0 || ins.Failed != srv.Errors() {
+		t.Fatalf("%d points accepted, %d failed, want 0, %d", ins.Accepted, ins.Failed, srv.Errors())
+	}
+}
@@ -0,0 +1,241 @@
+package mdstress
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// TelegrafOptions sizes the fleet an imported Telegraf config is simulated
+// for.
+type TelegrafOptions struct {
+	// Hosts is the number of agents writing, each with a distinct host tag.
+	Hosts int
+	// Duration is the span of data written per host.
+	Duration time.Duration
+	// SkipUnknown ignores input plugins without a known schema instead of
+	// returning an error.
+	SkipUnknown bool
+}
+
+type telegrafConfig struct {
+	GlobalTags map[string]string `toml:"global_tags"`
+	Agent      struct {
+		Interval string `toml:"interval"`
+	} `toml:"agent"`
+	Inputs map[string][]map[string]interface{} `toml:"inputs"`
+}
+
+// telegrafSchema describes the series an input plugin produces per host.
+type telegrafSchema struct {
+	tags   []WorkloadTag
+	ints   []string
+	floats []string
+}
+
+var telegrafSchemas = map[string]telegrafSchema{
+	"cpu": {
+		tags:   []WorkloadTag{{Key: "cpu", Values: []string{"cpu-total"}}},
+		floats: []string{"usage_user", "usage_system", "usage_idle", "usage_iowait", "usage_irq", "usage_softirq", "usage_steal", "usage_nice", "usage_guest"},
+	},
+	"mem": {
+		ints:   []string{"total", "available", "used", "free", "cached", "buffered"},
+		floats: []string{"used_percent", "available_percent"},
+	},
+	"swap": {
+		ints:   []string{"total", "used", "free", "in", "out"},
+		floats: []string{"used_percent"},
+	},
+	"disk": {
+		tags: []WorkloadTag{
+			{Key: "device", Values: []string{"sda1", "sda2"}},
+			{Key: "fstype", Values: []string{"ext4"}},
+			{Key: "mode", Values: []string{"rw"}},
+		},
+		ints:   []string{"total", "free", "used", "inodes_total", "inodes_free", "inodes_used"},
+		floats: []string{"used_percent"},
+	},
+	"diskio": {
+		tags: []WorkloadTag{{Key: "name", Values: []string{"sda", "sdb"}}},
+		ints: []string{"reads", "writes", "read_bytes", "write_bytes", "read_time", "write_time", "io_time", "iops_in_progress"},
+	},
+	"net": {
+		tags: []WorkloadTag{{Key: "interface", Values: []string{"eth0"}}},
+		ints: []string{"bytes_sent", "bytes_recv", "packets_sent", "packets_recv", "err_in", "err_out", "drop_in", "drop_out"},
+	},
+	"system": {
+		ints:   []string{"n_cpus", "n_users", "uptime"},
+		floats: []string{"load1", "load5", "load15"},
+	},
+	"processes": {
+		ints: []string{"running", "sleeping", "blocked", "zombies", "stopped", "total", "total_threads"},
+	},
+	"kernel": {
+		ints: []string{"boot_time", "context_switches", "interrupts", "processes_forked", "entropy_avail"},
+	},
+}
+
+// ImportTelegraf reads a Telegraf config and synthesizes one INSERT per input
+// plugin, shaped like the measurements the plugin reports and written at its
+// collection interval by opts.Hosts agents. The inserts run concurrently.
+func ImportTelegraf(file string, opts TelegrafOptions) ([]stressql.Statement, error) {
+	if opts.Hosts <= 0 {
+		opts.Hosts = 1
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = time.Hour
+	}
+
+	c := &telegrafConfig{}
+	if _, err := toml.DecodeFile(file, c); err != nil {
+		return nil, err
+	}
+
+	interval := c.Agent.Interval
+	if interval == "" {
+		interval = "10s"
+	}
+
+	names := make([]string, 0, len(c.Inputs))
+	for name := range c.Inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seq := []stressql.Statement{}
+	for _, name := range names {
+		schema, ok := telegrafSchemas[name]
+		if !ok {
+			if opts.SkipUnknown {
+				continue
+			}
+			return nil, fmt.Errorf("inputs.%s: no known schema", name)
+		}
+
+		for i, plugin := range c.Inputs[name] {
+			m, err := telegrafMeasurement(name, i, schema, plugin, c.GlobalTags, interval, opts)
+			if err != nil {
+				return nil, fmt.Errorf("inputs.%s: %v", name, err)
+			}
+			stmt, err := m.statement()
+			if err != nil {
+				return nil, fmt.Errorf("inputs.%s: %v", name, err)
+			}
+			seq = append(seq, &stressql.GoStatement{Statement: stmt})
+		}
+	}
+
+	if len(seq) > 0 {
+		seq = append(seq, &stressql.WaitStatement{})
+	}
+
+	return seq, nil
+}
+
+func telegrafMeasurement(name string, n int, schema telegrafSchema, plugin map[string]interface{}, global map[string]string, interval string, opts TelegrafOptions) (*WorkloadMeasurement, error) {
+	if v, ok := plugin["interval"].(string); ok {
+		interval = v
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid interval %q", interval)
+	}
+
+	m := &WorkloadMeasurement{
+		Name:        "telegraf_" + name,
+		Measurement: name,
+		Interval:    interval,
+	}
+	if n > 0 {
+		m.Name += "_" + strconv.Itoa(n)
+	}
+	if v, ok := plugin["name_override"].(string); ok {
+		m.Measurement = v
+	}
+
+	m.Tags = append(m.Tags, WorkloadTag{
+		Key:       "host",
+		Generator: fmt.Sprintf("int inc(0) %d", opts.Hosts),
+	})
+
+	series := int64(opts.Hosts)
+	for _, t := range schema.tags {
+		t.Values = telegrafTagValues(name, t, plugin)
+		series *= int64(len(t.Values))
+		m.Tags = append(m.Tags, t)
+	}
+
+	static := map[string]string{}
+	for k, v := range global {
+		static[k] = v
+	}
+	if tags, ok := plugin["tags"].(map[string]interface{}); ok {
+		for k, v := range tags {
+			static[k] = fmt.Sprint(v)
+		}
+	}
+	keys := make([]string, 0, len(static))
+	for k := range static {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		m.Tags = append(m.Tags, WorkloadTag{Key: k, Values: []string{static[k]}})
+	}
+
+	for _, f := range schema.ints {
+		m.Fields = append(m.Fields, WorkloadField{Key: f, Generator: "int rand(100000) 0"})
+	}
+	for _, f := range schema.floats {
+		m.Fields = append(m.Fields, WorkloadField{Key: f, Generator: "float rand(100) 0"})
+	}
+
+	m.Points = series * int64(opts.Duration/d)
+	if m.Points <= 0 {
+		m.Points = series
+	}
+
+	return m, nil
+}
+
+// telegrafTagValues applies the plugin options that change which tag values
+// the plugin reports.
+func telegrafTagValues(name string, t WorkloadTag, plugin map[string]interface{}) []string {
+	list := func(key string) []string {
+		vs, _ := plugin[key].([]interface{})
+		out := make([]string, 0, len(vs))
+		for _, v := range vs {
+			out = append(out, strings.TrimPrefix(fmt.Sprint(v), "/dev/"))
+		}
+		return out
+	}
+
+	switch {
+	case name == "cpu" && t.Key == "cpu":
+		values := []string{}
+		if total, ok := plugin["totalcpu"].(bool); !ok || total {
+			values = append(values, "cpu-total")
+		}
+		if per, ok := plugin["percpu"].(bool); !ok || per {
+			values = append(values, "cpu0", "cpu1", "cpu2", "cpu3")
+		}
+		if len(values) > 0 {
+			return values
+		}
+	case name == "net" && t.Key == "interface":
+		if vs := list("interfaces"); len(vs) > 0 {
+			return vs
+		}
+	case name == "diskio" && t.Key == "name":
+		if vs := list("devices"); len(vs) > 0 {
+			return vs
+		}
+	}
+
+	return t.Values
+}
@@ -0,0 +1,49 @@
+package mdstress
+
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// ParseTOML reads a workload in its TOML representation and converts it to
+// statements.
+//
+//	setup = ["CREATE DATABASE stress"]
+//
+//	[vars]
+//	  database = "stress"
+//	  concurrency = "10"
+//
+//	[[phases]]
+//	  name = "ingest"
+//	  concurrent = true
+//
+//	  [[phases.measurements]]
+//	    name = "mockCpu"
+//	    measurement = "cpu"
+//	    points = 100000
+//	    interval = "10s"
+//	    tags = [
+//	      {key = "host", values = ["us-west", "us-east"]},
+//	      {key = "server_id", generator = "str rand(7) 1000"},
+//	    ]
+//	    fields = [{key = "busy", generator = "int rand(1000) 100"}]
+//
+//	  [[phases.queries]]
+//	    name = "mockCpu"
+//	    query = "SELECT mean(%f) FROM %m WHERE %t"
+//	    count = 10000
+func ParseTOML(file string) ([]stressql.Statement, error) {
+	w := &Workload{}
+	md, err := toml.DecodeFile(file, w)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown key %q", undecoded[0].String())
+	}
+
+	return w.Statements()
+}
@@ -0,0 +1,279 @@
+package mdstress
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mjdesa/stress_parser/stressql"
+)
+
+// Workload is the structured representation of a config, for tooling that
+// generates workloads rather than writing DSL text. It converts to the same
+// statements ParseCommands produces.
+type Workload struct {
+	Vars   map[string]string `json:"vars,omitempty" yaml:"vars" toml:"vars"`
+	Setup  []string          `json:"setup,omitempty" yaml:"setup" toml:"setup"`
+	Phases []WorkloadPhase   `json:"phases,omitempty" yaml:"phases" toml:"phases"`
+}
+
+// WorkloadPhase is a group of inserts and queries. A concurrent phase runs
+// every statement with GO and waits for all of them before the next phase.
+type WorkloadPhase struct {
+	Name         string                `json:"name,omitempty" yaml:"name" toml:"name"`
+	Vars         map[string]string     `json:"vars,omitempty" yaml:"vars" toml:"vars"`
+	Concurrent   bool                  `json:"concurrent,omitempty" yaml:"concurrent" toml:"concurrent"`
+	Measurements []WorkloadMeasurement `json:"measurements,omitempty" yaml:"measurements" toml:"measurements"`
+	Queries      []WorkloadQuery       `json:"queries,omitempty" yaml:"queries" toml:"queries"`
+	Meta         []WorkloadMeta        `json:"meta,omitempty" yaml:"meta" toml:"meta"`
+	// SLOs are objectives for the phase's statements, such as "p99 < 200ms".
+	SLOs []string `json:"slos,omitempty" yaml:"slos" toml:"slos"`
+}
+
+type WorkloadMeasurement struct {
+	Name        string          `json:"name,omitempty" yaml:"name" toml:"name"`
+	Measurement string          `json:"measurement,omitempty" yaml:"measurement" toml:"measurement"`
+	Tags        []WorkloadTag   `json:"tags,omitempty" yaml:"tags" toml:"tags"`
+	Fields      []WorkloadField `json:"fields,omitempty" yaml:"fields" toml:"fields"`
+	Points      int64           `json:"points,omitempty" yaml:"points" toml:"points"`
+	Interval    string          `json:"interval,omitempty" yaml:"interval" toml:"interval"`
+	Jitter      bool            `json:"jitter,omitempty" yaml:"jitter" toml:"jitter"`
+	RealTime    bool            `json:"realtime,omitempty" yaml:"realtime" toml:"realtime"`
+}
+
+// WorkloadTag takes its value from either a list of values or a generator
+// spec such as "str rand(7) 1000".
+type WorkloadTag struct {
+	Key       string   `json:"key,omitempty" yaml:"key" toml:"key"`
+	Values    []string `json:"values,omitempty" yaml:"values" toml:"values"`
+	Generator string   `json:"generator,omitempty" yaml:"generator" toml:"generator"`
+}
+
+type WorkloadField struct {
+	Key       string `json:"key,omitempty" yaml:"key" toml:"key"`
+	Generator string `json:"generator,omitempty" yaml:"generator" toml:"generator"`
+}
+
+type WorkloadQuery struct {
+	Name  string `json:"name,omitempty" yaml:"name" toml:"name"`
+	Query string `json:"query,omitempty" yaml:"query" toml:"query"`
+	Count int64  `json:"count,omitempty" yaml:"count" toml:"count"`
+	// Fanout issues this many queries at once per count, as FANOUT does.
+	Fanout int64 `json:"fanout,omitempty" yaml:"fanout" toml:"fanout"`
+	// ExpectSeries and ExpectNonEmpty check every response, as EXPECT
+	// does.
+	ExpectSeries   int64 `json:"expectSeries,omitempty" yaml:"expectSeries" toml:"expectSeries"`
+	ExpectNonEmpty bool  `json:"expectNonEmpty,omitempty" yaml:"expectNonEmpty" toml:"expectNonEmpty"`
+}
+
+// WorkloadMeta repeats a SHOW query at an interval, Count times or until
+// the run ends.
+type WorkloadMeta struct {
+	Query    string `json:"query,omitempty" yaml:"query" toml:"query"`
+	Interval string `json:"interval,omitempty" yaml:"interval" toml:"interval"`
+	Count    int64  `json:"count,omitempty" yaml:"count" toml:"count"`
+}
+
+// Statements converts the workload to statements.
+func (w *Workload) Statements() ([]stressql.Statement, error) {
+	seq := []stressql.Statement{}
+	seq = appendSets(seq, w.Vars)
+
+	for _, q := range w.Setup {
+		seq = append(seq, &stressql.InfluxqlStatement{Value: q})
+	}
+
+	for i, p := range w.Phases {
+		name := p.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+
+		seq = appendSets(seq, p.Vars)
+		if p.Name != "" || len(p.SLOs) > 0 {
+			seq = append(seq, &stressql.SetStatement{Var: "phase", Value: name})
+		}
+
+		var body []stressql.Statement
+		for _, m := range p.Measurements {
+			s, err := m.statement()
+			if err != nil {
+				return nil, fmt.Errorf("phase %s: measurement %q: %v", name, m.Name, err)
+			}
+			body = append(body, s)
+		}
+		for _, q := range p.Queries {
+			s, err := q.statement()
+			if err != nil {
+				return nil, fmt.Errorf("phase %s: query %q: %v", name, q.Name, err)
+			}
+			body = append(body, s)
+		}
+		for _, m := range p.Meta {
+			s, err := m.statement()
+			if err != nil {
+				return nil, fmt.Errorf("phase %s: meta %q: %v", name, m.Query, err)
+			}
+			body = append(body, s)
+		}
+
+		if !p.Concurrent {
+			seq = append(seq, body...)
+		} else {
+			for _, s := range body {
+				seq = append(seq, &stressql.GoStatement{Statement: s})
+			}
+			seq = append(seq, &stressql.WaitStatement{})
+		}
+
+		for _, slo := range p.SLOs {
+			s, err := stressql.ParseStatement("SLO " + slo + " FOR " + name)
+			if err != nil {
+				return nil, fmt.Errorf("phase %s: slo %q: %v", name, slo, err)
+			}
+			seq = append(seq, s)
+		}
+	}
+
+	return seq, nil
+}
+
+func appendSets(seq []stressql.Statement, vars map[string]string) []stressql.Statement {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		seq = append(seq, &stressql.SetStatement{Var: k, Value: vars[k]})
+	}
+	return seq
+}
+
+func (m *WorkloadMeasurement) statement() (*stressql.InsertStatement, error) {
+	if m.Measurement == "" {
+		return nil, fmt.Errorf("missing measurement")
+	}
+	if len(m.Fields) == 0 {
+		return nil, fmt.Errorf("missing fields")
+	}
+	if m.Points <= 0 || m.Interval == "" {
+		return nil, fmt.Errorf("missing points or interval")
+	}
+
+	stmt := &stressql.InsertStatement{
+		Name: m.Name,
+		Timestamp: &stressql.Timestamp{
+			Count:    strconv.FormatInt(m.Points, 10),
+			Duration: m.Interval,
+			Jitter:   m.Jitter,
+			RealTime: m.RealTime,
+		},
+	}
+
+	tmpl := m.Measurement
+	for _, t := range m.Tags {
+		tmpl += "," + t.Key + "="
+		switch {
+		case t.Generator != "":
+			fn, err := parseGenerator(t.Generator)
+			if err != nil {
+				return nil, fmt.Errorf("tag %q: %v", t.Key, err)
+			}
+			tmpl += "%v"
+			stmt.Templates = append(stmt.Templates, &stressql.Template{Functions: []*stressql.Function{fn}})
+		case len(t.Values) == 1:
+			tmpl += t.Values[0]
+		case len(t.Values) > 1:
+			tmpl += "%v"
+			stmt.Templates = append(stmt.Templates, &stressql.Template{Tags: t.Values})
+		default:
+			return nil, fmt.Errorf("tag %q: missing values or generator", t.Key)
+		}
+	}
+
+	for i, f := range m.Fields {
+		fn, err := parseGenerator(f.Generator)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", f.Key, err)
+		}
+		if i == 0 {
+			tmpl += " "
+		} else {
+			tmpl += ","
+		}
+		tmpl += f.Key + "=%v"
+		stmt.Templates = append(stmt.Templates, &stressql.Template{Functions: []*stressql.Function{fn}})
+	}
+
+	stmt.TemplateString = tmpl + " %v"
+
+	return stmt, nil
+}
+
+// parseGenerator parses a generator spec using the DSL's function grammar.
+func parseGenerator(spec string) (*stressql.Function, error) {
+	fn, err := stressql.NewParser(strings.NewReader(spec)).ParseFunction()
+	if err != nil {
+		return nil, fmt.Errorf("generator %q: %v", spec, err)
+	}
+
+	switch strings.ToUpper(fn.Type) {
+	case "INT", "FLOAT", "STR":
+	default:
+		return nil, fmt.Errorf("generator %q: unknown type %q", spec, fn.Type)
+	}
+
+	return fn, nil
+}
+
+func (q *WorkloadQuery) statement() (*stressql.QueryStatement, error) {
+	if q.Query == "" {
+		return nil, fmt.Errorf("missing query")
+	}
+	if q.Count <= 0 {
+		return nil, fmt.Errorf("missing count")
+	}
+
+	stmt := &stressql.QueryStatement{
+		Name:           q.Name,
+		Count:          strconv.FormatInt(q.Count, 10),
+		ExpectNonEmpty: q.ExpectNonEmpty,
+	}
+	if q.Fanout > 1 {
+		stmt.Fanout = strconv.FormatInt(q.Fanout, 10)
+	}
+	if q.ExpectSeries > 0 {
+		stmt.ExpectSeries = strconv.FormatInt(q.ExpectSeries, 10)
+	}
+
+	// Template variables are %-prefixed single characters, as in the DSL.
+	rs := []rune(q.Query)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] == '%' && i+1 < len(rs) {
+			stmt.TemplateString += "%v"
+			stmt.Args = append(stmt.Args, string(rs[i:i+2]))
+			i++
+			continue
+		}
+		stmt.TemplateString += string(rs[i])
+	}
+
+	return stmt, nil
+}
+
+func (m *WorkloadMeta) statement() (*stressql.EveryStatement, error) {
+	stmt := &stressql.EveryStatement{Interval: m.Interval, Query: m.Query}
+	if m.Count > 0 {
+		stmt.Count = strconv.FormatInt(m.Count, 10)
+	}
+
+	// Round trip through the parser so the query is checked the same way
+	// as in the DSL.
+	if _, err := stressql.ParseStatement(stmt.String()); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
@@ -0,0 +1,40 @@
+package mdstress
+
+import (
+	"io/ioutil"
+
+	"github.com/mjdesa/stress_parser/stressql"
+	"gopkg.in/yaml.v2"
+)
+
+// ParseYAML reads a workload in its YAML representation and converts it to
+// statements.
+//
+//	vars:
+//	  database: stress
+//	phases:
+//	  - name: ingest
+//	    concurrent: true
+//	    measurements:
+//	      - name: mockCpu
+//	        measurement: cpu
+//	        tags:
+//	          - {key: host, values: [us-west, us-east]}
+//	          - {key: server_id, generator: "str rand(7) 1000"}
+//	        fields:
+//	          - {key: busy, generator: "int rand(1000) 100"}
+//	        points: 100000
+//	        interval: 10s
+func ParseYAML(file string) ([]stressql.Statement, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Workload{}
+	if err := yaml.UnmarshalStrict(buf, w); err != nil {
+		return nil, err
+	}
+
+	return w.Statements()
+}